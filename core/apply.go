@@ -0,0 +1,294 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Applier 把变更写入目标PostgreSQL，每次handler调用(一个事务)在目标库的一个事务中执行，成功后才确认lsn
+// insert/update按主键upsert，delete按主键删除，目标表没有主键时insert直接插入、delete按所有非空列匹配
+// 生成列不写入，GENERATED ALWAYS的identity列使用OVERRIDING SYSTEM VALUE写入源库的值且不参与更新
+// 收到EventType_SEQUENCE消息(需配置SequenceSync)时用setval同步目标库的序列
+//
+// 实现了Sink，写入失败时停止同步，重启后服务器从失败的事务重新发送:
+//
+//	applier := core.NewApplier(targetConfig)
+//	defer applier.Close()
+//	r.Start(ctx, r.SinkHandler(ctx, applier))
+type Applier struct {
+	config pgx.ConnConfig
+
+	mu     sync.Mutex
+	conn   *pgx.Conn
+	tables map[string]TableMeta
+}
+
+func NewApplier(config pgx.ConnConfig) *Applier {
+	return &Applier{config: config, tables: map[string]TableMeta{}}
+}
+
+// Write 实现Sink，同Apply
+func (a *Applier) Write(ctx context.Context, msg ...ReplicationMessage) error {
+	if err := a.Apply(msg...); err != nil {
+		return fmt.Errorf("apply: %w", err)
+	}
+	return nil
+}
+
+// Apply 在一个事务中写入消息
+func (a *Applier) Apply(msg ...ReplicationMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == nil || !a.conn.IsAlive() {
+		conn, err := pgx.Connect(a.config)
+		if err != nil {
+			return err
+		}
+		a.conn = conn
+	}
+	tx, err := a.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range msg {
+		if m.EventType == EventType_SCHEMA_RESET {
+			delete(a.tables, TableName(m.SchemaName, m.TableName))
+			continue
+		}
+		sql, args, err := a.statement(m)
+		if err != nil {
+			return err
+		}
+		if sql == "" {
+			continue
+		}
+		if _, err = tx.Exec(sql, args...); err != nil {
+			return fmt.Errorf("%s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Close 关闭目标库连接
+func (a *Applier) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	return err
+}
+
+// table 需持有a.mu
+func (a *Applier) table(schema, name string) (TableMeta, error) {
+	key := TableName(schema, name)
+	if meta, ok := a.tables[key]; ok {
+		return meta, nil
+	}
+	meta, ok, err := LoadTableMeta(a.conn, key)
+	if err != nil {
+		return meta, err
+	}
+	if !ok {
+		return meta, &MissingTablesError{Tables: []string{key}}
+	}
+	a.tables[key] = meta
+	return meta, nil
+}
+
+// statement 生成消息对应的sql，需持有a.mu
+func (a *Applier) statement(m ReplicationMessage) (sql string, args []interface{}, err error) {
+	if m.EventType == EventType_SEQUENCE {
+		// 序列值只前进不后退
+		sql = "SELECT setval($1::text::regclass, greatest($2::int8, (SELECT last_value FROM pg_sequences WHERE schemaname = $3 AND sequencename = $4)))"
+		args = []interface{}{pgx.Identifier{m.SchemaName, m.TableName}.Sanitize(), m.Body["last_value"], m.SchemaName, m.TableName}
+		return
+	}
+	switch m.EventType {
+	case EventType_INSERT, EventType_UPDATE, EventType_DELETE, EventType_TRUNCATE:
+	default:
+		return
+	}
+	meta, err := a.table(m.SchemaName, m.TableName)
+	if err != nil {
+		return
+	}
+	table := pgx.Identifier{meta.Schema, meta.Name}.Sanitize()
+	switch m.EventType {
+	case EventType_TRUNCATE:
+		sql = "TRUNCATE " + table
+	case EventType_DELETE:
+		sql, args = deleteStatement(table, meta, m)
+	default:
+		sql, args = upsertStatement(table, meta, m)
+	}
+	return
+}
+
+func upsertStatement(table string, meta TableMeta, m ReplicationMessage) (string, []interface{}) {
+	generated := make(map[string]bool, len(m.Generated))
+	for _, name := range m.Generated {
+		generated[name] = true
+	}
+	var columns, params, updates []string
+	var args []interface{}
+	var overriding bool
+	for _, name := range sortedKeys(m.Body) {
+		col, ok := meta.Column(name)
+		if !ok || col.Generated != "" || generated[name] {
+			// 目标表不存在的列及生成列不能写入
+			continue
+		}
+		if m.Body[name] == UnchangedToast {
+			// 值未变化，保留目标表中的值
+			continue
+		}
+		args = append(args, applyArg(m.Body[name]))
+		columns = append(columns, pgx.Identifier{name}.Sanitize())
+		params = append(params, fmt.Sprintf("$%d", len(args)))
+		if col.Identity == "a" {
+			overriding = true
+		}
+		if col.Identity == "" && !contains(meta.PrimaryKey, name) {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", columns[len(columns)-1], columns[len(columns)-1]))
+		}
+	}
+	if len(columns) == 0 {
+		return "", nil
+	}
+	sql := fmt.Sprintf("INSERT INTO %s (%s)", table, strings.Join(columns, ", "))
+	if overriding {
+		sql += " OVERRIDING SYSTEM VALUE"
+	}
+	sql += fmt.Sprintf(" VALUES (%s)", strings.Join(params, ", "))
+	if len(meta.PrimaryKey) > 0 {
+		keys := make([]string, 0, len(meta.PrimaryKey))
+		for _, k := range meta.PrimaryKey {
+			keys = append(keys, pgx.Identifier{k}.Sanitize())
+		}
+		if len(updates) > 0 {
+			sql += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keys, ", "), strings.Join(updates, ", "))
+		} else {
+			sql += fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(keys, ", "))
+		}
+	}
+	return sql, args
+}
+
+func deleteStatement(table string, meta TableMeta, m ReplicationMessage) (string, []interface{}) {
+	keys := meta.PrimaryKey
+	if len(keys) == 0 {
+		for _, name := range sortedKeys(m.Body) {
+			if m.Body[name] != nil {
+				keys = append(keys, name)
+			}
+		}
+	}
+	var conditions []string
+	var args []interface{}
+	for _, name := range keys {
+		v, ok := m.Body[name]
+		if !ok || v == nil {
+			// 复制标识不包含主键，无法定位行
+			defaultLogger().Warn("apply: delete without key", "table", table, "column", name)
+			return "", nil
+		}
+		args = append(args, applyArg(v))
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", pgx.Identifier{name}.Sanitize(), len(args)))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", table, strings.Join(conditions, " AND ")), args
+}
+
+// applyArg 数组列的值([]interface{})转换为数组的文本格式，NumericRat的值转换为小数文本，由目标库按列类型解析
+func applyArg(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		return arrayLiteral(val)
+	case *big.Rat:
+		return ratString(val)
+	}
+	return v
+}
+
+// ratString 有限小数的精确文本，分母为2^a*5^b时需要max(a,b)位小数
+func ratString(r *big.Rat) string {
+	d := new(big.Int).Set(r.Denom())
+	var digits int
+	for _, p := range []int64{2, 5} {
+		n, m := 0, new(big.Int)
+		for q := big.NewInt(p); m.Mod(d, q).Sign() == 0; n++ {
+			d.Quo(d, q)
+		}
+		if n > digits {
+			digits = n
+		}
+	}
+	return r.FloatString(digits)
+}
+
+// arrayLiteral 数组的文本格式，如{1,NULL,"a b"}，多维数组为嵌套的{}
+func arrayLiteral(values []interface{}) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var s string
+		switch val := v.(type) {
+		case nil:
+			b.WriteString("NULL")
+			continue
+		case []interface{}:
+			b.WriteString(arrayLiteral(val))
+			continue
+		case string:
+			s = val
+		case []byte:
+			s = `\x` + hex.EncodeToString(val)
+		case [16]byte:
+			s = fmt.Sprintf("%x-%x-%x-%x-%x", val[0:4], val[4:6], val[6:8], val[8:10], val[10:16])
+		case time.Time:
+			s = val.Format(time.RFC3339Nano)
+		default:
+			s = fmt.Sprint(val)
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func sortedKeys(body map[string]interface{}) []string {
+	keys := make([]string, 0, len(body))
+	for k := range body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const (
+	// backfillPrefix 增量快照水位消息的前缀
+	backfillPrefix = "pg-replication.backfill"
+	// backfillChunk 增量快照每段的行数
+	backfillChunk = 1024
+)
+
+// IncrementalSnapshot 开启增量快照，同步过程中可调用Backfill按段回填表的现有数据，不需要停止同步
+// 每段读取前后用pg_logical_emit_message写入低/高水位，两个水位之间复制流中同一主键的变更比快照中的行更新，
+// 收到高水位时丢弃这些行，其余行以EventType_SNAPSHOT交给handler，回填的数据与实时变更之间不会出现旧值覆盖新值
+// 需要PostgreSQL 14+(pgoutput messages选项)，表需有主键且主键列不能被ExcludeColumns排除
+func (t *Replication) IncrementalSnapshot() *Replication {
+	t._backfill = &backfillState{}
+	return t
+}
+
+type backfillState struct {
+	run sync.Mutex // Backfill串行执行
+	mu  sync.Mutex // 保护chunk，复制流与Backfill并发访问
+	seq uint64
+	// 当前正在回填的段
+	chunk *backfillRange
+}
+
+// backfillRange 增量快照的一段
+type backfillRange struct {
+	id     string
+	schema string
+	table  string
+	keys   []string
+	// 已收到低水位
+	low bool
+	// 快照中的行及其主键，高水位写入前由Backfill设置
+	rows    []ReplicationMessage
+	rowKeys []string
+	// 两个水位之间复制流中发生变更的主键
+	changed map[string]bool
+	// 两个水位之间表被清空
+	truncated bool
+	// handler未接受快照中的行时的错误，done关闭后由Backfill返回
+	err  error
+	done chan struct{}
+}
+
+// Backfill 按主键顺序分段回填表的现有数据，需配置IncrementalSnapshot且Start正在运行，阻塞到回填完成或ctx结束
+// 同一时间只回填一张表，多次调用依次执行；回填进度不持久化，中断或handler未接受某段数据时返回错误，需重新调用
+func (t *Replication) Backfill(ctx context.Context, table string) error {
+	state := t._backfill
+	if state == nil {
+		return errors.New("Backfill requires IncrementalSnapshot")
+	}
+	spec, err := ParseTableSpec(table)
+	if err != nil {
+		return err
+	}
+	state.run.Lock()
+	defer state.run.Unlock()
+	defer state.set(nil)
+	config, err := t.primaryConfig()
+	if err != nil {
+		return err
+	}
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	name := pgx.Identifier{spec.Schema, spec.Name}.Sanitize()
+	keys, _, err := primaryKey(ctx, conn, name)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("table %s has no primary key", spec.Table())
+	}
+	var relation int64
+	if err = conn.QueryRowEx(ctx, fmt.Sprintf("SELECT %s::regclass::oid::int8", quoteLiteral(name)), nil).Scan(&relation); err != nil {
+		return err
+	}
+	quoted := make([]string, 0, len(keys))
+	for _, v := range keys {
+		quoted = append(quoted, pgx.Identifier{v}.Sanitize())
+	}
+	var last []interface{}
+	for {
+		state.mu.Lock()
+		state.seq++
+		chunk := &backfillRange{id: fmt.Sprintf("%s.%d", t.name, state.seq), schema: spec.Schema, table: spec.Name, keys: keys, done: make(chan struct{})}
+		state.mu.Unlock()
+		state.set(chunk)
+		if err = t.watermark(ctx, conn, "low", chunk.id); err != nil {
+			return err
+		}
+		sql := fmt.Sprintf("SELECT * FROM %s", name)
+		if last != nil {
+			params := make([]string, 0, len(last))
+			for i := range last {
+				params = append(params, fmt.Sprintf("$%d", i+1))
+			}
+			sql += fmt.Sprintf(" WHERE (%s) > (%s)", strings.Join(quoted, ", "), strings.Join(params, ", "))
+		}
+		sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", strings.Join(quoted, ", "), backfillChunk)
+		var count int
+		if count, last, err = t.backfillRows(ctx, conn, sql, last, uint32(relation), chunk); err != nil {
+			return err
+		}
+		if err = t.watermark(ctx, conn, "high", chunk.id); err != nil {
+			return err
+		}
+		select {
+		case <-chunk.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if chunk.err != nil {
+			return chunk.err
+		}
+		t.debug("backfill", spec.Table(), chunk.id, count, "rows")
+		if count < backfillChunk {
+			return nil
+		}
+	}
+}
+
+// backfillRows 读取一段数据交给chunk，返回行数及最后一行的主键值
+func (t *Replication) backfillRows(ctx context.Context, conn *pgx.Conn, sql string, after []interface{}, relation uint32, chunk *backfillRange) (count int, last []interface{}, err error) {
+	rows, err := conn.QueryEx(ctx, sql, nil, after...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var msgs []ReplicationMessage
+	var rowKeys []string
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return 0, nil, err
+		}
+		fields := rows.FieldDescriptions()
+		body := make(map[string]interface{}, len(values))
+		for i, field := range fields {
+			body[field.Name] = values[i]
+		}
+		last = last[:0]
+		for _, k := range chunk.keys {
+			last = append(last, body[k])
+		}
+		// 主键取转换后的值，与复制流中变更的Body按同样的JSONColumns/NumericColumns/TimeColumns转换
+		msg := t.snapshotMessage(0, relation, chunk.schema, chunk.table, fields, values)
+		msgs = append(msgs, msg)
+		rowKeys = append(rowKeys, backfillKey(msg.Body, chunk.keys))
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	t._backfill.mu.Lock()
+	chunk.rows, chunk.rowKeys = msgs, rowKeys
+	t._backfill.mu.Unlock()
+	return
+}
+
+// watermark 写入非事务性的水位消息，在复制流中的位置即写入wal的位置
+func (t *Replication) watermark(ctx context.Context, conn *pgx.Conn, kind, id string) error {
+	_, err := conn.ExecEx(ctx, "SELECT pg_logical_emit_message(false, $1, $2)", nil, backfillPrefix, kind+" "+id)
+	return err
+}
+
+func (s *backfillState) set(chunk *backfillRange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunk = chunk
+}
+
+// backfillKey 主键值拼接为字符串，用于比较快照中的行与复制流中的变更
+// 快照连接解码的timestamptz为本地时区，复制流按服务器输出的偏移解码，time.Time统一为UTC
+func backfillKey(body map[string]interface{}, keys []string) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := body[k]
+		if tm, ok := v.(time.Time); ok {
+			v = tm.UTC().Format(time.RFC3339Nano)
+		}
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// trackBackfill 记录两个水位之间正在回填的表发生变更的主键
+func (t *Replication) trackBackfill(m ReplicationMessage) {
+	state := t._backfill
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	chunk := state.chunk
+	if chunk == nil || !chunk.low || m.SchemaName != chunk.schema || m.TableName != chunk.table {
+		return
+	}
+	switch m.EventType {
+	case EventType_INSERT, EventType_UPDATE, EventType_DELETE:
+		chunk.changed[backfillKey(m.Body, chunk.keys)] = true
+	case EventType_TRUNCATE:
+		// 快照中的行均已被删除
+		chunk.truncated = true
+	}
+}
+
+// backfillMessage 处理复制流中的水位消息，收到高水位时把快照中未被变更的行交给handler，handler未接受时Backfill返回错误
+func (t *Replication) backfillMessage(v LogicalMessage, lsn uint64, dmlHandler ReplicationDMLHandler) {
+	state := t._backfill
+	if state == nil || v.Prefix != backfillPrefix {
+		return
+	}
+	kind, id, _ := strings.Cut(string(v.Content), " ")
+	state.mu.Lock()
+	chunk := state.chunk
+	if chunk == nil || chunk.id != id {
+		state.mu.Unlock()
+		return
+	}
+	if kind == "low" {
+		chunk.low, chunk.changed = true, make(map[string]bool)
+		state.mu.Unlock()
+		return
+	}
+	if kind != "high" || !chunk.low {
+		state.mu.Unlock()
+		return
+	}
+	batch := make([]ReplicationMessage, 0, len(chunk.rows))
+	for i, row := range chunk.rows {
+		if chunk.truncated || chunk.changed[chunk.rowKeys[i]] {
+			continue
+		}
+		if row.Lsn = lsn; t.accept(&row) {
+			batch = append(batch, row)
+		}
+	}
+	dropped := len(chunk.rows) - len(batch)
+	state.chunk = nil
+	state.mu.Unlock()
+	if len(batch) > 0 && dmlHandler(batch...) != DMLHandlerStatusSuccess {
+		t.debug("backfill", id, "rows not accepted by handler")
+		chunk.err = fmt.Errorf("backfill %s.%s: rows not accepted by handler", chunk.schema, chunk.table)
+	}
+	t.debug("backfill", id, len(batch), "rows delivered", dropped, "superseded")
+	close(chunk.done)
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// 快照连接按二进制解码的主键与复制流按文本解码的主键得到相同的key
+func TestBackfillKey(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	tm := time.Date(2024, time.January, 1, 8, 0, 0, 123000, shanghai)
+	cases := []struct {
+		name     string
+		oid      pgtype.OID
+		snapshot interface{}
+		text     string
+	}{
+		{"timestamptz", pgtype.TimestamptzOID, tm.In(time.Local), "2024-01-01 08:00:00.000123+08"},
+		{"timestamp", pgtype.TimestampOID, time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC), "2024-01-01 08:00:00"},
+		{"date", pgtype.DateOID, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), "2024-01-01"},
+		{"numeric", pgtype.NumericOID, &pgtype.Numeric{Int: big.NewInt(150), Exp: -2, Status: pgtype.Present}, "1.50"},
+		{"int8", pgtype.Int8OID, int64(42), "42"},
+	}
+	policies := []func(r *Replication){
+		func(r *Replication) {},
+		func(r *Replication) { r.TimeColumns(TimeRFC3339, shanghai) },
+		func(r *Replication) { r.TimeColumns(TimeEpochMillis, nil).NumericColumns(NumericRat) },
+		func(r *Replication) { r.NumericColumns(NumericFloat64) },
+	}
+	for i, policy := range policies {
+		r := NewReplication("backfill", pgx.ConnConfig{})
+		policy(r)
+		for _, c := range cases {
+			fields := []pgx.FieldDescription{{Name: "id", DataType: c.oid}}
+			snapshot := r.snapshotMessage(0, 1, "public", "t", fields, []interface{}{c.snapshot})
+			decoder := ColumnDecoder(Column{Name: "id", Type: uint32(c.oid)})
+			if err := decoder.DecodeText(nil, []byte(c.text)); err != nil {
+				t.Fatal(err)
+			}
+			stream := map[string]interface{}{"id": r.columnValue(decoder)}
+			if got, want := backfillKey(snapshot.Body, []string{"id"}), backfillKey(stream, []string{"id"}); got != want {
+				t.Errorf("policy %d %s: snapshot key %q, stream key %q", i, c.name, got, want)
+			}
+		}
+	}
+}
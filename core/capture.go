@@ -0,0 +1,234 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// 抓包文件格式：
+// 文件头 "PGRCAP1\n"，之后每条记录为
+// kind(1) 'w'=wal 'h'=heartbeat | 抓取时间unix纳秒(8) | WalStart/ServerWalEnd(8) | ServerWalEnd/ServerTime(8) |
+// ServerTime/ReplyRequested(8) | 数据长度(4) | 数据
+// 所有整数均为大端序
+const captureMagic = "PGRCAP1\n"
+
+// CaptureRecord 抓包文件中的一条记录
+type CaptureRecord struct {
+	Time    time.Time
+	Message *pgx.ReplicationMessage
+}
+
+// CaptureWriter 把原始复制消息写入抓包文件
+type CaptureWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	header bool
+}
+
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{w: w}
+}
+
+func (c *CaptureWriter) Write(msg *pgx.ReplicationMessage) error {
+	if msg == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.header {
+		if _, err := io.WriteString(c.w, captureMagic); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	var head [37]byte
+	var data []byte
+	binary.BigEndian.PutUint64(head[1:], uint64(time.Now().UnixNano()))
+	switch {
+	case msg.WalMessage != nil:
+		head[0] = 'w'
+		binary.BigEndian.PutUint64(head[9:], msg.WalMessage.WalStart)
+		binary.BigEndian.PutUint64(head[17:], msg.WalMessage.ServerWalEnd)
+		binary.BigEndian.PutUint64(head[25:], msg.WalMessage.ServerTime)
+		data = msg.WalMessage.WalData
+	case msg.ServerHeartbeat != nil:
+		head[0] = 'h'
+		binary.BigEndian.PutUint64(head[9:], msg.ServerHeartbeat.ServerWalEnd)
+		binary.BigEndian.PutUint64(head[17:], msg.ServerHeartbeat.ServerTime)
+		binary.BigEndian.PutUint64(head[25:], uint64(msg.ServerHeartbeat.ReplyRequested))
+	default:
+		return nil
+	}
+	binary.BigEndian.PutUint32(head[33:], uint32(len(data)))
+	if _, err := c.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(data)
+	return err
+}
+
+// CaptureReader 读取抓包文件
+type CaptureReader struct {
+	r      *bufio.Reader
+	header bool
+}
+
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r)}
+}
+
+// Next 读取下一条记录，文件结束时返回io.EOF
+func (c *CaptureReader) Next() (rec CaptureRecord, err error) {
+	if !c.header {
+		magic := make([]byte, len(captureMagic))
+		if _, err = io.ReadFull(c.r, magic); err != nil {
+			return
+		}
+		if string(magic) != captureMagic {
+			return rec, fmt.Errorf("invalid capture file header %q", magic)
+		}
+		c.header = true
+	}
+	var head [37]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated capture record: %w", err)
+		}
+		return
+	}
+	data := make([]byte, binary.BigEndian.Uint32(head[33:]))
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return rec, fmt.Errorf("truncated capture record: %w", err)
+	}
+	rec.Time = time.Unix(0, int64(binary.BigEndian.Uint64(head[1:])))
+	a, b, d := binary.BigEndian.Uint64(head[9:]), binary.BigEndian.Uint64(head[17:]), binary.BigEndian.Uint64(head[25:])
+	switch head[0] {
+	case 'w':
+		rec.Message = &pgx.ReplicationMessage{WalMessage: &pgx.WalMessage{WalStart: a, ServerWalEnd: b, ServerTime: d, WalData: data}}
+	case 'h':
+		rec.Message = &pgx.ReplicationMessage{ServerHeartbeat: &pgx.ServerHeartbeat{ServerWalEnd: a, ServerTime: b, ReplyRequested: byte(d)}}
+	default:
+		return rec, fmt.Errorf("unknown capture record kind %q", head[0])
+	}
+	return
+}
+
+// Record 把收到的原始复制消息写入抓包文件，可通过NewReplayer回放
+func (t *Replication) Record(w io.Writer) *Replication {
+	t._capture = NewCaptureWriter(w)
+	return t
+}
+
+// RecordFile 与Record相同，Start时以追加方式打开path，Start返回时关闭
+// 抓包文件包含每条消息的lsn、服务器时间及原始数据，可用于离线复现解码问题，注意其中包含变更的明文数据
+func (t *Replication) RecordFile(path string) *Replication {
+	t._captureFile = path
+	return t
+}
+
+// openCapture 打开RecordFile配置的文件，返回关闭函数
+func (t *Replication) openCapture() (func(), error) {
+	if t._captureFile == "" {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(t._captureFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	// 追加到已有的抓包文件时不再写入文件头
+	t._capture = &CaptureWriter{w: f, header: info.Size() > 0}
+	return func() {
+		t._capture = nil
+		if err := f.Close(); err != nil {
+			t.logger().Error("capture: close", "file", t._captureFile, "error", err)
+		}
+	}, nil
+}
+
+// Replayer 回放抓包文件的传输层，实现Transport
+type Replayer struct {
+	reader *CaptureReader
+	speed  float64
+
+	mu     sync.Mutex
+	last   time.Time
+	acks   []uint64
+	closed bool
+}
+
+// NewReplayer speed为回放速度倍数，1为按抓取时的间隔回放，0为不等待尽快回放
+// 抓包文件读取完后WaitForReplicationMessage返回io.EOF
+func NewReplayer(r io.Reader, speed float64) *Replayer {
+	return &Replayer{reader: NewCaptureReader(r), speed: speed}
+}
+
+// Acks 获取回放过程中确认的lsn
+func (r *Replayer) Acks() []uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]uint64(nil), r.acks...)
+}
+
+func (r *Replayer) StartReplication(slotName string, startLsn uint64, timeline int64, pluginArguments ...string) error {
+	return nil
+}
+
+func (r *Replayer) WaitForReplicationMessage(ctx context.Context) (*pgx.ReplicationMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, fmt.Errorf("replayer closed")
+	}
+	rec, err := r.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if r.speed > 0 && !r.last.IsZero() {
+		if wait := time.Duration(float64(rec.Time.Sub(r.last)) / r.speed); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	r.last = rec.Time
+	return rec.Message, nil
+}
+
+func (r *Replayer) SendStandbyStatus(k *pgx.StandbyStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k.WalFlushPosition > 0 {
+		r.acks = append(r.acks, k.WalFlushPosition)
+	}
+	return nil
+}
+
+func (r *Replayer) IsAlive() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.closed
+}
+
+func (r *Replayer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
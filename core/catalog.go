@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// ColumnMeta pg_catalog中的列信息，Relation消息中不包含这些内容
+type ColumnMeta struct {
+	Name    string
+	NotNull bool
+	// 默认值表达式，没有默认值时为空
+	Default string
+	// 'a' generated always / 'd' by default，非identity列为空
+	Identity string
+	// 's' stored generated column，非生成列为空
+	Generated string
+}
+
+// Constraint 表约束
+type Constraint struct {
+	Name string
+	// 'p' primary key / 'u' unique / 'f' foreign key / 'c' check / 'x' exclusion
+	Type       string
+	Definition string
+}
+
+// TableMeta 表的完整结构信息
+type TableMeta struct {
+	RelationID  uint32
+	Schema      string
+	Name        string
+	Columns     []ColumnMeta
+	Constraints []Constraint
+	// 主键列，按主键定义的顺序
+	PrimaryKey  []string
+	RefreshedAt time.Time
+}
+
+// Column 按列名查找列信息
+func (m TableMeta) Column(name string) (ColumnMeta, bool) {
+	for _, col := range m.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return ColumnMeta{}, false
+}
+
+// Catalog 定期从pg_catalog刷新的表结构缓存
+type Catalog struct {
+	mu     sync.RWMutex
+	tables map[uint32]TableMeta
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{tables: map[uint32]TableMeta{}}
+}
+
+// Table 获取表结构信息
+func (c *Catalog) Table(id uint32) (meta TableMeta, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	meta, ok = c.tables[id]
+	return
+}
+
+// Lookup 按schema和表名获取表结构信息
+func (c *Catalog) Lookup(schema, table string) (meta TableMeta, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, m := range c.tables {
+		if m.Schema == schema && m.Name == table {
+			return m, true
+		}
+	}
+	return
+}
+
+const catalogColumnsSQL = `SELECT a.attrelid::int8, n.nspname, c.relname, a.attname, a.attnotnull,
+	coalesce(pg_get_expr(d.adbin, d.adrelid), ''), a.attidentity::text, a.attgenerated::text
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE a.attnum > 0 AND NOT a.attisdropped AND a.attrelid::int8 = ANY($1)
+ORDER BY a.attrelid, a.attnum`
+
+const catalogConstraintsSQL = `SELECT conrelid::int8, conname, contype::text, pg_get_constraintdef(oid)
+FROM pg_constraint
+WHERE conrelid::int8 = ANY($1)
+ORDER BY conrelid, conname`
+
+const catalogPrimaryKeySQL = `SELECT i.indrelid::int8, a.attname
+FROM pg_index i
+JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey::int2[])
+WHERE i.indisprimary AND i.indrelid::int8 = ANY($1)
+ORDER BY i.indrelid, array_position(i.indkey::int2[], a.attnum)`
+
+// Refresh 重新加载指定表的结构信息，不在ids中的表将被移除
+func (c *Catalog) Refresh(conn *pgx.Conn, ids []uint32) error {
+	tables, err := loadTableMeta(conn, ids)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.tables = tables
+	c.mu.Unlock()
+	return nil
+}
+
+// LoadTableMeta 按表名从conn所在的数据库加载表结构信息，表不存在时ok为false
+func LoadTableMeta(conn *pgx.Conn, table string) (meta TableMeta, ok bool, err error) {
+	quoted, err := quoteTable(table)
+	if err != nil {
+		return
+	}
+	var oid int64
+	if err = conn.QueryRow("SELECT coalesce(to_regclass($1)::oid::int8, 0)", quoted).Scan(&oid); err != nil || oid == 0 {
+		return
+	}
+	tables, err := loadTableMeta(conn, []uint32{uint32(oid)})
+	if err != nil {
+		return
+	}
+	meta, ok = tables[uint32(oid)]
+	return
+}
+
+func loadTableMeta(conn *pgx.Conn, ids []uint32) (map[uint32]TableMeta, error) {
+	oids := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		oids = append(oids, int64(id))
+	}
+	now := time.Now()
+	tables := make(map[uint32]TableMeta, len(ids))
+	rows, err := conn.Query(catalogColumnsSQL, oids)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var oid int64
+		var schema, table string
+		var col ColumnMeta
+		if err = rows.Scan(&oid, &schema, &table, &col.Name, &col.NotNull, &col.Default, &col.Identity, &col.Generated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		meta := tables[uint32(oid)]
+		meta.RelationID, meta.Schema, meta.Name, meta.RefreshedAt = uint32(oid), schema, table, now
+		meta.Columns = append(meta.Columns, col)
+		tables[uint32(oid)] = meta
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = conn.Query(catalogConstraintsSQL, oids)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var oid int64
+		var con Constraint
+		if err = rows.Scan(&oid, &con.Name, &con.Type, &con.Definition); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if meta, ok := tables[uint32(oid)]; ok {
+			meta.Constraints = append(meta.Constraints, con)
+			tables[uint32(oid)] = meta
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = conn.Query(catalogPrimaryKeySQL, oids)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var oid int64
+		var name string
+		if err = rows.Scan(&oid, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if meta, ok := tables[uint32(oid)]; ok {
+			meta.PrimaryKey = append(meta.PrimaryKey, name)
+			tables[uint32(oid)] = meta
+		}
+	}
+	return tables, rows.Err()
+}
+
+// SchemaRefresh 按interval定期从pg_catalog刷新已订阅表的默认值、identity/generated列及约束信息
+// 刷新使用独立的普通连接，通过Catalog()获取结果
+func (t *Replication) SchemaRefresh(interval time.Duration) *Replication {
+	t._schemaRefresh = interval
+	return t
+}
+
+// Catalog 获取pg_catalog表结构缓存
+func (t *Replication) Catalog() *Catalog {
+	return t.catalog
+}
+
+func (t *Replication) refreshCatalog(ctx context.Context) {
+	t.poll(ctx, "catalog", t._schemaRefresh, func(conn *pgx.Conn, fresh bool) bool {
+		relations := t.set.Relations()
+		ids := make([]uint32, 0, len(relations))
+		for _, rel := range relations {
+			ids = append(ids, rel.ID)
+		}
+		if err := t.catalog.Refresh(conn, ids); err != nil {
+			t.debug("catalog", "refresh", err)
+		}
+		return true
+	})
+}
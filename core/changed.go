@@ -0,0 +1,164 @@
+package core
+
+import (
+	"container/list"
+	"strings"
+)
+
+// InferChangedColumns 不修改复制标识(不需要ALTER TABLE及表的所有权)时推断update变化的列
+// 缓存每个复制标识键最近一次的行值(来自insert/update)，最多cacheRows行，update没有完整旧值时与缓存的行比较得到Columns；
+// 主键变化时服务器发送的旧主键用于查找缓存，新行中未变化的TOAST值视为未变化
+// 缓存中没有该行(如启动后第一次变更)时无法得知变化的列，Columns为nil，表为REPLICA IDENTITY FULL时不使用缓存
+func (t *Replication) InferChangedColumns(cacheRows int) *Replication {
+	t._rowCache = newRowCache(cacheRows)
+	return t
+}
+
+// rowCache 按relation及复制标识键缓存最近的行值，超过容量时淘汰最久未使用的行
+type rowCache struct {
+	size  int
+	order *list.List
+	rows  map[string]*list.Element
+}
+
+type cachedRow struct {
+	key string
+	row []Tuple
+}
+
+func newRowCache(size int) *rowCache {
+	return &rowCache{size: size, order: list.New(), rows: make(map[string]*list.Element)}
+}
+
+// rowKey 复制标识列的原始值，任一复制标识列的值未知时返回false
+func rowKey(rel Relation, row []Tuple) (string, bool) {
+	var b strings.Builder
+	b.WriteString(rel.Namespace + "." + rel.Name)
+	found := false
+	for i, col := range rel.Columns {
+		if !col.Key {
+			continue
+		}
+		if i >= len(row) || row[i].Flag == 'u' {
+			return "", false
+		}
+		found = true
+		b.WriteByte(0)
+		b.WriteByte(byte(row[i].Flag))
+		b.Write(row[i].Value)
+	}
+	return b.String(), found
+}
+
+func (c *rowCache) get(rel Relation, row []Tuple) []Tuple {
+	key, ok := rowKey(rel, row)
+	if !ok {
+		return nil
+	}
+	e, ok := c.rows[key]
+	if !ok {
+		return nil
+	}
+	cached := e.Value.(*cachedRow).row
+	if len(cached) != len(rel.Columns) {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return cached
+}
+
+func (c *rowCache) put(rel Relation, row []Tuple) {
+	key, ok := rowKey(rel, row)
+	if !ok || c.size <= 0 {
+		return
+	}
+	// 行值引用wal消息的缓冲区，需要复制
+	copied := make([]Tuple, len(row))
+	for i, v := range row {
+		copied[i] = Tuple{Flag: v.Flag, Value: append([]byte(nil), v.Value...)}
+	}
+	if e, ok := c.rows[key]; ok {
+		e.Value.(*cachedRow).row = copied
+		c.order.MoveToFront(e)
+		return
+	}
+	c.rows[key] = c.order.PushFront(&cachedRow{key: key, row: copied})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.rows, e.Value.(*cachedRow).key)
+	}
+}
+
+func (c *rowCache) remove(rel Relation, row []Tuple) {
+	key, ok := rowKey(rel, row)
+	if !ok {
+		return
+	}
+	if e, ok := c.rows[key]; ok {
+		c.order.Remove(e)
+		delete(c.rows, key)
+	}
+}
+
+// clear 清空表的缓存，truncate或表结构变化时调用
+func (c *rowCache) clear(rel Relation) {
+	prefix := rel.Namespace + "." + rel.Name + "\x00"
+	for key, e := range c.rows {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(e)
+			delete(c.rows, key)
+		}
+	}
+}
+
+func (c *rowCache) reset() {
+	c.order.Init()
+	c.rows = make(map[string]*list.Element)
+}
+
+// inferOldRow update没有完整旧值时用缓存的行作为旧值，oldRow为主键变化时的旧主键
+// 新行中未变化的TOAST值用缓存的值补全后放回缓存
+func (t *Replication) inferOldRow(v Update) []Tuple {
+	rel, ok := t.set.Get(v.RelationID)
+	if !ok {
+		return v.OldRow
+	}
+	lookup := v.Row
+	if v.Key {
+		lookup = v.OldRow
+		defer t._rowCache.remove(rel, v.OldRow)
+	}
+	cached := t._rowCache.get(rel, lookup)
+	row := v.Row
+	if cached != nil {
+		row = make([]Tuple, len(v.Row))
+		for i, tuple := range v.Row {
+			if tuple.Flag == 'u' && i < len(cached) {
+				tuple = cached[i]
+			}
+			row[i] = tuple
+		}
+	}
+	t._rowCache.put(rel, row)
+	return cached
+}
+
+// cacheRow insert/delete/truncate时维护缓存
+func (t *Replication) cacheRow(eventType EventType, relation uint32, row []Tuple) {
+	if t._rowCache == nil {
+		return
+	}
+	rel, ok := t.set.Get(relation)
+	if !ok {
+		return
+	}
+	switch eventType {
+	case EventType_INSERT:
+		t._rowCache.put(rel, row)
+	case EventType_DELETE:
+		t._rowCache.remove(rel, row)
+	case EventType_TRUNCATE:
+		t._rowCache.clear(rel)
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// CheckpointAdapter 持久化已确认的lsn，重启后从该位置继续同步
+// 复制槽的confirmed_flush_lsn同样记录了确认位置，外部持久化用于复制槽重建、切换到同步槽等场景下的断点续传
+type CheckpointAdapter interface {
+	// Get 获取保存的lsn，没有记录时返回0
+	Get() (uint64, error)
+	// Set 保存已确认的lsn，在向服务器确认之前调用
+	Set(lsn uint64) error
+	Close() error
+}
+
+// Checkpoint 使用adapter保存确认位置，Start时从保存的位置开始(已通过StartLsn指定位置时除外)
+// Close时关闭adapter
+func (t *Replication) Checkpoint(adapter CheckpointAdapter) *Replication {
+	t._checkpoint = adapter
+	return t
+}
+
+// startPosition 同步的起始位置
+func (t *Replication) startPosition() (uint64, error) {
+	if t._startLsn > 0 || t._checkpoint == nil {
+		return t._startLsn, nil
+	}
+	lsn, err := t._checkpoint.Get()
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint: %w", err)
+	}
+	if lsn > 0 {
+		t.debug("checkpoint", "resume from", pgx.FormatLSN(lsn))
+	}
+	return lsn, nil
+}
+
+// confirm 保存并向服务器确认已处理的lsn
+func (t *Replication) confirm(lsn uint64) error {
+	t._statusMu.Lock()
+	defer t._statusMu.Unlock()
+	if t._checkpoint != nil {
+		if err := t._checkpoint.Set(lsn); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+	return t.sendStatus(lsn)
+}
+
+// FileCheckpoint 把lsn保存在本地文件中，先写临时文件再重命名，避免写入中断导致文件损坏
+type FileCheckpoint struct {
+	path string
+}
+
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{path: path}
+}
+
+func (c *FileCheckpoint) Get() (uint64, error) {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	return pgx.ParseLSN(fields[0])
+}
+
+func (c *FileCheckpoint) Set(lsn uint64) error {
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(pgx.FormatLSN(lsn)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *FileCheckpoint) Close() error {
+	return nil
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const coordinatorAppPrefix = "pgrepl:"
+
+// Coordinator 在多个消费进程之间分配同步流(复制槽/分片)
+// 成员通过协调连接的application_name注册，连接断开即退出消费组
+// 同步流按rendezvous hash分配给成员，启动前需获取该同步流的advisory lock，保证同一时刻只有一个成员消费
+type Coordinator struct {
+	config   pgx.ConnConfig
+	group    string
+	member   string
+	interval time.Duration
+	creds    CredentialsProvider
+
+	mu      sync.Mutex
+	streams map[string]*managedStream
+	running map[string]*coordinatedStream
+}
+
+type coordinatedStream struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCoordinator config为协调连接，所有成员需连接同一个数据库
+// group为消费组名称，member为当前进程在消费组内的唯一标识
+func NewCoordinator(config pgx.ConnConfig, group, member string) *Coordinator {
+	return &Coordinator{
+		config:   config,
+		group:    group,
+		member:   member,
+		interval: 10 * time.Second,
+		streams:  map[string]*managedStream{},
+		running:  map[string]*coordinatedStream{},
+	}
+}
+
+// Interval 成员变化检测及重新分配的周期，默认10秒
+func (c *Coordinator) Interval(interval time.Duration) *Coordinator {
+	c.interval = interval
+	return c
+}
+
+// Credentials 协调连接使用动态凭证，每次(重新)连接时获取
+func (c *Coordinator) Credentials(provider CredentialsProvider) *Coordinator {
+	c.creds = provider
+	return c
+}
+
+// Add 添加由消费组分配的同步流，所有成员需添加相同的同步流
+func (c *Coordinator) Add(r *Replication, handler ReplicationDMLHandler, policy RestartPolicy) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := streamKey(r)
+	if _, ok := c.streams[key]; ok {
+		return fmt.Errorf("stream %s already exists", key)
+	}
+	c.streams[key] = &managedStream{
+		replication: r,
+		handler:     handler,
+		policy:      policy,
+		stats:       StreamStats{Name: r.name, Database: r.config.Database},
+	}
+	return nil
+}
+
+// Owned 获取当前成员正在消费的同步流
+func (c *Coordinator) Owned() []StreamStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]StreamStats, 0, len(c.running))
+	for key := range c.running {
+		res = append(res, c.streams[key].snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Database+res[i].Name < res[j].Database+res[j].Name })
+	return res
+}
+
+func advisoryKey(s string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int32(h.Sum32())
+}
+
+func (c *Coordinator) connect() (*pgx.Conn, error) {
+	config := c.config
+	params := make(map[string]string, len(config.RuntimeParams)+1)
+	for k, v := range config.RuntimeParams {
+		params[k] = v
+	}
+	params["application_name"] = coordinatorAppPrefix + c.group + ":" + c.member
+	config.RuntimeParams = params
+	config, _, err := applyCredentials(c.creds, config)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.Connect(config)
+}
+
+// members 获取消费组内所有在线成员
+func (c *Coordinator) members(conn *pgx.Conn) ([]string, error) {
+	prefix := coordinatorAppPrefix + c.group + ":"
+	rows, err := conn.Query("SELECT DISTINCT application_name FROM pg_stat_activity WHERE left(application_name, length($1)) = $1", prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res = append(res, strings.TrimPrefix(name, prefix))
+	}
+	sort.Strings(res)
+	return res, rows.Err()
+}
+
+// Start 加入消费组并消费分配给当前成员的同步流，阻塞直到ctx取消
+func (c *Coordinator) Start(ctx context.Context) error {
+	defer c.stopAll(nil)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	var conn *pgx.Conn
+	for {
+		if conn == nil || !conn.IsAlive() {
+			// 连接断开后advisory lock已释放，必须停止所有同步流
+			c.stopAll(nil)
+			var err error
+			if conn, err = c.connect(); err != nil {
+				defaultLogger().Error("coordinator: connect", "group", c.group, "error", err)
+			}
+		}
+		if conn != nil {
+			if err := c.rebalance(ctx, conn); err != nil {
+				defaultLogger().Error("coordinator: rebalance", "group", c.group, "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if conn != nil {
+				c.stopAll(conn)
+				conn.Close()
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Coordinator) rebalance(ctx context.Context, conn *pgx.Conn) error {
+	members, err := c.members(conn)
+	if err != nil {
+		return err
+	}
+	groupKey := advisoryKey(c.group)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, s := range c.streams {
+		owner := ""
+		if i := rendezvous(key, members); i >= 0 {
+			owner = members[i]
+		}
+		running, ok := c.running[key]
+		if ok {
+			select {
+			case <-running.done:
+				// 同步流已超出重启策略退出，释放锁等待下次分配
+				ok = false
+				delete(c.running, key)
+				if _, err = conn.Exec("SELECT pg_advisory_unlock($1::int4, $2::int4)", groupKey, advisoryKey(key)); err != nil {
+					return err
+				}
+			default:
+			}
+		}
+		switch {
+		case ok && owner != c.member:
+			c.stop(conn, key, running)
+		case !ok && owner == c.member:
+			var locked bool
+			if err = conn.QueryRow("SELECT pg_try_advisory_lock($1::int4, $2::int4)", groupKey, advisoryKey(key)).Scan(&locked); err != nil {
+				return err
+			}
+			if !locked {
+				// 上一个成员还未释放
+				continue
+			}
+			sctx, cancel := context.WithCancel(ctx)
+			running = &coordinatedStream{cancel: cancel, done: make(chan struct{})}
+			c.running[key] = running
+			go func(s *managedStream, running *coordinatedStream) {
+				defer close(running.done)
+				if err := s.run(sctx); err != nil {
+					defaultLogger().Error("coordinator: stream", "group", c.group, "stream", s.stats.Name, "error", err)
+				}
+			}(s, running)
+		}
+	}
+	return nil
+}
+
+// stop 需持有c.mu
+func (c *Coordinator) stop(conn *pgx.Conn, key string, running *coordinatedStream) {
+	running.cancel()
+	<-running.done
+	delete(c.running, key)
+	if conn != nil {
+		if _, err := conn.Exec("SELECT pg_advisory_unlock($1::int4, $2::int4)", advisoryKey(c.group), advisoryKey(key)); err != nil {
+			defaultLogger().Error("coordinator: unlock", "group", c.group, "stream", key, "error", err)
+		}
+	}
+}
+
+func (c *Coordinator) stopAll(conn *pgx.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, running := range c.running {
+		c.stop(conn, key, running)
+	}
+}
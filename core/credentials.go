@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Credentials 数据库登录凭证
+type Credentials struct {
+	// 为空时使用连接配置中的用户
+	User     string
+	Password string
+	// 凭证过期时间，零值为不过期
+	// 复制连接会在过期前重连，已收到但未确认的事务会从复制槽的确认位置重新发送
+	Expires time.Time
+}
+
+// CredentialsProvider 每次建立连接(包括重连及故障转移)时获取凭证，config为即将使用的连接配置
+type CredentialsProvider interface {
+	Credentials(config pgx.ConnConfig) (Credentials, error)
+}
+
+// CredentialsFunc 函数形式的CredentialsProvider
+type CredentialsFunc func(config pgx.ConnConfig) (Credentials, error)
+
+func (f CredentialsFunc) Credentials(config pgx.ConnConfig) (Credentials, error) {
+	return f(config)
+}
+
+// Credentials 使用动态凭证代替config中的用户和密码
+func (t *Replication) Credentials(provider CredentialsProvider) *Replication {
+	t._credentials = provider
+	return t
+}
+
+// connConfig 建立连接使用的配置，配置了凭证提供者时每次重新获取
+// reconnectAt为凭证过期前需要重连的时间，零值为不需要重连
+func (t *Replication) connConfig(config pgx.ConnConfig) (pgx.ConnConfig, time.Time, error) {
+	return applyCredentials(t._credentials, config)
+}
+
+func applyCredentials(provider CredentialsProvider, config pgx.ConnConfig) (_ pgx.ConnConfig, reconnectAt time.Time, err error) {
+	if provider == nil {
+		return config, reconnectAt, nil
+	}
+	creds, err := provider.Credentials(config)
+	if err != nil {
+		return config, reconnectAt, fmt.Errorf("credentials: %w", err)
+	}
+	if creds.User != "" {
+		config.User = creds.User
+	}
+	config.Password = creds.Password
+	if !creds.Expires.IsZero() {
+		// 剩余有效期的80%后重连，留出建立新连接的时间
+		now := time.Now()
+		reconnectAt = now.Add(creds.Expires.Sub(now) * 4 / 5)
+	}
+	return config, reconnectAt, nil
+}
+
+// StaticCredentials 固定的用户和密码
+func StaticCredentials(user, password string) CredentialsProvider {
+	return CredentialsFunc(func(pgx.ConnConfig) (Credentials, error) {
+		return Credentials{User: user, Password: password}, nil
+	})
+}
+
+// EnvCredentials 每次连接时从环境变量读取用户和密码，userEnv为空时使用连接配置中的用户
+func EnvCredentials(userEnv, passwordEnv string) CredentialsProvider {
+	return CredentialsFunc(func(pgx.ConnConfig) (creds Credentials, err error) {
+		if userEnv != "" {
+			creds.User = os.Getenv(userEnv)
+		}
+		password, ok := os.LookupEnv(passwordEnv)
+		if !ok {
+			return creds, fmt.Errorf("environment variable %s not set", passwordEnv)
+		}
+		creds.Password = password
+		return creds, nil
+	})
+}
+
+// FileCredentials 每次连接时从文件读取密码(如Kubernetes/Docker secret挂载的文件)，忽略首尾空白
+// userFile为空时使用连接配置中的用户
+func FileCredentials(userFile, passwordFile string) CredentialsProvider {
+	return CredentialsFunc(func(pgx.ConnConfig) (creds Credentials, err error) {
+		if userFile != "" {
+			data, err := os.ReadFile(userFile)
+			if err != nil {
+				return creds, err
+			}
+			creds.User = strings.TrimSpace(string(data))
+		}
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return creds, err
+		}
+		creds.Password = strings.TrimSpace(string(data))
+		return creds, nil
+	})
+}
+
+func (t *Replication) reconnectAt() time.Time {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._reconnectAt
+}
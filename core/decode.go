@@ -0,0 +1,208 @@
+package core
+
+import (
+	"database/sql"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Decode 把消息的Body按列名写入结构体T，列名取字段的db标签，其次json标签，都没有时按字段名(不区分大小写)
+// 标签为"-"的字段及Body中没有的列忽略，NULL为零值；数值类型之间按需转换，数值文本(NumericString)可写入数值字段，
+// json列及数组可写入结构体、map及切片字段，字段实现sql.Scanner或encoding.TextUnmarshaler时使用其解码
+//
+//	type User struct {
+//		ID   int64  `db:"id"`
+//		Name string `db:"name"`
+//	}
+//	user, err := core.Decode[User](msg)
+func Decode[T any](msg ReplicationMessage) (T, error) {
+	var res T
+	v := reflect.ValueOf(&res).Elem()
+	if v.Kind() != reflect.Struct {
+		return res, fmt.Errorf("decode: %T is not a struct", res)
+	}
+	for _, f := range decodePlan(v.Type()) {
+		val, ok := msg.Body[f.column]
+		if !ok {
+			if val, ok = bodyFold(msg.Body, f.column, f.fold); !ok {
+				continue
+			}
+		}
+		if err := assignValue(v.FieldByIndex(f.index), val); err != nil {
+			return res, fmt.Errorf("decode %s.%s column %s: %w", msg.SchemaName, msg.TableName, f.column, err)
+		}
+	}
+	return res, nil
+}
+
+type decodeField struct {
+	column string
+	// 字段名匹配时不区分大小写
+	fold  bool
+	index []int
+}
+
+// decodePlans reflect.Type -> []decodeField
+var decodePlans sync.Map
+
+func decodePlan(typ reflect.Type) []decodeField {
+	if plan, ok := decodePlans.Load(typ); ok {
+		return plan.([]decodeField)
+	}
+	var plan []decodeField
+	var walk func(typ reflect.Type, index []int)
+	walk = func(typ reflect.Type, index []int) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			idx := append(append([]int(nil), index...), i)
+			name, tagged := field.Tag.Lookup("db")
+			if !tagged {
+				name, tagged = field.Tag.Lookup("json")
+			}
+			name, _, _ = strings.Cut(name, ",")
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous && field.Type.Kind() == reflect.Struct && name == "" {
+				walk(field.Type, idx)
+				continue
+			}
+			if name == "" {
+				plan = append(plan, decodeField{column: field.Name, fold: true, index: idx})
+				continue
+			}
+			plan = append(plan, decodeField{column: name, index: idx})
+		}
+	}
+	walk(typ, nil)
+	decodePlans.Store(typ, plan)
+	return plan
+}
+
+func bodyFold(body map[string]interface{}, column string, fold bool) (interface{}, bool) {
+	if !fold {
+		return nil, false
+	}
+	for k, v := range body {
+		if strings.EqualFold(k, column) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	scannerType         = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// assignValue 把列值写入字段
+func assignValue(dst reflect.Value, src interface{}) error {
+	if reflect.PtrTo(dst.Type()).Implements(scannerType) {
+		return dst.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		elem := reflect.New(dst.Type().Elem())
+		if err := assignValue(elem.Elem(), src); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+	if s, ok := src.(string); ok {
+		if reflect.PtrTo(dst.Type()).Implements(textUnmarshalerType) {
+			return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+		return assignString(dst, s)
+	}
+	if isNumber(sv.Kind()) && isNumber(dst.Kind()) {
+		return assignNumber(dst, sv)
+	}
+	switch src.(type) {
+	case map[string]interface{}, []interface{}, json.RawMessage:
+		// json列及数组
+		data, err := json.Marshal(src)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, dst.Addr().Interface())
+	}
+	if sv.Type().ConvertibleTo(dst.Type()) && sv.Kind() == dst.Kind() {
+		dst.Set(sv.Convert(dst.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot assign %T to %s", src, dst.Type())
+}
+
+// assignString 文本写入字符串或数值字段
+func assignString(dst reflect.Value, s string) error {
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(b)
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot assign string to %s", dst.Type())
+		}
+		dst.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("cannot assign string to %s", dst.Type())
+	}
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// assignNumber 数值类型之间转换，超出字段范围或丢失小数部分时返回错误
+func assignNumber(dst, src reflect.Value) error {
+	converted := src.Convert(dst.Type())
+	negative := src.Kind() >= reflect.Int && src.Kind() <= reflect.Int64 && src.Int() < 0
+	unsigned := dst.Kind() >= reflect.Uint && dst.Kind() <= reflect.Uint64
+	if converted.Convert(src.Type()).Interface() != src.Interface() || (negative && unsigned) {
+		return fmt.Errorf("%v overflows %s", src.Interface(), dst.Type())
+	}
+	dst.Set(converted)
+	return nil
+}
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const (
+	pluginPgoutput    = "pgoutput"
+	pluginDecoderbufs = "decoderbufs"
+)
+
+// Decoderbufs 使用decoderbufs插件(protobuf格式)代替pgoutput，用于已统一使用该插件的部署
+// decoderbufs不使用发布流，复制槽需以decoderbufs插件创建，不支持Streaming/TwoPhase/Binary
+// 消息没有relation id，RelationID为按表名分配的编号，同一进程内保持不变；值按插件输出的类型转换为int32/int64/float32/float64/bool/string/[]byte，
+// point为[2]float64，未变化的TOAST值不包含在Body中
+func (t *Replication) Decoderbufs() *Replication {
+	t._plugin = pluginDecoderbufs
+	return t
+}
+
+// plugin 逻辑解码插件名称
+func (t *Replication) plugin() string {
+	if t._plugin == "" {
+		return pluginPgoutput
+	}
+	return t._plugin
+}
+
+// decoderbufs的RowMessage.op
+const (
+	dbufsInsert = 0
+	dbufsUpdate = 1
+	dbufsDelete = 2
+	dbufsBegin  = 3
+	dbufsCommit = 4
+)
+
+type dbufsDatum struct {
+	name    string
+	value   interface{}
+	missing bool
+}
+
+type dbufsRow struct {
+	xid        uint32
+	commitTime uint64 // unix epoch微秒
+	table      string
+	op         int64
+	newTuple   []dbufsDatum
+	oldTuple   []dbufsDatum
+}
+
+// protoReader protobuf编码读取，只实现decoderbufs用到的类型
+type protoReader struct {
+	buf []byte
+}
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errProtoTruncated
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+func (r *protoReader) fixed(size int) ([]byte, error) {
+	if len(r.buf) < size {
+		return nil, errProtoTruncated
+	}
+	b := r.buf[:size]
+	r.buf = r.buf[size:]
+	return b, nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)) {
+		return nil, errProtoTruncated
+	}
+	return r.fixed(int(n))
+}
+
+// field 读取下一个字段，value为varint/fixed的原始值或length-delimited的内容
+func (r *protoReader) field() (num int, wire int, varint uint64, data []byte, err error) {
+	key, err := r.varint()
+	if err != nil {
+		return
+	}
+	num, wire = int(key>>3), int(key&7)
+	switch wire {
+	case 0:
+		varint, err = r.varint()
+	case 1:
+		data, err = r.fixed(8)
+	case 2:
+		data, err = r.bytes()
+	case 5:
+		data, err = r.fixed(4)
+	default:
+		err = fmt.Errorf("unsupported protobuf wire type %d", wire)
+	}
+	return
+}
+
+func parseDbufsRow(src []byte) (row dbufsRow, err error) {
+	row.op = -1
+	r := &protoReader{buf: src}
+	for len(r.buf) > 0 {
+		num, _, varint, data, err := r.field()
+		if err != nil {
+			return row, err
+		}
+		switch num {
+		case 1:
+			row.xid = uint32(varint)
+		case 2:
+			row.commitTime = varint
+		case 3:
+			row.table = string(data)
+		case 4:
+			row.op = int64(int32(varint))
+		case 5, 6:
+			d, err := parseDbufsDatum(data)
+			if err != nil {
+				return row, err
+			}
+			if num == 5 {
+				row.newTuple = append(row.newTuple, d)
+			} else {
+				row.oldTuple = append(row.oldTuple, d)
+			}
+		}
+	}
+	return
+}
+
+func parseDbufsDatum(src []byte) (d dbufsDatum, err error) {
+	r := &protoReader{buf: src}
+	for len(r.buf) > 0 {
+		num, _, varint, data, err := r.field()
+		if err != nil {
+			return d, err
+		}
+		switch num {
+		case 1:
+			d.name = string(data)
+		case 3:
+			d.value = int32(varint)
+		case 4:
+			d.value = int64(varint)
+		case 5:
+			d.value = math.Float32frombits(binary.LittleEndian.Uint32(data))
+		case 6:
+			d.value = math.Float64frombits(binary.LittleEndian.Uint64(data))
+		case 7:
+			d.value = varint != 0
+		case 8:
+			d.value = string(data)
+		case 9:
+			d.value = append([]byte(nil), data...)
+		case 10:
+			var p [2]float64
+			pr := &protoReader{buf: data}
+			for len(pr.buf) > 0 {
+				n, _, _, v, err := pr.field()
+				if err != nil {
+					return d, err
+				}
+				if (n == 1 || n == 2) && len(v) == 8 {
+					p[n-1] = math.Float64frombits(binary.LittleEndian.Uint64(v))
+				}
+			}
+			d.value = p
+		case 11:
+			d.missing = varint != 0
+		}
+	}
+	return
+}
+
+// handleDecoderbufs 处理decoderbufs插件的消息
+func (t *Replication) handleDecoderbufs(message *pgx.WalMessage, dmlHandler ReplicationDMLHandler) error {
+	row, err := parseDbufsRow(message.WalData)
+	if err != nil {
+		return t.parseError(pluginDecoderbufs, message.WalData, err)
+	}
+	commitTime := time.Unix(0, int64(row.commitTime)*int64(time.Microsecond)).UTC()
+	switch row.op {
+	case dbufsBegin:
+		if len(t._flushMsg) > 0 {
+			t.debug("replication", "discard", len(t._flushMsg), "messages of unfinished transaction")
+		}
+		t._xid = row.xid
+		t._flushMsg = []ReplicationMessage{{EventType: EventType_BEGIN, Lsn: message.WalStart, CommitTime: commitTime, Xid: row.xid}}
+	case dbufsCommit:
+		return t.commit(message.WalStart, commitTime, dmlHandler)
+	case dbufsInsert, dbufsUpdate, dbufsDelete:
+		schema, table := "public", row.table
+		if ident, err := ParseTable(row.table); err == nil {
+			schema, table = ident[0], ident[1]
+		}
+		if !t.allowTable(schema, table) {
+			return nil
+		}
+		m := ReplicationMessage{Lsn: message.WalStart, RelationID: t.dbufsRelation(schema, table), SchemaName: schema, TableName: table, Xid: t._xid}
+		tuple := row.newTuple
+		switch row.op {
+		case dbufsInsert:
+			m.EventType = EventType_INSERT
+		case dbufsUpdate:
+			m.EventType = EventType_UPDATE
+		case dbufsDelete:
+			m.EventType = EventType_DELETE
+			tuple = row.oldTuple
+		}
+		m.Body = make(map[string]interface{}, len(tuple))
+		for _, d := range tuple {
+			if d.missing {
+				continue
+			}
+			m.Body[d.name] = d.value
+			m.Fields = append(m.Fields, Field{Name: d.name, Value: d.value})
+		}
+		if row.op == dbufsUpdate && len(row.oldTuple) > 0 {
+			old := make(map[string]interface{}, len(row.oldTuple))
+			for _, d := range row.oldTuple {
+				if !d.missing {
+					old[d.name] = d.value
+				}
+			}
+			for i, f := range m.Fields {
+				if v, ok := old[f.Name]; ok && !reflect.DeepEqual(v, f.Value) {
+					m.Fields[i].Changed = true
+					m.Columns = append(m.Columns, f.Name)
+				}
+			}
+			// 复制标识不是FULL时旧值只有复制标识列
+			if len(old) == len(m.Body) {
+				m.OldBody = old
+			}
+		}
+		t.project(&m)
+		t.buffer(m)
+	default:
+		t.debug("decoderbufs", "unknown op", row.op, row.table)
+	}
+	return nil
+}
+
+// dbufsRelation 按表名分配relation编号
+func (t *Replication) dbufsRelation(schema, table string) uint32 {
+	key := TableName(schema, table)
+	if t._dbufsIDs == nil {
+		t._dbufsIDs = map[string]uint32{}
+	}
+	id, ok := t._dbufsIDs[key]
+	if !ok {
+		id = uint32(len(t._dbufsIDs) + 1)
+		t._dbufsIDs[key] = id
+	}
+	return id
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// ListDatabases 获取服务器上所有可连接且名称匹配pattern的数据库，pattern为nil时不过滤
+func ListDatabases(config pgx.ConnConfig, pattern *regexp.Regexp) ([]string, error) {
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	rows, err := conn.Query("SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if pattern == nil || pattern.MatchString(name) {
+			res = append(res, name)
+		}
+	}
+	return res, rows.Err()
+}
+
+var slotNameInvalid = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// Discovery 发现服务器上名称匹配的数据库，为每个数据库启动一个同步流
+// 适用于每个租户一个数据库的多租户集群
+type Discovery struct {
+	config   pgx.ConnConfig
+	pattern  *regexp.Regexp
+	template string
+	interval time.Duration
+	setup    func(r *Replication) error
+	manager  *Manager
+}
+
+// NewDiscovery config为用于枚举数据库的连接(如postgres库)
+// template为复制槽和发布流名称模板，{database}会被替换为数据库名(转为小写，非法字符替换为_)
+func NewDiscovery(config pgx.ConnConfig, pattern *regexp.Regexp, template string) *Discovery {
+	return &Discovery{config: config, pattern: pattern, template: template, manager: NewManager()}
+}
+
+// Interval 定期重新发现数据库，新增的数据库会自动启动同步，0只在启动时发现一次
+func (d *Discovery) Interval(interval time.Duration) *Discovery {
+	d.interval = interval
+	return d
+}
+
+// Setup 每个数据库同步流启动前的初始化，如创建发布流、设置复制标识
+func (d *Discovery) Setup(setup func(r *Replication) error) *Discovery {
+	d.setup = setup
+	return d
+}
+
+// Manager 获取管理所有数据库同步流的Manager
+func (d *Discovery) Manager() *Manager {
+	return d.manager
+}
+
+// Name 根据模板生成数据库对应的复制槽和发布流名称
+// 超过63字节时截断数据库名并加入其hash，模板中的其他部分保持不变
+func (d *Discovery) Name(database string) string {
+	name := slotNameInvalid.ReplaceAllString(strings.ToLower(database), "_")
+	if n := strings.Count(d.template, "{database}"); n > 0 {
+		if over := len(d.template) + n*(len(name)-len("{database}")) - 63; over > 0 {
+			name = shortName(name, len(name)-(over+n-1)/n)
+		}
+	}
+	return strings.ReplaceAll(d.template, "{database}", name)
+}
+
+func (d *Discovery) discover(handler ReplicationDMLHandler, policy RestartPolicy) error {
+	databases, err := ListDatabases(d.config, d.pattern)
+	if err != nil {
+		return err
+	}
+	for _, database := range databases {
+		config := d.config
+		config.Database = database
+		name := d.Name(database)
+		if err = ValidateName(name); err != nil {
+			defaultLogger().Error("discovery", "database", database, "error", err)
+			continue
+		}
+		r := NewReplication(name, config)
+		if d.manager.Has(r) {
+			continue
+		}
+		if d.setup != nil {
+			if err = d.setup(r); err != nil {
+				defaultLogger().Error("discovery: setup", "database", database, "error", err)
+				continue
+			}
+		}
+		if err = d.manager.Add(r, handler, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start 发现数据库并启动同步，阻塞直到ctx取消或所有同步流退出
+func (d *Discovery) Start(ctx context.Context, handler ReplicationDMLHandler, policy RestartPolicy) error {
+	if err := d.discover(handler, policy); err != nil {
+		return err
+	}
+	var tick <-chan time.Time
+	if d.interval > 0 {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var errc chan error
+	for {
+		if errc == nil && len(d.manager.Streams()) > 0 {
+			errc = make(chan error, 1)
+			go func() { errc <- d.manager.Start(ctx) }()
+		}
+		if errc == nil && tick == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errc != nil {
+				return <-errc
+			}
+			return nil
+		case err := <-errc:
+			return err
+		case <-tick:
+			if err := d.discover(handler, policy); err != nil {
+				defaultLogger().Error("discovery", "error", err)
+			}
+		}
+	}
+}
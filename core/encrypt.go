@@ -0,0 +1,203 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 加密值的前缀，完整格式为 enc:v1:<key id>:<base64(nonce+密文)>
+const encryptedPrefix = "enc:v1:"
+
+// KeyProvider 字段加密的密钥来源，可对接KMS
+// DataKey返回当前用于加密的密钥，Key按id返回历史密钥用于解密，密钥长度需为16/24/32字节
+type KeyProvider interface {
+	DataKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys 固定密钥，Current为当前加密密钥的id，其他密钥只用于解密
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k StaticKeys) DataKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", id)
+	}
+	return key, nil
+}
+
+// FieldEncryptor 在消息交给handler前使用AES-GCM加密指定列的值，NULL不加密
+// 值先序列化为JSON再加密，密文绑定schema.table.column，不能被挪用到其他列
+//
+//	enc := core.NewFieldEncryptor(keys, "public.users.email", "users.phone")
+//	r.Start(ctx, enc.Handle(handler))
+type FieldEncryptor struct {
+	keys KeyProvider
+	// schema.table -> columns
+	columns map[string]map[string]bool
+}
+
+// NewFieldEncryptor columns格式为schema.table.column或table.column(默认public)
+func NewFieldEncryptor(keys KeyProvider, columns ...string) *FieldEncryptor {
+	e := &FieldEncryptor{keys: keys, columns: map[string]map[string]bool{}}
+	for _, c := range columns {
+		parts := strings.Split(c, ".")
+		if len(parts) == 2 {
+			parts = append([]string{"public"}, parts...)
+		}
+		if len(parts) != 3 {
+			panic(fmt.Sprintf("invalid encrypted column %s", c))
+		}
+		key := relationKey(parts[0], parts[1])
+		if e.columns[key] == nil {
+			e.columns[key] = map[string]bool{}
+		}
+		e.columns[key][parts[2]] = true
+	}
+	return e
+}
+
+// Handle 返回先加密再调用next的handler，加密失败时不调用next并返回DMLHandlerStatusContinue
+func (e *FieldEncryptor) Handle(next ReplicationDMLHandler) ReplicationDMLHandler {
+	return func(msg ...ReplicationMessage) DMLHandlerStatus {
+		res := make([]ReplicationMessage, len(msg))
+		for i, m := range msg {
+			var err error
+			if res[i], err = e.Encrypt(m); err != nil {
+				defaultLogger().Error("encrypt", "table", TableName(m.SchemaName, m.TableName), "error", err)
+				return DMLHandlerStatusContinue
+			}
+		}
+		return next(res...)
+	}
+}
+
+// Encrypt 加密消息中配置的列(包括OldBody中的旧值及Key)，返回的消息使用新的Body和Fields，不修改原消息
+func (e *FieldEncryptor) Encrypt(msg ReplicationMessage) (ReplicationMessage, error) {
+	columns := e.columns[relationKey(msg.SchemaName, msg.TableName)]
+	if len(columns) == 0 || (len(msg.Body) == 0 && len(msg.OldBody) == 0 && len(msg.Key) == 0) {
+		return msg, nil
+	}
+	// 同一消息的新旧值使用同一个数据密钥
+	var id string
+	var aead cipher.AEAD
+	seal := func(values map[string]interface{}) (map[string]interface{}, error) {
+		if values == nil {
+			return nil, nil
+		}
+		res := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			if !columns[k] || v == nil {
+				res[k] = v
+				continue
+			}
+			if aead == nil {
+				key, err := e.dataKey(&id)
+				if err != nil {
+					return nil, err
+				}
+				if aead, err = newGCM(key); err != nil {
+					return nil, err
+				}
+			}
+			plain, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", k, err)
+			}
+			nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+			if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+				return nil, err
+			}
+			sealed := aead.Seal(nonce, nonce, plain, encryptionAAD(msg.SchemaName, msg.TableName, k))
+			res[k] = encryptedPrefix + id + ":" + base64.StdEncoding.EncodeToString(sealed)
+		}
+		return res, nil
+	}
+	body, err := seal(msg.Body)
+	if err != nil {
+		return msg, err
+	}
+	old, err := seal(msg.OldBody)
+	if err != nil {
+		return msg, err
+	}
+	key, err := seal(msg.Key)
+	if err != nil {
+		return msg, err
+	}
+	msg.Body, msg.OldBody, msg.Key = body, old, key
+	if len(msg.Fields) > 0 {
+		fields := make(Fields, len(msg.Fields))
+		for i, f := range msg.Fields {
+			f.Value = body[f.Name]
+			fields[i] = f
+		}
+		msg.Fields = fields
+	}
+	return msg, nil
+}
+
+func (e *FieldEncryptor) dataKey(id *string) ([]byte, error) {
+	keyID, key, err := e.keys.DataKey()
+	if err != nil {
+		return nil, fmt.Errorf("data key: %w", err)
+	}
+	if strings.Contains(keyID, ":") {
+		return nil, fmt.Errorf("invalid key id %s", keyID)
+	}
+	*id = keyID
+	return key, nil
+}
+
+// Decrypt 解密Encrypt生成的值，返回原值的JSON
+func (e *FieldEncryptor) Decrypt(schema, table, column, value string) (json.RawMessage, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	key, err := e.keys.Key(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], encryptionAAD(schema, table, column))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptionAAD(schema, table, column string) []byte {
+	return []byte(relationKey(schema, table) + "." + column)
+}
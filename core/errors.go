@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+var (
+	// ErrSlotInvalidated 复制槽已失效(备库上与恢复冲突、WAL已被移除等)，需要重新创建
+	ErrSlotInvalidated = errors.New("replication slot invalidated")
+	// ErrSlotExists 复制槽已存在
+	ErrSlotExists = errors.New("replication slot already exists")
+	// ErrSlotInUse 复制槽正被其他连接使用
+	ErrSlotInUse = errors.New("replication slot is in use")
+	// ErrPublicationMissing 发布流不存在
+	ErrPublicationMissing = errors.New("publication does not exist")
+	// ErrWalLevelNotLogical 服务器未开启wal_level=logical
+	ErrWalLevelNotLogical = errors.New("wal_level is not logical")
+)
+
+// MissingTablesError 配置的表不存在
+type MissingTablesError struct {
+	Tables []string
+}
+
+func (e *MissingTablesError) Error() string {
+	return fmt.Sprintf("tables do not exist: %s", strings.Join(e.Tables, ", "))
+}
+
+// pgError 附加了错误类型的数据库错误，errors.Is可判断错误类型，errors.As可获取原始的pgx.PgError
+type pgError struct {
+	kind error
+	err  error
+}
+
+func (e *pgError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.err)
+}
+
+func (e *pgError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *pgError) Unwrap() error {
+	return e.err
+}
+
+// classifyError 识别常见的数据库错误，无法识别时原样返回
+func classifyError(err error) error {
+	var pgErr pgx.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	var kind error
+	switch {
+	case pgErr.Code == "42710" && strings.Contains(pgErr.Message, "replication slot"):
+		kind = ErrSlotExists
+	case pgErr.Code == "55006" && strings.Contains(pgErr.Message, "replication slot"):
+		// replication slot "x" is active for PID n
+		kind = ErrSlotInUse
+	case pgErr.Code == "42704" && strings.Contains(pgErr.Message, "publication"):
+		kind = ErrPublicationMissing
+	case pgErr.Code == "55000" && strings.Contains(pgErr.Message, "wal_level"):
+		// logical decoding requires wal_level >= logical
+		kind = ErrWalLevelNotLogical
+	case pgErr.Code == "55000" && (strings.Contains(pgErr.Message, "invalidated") || strings.Contains(pgErr.Message, "can no longer get changes")):
+		kind = ErrSlotInvalidated
+	default:
+		return err
+	}
+	return &pgError{kind: kind, err: err}
+}
+
+// SchemaDriftError 严格模式下表结构发生变化
+// Lsn为未确认的wal位置，重启后会从该事务重新开始
+type SchemaDriftError struct {
+	Lsn     uint64
+	Old     Relation
+	New     Relation
+	Changes []string
+}
+
+func (e *SchemaDriftError) Error() string {
+	return fmt.Sprintf("schema drift on %s.%s at %s: %s", e.New.Namespace, e.New.Name, pgx.FormatLSN(e.Lsn), strings.Join(e.Changes, "; "))
+}
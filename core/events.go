@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+)
+
+// Events 在后台启动同步，按顺序通过通道返回消息(包括READY/BEGIN/COMMIT等控制消息)，用于在调用方自己的select循环中消费
+// 通道没有及时消费时同步会阻塞，buffer为通道缓冲的消息数量
+// 消息不会自动确认，事务处理完成后需调用Ack确认EventType_COMMIT消息的Lsn
+// 同步结束(ctx取消、Stop或出错)后关闭通道，之后可通过Err获取结束原因
+//
+//	events := r.Events(ctx, 128)
+//	for m := range events {
+//		...
+//		if m.EventType == core.EventType_COMMIT {
+//			r.Ack(m.Lsn)
+//		}
+//	}
+//	err := r.Err()
+func (t *Replication) Events(ctx context.Context, buffer int) <-chan ReplicationMessage {
+	ch := make(chan ReplicationMessage, buffer)
+	t._mu.Lock()
+	t._err = nil
+	t._mu.Unlock()
+	go func() {
+		defer close(ch)
+		err := t.Start(ctx, func(msg ...ReplicationMessage) DMLHandlerStatus {
+			for _, m := range msg {
+				select {
+				case ch <- m:
+				case <-ctx.Done():
+					return DMLHandlerStatusContinue
+				}
+			}
+			return DMLHandlerStatusContinue
+		})
+		t._mu.Lock()
+		t._err = err
+		t._mu.Unlock()
+	}()
+	return ch
+}
+
+// Ack 确认lsn之前的变更已处理完成，保存checkpoint并向服务器发送状态
+// 可在消费消息的goroutine中调用，与Start定时发送的状态串行执行
+func (t *Replication) Ack(lsn uint64) error {
+	return t.confirm(lsn)
+}
+
+// Err Events的通道关闭后获取同步结束的原因，Stop停止时为nil
+func (t *Replication) Err() error {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._err
+}
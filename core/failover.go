@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// SlotInfo 复制槽状态
+type SlotInfo struct {
+	Name              string
+	Exists            bool
+	Active            bool
+	ConfirmedFlushLsn string
+	// PostgreSQL 17+：复制槽会同步到备库
+	Failover bool
+	// PostgreSQL 17+：复制槽是从主库同步而来
+	Synced bool
+	// PostgreSQL 15+：复制槽支持两阶段提交解码
+	TwoPhase bool
+	// 临时复制槽，创建它的连接关闭时自动删除
+	Temporary bool
+}
+
+// Failover 配置故障转移候选节点(host:port)，连接时自动选择当前主库
+// PostgreSQL 17+会以FAILOVER方式创建复制槽，复制槽同步到备库后，主备切换时可直接从新主库的同步槽继续消费
+func (t *Replication) Failover(hosts ...string) *Replication {
+	t._failoverHosts = hosts
+	return t
+}
+
+// connectPrimary 依次尝试候选节点，返回第一个不处于恢复状态的节点连接
+func (t *Replication) connectPrimary() (*pgx.ReplicationConn, time.Time, error) {
+	var lastErr error
+	for _, host := range t._failoverHosts {
+		config := t.config
+		h, p, err := net.SplitHostPort(host)
+		if err != nil {
+			config.Host = host
+		} else {
+			port, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return nil, time.Time{}, fmt.Errorf("invalid failover host %s", host)
+			}
+			config.Host, config.Port = h, uint16(port)
+		}
+		config, reconnectAt, err := t.connConfig(config)
+		if err != nil {
+			return nil, reconnectAt, err
+		}
+		conn, err := pgx.ReplicationConnect(config)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var recovery string
+		if err = conn.QueryRow("SELECT pg_is_in_recovery()::text").Scan(&recovery); err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+		if recovery == "true" {
+			conn.Close()
+			lastErr = fmt.Errorf("%s is in recovery", host)
+			continue
+		}
+		if t._primaryHost != "" && t._primaryHost != host {
+			t.debug("failover", t._primaryHost, "->", host)
+		}
+		t._primaryHost = host
+		return conn, reconnectAt, nil
+	}
+	return nil, time.Time{}, fmt.Errorf("no primary available: %v", lastErr)
+}
+
+// Slot 获取复制槽状态
+func (t *Replication) Slot() (info SlotInfo, err error) {
+	info.Name = t.name
+	f, err := t.Features()
+	if err != nil {
+		return
+	}
+	columns := "active::text, temporary::text, coalesce(confirmed_flush_lsn::text, '') AS confirmed_flush_lsn"
+	if f.TwoPhase {
+		columns += ", two_phase::text"
+	}
+	if f.FailoverSlots {
+		columns += ", failover::text, synced::text"
+	}
+	res, err := t.result(fmt.Sprintf("SELECT %s FROM pg_replication_slots WHERE slot_name = %s", columns, quoteLiteral(t.name)))
+	if err != nil || len(res) == 0 {
+		return
+	}
+	info.Exists = true
+	info.Active = res[0]["active"] == "true"
+	info.ConfirmedFlushLsn = fmt.Sprint(res[0]["confirmed_flush_lsn"])
+	info.Failover = res[0]["failover"] == "true"
+	info.Synced = res[0]["synced"] == "true"
+	info.TwoPhase = res[0]["two_phase"] == "true"
+	info.Temporary = res[0]["temporary"] == "true"
+	return
+}
+
+// TemporarySlot 以TEMPORARY方式创建复制槽，复制连接关闭时服务器自动删除，不会因消费者退出而遗留复制槽并持续保留wal
+// 适用于临时消费者及集成测试，重连后重新创建复制槽并从新的一致点开始，断开期间的变更会丢失
+// 最小权限模式下同样会创建(临时复制槽只需要REPLICATION权限)，不支持FAILOVER
+func (t *Replication) TemporarySlot() *Replication {
+	t._tempSlot = true
+	return t
+}
+
+// slotOptions 创建复制槽的选项
+// 配置Failover时PostgreSQL 17+以FAILOVER方式创建，复制槽已存在(包括从旧主库同步而来)时由CreateReplication直接复用
+func (t *Replication) slotOptions() (string, error) {
+	f, err := t.Features()
+	if err != nil {
+		return "", err
+	}
+	var options []string
+	if len(t._failoverHosts) > 0 {
+		if t._tempSlot {
+			t.debug("failover", "temporary slots cannot be failover slots")
+		} else if f.FailoverSlots {
+			options = append(options, "FAILOVER true")
+		} else {
+			t.debug("failover", "failover slots require PostgreSQL 17+", f.Version)
+		}
+	}
+	if t._twoPhase {
+		options = append(options, "TWO_PHASE true")
+	}
+	snapshot, legacy := "nothing", "NOEXPORT_SNAPSHOT"
+	if t._snapshot {
+		snapshot, legacy = "export", "EXPORT_SNAPSHOT"
+	}
+	if len(options) == 0 {
+		return legacy, nil
+	}
+	return fmt.Sprintf("(SNAPSHOT '%s', %s)", snapshot, strings.Join(options, ", ")), nil
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// ErrInjectedFault 故障注入产生的错误
+var ErrInjectedFault = errors.New("injected fault")
+
+// Faults 故障注入配置，用于在上线前验证重试、重连及lsn确认逻辑
+// 概率取值0~1
+type Faults struct {
+	// 每次读取消息时断开连接的概率
+	DropRate float64
+	// 服务器心跳被延迟的概率及延迟时间
+	HeartbeatDelayRate float64
+	HeartbeatDelay     time.Duration
+	// WAL消息被截断导致解析失败的概率
+	DecodeErrorRate float64
+	// 发送standby status失败的概率
+	AckErrorRate float64
+	// 随机数种子，相同的种子产生相同的故障序列，0为使用当前时间
+	Seed int64
+}
+
+type faultInjector struct {
+	faults Faults
+	mu     sync.Mutex
+	rnd    *rand.Rand
+}
+
+func (f *faultInjector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rnd.Float64() < rate
+}
+
+// InjectFaults 按配置的概率在复制流中注入故障
+func (t *Replication) InjectFaults(faults Faults) *Replication {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t._faults = &faultInjector{faults: faults, rnd: rand.New(rand.NewSource(seed))}
+	return t
+}
+
+type faultTransport struct {
+	Transport
+	injector *faultInjector
+}
+
+func (f *faultTransport) WaitForReplicationMessage(ctx context.Context) (*pgx.ReplicationMessage, error) {
+	faults := f.injector.faults
+	if f.injector.roll(faults.DropRate) {
+		f.Transport.Close()
+		return nil, fmt.Errorf("%w: connection dropped", ErrInjectedFault)
+	}
+	msg, err := f.Transport.WaitForReplicationMessage(ctx)
+	if err != nil || msg == nil {
+		return msg, err
+	}
+	if msg.ServerHeartbeat != nil && f.injector.roll(faults.HeartbeatDelayRate) {
+		timer := time.NewTimer(faults.HeartbeatDelay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if msg.WalMessage != nil && len(msg.WalMessage.WalData) > 1 && f.injector.roll(faults.DecodeErrorRate) {
+		wal := *msg.WalMessage
+		wal.WalData = wal.WalData[:1]
+		msg = &pgx.ReplicationMessage{WalMessage: &wal}
+	}
+	return msg, nil
+}
+
+func (f *faultTransport) SendStandbyStatus(k *pgx.StandbyStatus) error {
+	if f.injector.roll(f.injector.faults.AckErrorRate) {
+		return fmt.Errorf("%w: standby status", ErrInjectedFault)
+	}
+	return f.Transport.SendStandbyStatus(k)
+}
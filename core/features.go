@@ -0,0 +1,141 @@
+package core
+
+import (
+	"fmt"
+)
+
+// Features 根据服务器版本可使用的逻辑复制功能
+type Features struct {
+	// server_version_num，如160002
+	Version int
+	// pgoutput支持的最高协议版本：1(10+) 2(14+,大事务流式传输) 3(15+,两阶段提交) 4(16+,并行流式应用)
+	ProtoVersion int
+	// pgoutput binary选项，14+
+	Binary bool
+	// pgoutput messages选项(pg_logical_emit_message)，14+
+	Messages bool
+	// 大事务流式传输，14+
+	Streaming bool
+	// 两阶段提交解码，15+
+	TwoPhase bool
+	// 发布流行过滤(WHERE)及列列表，15+
+	RowFilter  bool
+	ColumnList bool
+	// FOR TABLES IN SCHEMA发布流，15+
+	SchemaPublication bool
+	// pgoutput origin选项，16+
+	Origin bool
+	// 备库逻辑解码，16+
+	StandbyDecoding bool
+	// FAILOVER复制槽，17+
+	FailoverSlots bool
+}
+
+// FeaturesFor 获取指定服务器版本可使用的功能
+func FeaturesFor(version int) Features {
+	f := Features{Version: version, ProtoVersion: 1}
+	if version >= 140000 {
+		f.ProtoVersion = 2
+		f.Binary, f.Messages, f.Streaming = true, true, true
+	}
+	if version >= 150000 {
+		f.ProtoVersion = 3
+		f.TwoPhase, f.RowFilter, f.ColumnList, f.SchemaPublication = true, true, true, true
+	}
+	if version >= 160000 {
+		f.ProtoVersion = 4
+		f.Origin, f.StandbyDecoding = true, true
+	}
+	if version >= 170000 {
+		f.FailoverSlots = true
+	}
+	return f
+}
+
+// UnsupportedFeatureError 配置的功能需要更高版本的服务器
+type UnsupportedFeatureError struct {
+	Feature string
+	// 需要的最低版本，如140000
+	Required int
+	Version  int
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("%s requires PostgreSQL %d+, server is %d", e.Feature, e.Required/10000, e.Version)
+}
+
+// Features 查询服务器版本并返回可使用的功能，结果在连接期间缓存
+func (t *Replication) Features() (Features, error) {
+	if t._features != nil {
+		return *t._features, nil
+	}
+	version, err := t.serverVersionNum()
+	if err != nil {
+		return Features{}, err
+	}
+	f := FeaturesFor(version)
+	t._features = &f
+	return f, nil
+}
+
+// require 检查服务器版本是否支持feature
+func (f Features) require(feature string, required int) error {
+	if f.Version < required {
+		return &UnsupportedFeatureError{Feature: feature, Required: required, Version: f.Version}
+	}
+	return nil
+}
+
+// negotiate 启动时查询服务器版本，检查配置的功能是否可用并选择协议版本
+func (t *Replication) negotiate() error {
+	// 重连后服务器可能已升级或切换到其他版本的节点
+	t._features = nil
+	f, err := t.Features()
+	if err != nil {
+		return err
+	}
+	if t._plugin == pluginDecoderbufs && (t._streaming || t._twoPhase || t._binary || t._backfill != nil) {
+		return fmt.Errorf("decoderbufs does not support streaming, two-phase, binary mode or incremental snapshots")
+	}
+	if t._binary {
+		if err = f.require("binary mode", 140000); err != nil {
+			return err
+		}
+	}
+	if t._streaming {
+		if err = f.require("streaming", 140000); err != nil {
+			return err
+		}
+	}
+	if t._twoPhase {
+		if err = f.require("two-phase decoding", 150000); err != nil {
+			return err
+		}
+	}
+	if t._backfill != nil {
+		if err = f.require("incremental snapshots", 140000); err != nil {
+			return err
+		}
+	}
+	t.debug("replication", "server version", f.Version, "proto_version", t.protoVersion(f))
+	return nil
+}
+
+// protoVersion 满足配置功能的最低协议版本，避免使用不需要的新协议
+func (t *Replication) protoVersion(f Features) string {
+	if t._twoPhase {
+		return "3"
+	}
+	if t._streaming {
+		return "2"
+	}
+	return "1"
+}
+
+// features 获取已协商的功能，自定义传输层没有数据库连接，按最新版本处理
+func (t *Replication) features() Features {
+	if t._features != nil {
+		return *t._features
+	}
+	return FeaturesFor(170000)
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IncludeTables 只同步匹配的表，其他表的变更在解析前丢弃，用于发布流为FOR ALL TABLES但只关心部分表的场景
+// pattern默认为glob(path.Match语法)，"re:"开头时为正则表达式
+// 包含"."时匹配"schema.table"，否则只匹配表名，如"public.order_*"、"audit_*"、"re:^sales\.(orders|items)$"
+func (t *Replication) IncludeTables(patterns ...string) *Replication {
+	t._include = patterns
+	t._filter = nil
+	return t
+}
+
+// ExcludeTables 不同步匹配的表，优先于IncludeTables，pattern格式同IncludeTables
+func (t *Replication) ExcludeTables(patterns ...string) *Replication {
+	t._exclude = patterns
+	t._filter = nil
+	return t
+}
+
+type tableMatcher struct {
+	qualified bool
+	glob      string
+	re        *regexp.Regexp
+}
+
+func newTableMatcher(pattern string) (m tableMatcher, err error) {
+	if strings.HasPrefix(pattern, "re:") {
+		expr := strings.TrimPrefix(pattern, "re:")
+		m.qualified = strings.Contains(expr, `\.`)
+		m.re, err = regexp.Compile(expr)
+		return
+	}
+	if _, err = path.Match(pattern, ""); err != nil {
+		return m, fmt.Errorf("invalid table pattern %s: %w", pattern, err)
+	}
+	m.qualified = strings.Contains(pattern, ".")
+	m.glob = pattern
+	return
+}
+
+func (m tableMatcher) match(schema, table string) bool {
+	name := table
+	if m.qualified {
+		name = schema + "." + table
+	}
+	if m.re != nil {
+		return m.re.MatchString(name)
+	}
+	ok, _ := path.Match(m.glob, name)
+	return ok
+}
+
+// tableFilter 按表名过滤变更，结果按表缓存
+type tableFilter struct {
+	include []tableMatcher
+	exclude []tableMatcher
+
+	mu    sync.Mutex
+	cache map[string]bool
+}
+
+func newTableFilter(include, exclude []string) (*tableFilter, error) {
+	f := &tableFilter{cache: map[string]bool{}}
+	for _, v := range include {
+		m, err := newTableMatcher(v)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, m)
+	}
+	for _, v := range exclude {
+		m, err := newTableMatcher(v)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, m)
+	}
+	return f, nil
+}
+
+// allow 表是否需要同步
+func (f *tableFilter) allow(schema, table string) bool {
+	key := schema + "." + table
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ok, cached := f.cache[key]; cached {
+		return ok
+	}
+	ok := len(f.include) == 0
+	for _, m := range f.include {
+		if m.match(schema, table) {
+			ok = true
+			break
+		}
+	}
+	for _, m := range f.exclude {
+		if ok && m.match(schema, table) {
+			ok = false
+		}
+	}
+	f.cache[key] = ok
+	return ok
+}
+
+// tableFilter 编译IncludeTables/ExcludeTables，没有配置时返回nil
+func (t *Replication) tableFilter() (*tableFilter, error) {
+	if t._filter != nil || (len(t._include) == 0 && len(t._exclude) == 0) {
+		return t._filter, nil
+	}
+	f, err := newTableFilter(t._include, t._exclude)
+	if err != nil {
+		return nil, err
+	}
+	t._filter = f
+	return f, nil
+}
+
+// allowTable 该表的变更是否需要同步
+func (t *Replication) allowTable(schema, table string) bool {
+	return t._filter == nil || t._filter.allow(schema, table)
+}
+
+// allowRelation 该relation的变更是否需要同步
+func (t *Replication) allowRelation(relation uint32) bool {
+	if t._filter == nil {
+		return true
+	}
+	return t.allowTable(t.set.Assist(relation))
+}
+
+// MessageFilter 返回false的行变更不会交给handler
+type MessageFilter func(msg ReplicationMessage) bool
+
+// Filter 在handler之前按行过滤INSERT/UPDATE/DELETE/TRUNCATE消息，如只保留指定租户的数据
+// 被过滤的消息不影响所在事务的确认，事务仍以EventType_COMMIT结束
+func (t *Replication) Filter(f MessageFilter) *Replication {
+	t._msgFilter = f
+	return t
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// ConnState 同步流的连接状态
+type ConnState string
+
+const (
+	// ConnStopped 未启动或Start已返回
+	ConnStopped ConnState = "stopped"
+	// ConnConnecting 正在连接、创建复制槽或读取初始快照
+	ConnConnecting ConnState = "connecting"
+	// ConnStreaming 正在接收复制流
+	ConnStreaming ConnState = "streaming"
+	// ConnPaused 已连接，Pause暂停中
+	ConnPaused ConnState = "paused"
+)
+
+// Health 同步流的运行状态
+type Health struct {
+	State ConnState `json:"state"`
+	// 最近一次收到服务器消息(包括心跳)的时间，服务器至少每wal_sender_timeout/2发送一次心跳
+	LastMessage time.Time `json:"-"`
+	ReceivedLsn uint64    `json:"-"`
+	FlushedLsn  uint64    `json:"-"`
+	// 服务器wal的最新位置
+	ServerLsn uint64 `json:"-"`
+	// 服务器wal最新位置与已确认位置的差
+	LagBytes uint64 `json:"lag_bytes"`
+	// Start因错误返回时的错误，ctx取消或Stop停止时为nil
+	LastError error `json:"-"`
+}
+
+func (h Health) MarshalJSON() ([]byte, error) {
+	type health Health
+	res := struct {
+		health
+		Received string     `json:"received_lsn"`
+		Flushed  string     `json:"flushed_lsn"`
+		Server   string     `json:"server_lsn"`
+		Error    string     `json:"last_error,omitempty"`
+		Message  *time.Time `json:"last_message,omitempty"`
+	}{health: health(h), Received: pgx.FormatLSN(h.ReceivedLsn), Flushed: pgx.FormatLSN(h.FlushedLsn), Server: pgx.FormatLSN(h.ServerLsn)}
+	if !h.LastMessage.IsZero() {
+		res.Message = &h.LastMessage
+	}
+	if h.LastError != nil {
+		res.Error = h.LastError.Error()
+	}
+	return json.Marshal(res)
+}
+
+// Health 当前的连接状态、最近收到消息的时间、已确认位置及延迟
+func (t *Replication) Health() Health {
+	t._mu.Lock()
+	h := Health{State: t._state, LastError: t._lastErr}
+	if h.State == "" {
+		h.State = ConnStopped
+	}
+	if h.State == ConnStreaming && t._pause != nil {
+		h.State = ConnPaused
+	}
+	t._mu.Unlock()
+	h.ReceivedLsn, h.FlushedLsn = t._lsn.positions()
+	h.ServerLsn, h.LastMessage = t._lsn.serverEnd(), t._lsn.lastMessage()
+	if h.ServerLsn > h.FlushedLsn {
+		h.LagBytes = h.ServerLsn - h.FlushedLsn
+	}
+	return h
+}
+
+// Live 同步流是否存活：Start没有因错误返回，接收复制流时staleAfter内收到过服务器消息
+// staleAfter为0时不检查消息时间，应大于wal_sender_timeout/2
+func (h Health) Live(staleAfter time.Duration) bool {
+	if h.LastError != nil {
+		return false
+	}
+	return h.State != ConnStreaming || staleAfter <= 0 || h.LastMessage.IsZero() || time.Since(h.LastMessage) < staleAfter
+}
+
+// Ready 是否已开始接收复制流(包括暂停中)
+func (h Health) Ready() bool {
+	return h.State == ConnStreaming || h.State == ConnPaused
+}
+
+// HealthHandler Kubernetes探针使用的http.Handler，/healthz对应Live，/readyz对应Ready
+// 检查通过时返回200，否则返回503，响应内容为JSON格式的Health
+//
+//	http.Handle("/", r.HealthHandler(time.Minute))
+func (t *Replication) HealthHandler(staleAfter time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	probe := func(check func(Health) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			h := t.Health()
+			w.Header().Set("Content-Type", "application/json")
+			if !check(h) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(h)
+		}
+	}
+	mux.Handle("/healthz", probe(func(h Health) bool { return h.Live(staleAfter) }))
+	mux.Handle("/readyz", probe(Health.Ready))
+	return mux
+}
+
+func (t *Replication) setState(state ConnState) {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	t._state = state
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// 复制槽/发布流名称，复制槽名称只允许小写字母、数字和下划线，最长63字节
+var namePattern = regexp.MustCompile(`^[a-z0-9_]{3,63}$`)
+
+// ValidateName 检查复制槽/发布流名称，只允许小写字母、数字和下划线，长度3到63字节
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid replication name %q: must match %s", name, namePattern)
+	}
+	return nil
+}
+
+// slotName name后追加suffix，超过63字节时按shortName截断name
+func slotName(name, suffix string) string {
+	return shortName(name, 63-len(suffix)) + suffix
+}
+
+// shortName 超过max字节时截断name并在末尾加入name的hash，不同的name得到不同的结果
+func shortName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hash := fmt.Sprintf("_%08x", h.Sum32())
+	if max <= len(hash) {
+		return hash[len(hash)-max:]
+	}
+	return name[:max-len(hash)] + hash
+}
+
+var unquotedIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// ParseIdentifier 解析可能带schema的标识符，如users、public.users、"Sales"."OrderItems"
+// 未加引号的部分按PostgreSQL规则转为小写，加引号的部分保持原样，引号内的""表示一个双引号
+func ParseIdentifier(name string) (pgx.Identifier, error) {
+	var ident pgx.Identifier
+	s := strings.TrimSpace(name)
+	for {
+		var part string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '"' {
+					if i+1 < len(s) && s[i+1] == '"' {
+						b.WriteByte('"')
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			if i >= len(s) || b.Len() == 0 {
+				return nil, fmt.Errorf("invalid identifier %q", name)
+			}
+			part, s = b.String(), s[i+1:]
+		} else {
+			end := strings.IndexByte(s, '.')
+			if end < 0 {
+				end = len(s)
+			}
+			if !unquotedIdentifier.MatchString(s[:end]) {
+				return nil, fmt.Errorf("invalid identifier %q", name)
+			}
+			part, s = strings.ToLower(s[:end]), s[end:]
+		}
+		ident = append(ident, part)
+		if s == "" {
+			break
+		}
+		if s[0] != '.' || len(ident) >= 2 {
+			return nil, fmt.Errorf("invalid identifier %q", name)
+		}
+		s = s[1:]
+	}
+	return ident, nil
+}
+
+// ParseTable 解析表名，格式同ParseIdentifier，未指定schema时默认为public
+func ParseTable(name string) (pgx.Identifier, error) {
+	ident, err := ParseIdentifier(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(ident) == 1 {
+		ident = pgx.Identifier{"public", ident[0]}
+	}
+	return ident, nil
+}
+
+// TableSpec 发布流中的表，可带列列表及行过滤条件(PostgreSQL 15+)，
+// 如public.orders(id, status, total)、public.orders WHERE (region = 'eu')
+type TableSpec struct {
+	Schema string
+	Name   string
+	// 发布的列，为空时发布所有列
+	Columns []string
+	// 行过滤条件，不含WHERE关键字，为空时发布所有行
+	// 服务器只发送满足条件的行，update/delete时条件中的列需包含在复制标识中
+	Where string
+}
+
+// ParseTableSpec 解析带列列表及行过滤条件的表名，格式为"表名[(列, ...)] [WHERE (条件)]"，
+// 表名格式同ParseTable，列名格式同ParseIdentifier(不带schema)，条件原样使用，未加括号时自动加上
+func ParseTableSpec(spec string) (TableSpec, error) {
+	s := strings.TrimSpace(spec)
+	var where string
+	if i := indexKeyword(s, "where"); i >= 0 {
+		s, where = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len("where"):])
+		if where == "" {
+			return TableSpec{}, fmt.Errorf("empty row filter in %q", spec)
+		}
+		if !enclosed(where) {
+			where = "(" + where + ")"
+		}
+	}
+	name, rest := s, ""
+	if i := indexUnquoted(s, '('); i >= 0 {
+		name, rest = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i:])
+	}
+	ident, err := ParseTable(name)
+	if err != nil {
+		return TableSpec{}, err
+	}
+	t := TableSpec{Schema: ident[0], Name: ident[1], Where: where}
+	if rest == "" {
+		return t, nil
+	}
+	if !strings.HasSuffix(rest, ")") {
+		return t, fmt.Errorf("invalid column list in %q", spec)
+	}
+	for _, v := range splitUnquoted(rest[1:len(rest)-1], ',') {
+		col, err := ParseIdentifier(v)
+		if err != nil || len(col) != 1 {
+			return t, fmt.Errorf("invalid column %q in %q", v, spec)
+		}
+		t.Columns = append(t.Columns, col[0])
+	}
+	return t, nil
+}
+
+// Table 规范表名，格式见TableName
+func (t TableSpec) Table() string {
+	return TableName(t.Schema, t.Name)
+}
+
+// String 规范格式，如public.orders(id, status) WHERE (region = 'eu')
+func (t TableSpec) String() string {
+	s := t.Table()
+	if len(t.Columns) > 0 {
+		columns := make([]string, 0, len(t.Columns))
+		for _, v := range t.Columns {
+			columns = append(columns, canonicalPart(v))
+		}
+		s = fmt.Sprintf("%s(%s)", s, strings.Join(columns, ", "))
+	}
+	if t.Where != "" {
+		s += " WHERE " + t.Where
+	}
+	return s
+}
+
+// sql 用于CREATE/ALTER PUBLICATION的表定义
+func (t TableSpec) sql() string {
+	s := pgx.Identifier{t.Schema, t.Name}.Sanitize()
+	if len(t.Columns) > 0 {
+		columns := make([]string, 0, len(t.Columns))
+		for _, v := range t.Columns {
+			columns = append(columns, pgx.Identifier{v}.Sanitize())
+		}
+		s += " (" + strings.Join(columns, ", ") + ")"
+	}
+	if t.Where != "" {
+		s += " WHERE " + t.Where
+	}
+	return s
+}
+
+// SplitTables 按逗号拆分表列表，括号和引号中的逗号不拆分，如"public.orders(id, status),users WHERE (id > 10)"
+func SplitTables(s string) []string {
+	var res []string
+	for _, v := range splitUnquoted(s, ',') {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// indexUnquoted 查找不在双引号中的字符
+func indexUnquoted(s string, c byte) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == c && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+// indexKeyword 查找不在引号和括号中的关键字(不区分大小写，前后需为空白或括号)
+func indexKeyword(s, keyword string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && i > 0 && isSpace(s[i-1]) && strings.HasPrefix(strings.ToLower(s[i:]), keyword):
+			if end := i + len(keyword); end == len(s) || isSpace(s[end]) || s[end] == '(' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// enclosed 整个表达式是否在一对括号中，如(a) OR (b)不是
+func enclosed(s string) bool {
+	if !strings.HasPrefix(s, "(") {
+		return false
+	}
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth == 0 {
+				return i == len(s)-1
+			}
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// splitUnquoted 按不在引号和括号中的分隔符拆分
+func splitUnquoted(s string, sep byte) []string {
+	var res []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			res = append(res, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(res, strings.TrimSpace(s[start:]))
+}
+
+// TableName 表的规范名称schema.table，只有需要时才加引号，如public.users、"Sales"."OrderItems"
+// 同一张表的不同写法(users、public.users、"public"."users")得到相同的名称
+func TableName(schema, table string) string {
+	return canonicalPart(schema) + "." + canonicalPart(table)
+}
+
+var lowerIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+func canonicalPart(s string) string {
+	if lowerIdentifier.MatchString(s) {
+		return s
+	}
+	return pgx.Identifier{s}.Sanitize()
+}
+
+// quoteTable 解析并转义表名，用于拼接sql，忽略列列表及行过滤条件
+func quoteTable(name string) (string, error) {
+	spec, err := ParseTableSpec(name)
+	if err != nil {
+		return "", err
+	}
+	return pgx.Identifier{spec.Schema, spec.Name}.Sanitize(), nil
+}
+
+// quoteTables 解析并转义多个表名，以逗号分隔
+func quoteTables(names []string) (string, error) {
+	quoted := make([]string, 0, len(names))
+	for _, v := range names {
+		q, err := quoteTable(v)
+		if err != nil {
+			return "", err
+		}
+		quoted = append(quoted, q)
+	}
+	return strings.Join(quoted, ", "), nil
+}
+
+// publicationTables 解析并转义多个表定义(包括列列表及行过滤条件)，以逗号分隔，用于CREATE/ALTER PUBLICATION ... ADD TABLE
+func publicationTables(names []string) (sql string, specs []TableSpec, err error) {
+	quoted := make([]string, 0, len(names))
+	for _, v := range names {
+		spec, err := ParseTableSpec(v)
+		if err != nil {
+			return "", nil, err
+		}
+		specs = append(specs, spec)
+		quoted = append(quoted, spec.sql())
+	}
+	return strings.Join(quoted, ", "), specs, nil
+}
+
+// quoteLiteral 转义字符串常量
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
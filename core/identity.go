@@ -0,0 +1,75 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// RestoreIdentityOnDrop DropReplication时把SetReplicaIdentity修改过的表恢复为原来的复制标识
+// REPLICA IDENTITY FULL会使update/delete的wal包含整行旧值，同步结束后不恢复会一直增加wal
+func (t *Replication) RestoreIdentityOnDrop() *Replication {
+	t._restoreIdent = true
+	return t
+}
+
+// currentReplicaIdentity 获取表当前的复制标识，USING INDEX时包含索引名
+func (t *Replication) currentReplicaIdentity(table string) (ReplicaIdentity, error) {
+	res, err := t.result(fmt.Sprintf(`SELECT c.relreplident::text AS ident, coalesce((SELECT i.relname::text FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+WHERE x.indrelid = c.oid AND x.indisreplident), '') AS index FROM pg_class c WHERE c.oid = %s::regclass`, quoteLiteral(table)))
+	if err != nil || len(res) == 0 {
+		return "", err
+	}
+	switch res[0]["ident"] {
+	case "f":
+		return ReplicaIdentityFull, nil
+	case "n":
+		return ReplicaIdentityNothing, nil
+	case "i":
+		return ReplicaIdentity("USING INDEX " + pgx.Identifier{fmt.Sprint(res[0]["index"])}.Sanitize()), nil
+	}
+	return ReplicaIdentityDefault, nil
+}
+
+// recordReplicaIdentity 修改前记录表原来的复制标识，已记录过的表保留最初的值
+func (t *Replication) recordReplicaIdentity(name, table string, status ReplicaIdentity) error {
+	key := qualifiedTable(name)
+	if _, ok := t._identities[key]; ok {
+		return nil
+	}
+	current, err := t.currentReplicaIdentity(table)
+	if err != nil || current == "" || current == status {
+		return err
+	}
+	if t._identities == nil {
+		t._identities = make(map[string]ReplicaIdentity)
+	}
+	t._identities[key] = current
+	return nil
+}
+
+// OriginalReplicaIdentities SetReplicaIdentity修改过的表及其原来的复制标识，格式见TableName
+// 只保存在内存中，需要跨进程恢复时由调用方持久化，之后用SetReplicaIdentity逐表恢复
+func (t *Replication) OriginalReplicaIdentities() map[string]ReplicaIdentity {
+	res := make(map[string]ReplicaIdentity, len(t._identities))
+	for k, v := range t._identities {
+		res[k] = v
+	}
+	return res
+}
+
+// RestoreReplicaIdentity 把SetReplicaIdentity修改过的表恢复为原来的复制标识
+func (t *Replication) RestoreReplicaIdentity() error {
+	for name, status := range t._identities {
+		table, err := quoteTable(name)
+		if err != nil {
+			return err
+		}
+		if err = t.execEx(fmt.Sprintf("ALTER TABLE %s replica identity %s", table, status)); err != nil {
+			return err
+		}
+		t.debug("identity", "restored", name, status)
+		delete(t._identities, name)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Iterator 拉取方式消费同步流，类似bufio.Scanner，消费者按自己的节奏调用Next，未调用时同步阻塞
+// 消息不会自动确认，事务处理完成后调用Commit确认最近一个EventType_COMMIT，或调用Ack确认指定lsn
+//
+//	it := r.Iterator()
+//	defer it.Close()
+//	for it.Next(ctx) {
+//		m := it.Message()
+//		...
+//		if m.EventType == core.EventType_COMMIT {
+//			it.Commit()
+//		}
+//	}
+//	err := it.Err()
+type Iterator struct {
+	r *Replication
+
+	once   sync.Once
+	cancel context.CancelFunc
+	events <-chan ReplicationMessage
+	msg    ReplicationMessage
+	commit uint64
+	err    error
+}
+
+// Iterator 创建拉取方式的消费者，第一次调用Next时在后台启动同步，Close时停止
+func (t *Replication) Iterator() *Iterator {
+	return &Iterator{r: t}
+}
+
+// Next 等待下一条消息，返回false时同步已结束或ctx已结束，原因见Err
+// ctx只控制本次等待，ctx结束后可使用新的ctx继续调用Next
+func (it *Iterator) Next(ctx context.Context) bool {
+	it.once.Do(func() {
+		var bg context.Context
+		bg, it.cancel = context.WithCancel(context.Background())
+		it.events = it.r.Events(bg, 0)
+	})
+	select {
+	case m, ok := <-it.events:
+		if !ok {
+			it.err = it.r.Err()
+			if it.err == nil {
+				it.err = io.EOF
+			}
+			return false
+		}
+		it.msg, it.err = m, nil
+		if m.EventType == EventType_COMMIT {
+			it.commit = m.Lsn
+		}
+		return true
+	case <-ctx.Done():
+		it.err = ctx.Err()
+		return false
+	}
+}
+
+// Message Next返回true后获取当前消息
+func (it *Iterator) Message() ReplicationMessage {
+	return it.msg
+}
+
+// Err Next返回false的原因，Stop/Close正常结束时为io.EOF
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Commit 确认最近一个已通过Next返回的EventType_COMMIT，没有时不做任何操作
+func (it *Iterator) Commit() error {
+	if it.commit == 0 {
+		return nil
+	}
+	return it.r.Ack(it.commit)
+}
+
+// Ack 确认lsn之前的变更已处理完成
+func (it *Iterator) Ack(lsn uint64) error {
+	return it.r.Ack(lsn)
+}
+
+// Close 发送最终状态后停止同步
+func (it *Iterator) Close() error {
+	if it.cancel == nil {
+		return nil
+	}
+	defer it.cancel()
+	done := make(chan struct{})
+	go func() {
+		// Stop等待handler处理完当前事务，handler阻塞在未被消费的通道上时需要丢弃剩余消息
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-it.events:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	defer close(done)
+	return it.r.Stop(context.Background())
+}
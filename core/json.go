@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSONPolicy json/jsonb列的解码方式
+type JSONPolicy int
+
+const (
+	// JSONDecode 用encoding/json解码为map[string]interface{}、[]interface{}等，数字为float64(默认)
+	JSONDecode JSONPolicy = iota
+	// JSONNumber 同JSONDecode，数字为json.Number，大整数及小数不丢失精度
+	JSONNumber
+	// JSONRaw 值为原始文本json.RawMessage，序列化时原样输出，handler按需解码
+	JSONRaw
+	// JSONString 值为原始文本的字符串
+	JSONString
+)
+
+// JSONColumns json/jsonb列的解码方式
+// 初始快照及增量快照的行由查询连接解码，JSONNumber/JSONRaw/JSONString时重新编码，数字精度同JSONDecode
+func (t *Replication) JSONColumns(policy JSONPolicy) *Replication {
+	t._json = policy
+	return t
+}
+
+// jsonValue 按JSONColumns转换json文本，解码失败时返回文本
+func (t *Replication) jsonValue(data []byte) interface{} {
+	switch t._json {
+	case JSONNumber:
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return string(data)
+		}
+		return v
+	case JSONRaw:
+		// 列值引用wal消息的缓冲区，需要复制
+		return json.RawMessage(append([]byte(nil), data...))
+	case JSONString:
+		return string(data)
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+	return v
+}
+
+// snapshotJSON 快照查询连接已解码的json列按JSONColumns重新编码
+func (t *Replication) snapshotJSON(v interface{}) interface{} {
+	if t._json == JSONDecode || v == nil {
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	return t.jsonValue(data)
+}
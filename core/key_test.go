@@ -0,0 +1,47 @@
+package core_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// insert/update/delete的Key都是复制标识列，REPLICA IDENTITY FULL的insert没有Key
+func TestKey(t *testing.T) {
+	for _, replica := range []byte{'d', 'f'} {
+		cols := []core.Column{mock.Key("id", pgtype.Int4OID), mock.Col("name", pgtype.TextOID)}
+		if replica == 'f' {
+			cols[1] = mock.Key("name", pgtype.TextOID)
+		}
+		src := mock.NewSource().Relation(core.Relation{ID: 1, Namespace: "public", Name: "users", Replica: replica, Columns: cols}).
+			Begin().Insert(1, 1, "tom").Update(1, nil, []interface{}{1, "amy"}).Delete(1, 1, "amy").Commit().End()
+		keys := map[core.EventType]map[string]interface{}{}
+		core.NewReplication("users_slot", pgx.ConnConfig{}).WithTransport(src).
+			Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+				for _, m := range msg {
+					if m.RelationID > 0 {
+						keys[m.EventType] = m.Key
+					}
+				}
+				return core.DMLHandlerStatusSuccess
+			})
+		want := map[core.EventType]map[string]interface{}{
+			core.EventType_INSERT: {"id": int32(1)},
+			core.EventType_UPDATE: {"id": int32(1)},
+			core.EventType_DELETE: {"id": int32(1)},
+		}
+		if replica == 'f' {
+			want[core.EventType_INSERT] = nil
+			want[core.EventType_UPDATE] = map[string]interface{}{"id": int32(1), "name": "amy"}
+			want[core.EventType_DELETE] = map[string]interface{}{"id": int32(1), "name": "amy"}
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("replica %c: keys %v, want %v", replica, keys, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const lagQuery = `SELECT (CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END)::text,
+	coalesce(s.confirmed_flush_lsn::text, '0/0'), coalesce(extract(epoch FROM r.flush_lag), 0)::text
+FROM pg_replication_slots s LEFT JOIN pg_stat_replication r ON r.pid = s.active_pid
+WHERE s.slot_name = $1`
+
+// Lag 复制槽的延迟
+type Lag struct {
+	// 服务器wal的当前位置(备库为已回放的位置)
+	ServerLsn uint64
+	// 复制槽的confirmed_flush_lsn
+	ConfirmedLsn uint64
+	// ServerLsn与ConfirmedLsn的差
+	Bytes uint64
+	// pg_stat_replication.flush_lag：服务器写入wal到收到本端确认的时间，没有待确认的变更时为0
+	Duration time.Duration
+	// 查询时间
+	CheckedAt time.Time
+}
+
+// LagThreshold 延迟告警阈值，为0的项不检查
+type LagThreshold struct {
+	Bytes    uint64
+	Duration time.Duration
+}
+
+func (l LagThreshold) exceeded(lag Lag) bool {
+	return (l.Bytes > 0 && lag.Bytes > l.Bytes) || (l.Duration > 0 && lag.Duration > l.Duration)
+}
+
+type lagMonitor struct {
+	interval  time.Duration
+	threshold LagThreshold
+	fn        func(lag Lag, exceeded bool)
+
+	mu       sync.Mutex
+	last     Lag
+	exceeded bool
+}
+
+// LagMonitor 同步期间按interval使用独立的普通连接查询复制槽的延迟，通过Lag()获取最近结果
+// 延迟超过threshold时调用fn(lag, true)，之后回到阈值以内时调用fn(lag, false)，只在状态变化时调用
+// fn在查询的goroutine中执行，不应阻塞；fn为nil时只记录延迟
+func (t *Replication) LagMonitor(interval time.Duration, threshold LagThreshold, fn func(lag Lag, exceeded bool)) *Replication {
+	t._lag = &lagMonitor{interval: interval, threshold: threshold, fn: fn}
+	return t
+}
+
+// Lag LagMonitor最近一次查询到的延迟，未配置或尚未查询时为零值
+func (t *Replication) Lag() Lag {
+	if t._lag == nil {
+		return Lag{}
+	}
+	t._lag.mu.Lock()
+	defer t._lag.mu.Unlock()
+	return t._lag.last
+}
+
+func (t *Replication) monitorLag(ctx context.Context) {
+	t.poll(ctx, "lag", t._lag.interval, func(conn *pgx.Conn, fresh bool) bool {
+		lag, err := queryLag(conn, t.name)
+		if err != nil {
+			t.debug("lag", "query", err)
+			return true
+		}
+		t._lag.update(lag)
+		return true
+	})
+}
+
+func queryLag(conn *pgx.Conn, slot string) (lag Lag, err error) {
+	var current, confirmed, seconds string
+	if err = conn.QueryRow(lagQuery, slot).Scan(&current, &confirmed, &seconds); err != nil {
+		return
+	}
+	if lag.ServerLsn, err = pgx.ParseLSN(current); err != nil {
+		return
+	}
+	if lag.ConfirmedLsn, err = pgx.ParseLSN(confirmed); err != nil {
+		return
+	}
+	if lag.ServerLsn > lag.ConfirmedLsn {
+		lag.Bytes = lag.ServerLsn - lag.ConfirmedLsn
+	}
+	s, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return
+	}
+	lag.Duration = time.Duration(s * float64(time.Second))
+	lag.CheckedAt = time.Now()
+	return
+}
+
+// update 记录延迟，是否超过阈值的状态变化时调用fn
+func (m *lagMonitor) update(lag Lag) {
+	exceeded := m.threshold.exceeded(lag)
+	m.mu.Lock()
+	m.last = lag
+	changed := exceeded != m.exceeded
+	m.exceeded = exceeded
+	m.mu.Unlock()
+	if changed && m.fn != nil {
+		m.fn(lag, exceeded)
+	}
+}
@@ -1,11 +1,168 @@
 package core
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Logger 结构化日志，fields为交替的键值对(key1, value1, key2, value2...)
+// *slog.Logger直接实现了该接口；zap使用SugaredLogger包装*zap.SugaredLogger；
+// logrus等其他日志库可用LoggerFunc适配:
+//
+//	core.SetLogger(core.LoggerFunc(func(level core.LogLevel, msg string, fields ...interface{}) {
+//		levels := [...]logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel}
+//		logrus.WithFields(core.FieldMap(fields)).Log(levels[level], msg)
+//	}))
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// LoggerFunc 以函数实现Logger
+type LoggerFunc func(level LogLevel, msg string, fields ...interface{})
+
+func (f LoggerFunc) Debug(msg string, fields ...interface{}) { f(LevelDebug, msg, fields...) }
+func (f LoggerFunc) Info(msg string, fields ...interface{})  { f(LevelInfo, msg, fields...) }
+func (f LoggerFunc) Warn(msg string, fields ...interface{})  { f(LevelWarn, msg, fields...) }
+func (f LoggerFunc) Error(msg string, fields ...interface{}) { f(LevelError, msg, fields...) }
+
+// SugaredLogger 包装*zap.SugaredLogger
+//
+//	core.SetLogger(core.SugaredLogger(zapLogger.Sugar()))
+func SugaredLogger(l interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}) Logger {
+	return LoggerFunc(func(level LogLevel, msg string, fields ...interface{}) {
+		switch level {
+		case LevelDebug:
+			l.Debugw(msg, fields...)
+		case LevelInfo:
+			l.Infow(msg, fields...)
+		case LevelWarn:
+			l.Warnw(msg, fields...)
+		default:
+			l.Errorw(msg, fields...)
+		}
+	})
+}
+
+// FieldMap 键值对转换为map，键不是string时使用fmt.Sprint，缺少值的键对应nil
+func FieldMap(fields []interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		var v interface{}
+		if i+1 < len(fields) {
+			v = fields[i+1]
+		}
+		res[fmt.Sprint(fields[i])] = v
+	}
+	return res
+}
+
+var (
+	loggerMu      sync.RWMutex
+	packageLogger Logger
+)
+
+// SetLogger 设置默认的Logger，用于未配置WithLogger的Replication及Coordinator、Discovery等，nil恢复为标准库log
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	packageLogger = l
+}
+
+// defaultLogger SetLogger设置的Logger，未设置时为标准库log(不输出Debug)
+func defaultLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	if packageLogger != nil {
+		return packageLogger
+	}
+	return stdLogger{}
+}
+
+// WithLogger 设置该同步流使用的Logger
+// 配置了Logger(包括SetLogger)时调试日志总是以Debug级别输出，由Logger决定是否记录；未配置时只在Debug()后输出
+func (t *Replication) WithLogger(l Logger) *Replication {
+	t._logger = l
+	return t
+}
+
+func (t *Replication) logger() Logger {
+	if t._logger != nil {
+		return t._logger
+	}
+	l := defaultLogger()
+	if std, ok := l.(stdLogger); ok && t._debug {
+		std.debug = true
+		return std
+	}
+	return l
+}
 
 func (t *Replication) debug(name string, args ...interface{}) {
-	if !t._debug {
+	l := t.logger()
+	if std, ok := l.(stdLogger); ok && !std.debug {
 		return
 	}
-	args = append([]interface{}{name}, args...)
-	log.Println(args...)
+	l.Debug(strings.TrimSuffix(fmt.Sprintln(args...), "\n"), "component", name, "slot", t.name)
+}
+
+// stdLogger 标准库log，格式为"LEVEL msg key=value ..."
+type stdLogger struct {
+	debug bool
+}
+
+func (l stdLogger) Debug(msg string, fields ...interface{}) {
+	if l.debug {
+		l.print(LevelDebug, msg, fields)
+	}
+}
+func (l stdLogger) Info(msg string, fields ...interface{})  { l.print(LevelInfo, msg, fields) }
+func (l stdLogger) Warn(msg string, fields ...interface{})  { l.print(LevelWarn, msg, fields) }
+func (l stdLogger) Error(msg string, fields ...interface{}) { l.print(LevelError, msg, fields) }
+
+func (l stdLogger) print(level LogLevel, msg string, fields []interface{}) {
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		var v interface{}
+		if i+1 < len(fields) {
+			v = fields[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", fields[i], v)
+	}
+	log.Println(b.String())
 }
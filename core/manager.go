@@ -0,0 +1,234 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RestartPolicy 同步流异常退出后的重启策略
+type RestartPolicy struct {
+	// 最大重启次数，0不重启，小于0无限重启
+	MaxRestarts int
+	// 首次重启等待时间，之后每次翻倍
+	Backoff time.Duration
+	// 重启等待时间上限，0不限制
+	MaxBackoff time.Duration
+}
+
+func (p RestartPolicy) delay(restarts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < restarts && i < 32; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// StreamStats 单个同步流的运行状态
+type StreamStats struct {
+	Name      string
+	Database  string
+	Running   bool
+	Restarts  int
+	Messages  uint64
+	LastLsn   uint64
+	LastError error
+}
+
+// ManagerStats 所有同步流的汇总状态
+type ManagerStats struct {
+	Streams  int
+	Running  int
+	Restarts int
+	Messages uint64
+}
+
+type managedStream struct {
+	replication *Replication
+	handler     ReplicationDMLHandler
+	policy      RestartPolicy
+
+	mu    sync.Mutex
+	stats StreamStats
+}
+
+func (s *managedStream) snapshot() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *managedStream) handle(msg ...ReplicationMessage) DMLHandlerStatus {
+	status := s.handler(msg...)
+	s.mu.Lock()
+	for _, m := range msg {
+		if m.EventType == EventType_READY {
+			continue
+		}
+		s.stats.Messages++
+		if status == DMLHandlerStatusSuccess && m.Lsn > s.stats.LastLsn {
+			s.stats.LastLsn = m.Lsn
+		}
+	}
+	s.mu.Unlock()
+	return status
+}
+
+func (s *managedStream) run(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		s.stats.Running = true
+		s.mu.Unlock()
+		err := s.replication.Start(ctx, s.handle)
+		s.mu.Lock()
+		s.stats.Running = false
+		s.stats.LastError = err
+		restarts := s.stats.Restarts
+		s.mu.Unlock()
+		if ctx.Err() != nil || err == nil {
+			// err为nil时同步流已被Replication.Stop停止
+			return nil
+		}
+		if s.policy.MaxRestarts >= 0 && restarts >= s.policy.MaxRestarts {
+			return fmt.Errorf("%s: %v", s.stats.Name, err)
+		}
+		s.replication.debug("manager", "restart", s.stats.Name, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.policy.delay(restarts + 1)):
+		}
+		s.mu.Lock()
+		s.stats.Restarts++
+		s.mu.Unlock()
+	}
+}
+
+// Manager 在同一进程内管理多个数据库/复制槽的同步流
+type Manager struct {
+	mu      sync.Mutex
+	streams map[string]*managedStream
+
+	// 运行中的状态
+	ctx      context.Context
+	active   int
+	done     chan struct{}
+	firstErr error
+}
+
+func NewManager() *Manager {
+	return &Manager{streams: map[string]*managedStream{}}
+}
+
+func streamKey(r *Replication) string {
+	return r.config.Database + "/" + r.name
+}
+
+// Add 添加同步流，同一数据库下的复制槽名称不可重复
+// Manager运行中添加的同步流会立即启动
+func (m *Manager) Add(r *Replication, handler ReplicationDMLHandler, policy RestartPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := streamKey(r)
+	if _, ok := m.streams[key]; ok {
+		return fmt.Errorf("stream %s already exists", key)
+	}
+	s := &managedStream{
+		replication: r,
+		handler:     handler,
+		policy:      policy,
+		stats:       StreamStats{Name: r.name, Database: r.config.Database},
+	}
+	m.streams[key] = s
+	if m.ctx != nil && m.active > 0 {
+		m.launch(s)
+	}
+	return nil
+}
+
+// Has 判断同步流是否已存在
+func (m *Manager) Has(r *Replication) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.streams[streamKey(r)]
+	return ok
+}
+
+// launch 需持有m.mu
+func (m *Manager) launch(s *managedStream) {
+	m.active++
+	go func() {
+		err := s.run(m.ctx)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if err != nil && m.firstErr == nil {
+			m.firstErr = err
+		}
+		if m.active--; m.active == 0 {
+			close(m.done)
+		}
+	}()
+}
+
+// Start 启动所有同步流并阻塞，直到ctx取消或所有同步流退出
+// 返回第一个超出重启策略的错误
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	if m.ctx != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("manager already started")
+	}
+	m.ctx, m.done, m.firstErr = ctx, make(chan struct{}), nil
+	for _, s := range m.streams {
+		m.launch(s)
+	}
+	if m.active == 0 {
+		close(m.done)
+	}
+	done := m.done
+	m.mu.Unlock()
+
+	<-done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ctx = nil
+	return m.firstErr
+}
+
+// Streams 获取每个同步流的运行状态
+func (m *Manager) Streams() []StreamStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]StreamStats, 0, len(m.streams))
+	for _, s := range m.streams {
+		res = append(res, s.snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Database != res[j].Database {
+			return res[i].Database < res[j].Database
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Stats 获取所有同步流的汇总状态
+func (m *Manager) Stats() (stats ManagerStats) {
+	for _, s := range m.Streams() {
+		stats.Streams++
+		if s.Running {
+			stats.Running++
+		}
+		stats.Restarts += s.Restarts
+		stats.Messages += s.Messages
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Merger 把多个源数据库的同步流合并到同一个handler，按事务提交时间排序
+// 每个源的事务最多等待skew时间以等待其他源更早提交的事务，超时后直接投递
+// 各源的handler会阻塞直到事务被投递，并返回下游handler的结果
+type Merger struct {
+	handler ReplicationDMLHandler
+	skew    time.Duration
+
+	mu      sync.Mutex
+	sources map[string]bool
+	pending map[string]*mergeItem
+	changed chan struct{}
+}
+
+type mergeItem struct {
+	source     string
+	commitTime time.Time
+	arrived    time.Time
+}
+
+func NewMerger(handler ReplicationDMLHandler, skew time.Duration) *Merger {
+	return &Merger{
+		handler: handler,
+		skew:    skew,
+		sources: map[string]bool{},
+		pending: map[string]*mergeItem{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Source 获取源对应的handler，作为该源Replication.Start的参数
+func (m *Merger) Source(name string) ReplicationDMLHandler {
+	m.mu.Lock()
+	m.sources[name] = true
+	m.mu.Unlock()
+	return func(msg ...ReplicationMessage) DMLHandlerStatus {
+		return m.handle(name, msg)
+	}
+}
+
+// notify 需持有m.mu
+func (m *Merger) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// ready 需持有m.mu，返回事务是否可以投递，不可投递时返回需要等待的时间
+func (m *Merger) ready(it *mergeItem) (bool, time.Duration) {
+	for _, other := range m.pending {
+		if other == it {
+			continue
+		}
+		if other.commitTime.Before(it.commitTime) || (other.commitTime.Equal(it.commitTime) && other.source < it.source) {
+			return false, m.skew
+		}
+	}
+	if len(m.pending) >= len(m.sources) {
+		return true, 0
+	}
+	if waited := time.Since(it.arrived); waited < m.skew {
+		return false, m.skew - waited
+	}
+	return true, 0
+}
+
+func (m *Merger) handle(source string, msg []ReplicationMessage) DMLHandlerStatus {
+	var commitTime time.Time
+	for _, v := range msg {
+		if v.EventType == EventType_COMMIT {
+			commitTime = v.CommitTime
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if commitTime.IsZero() {
+		// READY等控制消息直接投递
+		return m.handler(msg...)
+	}
+	it := &mergeItem{source: source, commitTime: commitTime, arrived: time.Now()}
+	m.pending[source] = it
+	m.notify()
+	for {
+		ok, wait := m.ready(it)
+		if ok {
+			break
+		}
+		changed := m.changed
+		m.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+		m.mu.Lock()
+	}
+	delete(m.pending, source)
+	status := m.handler(msg...)
+	m.notify()
+	return status
+}
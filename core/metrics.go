@@ -0,0 +1,269 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handlerBuckets handler耗时直方图的桶(秒)
+var handlerBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics 同步流的运行指标，多个Replication可共用同一个Metrics，按slot、database标签区分
+// 实现了http.Handler，以Prometheus文本格式输出，可直接挂载到/metrics；
+// 已有Prometheus registry时注册metrics/prometheus包的Collector，core不依赖Prometheus客户端:
+//
+//	import pgprom "github.com/cube-group/pg-replication/metrics/prometheus"
+//
+//	prometheus.MustRegister(pgprom.NewCollector(m))
+type Metrics struct {
+	mu      sync.Mutex
+	streams []*streamMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// WithMetrics 记录同步流的运行指标
+func (t *Replication) WithMetrics(m *Metrics) *Replication {
+	t._metrics = m.add(t)
+	return t
+}
+
+type eventKey struct {
+	eventType EventType
+	schema    string
+	table     string
+}
+
+// streamMetrics 单个同步流的指标
+type streamMetrics struct {
+	replication *Replication
+
+	mu         sync.Mutex
+	events     map[eventKey]uint64
+	bytes      uint64
+	reconnects uint64
+	started    bool
+	// handler耗时，buckets[i]为不超过handlerBuckets[i]的次数(非累计)
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (m *Metrics) add(t *Replication) *streamMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.streams {
+		if s.replication == t {
+			return s
+		}
+	}
+	s := &streamMetrics{replication: t, events: map[eventKey]uint64{}, buckets: make([]uint64, len(handlerBuckets)+1)}
+	m.streams = append(m.streams, s)
+	return s
+}
+
+// instrument 统计交给handler的消息及handler耗时
+func (s *streamMetrics) instrument(handler ReplicationDMLHandler) ReplicationDMLHandler {
+	return func(msg ...ReplicationMessage) DMLHandlerStatus {
+		begin := time.Now()
+		status := handler(msg...)
+		elapsed := time.Since(begin).Seconds()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, m := range msg {
+			s.events[eventKey{m.EventType, m.SchemaName, m.TableName}]++
+		}
+		s.buckets[sort.SearchFloat64s(handlerBuckets, elapsed)]++
+		s.count++
+		s.sum += elapsed
+		return status
+	}
+}
+
+func (s *streamMetrics) receive(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes += uint64(n)
+}
+
+// start Start被再次调用(如Manager重启同步流)时计为重连
+func (s *streamMetrics) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		s.reconnects++
+	}
+	s.started = true
+}
+
+func (s *streamMetrics) reconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconnects++
+}
+
+// MetricFamily 同名指标，Samples的Name可能带有_bucket/_sum/_count后缀
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Type    string   // counter, gauge或histogram
+	Labels  []string // 样本的标签名，直方图的le除外
+	Samples []MetricSample
+}
+
+type MetricSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+var (
+	streamLabels = []string{"slot", "database"}
+	eventLabels  = []string{"slot", "database", "type", "schema", "table"}
+)
+
+// Families 当前所有指标:
+//
+//	pg_replication_events_total           counter   交给handler的消息数，按type、schema、table区分
+//	pg_replication_received_bytes_total   counter   收到的wal数据字节数
+//	pg_replication_handler_seconds        histogram 每次调用handler的耗时
+//	pg_replication_flushed_lsn            gauge     已确认处理的最新位置
+//	pg_replication_lag_bytes              gauge     服务器wal最新位置与已确认位置的差
+//	pg_replication_reconnects_total       counter   重连次数(凭证刷新、Manager重启等)
+//	pg_replication_skipped_messages_total counter   ParseLenient跳过的消息数
+func (m *Metrics) Families() []MetricFamily {
+	m.mu.Lock()
+	streams := append([]*streamMetrics(nil), m.streams...)
+	m.mu.Unlock()
+	families := []MetricFamily{
+		{Name: "pg_replication_events_total", Help: "Replication messages passed to the handler.", Type: "counter", Labels: eventLabels},
+		{Name: "pg_replication_received_bytes_total", Help: "Bytes of WAL data received.", Type: "counter", Labels: streamLabels},
+		{Name: "pg_replication_handler_seconds", Help: "Time spent in the handler per call.", Type: "histogram", Labels: streamLabels},
+		{Name: "pg_replication_flushed_lsn", Help: "Last LSN confirmed to the server.", Type: "gauge", Labels: streamLabels},
+		{Name: "pg_replication_lag_bytes", Help: "Bytes between the server WAL end and the confirmed LSN.", Type: "gauge", Labels: streamLabels},
+		{Name: "pg_replication_reconnects_total", Help: "Reconnects of the replication stream.", Type: "counter", Labels: streamLabels},
+		{Name: "pg_replication_skipped_messages_total", Help: "Messages skipped because they could not be parsed.", Type: "counter", Labels: streamLabels},
+	}
+	for _, s := range streams {
+		s.collect(families)
+	}
+	return families
+}
+
+func (s *streamMetrics) collect(families []MetricFamily) {
+	t := s.replication
+	labels := func(kv ...string) map[string]string {
+		res := map[string]string{"slot": t.name, "database": t.config.Database}
+		for i := 0; i+1 < len(kv); i += 2 {
+			res[kv[i]] = kv[i+1]
+		}
+		return res
+	}
+	sample := func(f int, suffix string, value float64, kv ...string) {
+		families[f].Samples = append(families[f].Samples, MetricSample{Name: families[f].Name + suffix, Labels: labels(kv...), Value: value})
+	}
+	s.mu.Lock()
+	keys := make([]eventKey, 0, len(s.events))
+	for k := range s.events {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.eventType != b.eventType {
+			return a.eventType < b.eventType
+		}
+		if a.schema != b.schema {
+			return a.schema < b.schema
+		}
+		return a.table < b.table
+	})
+	for _, k := range keys {
+		sample(0, "", float64(s.events[k]), "type", k.eventType.String(), "schema", k.schema, "table", k.table)
+	}
+	sample(1, "", float64(s.bytes))
+	var cumulative uint64
+	for i, le := range handlerBuckets {
+		cumulative += s.buckets[i]
+		sample(2, "_bucket", float64(cumulative), "le", strconv.FormatFloat(le, 'g', -1, 64))
+	}
+	sample(2, "_bucket", float64(s.count), "le", "+Inf")
+	sample(2, "_sum", s.sum)
+	sample(2, "_count", float64(s.count))
+	sample(5, "", float64(s.reconnects))
+	s.mu.Unlock()
+
+	_, flushed := t.Positions()
+	var lag uint64
+	if end := t._lsn.serverEnd(); end > flushed {
+		lag = end - flushed
+	}
+	sample(3, "", float64(flushed))
+	sample(4, "", float64(lag))
+	sample(6, "", float64(t.SkippedMessages()))
+}
+
+// WritePrometheus 以Prometheus文本格式(0.0.4)输出所有指标
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range m.Families() {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.Name, f.Help, f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(s.Name)
+			writeLabels(bw, s.Labels)
+			bw.WriteByte(' ')
+			bw.WriteString(formatSample(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabels(w *bufio.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	w.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, `%s="%s"`, k, labelEscaper.Replace(labels[k]))
+	}
+	w.WriteByte('}')
+}
+
+func formatSample(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
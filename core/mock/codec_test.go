@@ -0,0 +1,162 @@
+package mock_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+)
+
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// parseEncoded 按服务器的顺序解析编码后的消息，xid不为0时在流式传输的事务块中
+func parseEncoded(t *testing.T, xid uint32, data []byte) core.Message {
+	t.Helper()
+	var p core.Parser
+	if xid != 0 {
+		if _, err := p.Parse(mock.EncodeStreamStart(core.StreamStart{XID: xid, FirstSegment: true})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := p.Parse(data)
+	if err != nil {
+		t.Fatalf("parse %x: %v", data, err)
+	}
+	return msg
+}
+
+func roundTrip(t *testing.T, xid uint32, data []byte, want core.Message) {
+	t.Helper()
+	if got := parseEncoded(t, xid, data); !reflect.DeepEqual(got, want) {
+		t.Fatalf("encoded %x\ngot  %#v\nwant %#v", data, got, want)
+	}
+}
+
+// fuzzTime 编码为距2000-01-01的微秒数，限制范围避免溢出time.Duration
+func fuzzTime(micros int64) time.Time {
+	return epoch.Add(time.Duration(micros%(1<<52)) * time.Microsecond)
+}
+
+// fuzzRow flags的每个字节选择一列的类型，文本及二进制列的值为value
+func fuzzRow(flags, value []byte) []core.Tuple {
+	if len(flags) > 64 {
+		flags = flags[:64]
+	}
+	row := make([]core.Tuple, len(flags))
+	for i, b := range flags {
+		row[i].Flag = int8("ntub"[b%4])
+		if row[i].Flag == 't' || row[i].Flag == 'b' {
+			row[i].Value = append([]byte{}, value...)
+		}
+	}
+	return row
+}
+
+func FuzzEncodeTuples(f *testing.F) {
+	f.Add(uint32(0), uint32(16384), []byte{0, 1, 2, 3}, []byte("tom"), byte(0))
+	f.Add(uint32(7), uint32(1), []byte{}, []byte{}, byte(1))
+	f.Add(uint32(0), uint32(42), []byte{3, 3}, []byte{0, 0xff, '\t'}, byte(2))
+	f.Fuzz(func(t *testing.T, xid, relation uint32, flags, value []byte, identity byte) {
+		row := fuzzRow(flags, value)
+		old := fuzzRow(flags, append(value, 'o'))
+
+		roundTrip(t, xid, mock.EncodeInsert(core.Insert{XID: xid, RelationID: relation, Row: row}),
+			core.Insert{XID: xid, RelationID: relation, New: true, Row: row})
+
+		// identity 0:只有新值 1:复制标识列的旧值 2:整行旧值
+		u := core.Update{XID: xid, RelationID: relation, New: true, Row: row}
+		switch identity % 3 {
+		case 1:
+			u.Key, u.OldRow = true, old
+		case 2:
+			u.Old, u.OldRow = true, old
+		}
+		roundTrip(t, xid, mock.EncodeUpdate(u), u)
+
+		full := identity%2 == 1
+		roundTrip(t, xid, mock.EncodeDelete(core.Delete{XID: xid, RelationID: relation, Old: full, Row: old}),
+			core.Delete{XID: xid, RelationID: relation, Key: !full, Old: full, Row: old})
+
+		truncate := core.Truncate{XID: xid, RelationIDs: []uint32{relation, relation + 1}, Cascade: identity&1 != 0, RestartIdentity: identity&2 != 0}
+		roundTrip(t, xid, mock.EncodeTruncate(truncate), truncate)
+	})
+}
+
+func FuzzEncodeRelation(f *testing.F) {
+	f.Add(uint32(0), uint32(16384), "public", "users", byte('d'), "id,name", uint32(23), []byte{1, 0})
+	f.Add(uint32(3), uint32(1), "Sales", "Order Items", byte('f'), "", uint32(0), []byte{})
+	f.Add(uint32(0), uint32(2), "s", "t", byte(0), "a,b,c", uint32(1009), []byte{0})
+	f.Fuzz(func(t *testing.T, xid, id uint32, namespace, name string, replica byte, columns string, typ uint32, keys []byte) {
+		// 名称以NUL结尾，不能包含NUL
+		if strings.ContainsRune(namespace+name+columns, 0) {
+			t.Skip()
+		}
+		rel := core.Relation{XID: xid, ID: id, Namespace: namespace, Name: name, Replica: replica}
+		if columns != "" {
+			for i, col := range strings.Split(columns, ",") {
+				rel.Columns = append(rel.Columns, core.Column{
+					Key:  i < len(keys) && keys[i]%2 == 1,
+					Name: col,
+					Type: typ + uint32(i),
+					Mode: uint32(i) - 1,
+				})
+			}
+		}
+		want := rel
+		if want.Replica == 0 {
+			want.Replica = 'd'
+		}
+		if want.Columns == nil {
+			want.Columns = []core.Column{}
+		}
+		roundTrip(t, xid, mock.EncodeRelation(rel), want)
+
+		roundTrip(t, xid, mock.EncodeType(core.Type{XID: xid, ID: id, Namespace: namespace, Name: name}),
+			core.Type{XID: xid, ID: id, Namespace: namespace, Name: name})
+	})
+}
+
+func FuzzEncodeTransaction(f *testing.F) {
+	f.Add(uint64(0x1000000), uint64(0x1000100), int64(757382400000000), uint32(740), uint8(0), "gid", []byte("content"), true)
+	f.Add(uint64(0), uint64(0), int64(-1), uint32(0), uint8(1), "", []byte{}, false)
+	f.Fuzz(func(t *testing.T, lsn, end uint64, micros int64, xid uint32, flags uint8, text string, content []byte, transactional bool) {
+		if strings.ContainsRune(text, 0) {
+			t.Skip()
+		}
+		ts := fuzzTime(micros)
+
+		roundTrip(t, 0, mock.EncodeBegin(core.Begin{LSN: lsn, Timestamp: ts, XID: int32(xid)}),
+			core.Begin{LSN: lsn, Timestamp: ts, XID: int32(xid)})
+		roundTrip(t, 0, mock.EncodeCommit(core.Commit{Flags: flags, LSN: lsn, TransactionLSN: end, Timestamp: ts}),
+			core.Commit{Flags: flags, LSN: lsn, TransactionLSN: end, Timestamp: ts})
+		roundTrip(t, 0, mock.EncodeOrigin(core.Origin{LSN: lsn, Name: text}), core.Origin{LSN: lsn, Name: text})
+
+		var streamXID uint32
+		if !transactional {
+			streamXID = xid
+		}
+		msg := core.LogicalMessage{XID: streamXID, Transactional: transactional, LSN: lsn, Prefix: text, Content: append([]byte{}, content...)}
+		roundTrip(t, streamXID, mock.EncodeLogicalMessage(msg), msg)
+
+		roundTrip(t, 0, mock.EncodeStreamStart(core.StreamStart{XID: xid, FirstSegment: transactional}),
+			core.StreamStart{XID: xid, FirstSegment: transactional})
+		roundTrip(t, 0, mock.EncodeStreamStop(), core.StreamStop{})
+		roundTrip(t, 0, mock.EncodeStreamCommit(core.StreamCommit{XID: xid, Flags: flags, LSN: lsn, TransactionLSN: end, Timestamp: ts}),
+			core.StreamCommit{XID: xid, Flags: flags, LSN: lsn, TransactionLSN: end, Timestamp: ts})
+		roundTrip(t, 0, mock.EncodeStreamAbort(core.StreamAbort{XID: xid, SubXID: uint32(flags)}),
+			core.StreamAbort{XID: xid, SubXID: uint32(flags)})
+
+		roundTrip(t, 0, mock.EncodeBeginPrepare(core.BeginPrepare{LSN: lsn, EndLSN: end, Timestamp: ts, XID: xid, GID: text}),
+			core.BeginPrepare{LSN: lsn, EndLSN: end, Timestamp: ts, XID: xid, GID: text})
+		prepare := core.Prepare{Flags: flags, LSN: lsn, EndLSN: end, Timestamp: ts, XID: xid, GID: text}
+		roundTrip(t, 0, mock.EncodePrepare(prepare), prepare)
+		roundTrip(t, 0, mock.EncodeStreamPrepare(core.StreamPrepare(prepare)), core.StreamPrepare(prepare))
+		roundTrip(t, 0, mock.EncodeCommitPrepared(core.CommitPrepared{Flags: flags, LSN: lsn, EndLSN: end, Timestamp: ts, XID: xid, GID: text}),
+			core.CommitPrepared{Flags: flags, LSN: lsn, EndLSN: end, Timestamp: ts, XID: xid, GID: text})
+		rollback := core.RollbackPrepared{Flags: flags, EndLSN: lsn, RollbackEndLSN: end, PrepareTimestamp: ts, Timestamp: fuzzTime(micros / 2), XID: xid, GID: text}
+		roundTrip(t, 0, mock.EncodeRollbackPrepared(rollback), rollback)
+	})
+}
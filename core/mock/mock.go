@@ -0,0 +1,537 @@
+package mock
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/jackc/pgx"
+)
+
+// Source 内存中的模拟复制数据源，实现core.Transport
+// 按脚本生成的pgoutput消息会经过真实的解析和分发流程，用于在没有数据库的情况下测试handler逻辑
+//
+//	src := mock.NewSource()
+//	src.Relation(core.Relation{ID: 1, Namespace: "public", Name: "users", Columns: cols}).
+//		Begin().Insert(1, 1, "tom").Commit().End()
+//	err := core.NewReplication("test", pgx.ConnConfig{}).WithTransport(src).Start(ctx, handler)
+type Source struct {
+	mu      sync.Mutex
+	queue   []*pgx.ReplicationMessage
+	changed chan struct{}
+	lsn     uint64
+	xid     int32
+	stream  uint32
+	now     time.Time
+	ended   bool
+	closed  bool
+	started bool
+	acks    []uint64
+	// 已写入的Relation，Delete按列补齐key
+	relations map[uint32]core.Relation
+}
+
+func NewSource() *Source {
+	return &Source{changed: make(chan struct{}), lsn: 0x1000000, now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), relations: map[uint32]core.Relation{}}
+}
+
+// push 需持有s.mu
+func (s *Source) push(data []byte) {
+	s.lsn += uint64(len(data))
+	s.queue = append(s.queue, &pgx.ReplicationMessage{WalMessage: &pgx.WalMessage{
+		WalStart:     s.lsn,
+		ServerWalEnd: s.lsn,
+		WalData:      data,
+	}})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Source) write(data []byte) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(data)
+	return s
+}
+
+// Raw 写入原始的pgoutput消息
+func (s *Source) Raw(data []byte) *Source {
+	return s.write(data)
+}
+
+// Heartbeat 写入服务器心跳
+func (s *Source) Heartbeat(replyRequested bool) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hb := &pgx.ServerHeartbeat{ServerWalEnd: s.lsn}
+	if replyRequested {
+		hb.ReplyRequested = 1
+	}
+	s.queue = append(s.queue, &pgx.ReplicationMessage{ServerHeartbeat: hb})
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return s
+}
+
+// Relation 写入表结构消息
+func (s *Source) Relation(rel core.Relation) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rel.XID == 0 {
+		rel.XID = s.stream
+	}
+	s.relations[rel.ID] = rel
+	s.push(EncodeRelation(rel))
+	return s
+}
+
+// Origin 写入复制源消息，需在Begin之后、事务的第一个变更之前
+func (s *Source) Origin(name string, lsn uint64) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeOrigin(core.Origin{LSN: lsn, Name: name}))
+	return s
+}
+
+// Type 写入自定义类型消息，服务器在使用该类型的Relation之前发送
+func (s *Source) Type(typ core.Type) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if typ.XID == 0 {
+		typ.XID = s.stream
+	}
+	s.push(EncodeType(typ))
+	return s
+}
+
+// Begin 开始事务，提交时间从2024-01-01起每个事务递增1ms，保证输出可重复
+func (s *Source) Begin() *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.xid++
+	s.now = s.now.Add(time.Millisecond)
+	s.push(EncodeBegin(core.Begin{LSN: s.lsn, Timestamp: s.now, XID: s.xid}))
+	return s
+}
+
+// Insert 写入插入消息，values按列顺序，nil为NULL，其他值按fmt.Sprint转为文本格式
+func (s *Source) Insert(relation uint32, values ...interface{}) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeInsert(core.Insert{XID: s.stream, RelationID: relation, New: true, Row: Row(values...)}))
+	return s
+}
+
+// Update 写入更新消息，old为nil时不包含旧值(默认复制标识且主键未变化)
+func (s *Source) Update(relation uint32, old, values []interface{}) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := core.Update{XID: s.stream, RelationID: relation, New: true, Row: Row(values...)}
+	if old != nil {
+		u.Old = true
+		u.OldRow = Row(old...)
+	}
+	s.push(EncodeUpdate(u))
+	return s
+}
+
+// Delete 写入删除消息，key为复制标识列的值，按列顺序
+// 与pgoutput相同，已写入表结构时key放在复制标识列的位置，其他列为NULL('n')
+func (s *Source) Delete(relation uint32, key ...interface{}) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeDelete(core.Delete{XID: s.stream, RelationID: relation, Key: true, Row: s.keyRow(relation, key)}))
+	return s
+}
+
+// keyRow 需持有s.mu，key的个数与列数相同(REPLICA IDENTITY FULL)或表结构未知时原样返回
+func (s *Source) keyRow(relation uint32, key []interface{}) []core.Tuple {
+	row := Row(key...)
+	rel, ok := s.relations[relation]
+	if !ok || len(row) == len(rel.Columns) {
+		return row
+	}
+	padded := make([]core.Tuple, 0, len(rel.Columns))
+	for _, col := range rel.Columns {
+		if col.Key && len(row) > 0 {
+			padded, row = append(padded, row[0]), row[1:]
+		} else {
+			padded = append(padded, core.Tuple{Flag: 'n'})
+		}
+	}
+	return padded
+}
+
+// Truncate 写入清空表消息，一条TRUNCATE语句可清空多张表
+func (s *Source) Truncate(relations ...uint32) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeTruncate(core.Truncate{XID: s.stream, RelationIDs: relations}))
+	return s
+}
+
+// Commit 提交事务
+func (s *Source) Commit() *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeCommit(core.Commit{LSN: s.lsn, TransactionLSN: s.lsn, Timestamp: s.now}))
+	return s
+}
+
+// LogicalMessage pg_logical_emit_message写入的消息，transactional为false时不需要在事务中
+func (s *Source) LogicalMessage(transactional bool, prefix string, content []byte) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeLogicalMessage(core.LogicalMessage{XID: s.stream, Transactional: transactional, LSN: s.lsn, Prefix: prefix, Content: content}))
+	return s
+}
+
+// StreamStart 开始流式传输的事务块，之后的变更消息带有xid，直到StreamStop
+// 同一事务的多个块使用相同的xid，first为该事务的第一个块
+func (s *Source) StreamStart(xid uint32, first bool) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = xid
+	s.push(EncodeStreamStart(core.StreamStart{XID: xid, FirstSegment: first}))
+	return s
+}
+
+// StreamStop 结束事务块
+func (s *Source) StreamStop() *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = 0
+	s.push(EncodeStreamStop())
+	return s
+}
+
+// StreamCommit 提交流式传输的事务
+func (s *Source) StreamCommit(xid uint32) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(time.Millisecond)
+	s.push(EncodeStreamCommit(core.StreamCommit{XID: xid, LSN: s.lsn, TransactionLSN: s.lsn, Timestamp: s.now}))
+	return s
+}
+
+// StreamAbort 回滚流式传输的事务，subXid与xid相同时回滚整个事务
+func (s *Source) StreamAbort(xid, subXid uint32) *Source {
+	return s.write(EncodeStreamAbort(core.StreamAbort{XID: xid, SubXID: subXid}))
+}
+
+// BeginPrepare 开始两阶段提交的事务，之后的变更在Prepare时交给handler
+func (s *Source) BeginPrepare(gid string) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.xid++
+	s.now = s.now.Add(time.Millisecond)
+	s.push(EncodeBeginPrepare(core.BeginPrepare{LSN: s.lsn, EndLSN: s.lsn, Timestamp: s.now, XID: uint32(s.xid), GID: gid}))
+	return s
+}
+
+// Prepare PREPARE TRANSACTION，xid为BeginPrepare开始的事务
+func (s *Source) Prepare(gid string) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodePrepare(core.Prepare{LSN: s.lsn, EndLSN: s.lsn, Timestamp: s.now, XID: uint32(s.xid), GID: gid}))
+	return s
+}
+
+// CommitPrepared COMMIT PREPARED
+func (s *Source) CommitPrepared(xid uint32, gid string) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(time.Millisecond)
+	s.push(EncodeCommitPrepared(core.CommitPrepared{LSN: s.lsn, EndLSN: s.lsn, Timestamp: s.now, XID: xid, GID: gid}))
+	return s
+}
+
+// RollbackPrepared ROLLBACK PREPARED
+func (s *Source) RollbackPrepared(xid uint32, gid string) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(time.Millisecond)
+	s.push(EncodeRollbackPrepared(core.RollbackPrepared{EndLSN: s.lsn, RollbackEndLSN: s.lsn, PrepareTimestamp: s.now, Timestamp: s.now, XID: xid, GID: gid}))
+	return s
+}
+
+// End 结束数据流，消息消费完后WaitForReplicationMessage返回io.EOF，Start随之返回
+func (s *Source) End() *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return s
+}
+
+// Acks 获取已确认的lsn
+func (s *Source) Acks() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint64(nil), s.acks...)
+}
+
+// Pending 获取尚未被消费的消息数量
+func (s *Source) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+func (s *Source) StartReplication(slotName string, startLsn uint64, timeline int64, pluginArguments ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+	s.closed = false
+	return nil
+}
+
+func (s *Source) WaitForReplicationMessage(ctx context.Context) (*pgx.ReplicationMessage, error) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("mock source closed")
+		}
+		if len(s.queue) > 0 {
+			msg := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		if s.ended {
+			s.mu.Unlock()
+			return nil, io.EOF
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *Source) SendStandbyStatus(k *pgx.StandbyStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k.WalFlushPosition > 0 {
+		s.acks = append(s.acks, k.WalFlushPosition)
+	}
+	return nil
+}
+
+func (s *Source) IsAlive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started && !s.closed
+}
+
+func (s *Source) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return nil
+}
+
+// Row 把值转换为文本格式的TupleData，nil为NULL
+func Row(values ...interface{}) []core.Tuple {
+	row := make([]core.Tuple, len(values))
+	for i, v := range values {
+		switch val := v.(type) {
+		case nil:
+			row[i] = core.Tuple{Flag: 'n'}
+		case []byte:
+			row[i] = core.Tuple{Flag: 't', Value: val}
+		default:
+			row[i] = core.Tuple{Flag: 't', Value: []byte(fmt.Sprint(val))}
+		}
+	}
+	return row
+}
+
+type encoder []byte
+
+func (e encoder) uint8(v uint8) encoder { return append(e, v) }
+
+func (e encoder) uint16(v uint16) encoder {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return append(e, b[:]...)
+}
+
+func (e encoder) uint32(v uint32) encoder {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(e, b[:]...)
+}
+
+func (e encoder) uint64(v uint64) encoder {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(e, b[:]...)
+}
+
+// xid 流式传输的事务块中的消息带有xid，0为不在事务块中
+func (e encoder) xid(v uint32) encoder {
+	if v == 0 {
+		return e
+	}
+	return e.uint32(v)
+}
+
+func (e encoder) string(v string) encoder { return append(append(e, v...), 0) }
+
+func (e encoder) timestamp(v time.Time) encoder {
+	epoch := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return e.uint64(uint64(v.Sub(epoch) / time.Microsecond))
+}
+
+func (e encoder) tupledata(row []core.Tuple) encoder {
+	e = e.uint16(uint16(len(row)))
+	for _, tuple := range row {
+		switch tuple.Flag {
+		case 'n', 'u':
+			e = e.uint8(uint8(tuple.Flag))
+		case 'b':
+			e = e.uint8('b').uint32(uint32(len(tuple.Value)))
+			e = append(e, tuple.Value...)
+		default:
+			e = e.uint8('t').uint32(uint32(len(tuple.Value)))
+			e = append(e, tuple.Value...)
+		}
+	}
+	return e
+}
+
+func EncodeBegin(b core.Begin) []byte {
+	return encoder{'B'}.uint64(b.LSN).timestamp(b.Timestamp).uint32(uint32(b.XID))
+}
+
+func EncodeCommit(c core.Commit) []byte {
+	return encoder{'C'}.uint8(c.Flags).uint64(c.LSN).uint64(c.TransactionLSN).timestamp(c.Timestamp)
+}
+
+func EncodeOrigin(o core.Origin) []byte {
+	return encoder{'O'}.uint64(o.LSN).string(o.Name)
+}
+
+func EncodeType(t core.Type) []byte {
+	return encoder{'Y'}.xid(t.XID).uint32(t.ID).string(t.Namespace).string(t.Name)
+}
+
+func EncodeRelation(r core.Relation) []byte {
+	replica := r.Replica
+	if replica == 0 {
+		replica = 'd'
+	}
+	e := encoder{'R'}.xid(r.XID).uint32(r.ID).string(r.Namespace).string(r.Name).uint8(replica).uint16(uint16(len(r.Columns)))
+	for _, col := range r.Columns {
+		var flags uint8
+		if col.Key {
+			flags = 1
+		}
+		e = e.uint8(flags).string(col.Name).uint32(col.Type).uint32(col.Mode)
+	}
+	return e
+}
+
+func EncodeInsert(i core.Insert) []byte {
+	return encoder{'I'}.xid(i.XID).uint32(i.RelationID).uint8('N').tupledata(i.Row)
+}
+
+func EncodeUpdate(u core.Update) []byte {
+	e := encoder{'U'}.xid(u.XID).uint32(u.RelationID)
+	switch {
+	case u.Key:
+		e = e.uint8('K').tupledata(u.OldRow)
+	case u.Old:
+		e = e.uint8('O').tupledata(u.OldRow)
+	}
+	return e.uint8('N').tupledata(u.Row)
+}
+
+func EncodeDelete(d core.Delete) []byte {
+	e := encoder{'D'}.xid(d.XID).uint32(d.RelationID)
+	if d.Old {
+		e = e.uint8('O')
+	} else {
+		e = e.uint8('K')
+	}
+	return e.tupledata(d.Row)
+}
+
+func EncodeTruncate(t core.Truncate) []byte {
+	var options uint8
+	if t.Cascade {
+		options |= 1
+	}
+	if t.RestartIdentity {
+		options |= 2
+	}
+	e := encoder{'T'}.xid(t.XID).uint32(uint32(len(t.RelationIDs))).uint8(options)
+	for _, id := range t.RelationIDs {
+		e = e.uint32(id)
+	}
+	return e
+}
+
+func EncodeLogicalMessage(m core.LogicalMessage) []byte {
+	var flags uint8
+	if m.Transactional {
+		flags = 1
+	}
+	return append(encoder{'M'}.xid(m.XID).uint8(flags).uint64(m.LSN).string(m.Prefix).uint32(uint32(len(m.Content))), m.Content...)
+}
+
+func EncodeStreamStart(s core.StreamStart) []byte {
+	var first uint8
+	if s.FirstSegment {
+		first = 1
+	}
+	return encoder{'S'}.uint32(s.XID).uint8(first)
+}
+
+func EncodeStreamStop() []byte {
+	return []byte{'E'}
+}
+
+func EncodeStreamCommit(c core.StreamCommit) []byte {
+	return encoder{'c'}.uint32(c.XID).uint8(c.Flags).uint64(c.LSN).uint64(c.TransactionLSN).timestamp(c.Timestamp)
+}
+
+func EncodeStreamAbort(a core.StreamAbort) []byte {
+	return encoder{'A'}.uint32(a.XID).uint32(a.SubXID)
+}
+
+func EncodeBeginPrepare(b core.BeginPrepare) []byte {
+	return encoder{'b'}.uint64(b.LSN).uint64(b.EndLSN).timestamp(b.Timestamp).uint32(b.XID).string(b.GID)
+}
+
+func encodePrepare(msgType byte, p core.Prepare) []byte {
+	return encoder{msgType}.uint8(p.Flags).uint64(p.LSN).uint64(p.EndLSN).timestamp(p.Timestamp).uint32(p.XID).string(p.GID)
+}
+
+func EncodePrepare(p core.Prepare) []byte {
+	return encodePrepare('P', p)
+}
+
+func EncodeStreamPrepare(p core.StreamPrepare) []byte {
+	return encodePrepare('p', core.Prepare(p))
+}
+
+func EncodeCommitPrepared(c core.CommitPrepared) []byte {
+	return encoder{'K'}.uint8(c.Flags).uint64(c.LSN).uint64(c.EndLSN).timestamp(c.Timestamp).uint32(c.XID).string(c.GID)
+}
+
+func EncodeRollbackPrepared(r core.RollbackPrepared) []byte {
+	return encoder{'r'}.uint8(r.Flags).uint64(r.EndLSN).uint64(r.RollbackEndLSN).timestamp(r.PrepareTimestamp).timestamp(r.Timestamp).uint32(r.XID).string(r.GID)
+}
@@ -0,0 +1,142 @@
+package mock
+
+import (
+	"fmt"
+
+	"github.com/cube-group/pg-replication/core"
+)
+
+// Table 模拟表的生命周期(建表、加列、改类型、删列、删表重建)，不需要在真实数据库上执行DDL
+// 每次变更记录一个步骤，可通过Replay直接应用到RelationSet，或在Source中用Relation(t.Relation())写入当前结构
+//
+//	t := mock.NewTable(1, "public", "users", mock.Key("id", pgtype.Int4OID), mock.Col("name", pgtype.TextOID))
+//	t.AddColumn(mock.Col("age", pgtype.Int4OID)).AlterType("age", pgtype.Int8OID).DropColumn("name").Recreate(2)
+//	for _, change := range t.Replay(core.NewRelationSet()) { ... }
+type Table struct {
+	rel   core.Relation
+	steps []SchemaStep
+}
+
+// SchemaStep 一次结构变更
+type SchemaStep struct {
+	// 变更描述，如"add column age"
+	Name     string
+	Relation core.Relation
+}
+
+// SchemaChange 步骤应用到RelationSet的结果
+type SchemaChange struct {
+	Step SchemaStep
+	// 应用前缓存的表结构，新表时为空
+	Old core.Relation
+	// RelationSet.Drift检测到的差异
+	Changes []string
+	// RelationSet.Add是否因删表重建或OID复用而重置
+	Reset bool
+}
+
+// Key 主键(复制标识)列
+func Key(name string, typ uint32) core.Column {
+	return core.Column{Key: true, Name: name, Type: typ, Mode: 0xffffffff}
+}
+
+// Col 普通列
+func Col(name string, typ uint32) core.Column {
+	return core.Column{Name: name, Type: typ, Mode: 0xffffffff}
+}
+
+// NewTable 建表，作为第一个步骤
+func NewTable(id uint32, schema, name string, columns ...core.Column) *Table {
+	t := &Table{rel: core.Relation{ID: id, Namespace: schema, Name: name, Replica: 'd'}}
+	t.rel.Columns = append([]core.Column(nil), columns...)
+	t.record(fmt.Sprintf("create table %s.%s", schema, name))
+	return t
+}
+
+func (t *Table) record(name string) *Table {
+	t.steps = append(t.steps, SchemaStep{Name: name, Relation: t.Relation()})
+	return t
+}
+
+func (t *Table) index(name string) int {
+	for i, col := range t.rel.Columns {
+		if col.Name == name {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("mock: table %s.%s has no column %s", t.rel.Namespace, t.rel.Name, name))
+}
+
+// Relation 获取当前表结构的副本
+func (t *Table) Relation() core.Relation {
+	rel := t.rel
+	rel.Columns = append([]core.Column(nil), t.rel.Columns...)
+	return rel
+}
+
+// Steps 获取所有变更步骤
+func (t *Table) Steps() []SchemaStep {
+	return append([]SchemaStep(nil), t.steps...)
+}
+
+// AddColumn 在末尾添加列
+func (t *Table) AddColumn(col core.Column) *Table {
+	t.rel.Columns = append(t.rel.Columns, col)
+	return t.record("add column " + col.Name)
+}
+
+// AlterType 修改列类型，mode为类型修饰符(如varchar长度)，不传时为-1
+func (t *Table) AlterType(name string, typ uint32, mode ...uint32) *Table {
+	i := t.index(name)
+	t.rel.Columns[i].Type = typ
+	t.rel.Columns[i].Mode = 0xffffffff
+	if len(mode) > 0 {
+		t.rel.Columns[i].Mode = mode[0]
+	}
+	return t.record(fmt.Sprintf("alter column %s type %d", name, typ))
+}
+
+// RenameColumn 重命名列
+func (t *Table) RenameColumn(name, newName string) *Table {
+	t.rel.Columns[t.index(name)].Name = newName
+	return t.record(fmt.Sprintf("rename column %s to %s", name, newName))
+}
+
+// DropColumn 删除列
+func (t *Table) DropColumn(name string) *Table {
+	i := t.index(name)
+	t.rel.Columns = append(t.rel.Columns[:i:i], t.rel.Columns[i+1:]...)
+	return t.record("drop column " + name)
+}
+
+// ReplicaIdentity 修改复制标识，d默认、f全部列、i索引、n无
+func (t *Table) ReplicaIdentity(replica uint8) *Table {
+	t.rel.Replica = replica
+	return t.record(fmt.Sprintf("replica identity %c", replica))
+}
+
+// Recreate 删表后以相同结构重建，表获得新的OID
+func (t *Table) Recreate(id uint32) *Table {
+	t.rel.ID = id
+	return t.record(fmt.Sprintf("recreate table %s.%s", t.rel.Namespace, t.rel.Name))
+}
+
+// Replay 按顺序把所有步骤应用到RelationSet，与Replication收到Relation消息时的处理相同
+func (t *Table) Replay(set *core.RelationSet) []SchemaChange {
+	res := make([]SchemaChange, 0, len(t.steps))
+	for _, step := range t.steps {
+		change := SchemaChange{Step: step}
+		change.Old, change.Changes = set.Drift(step.Relation)
+		change.Reset = set.Add(step.Relation)
+		res = append(res, change)
+	}
+	return res
+}
+
+// Write 把所有步骤作为Relation消息写入Source
+func (t *Table) Write(s *Source) *Source {
+	for _, step := range t.steps {
+		s.Relation(step.Relation)
+	}
+	return s
+}
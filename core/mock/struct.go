@@ -0,0 +1,250 @@
+package mock
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/jackc/pgx/pgtype"
+)
+
+type structField struct {
+	column string
+	key    bool
+	index  []int
+}
+
+// structFields 字段与列的对应规则与core.Decode相同：列名取db标签，其次json标签，都没有时为字段名，标签为"-"的字段忽略
+// 标签选项key表示该列为主键(复制标识)列
+func structFields(typ reflect.Type) []structField {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("mock: %s is not a struct", typ))
+	}
+	var res []structField
+	var walk func(typ reflect.Type, index []int)
+	walk = func(typ reflect.Type, index []int) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			idx := append(append([]int(nil), index...), i)
+			tag, tagged := field.Tag.Lookup("db")
+			if !tagged {
+				tag = field.Tag.Get("json")
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous && field.Type.Kind() == reflect.Struct && name == "" {
+				walk(field.Type, idx)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			res = append(res, structField{column: name, key: hasOption(opts, "key"), index: idx})
+		}
+	}
+	walk(typ, nil)
+	return res
+}
+
+func hasOption(opts, name string) bool {
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	// 按Go类型推断的列类型，其他结构体、map及切片为jsonb
+	structTypes = map[reflect.Kind]uint32{
+		reflect.Bool:    pgtype.BoolOID,
+		reflect.Int8:    pgtype.Int2OID,
+		reflect.Int16:   pgtype.Int2OID,
+		reflect.Uint8:   pgtype.Int2OID,
+		reflect.Int32:   pgtype.Int4OID,
+		reflect.Uint16:  pgtype.Int4OID,
+		reflect.Int:     pgtype.Int8OID,
+		reflect.Int64:   pgtype.Int8OID,
+		reflect.Uint32:  pgtype.Int8OID,
+		reflect.Float32: pgtype.Float4OID,
+		reflect.Float64: pgtype.Float8OID,
+		reflect.String:  pgtype.TextOID,
+	}
+	structArrayTypes = map[reflect.Kind]uint32{
+		reflect.Bool:    pgtype.BoolArrayOID,
+		reflect.Int16:   pgtype.Int2ArrayOID,
+		reflect.Int32:   pgtype.Int4ArrayOID,
+		reflect.Int:     pgtype.Int8ArrayOID,
+		reflect.Int64:   pgtype.Int8ArrayOID,
+		reflect.Float32: pgtype.Float4ArrayOID,
+		reflect.Float64: pgtype.Float8ArrayOID,
+		reflect.String:  pgtype.TextArrayOID,
+	}
+)
+
+// columnType 按字段的Go类型推断列类型OID
+func columnType(typ reflect.Type) uint32 {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ == timeType:
+		return pgtype.TimestamptzOID
+	case typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8:
+		return pgtype.ByteaOID
+	case typ.Kind() == reflect.Slice:
+		if oid, ok := structArrayTypes[typ.Elem().Kind()]; ok {
+			return oid
+		}
+	default:
+		if oid, ok := structTypes[typ.Kind()]; ok {
+			return oid
+		}
+	}
+	return pgtype.JSONBOID
+}
+
+// StructRelation 按结构体v(或其指针)的字段生成表结构，列类型按字段的Go类型推断：
+// 整数为int2/int4/int8，浮点数为float4/float8，time.Time为timestamptz，[]byte为bytea，
+// 基本类型的切片为对应的数组类型，其他结构体、map及切片为jsonb
+// 没有标记key的列时复制标识为nothing
+//
+//	type User struct {
+//		ID   int64  `db:"id,key"`
+//		Name string `db:"name"`
+//	}
+//	rel := mock.StructRelation(1, "public", "users", User{})
+//	src.Relation(rel).Begin().InsertStruct(rel, User{ID: 1, Name: "tom"}).Commit()
+//	user, err := core.Decode[User](msg)
+func StructRelation(id uint32, schema, name string, v interface{}) core.Relation {
+	rel := core.Relation{ID: id, Namespace: schema, Name: name, Replica: 'n'}
+	typ := reflect.TypeOf(v)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	for _, f := range structFields(typ) {
+		if !f.key {
+			rel.Columns = append(rel.Columns, Col(f.column, columnType(typ.FieldByIndex(f.index).Type)))
+			continue
+		}
+		rel.Columns = append(rel.Columns, Key(f.column, columnType(typ.FieldByIndex(f.index).Type)))
+		rel.Replica = 'd'
+	}
+	return rel
+}
+
+// StructRow 按rel的列顺序把结构体v的字段编码为PostgreSQL文本格式的TupleData，
+// 结构体中没有的列及nil指针为NULL；值无法按列类型编码时panic
+func StructRow(rel core.Relation, v interface{}) []core.Tuple {
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	fields := map[string][]int{}
+	for _, f := range structFields(val.Type()) {
+		fields[f.column] = f.index
+	}
+	row := make([]core.Tuple, len(rel.Columns))
+	for i, col := range rel.Columns {
+		row[i] = core.Tuple{Flag: 'n'}
+		index, ok := fields[col.Name]
+		if !ok {
+			continue
+		}
+		field, err := val.FieldByIndexErr(index)
+		if err != nil {
+			// 嵌入的结构体指针为nil
+			continue
+		}
+		for field.Kind() == reflect.Ptr && !field.IsNil() {
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Ptr {
+			continue
+		}
+		text, err := encodeText(col, field.Interface())
+		if err != nil {
+			panic(fmt.Sprintf("mock: encode %s.%s column %s: %v", rel.Namespace, rel.Name, col.Name, err))
+		}
+		row[i] = core.Tuple{Flag: 't', Value: text}
+	}
+	return row
+}
+
+// keyRow 删除及复制标识为default的更新消息中的旧值，只包含主键列，其他列为NULL
+func keyRow(rel core.Relation, row []core.Tuple) []core.Tuple {
+	res := make([]core.Tuple, len(row))
+	for i, tuple := range row {
+		res[i] = core.Tuple{Flag: 'n'}
+		if i < len(rel.Columns) && rel.Columns[i].Key {
+			res[i] = tuple
+		}
+	}
+	return res
+}
+
+// encodeText 按列类型的pgtype编码为文本格式
+func encodeText(col core.Column, v interface{}) ([]byte, error) {
+	value := core.ColumnDecoder(col)
+	encoder, ok := value.(pgtype.TextEncoder)
+	if !ok {
+		return []byte(fmt.Sprint(v)), nil
+	}
+	if err := value.Set(v); err != nil {
+		return nil, err
+	}
+	return encoder.EncodeText(nil, nil)
+}
+
+// InsertStruct 写入插入消息，行数据由StructRow生成
+func (s *Source) InsertStruct(rel core.Relation, v interface{}) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(EncodeInsert(core.Insert{XID: s.stream, RelationID: rel.ID, New: true, Row: StructRow(rel, v)}))
+	return s
+}
+
+// UpdateStruct 写入更新消息，old为nil时不包含旧值；复制标识为full时旧值包含所有列，否则只包含主键列
+func (s *Source) UpdateStruct(rel core.Relation, old, v interface{}) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := core.Update{XID: s.stream, RelationID: rel.ID, New: true, Row: StructRow(rel, v)}
+	if old != nil {
+		u.OldRow = StructRow(rel, old)
+		if rel.Replica == 'f' {
+			u.Old = true
+		} else {
+			u.Key = true
+			u.OldRow = keyRow(rel, u.OldRow)
+		}
+	}
+	s.push(EncodeUpdate(u))
+	return s
+}
+
+// DeleteStruct 写入删除消息，复制标识为full时包含所有列，否则只包含主键列
+func (s *Source) DeleteStruct(rel core.Relation, v interface{}) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := core.Delete{XID: s.stream, RelationID: rel.ID, Row: StructRow(rel, v)}
+	if rel.Replica == 'f' {
+		d.Old = true
+	} else {
+		d.Key = true
+		d.Row = keyRow(rel, d.Row)
+	}
+	s.push(EncodeDelete(d))
+	return s
+}
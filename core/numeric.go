@@ -0,0 +1,83 @@
+package core
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// NumericPolicy numeric列的解码方式
+type NumericPolicy int
+
+const (
+	// NumericString 服务器输出的文本，如"12.50"，不丢失精度(默认)
+	NumericString NumericPolicy = iota
+	// NumericRat *big.Rat，精确值，NaN及Infinity仍为文本
+	NumericRat
+	// NumericFloat64 float64，超出float64精度的值会被舍入
+	NumericFloat64
+)
+
+// numericText numeric列按文本解码，保留服务器输出的精度及NaN/Infinity
+type numericText struct {
+	pgtype.GenericText
+}
+
+// NumericColumns numeric列的解码方式
+func (t *Replication) NumericColumns(policy NumericPolicy) *Replication {
+	t._numeric = policy
+	t._numericFn = nil
+	return t
+}
+
+// NumericConverter 自定义numeric列的解码，参数为服务器输出的文本，返回错误时值为文本
+// 如使用shopspring/decimal：
+//
+//	r.NumericConverter(func(s string) (interface{}, error) { return decimal.NewFromString(s) })
+func (t *Replication) NumericConverter(fn func(string) (interface{}, error)) *Replication {
+	t._numericFn = fn
+	return t
+}
+
+// numericValue 按NumericColumns/NumericConverter转换numeric的文本
+func (t *Replication) numericValue(s string) interface{} {
+	if t._numericFn != nil {
+		if v, err := t._numericFn(s); err == nil {
+			return v
+		}
+		return s
+	}
+	switch t._numeric {
+	case NumericRat:
+		if r, ok := new(big.Rat).SetString(s); ok {
+			return r
+		}
+	case NumericFloat64:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// numericString pgtype.Numeric(快照查询连接的解码结果)转换为文本
+func numericString(n *pgtype.Numeric) string {
+	digits := n.Int.String()
+	if n.Exp >= 0 {
+		if digits == "0" {
+			return digits
+		}
+		return digits + strings.Repeat("0", int(n.Exp))
+	}
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	scale := int(-n.Exp)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
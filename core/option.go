@@ -1,5 +1,13 @@
 package core
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 type EventType int
 
 const (
@@ -8,10 +16,69 @@ const (
 	EventType_UPDATE   EventType = 2
 	EventType_DELETE   EventType = 3
 	EventType_TRUNCATE EventType = 4
-	EventType_COMMIT   EventType = 10
+	// 表被删除重建或relation id被复用，之前缓存的表结构已失效
+	EventType_SCHEMA_RESET EventType = 5
+	// 备库已被提升为主库，复制槽继续有效
+	EventType_PROMOTED EventType = 6
+	// 序列当前值，需配置Replication.SequenceSync
+	EventType_SEQUENCE EventType = 7
+	// 流式传输的大事务的一个块已结束，需配置Replication.Streaming
+	EventType_STREAM_STOP EventType = 8
+	// 流式传输的大事务或其子事务已回滚，需丢弃Xid为SubXid的已收到变更
+	EventType_STREAM_ABORT EventType = 9
+	EventType_COMMIT       EventType = 10
+	// 两阶段提交，需配置Replication.TwoPhase
+	// PREPARE TRANSACTION，msg包含事务中的所有变更，最后一条为EventType_PREPARE
+	EventType_PREPARE EventType = 11
+	// COMMIT PREPARED/ROLLBACK PREPARED，按Gid对应之前的EventType_PREPARE
+	EventType_COMMIT_PREPARED   EventType = 12
+	EventType_ROLLBACK_PREPARED EventType = 13
+	// 事务开始，handler收到的每个事务第一条为EventType_BEGIN，最后一条为EventType_COMMIT(或EventType_PREPARE)
+	EventType_BEGIN EventType = 14
+	// 初始快照中的一行，需配置Replication.InitialSnapshot，Lsn为复制槽的一致点
+	EventType_SNAPSHOT EventType = 15
 )
 
+func (e EventType) String() string {
+	switch e {
+	case EventType_READY:
+		return "READY"
+	case EventType_INSERT:
+		return "INSERT"
+	case EventType_UPDATE:
+		return "UPDATE"
+	case EventType_DELETE:
+		return "DELETE"
+	case EventType_TRUNCATE:
+		return "TRUNCATE"
+	case EventType_SCHEMA_RESET:
+		return "SCHEMA_RESET"
+	case EventType_PROMOTED:
+		return "PROMOTED"
+	case EventType_SEQUENCE:
+		return "SEQUENCE"
+	case EventType_STREAM_STOP:
+		return "STREAM_STOP"
+	case EventType_STREAM_ABORT:
+		return "STREAM_ABORT"
+	case EventType_BEGIN:
+		return "BEGIN"
+	case EventType_COMMIT:
+		return "COMMIT"
+	case EventType_PREPARE:
+		return "PREPARE"
+	case EventType_COMMIT_PREPARED:
+		return "COMMIT_PREPARED"
+	case EventType_ROLLBACK_PREPARED:
+		return "ROLLBACK_PREPARED"
+	case EventType_SNAPSHOT:
+		return "SNAPSHOT"
+	}
+	return fmt.Sprintf("EventType(%d)", int(e))
+}
+
 type ReplicationMessage struct {
+	// 消息的wal位置，EventType_COMMIT为事务提交的位置
 	Lsn        uint64
 	RelationID uint32
 	EventType  EventType
@@ -19,6 +86,86 @@ type ReplicationMessage struct {
 	TableName  string
 	Body       map[string]interface{}
 	Columns    []string
+	// update前的完整旧值，需要REPLICA IDENTITY FULL(或InferChangedColumns缓存中有该行)，否则为nil
+	// 旧值中未变化的TOAST列不包含在内
+	OldBody map[string]interface{}
+	// update/delete变更前复制标识列的值(默认为主键，REPLICA IDENTITY FULL时为所有列)，insert为新行的复制标识列(FULL时为nil)，decoderbufs不提供
+	Key map[string]interface{}
+	// delete的Body或update的OldBody是完整的旧行
+	// 为false时delete的Body只有复制标识列有值，其余列为nil
+	FullOldRow bool
+	// 与Body内容相同，按表结构中的列顺序排列
+	Fields Fields
+	// Body中的生成列(GENERATED ALWAYS AS ... STORED)，需配置SchemaRefresh
+	// 生成列的值由数据库计算，写入其他数据库时需排除
+	Generated []string
+	// 事务提交时间
+	CommitTime time.Time
+	// 事务的xid，同一事务的所有消息相同，可与Lsn一起用于下游去重和排序
+	// 注意xid会回卷，不能跨越较长时间比较大小
+	Xid uint32
+	// 变更所属的(子)事务xid，与Xid相同时为顶层事务
+	SubXid uint32
+	// 两阶段提交的全局事务标识(PREPARE TRANSACTION 'gid')
+	Gid string
+	// 租户标识，需配置Replication.Tenant
+	Tenant string
+	// 事务的复制源(其他节点通过逻辑复制写入时为pg_replication_origin.roname)，本地写入为空
+	Origin string
+	// 事务span所在的context，需配置WithTracer，下游可从中取得span继续追踪
+	Trace context.Context
+}
+
+// Field 按列顺序排列的列值
+type Field struct {
+	Name  string
+	Value interface{}
+	// update时该列是否变化，需要旧值(REPLICA IDENTITY FULL)
+	Changed bool
+}
+
+// Fields 按列顺序排列的列值，JSON序列化为保持列顺序的对象
+type Fields []Field
+
+// Get 按列名获取值
+func (f Fields) Get(name string) (interface{}, bool) {
+	for _, v := range f {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Names 列名
+func (f Fields) Names() []string {
+	res := make([]string, 0, len(f))
+	for _, v := range f {
+		res = append(res, v.Name)
+	}
+	return res
+}
+
+func (f Fields) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	for i, v := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(v.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
 type DMLHandlerStatus int
@@ -28,4 +175,9 @@ const (
 	DMLHandlerStatusContinue DMLHandlerStatus = 1 //wal lsn游标不会变动
 )
 
+// ReplicationDMLHandler 每个事务提交时调用一次，msg第一条为EventType_BEGIN，之后按顺序包含事务中的所有变更，最后一条为EventType_COMMIT
+// 返回DMLHandlerStatusSuccess后确认该事务的lsn
+// 配置Replication.Streaming时，大事务的每个块单独调用一次，最后一条为EventType_STREAM_STOP，
+// 提交时只包含一条EventType_COMMIT，回滚时只包含一条EventType_STREAM_ABORT，均带有Xid
+// 配置Replication.InitialSnapshot时，流式同步前先分批调用，每批只包含同一张表的EventType_SNAPSHOT
 type ReplicationDMLHandler func(msg ...ReplicationMessage) DMLHandlerStatus
@@ -0,0 +1,43 @@
+package core
+
+// SkipOrigins 跳过其他复制源(origin)写入的事务，双向复制或级联复制时避免变更循环
+// 不指定names时跳过所有带origin的事务，只同步本地写入，PostgreSQL 16+由服务器过滤(pgoutput origin 'none')
+// 指定names(pg_replication_origin.roname，如订阅pg_16384)时只跳过这些origin的事务，在客户端过滤
+// 客户端过滤不适用于Streaming分块发送的大事务；跳过的事务只把COMMIT交给handler，handler返回成功后确认lsn
+func (t *Replication) SkipOrigins(names ...string) *Replication {
+	t._skipOrigin = true
+	t._origins = names
+	return t
+}
+
+// skipOrigin 是否跳过origin写入的事务，本地写入(origin为空)不跳过
+func (t *Replication) skipOrigin(origin string) bool {
+	if !t._skipOrigin || origin == "" {
+		return false
+	}
+	if len(t._origins) == 0 {
+		return true
+	}
+	for _, v := range t._origins {
+		if v == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// origin 事务的复制源，在Begin之后、事务的第一个变更之前发送
+func (t *Replication) origin(v Origin) {
+	t._origin = v.Name
+	for i := range t._flushMsg {
+		t._flushMsg[i].Origin = v.Name
+	}
+	if t._stream == 0 && t.skipOrigin(v.Name) {
+		t.debug("origin", "skip transaction", t._xid, "from", v.Name)
+	}
+}
+
+// skipped 当前事务来自需要跳过的origin且已没有需要交给handler的消息(只有BEGIN)
+func (t *Replication) skipped() bool {
+	return t._stream == 0 && t.skipOrigin(t._origin) && len(t._flushMsg) <= 1
+}
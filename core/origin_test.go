@@ -0,0 +1,56 @@
+package core_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+func skippedSource() *mock.Source {
+	return mock.NewSource().Relation(core.Relation{ID: 1, Namespace: "public", Name: "users", Columns: []core.Column{
+		mock.Key("id", pgtype.Int4OID),
+		mock.Col("name", pgtype.TextOID),
+	}}).Begin().Insert(1, 1, "tom").Commit().
+		Begin().Origin("pg_16384", 1).Insert(1, 2, "amy").Commit().End()
+}
+
+// 跳过的事务只把COMMIT交给handler，handler缓存了之前的事务时不确认lsn
+func TestSkipOriginsBuffered(t *testing.T) {
+	src := skippedSource()
+	var batches [][]core.ReplicationMessage
+	core.NewReplication("users_slot", pgx.ConnConfig{}).WithTransport(src).SkipOrigins("pg_16384").
+		Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+			if msg[len(msg)-1].EventType == core.EventType_COMMIT {
+				batches = append(batches, msg)
+			}
+			return core.DMLHandlerStatusContinue
+		})
+	if len(batches) != 2 {
+		t.Fatalf("%d transactions, want 2", len(batches))
+	}
+	skipped := batches[1]
+	if len(skipped) != 1 || skipped[0].EventType != core.EventType_COMMIT || skipped[0].Origin != "pg_16384" {
+		t.Fatalf("skipped transaction delivered %+v", skipped)
+	}
+	if acks := src.Acks(); len(acks) != 0 {
+		t.Fatalf("acked %v while the handler buffered", acks)
+	}
+}
+
+func TestSkipOriginsConfirm(t *testing.T) {
+	src := skippedSource()
+	var commit uint64
+	core.NewReplication("users_slot", pgx.ConnConfig{}).WithTransport(src).SkipOrigins("pg_16384").
+		Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+			commit = msg[len(msg)-1].Lsn
+			return core.DMLHandlerStatusSuccess
+		})
+	acks := src.Acks()
+	if len(acks) == 0 || acks[len(acks)-1] != commit {
+		t.Fatalf("acked %v, want %x", acks, commit)
+	}
+}
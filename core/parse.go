@@ -1,278 +1,89 @@
 package core
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"time"
+	"sync/atomic"
 
+	"github.com/cube-group/pg-replication/pgoutput"
 	"github.com/jackc/pgx/pgtype"
 )
 
-type decoder struct {
-	order binary.ByteOrder
-	buf   *bytes.Buffer
-}
-
-func (d *decoder) bool() bool {
-	x := d.buf.Next(1)[0]
-	return x != 0
-}
-
-func (d *decoder) uint8() uint8 {
-	x := d.buf.Next(1)[0]
-	return x
-
-}
-
-func (d *decoder) uint16() uint16 {
-	x := d.order.Uint16(d.buf.Next(2))
-	return x
-}
-
-func (d *decoder) string() string {
-	s, err := d.buf.ReadBytes(0)
-	if err != nil {
-		// TODO: Return an error
-		panic(err)
-	}
-	return string(s[:len(s)-1])
-}
-
-func (d *decoder) uint32() uint32 {
-	x := d.order.Uint32(d.buf.Next(4))
-	return x
-
-}
+// pgoutput消息类型，解析器见pgoutput包
+type (
+	Begin    = pgoutput.Begin
+	Commit   = pgoutput.Commit
+	Relation = pgoutput.Relation
+	Type     = pgoutput.Type
+	Insert   = pgoutput.Insert
+	Update   = pgoutput.Update
+	Delete   = pgoutput.Delete
+	Truncate = pgoutput.Truncate
+	Origin   = pgoutput.Origin
+	Column   = pgoutput.Column
+	Tuple    = pgoutput.Tuple
+	Message  = pgoutput.Message
+
+	LogicalMessage = pgoutput.LogicalMessage
+
+	StreamStart  = pgoutput.StreamStart
+	StreamStop   = pgoutput.StreamStop
+	StreamCommit = pgoutput.StreamCommit
+	StreamAbort  = pgoutput.StreamAbort
+	Parser       = pgoutput.Parser
+	ParseError   = pgoutput.ParseError
+
+	BeginPrepare     = pgoutput.BeginPrepare
+	Prepare          = pgoutput.Prepare
+	StreamPrepare    = pgoutput.StreamPrepare
+	CommitPrepared   = pgoutput.CommitPrepared
+	RollbackPrepared = pgoutput.RollbackPrepared
+)
 
-func (d *decoder) uint64() uint64 {
-	x := d.order.Uint64(d.buf.Next(8))
-	return x
-}
+var (
+	// ErrUnknownMessage 复制流中有无法识别的消息类型
+	ErrUnknownMessage = pgoutput.ErrUnknownMessage
+	// ErrMalformedMessage 消息为空、被截断或字段无效，errors.As可获取*ParseError中的消息类型及出错位置
+	ErrMalformedMessage = pgoutput.ErrMalformedMessage
+)
 
-func (d *decoder) int8() int8   { return int8(d.uint8()) }
-func (d *decoder) int16() int16 { return int16(d.uint16()) }
-func (d *decoder) int32() int32 { return int32(d.uint32()) }
-func (d *decoder) int64() int64 { return int64(d.uint64()) }
+// ParseMode 无法解析的复制消息(未知的消息类型、格式错误)的处理方式
+type ParseMode int
 
-func (d *decoder) timestamp() time.Time {
-	micro := int(d.uint64())
-	ts := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
-	return ts.Add(time.Duration(micro) * time.Microsecond)
-}
+const (
+	// ParseStrict 停止同步并返回错误，errors.Is可判断ErrUnknownMessage/ErrMalformedMessage(默认)
+	// 当前事务不会确认lsn，重启后从该事务重新开始
+	ParseStrict ParseMode = iota
+	// ParseLenient 记录日志并计数后跳过该消息继续同步，跳过的消息如果是变更则该变更丢失
+	ParseLenient
+)
 
-func (d *decoder) rowinfo(char byte) bool {
-	if d.buf.Next(1)[0] == char {
-		return true
-	} else {
-		d.buf.UnreadByte()
-		return false
-	}
+// MessageParseMode 无法解析的复制消息的处理方式，跳过的消息数见SkippedMessages
+func (t *Replication) MessageParseMode(mode ParseMode) *Replication {
+	t._parseMode = mode
+	return t
 }
 
-func (d *decoder) tupledata() []Tuple {
-	size := int(d.uint16())
-	data := make([]Tuple, size)
-	for i := 0; i < size; i++ {
-		switch d.buf.Next(1)[0] {
-		case 'n':
-		case 'u':
-		case 't':
-			vsize := int(d.order.Uint32(d.buf.Next(4)))
-			data[i] = Tuple{Flag: 't', Value: d.buf.Next(vsize)}
-		}
-	}
-	return data
+// SkippedMessages ParseLenient时因无法解析而跳过的消息数
+func (t *Replication) SkippedMessages() uint64 {
+	return atomic.LoadUint64(&t._skippedMsg)
 }
 
-func (d *decoder) columns() []Column {
-	size := int(d.uint16())
-	data := make([]Column, size)
-	for i := 0; i < size; i++ {
-		data[i] = Column{
-			Key:  d.bool(),
-			Name: d.string(),
-			Type: d.uint32(),
-			Mode: d.uint32(),
-		}
+// parseError 按ParseMode处理解析错误，返回nil时跳过该消息
+func (t *Replication) parseError(plugin string, data []byte, err error) error {
+	if t._parseMode != ParseLenient {
+		return fmt.Errorf("invalid %s message: %w", plugin, err)
 	}
-	return data
-}
-
-type Begin struct {
-	// The final LSN of the transaction.
-	LSN uint64
-	// Commit timestamp of the transaction. The value is in number of
-	// microseconds since PostgreSQL epoch (2000-01-01).
-	Timestamp time.Time
-	// 	Xid of the transaction.
-	XID int32
+	n := atomic.AddUint64(&t._skippedMsg, 1)
+	t.logger().Warn("skip invalid message", "slot", t.name, "plugin", plugin, "skipped", n, "bytes", len(data), "error", err)
+	return nil
 }
 
-type Commit struct {
-	Flags uint8
-	// The final LSN of the transaction.
-	LSN uint64
-	// The final LSN of the transaction.
-	TransactionLSN uint64
-	Timestamp      time.Time
-}
-
-type Relation struct {
-	// ID of the relation.
-	ID uint32
-	// Namespace (empty string for pg_catalog).
-	Namespace string
-	Name      string
-	Replica   uint8
-	Columns   []Column
-}
-
-type Type struct {
-	// ID of the data type
-	ID        uint32
-	Namespace string
-	Name      string
-}
-
-type Insert struct {
-	/// ID of the relation corresponding to the ID in the relation message.
-	RelationID uint32
-	// Identifies the following TupleData message as a new tuple.
-	New bool
-	Row []Tuple
-}
-
-type Update struct {
-	/// ID of the relation corresponding to the ID in the relation message.
-	RelationID uint32
-	// Identifies the following TupleData message as a new tuple.
-	Old    bool
-	Key    bool
-	New    bool
-	OldRow []Tuple
-	Row    []Tuple
-}
-
-type Delete struct {
-	/// ID of the relation corresponding to the ID in the relation message.
-	RelationID uint32
-	// Identifies the following TupleData message as a new tuple.
-	Key bool // TODO
-	Old bool // TODO
-	Row []Tuple
-}
-
-type Truncate struct {
-	/// ID of the relation corresponding to the ID in the relation message.
-	RelationID uint32
-}
-
-type Origin struct {
-	LSN  uint64
-	Name string
+// Parse a logical replication message.
+func Parse(src []byte) (Message, error) {
+	return pgoutput.Parse(src)
 }
 
 type DecoderValue interface {
 	pgtype.TextDecoder
 	pgtype.Value
 }
-
-type Column struct {
-	Key  bool
-	Name string
-	Type uint32
-	Mode uint32
-}
-
-type Tuple struct {
-	Flag  int8
-	Value []byte
-}
-
-type Message interface {
-	msg()
-}
-
-func (Begin) msg()    {}
-func (Relation) msg() {}
-func (Update) msg()   {}
-func (Insert) msg()   {}
-func (Delete) msg()   {}
-func (Commit) msg()   {}
-func (Origin) msg()   {}
-func (Truncate) msg() {}
-func (Type) msg()     {}
-
-// Parse a logical replication message.
-// See https://www.postgresql.org/docs/current/static/protocol-logicalrep-message-formats.html
-func Parse(src []byte) (Message, error) {
-	msgType := src[0]
-	d := &decoder{order: binary.BigEndian, buf: bytes.NewBuffer(src[1:])}
-	switch msgType {
-	case 'B':
-		b := Begin{}
-		b.LSN = d.uint64()
-		b.Timestamp = d.timestamp()
-		b.XID = d.int32()
-		return b, nil
-	case 'C':
-		c := Commit{}
-		c.Flags = d.uint8()
-		c.LSN = d.uint64()
-		c.TransactionLSN = d.uint64()
-		c.Timestamp = d.timestamp()
-		return c, nil
-	case 'O':
-		o := Origin{}
-		o.LSN = d.uint64()
-		o.Name = d.string()
-		return o, nil
-	case 'R':
-		r := Relation{}
-		r.ID = d.uint32()
-		r.Namespace = d.string()
-		r.Name = d.string()
-		r.Replica = d.uint8()
-		r.Columns = d.columns()
-		return r, nil
-	case 'Y':
-		t := Type{}
-		t.ID = d.uint32()
-		t.Namespace = d.string()
-		t.Name = d.string()
-		return t, nil
-	case 'I':
-		i := Insert{}
-		i.RelationID = d.uint32()
-		i.New = d.uint8() > 0
-		i.Row = d.tupledata()
-		return i, nil
-	case 'U':
-		u := Update{}
-		u.RelationID = d.uint32()
-		u.Key = d.rowinfo('K')
-		u.Old = d.rowinfo('O')
-		if u.Key || u.Old {
-			u.OldRow = d.tupledata()
-		}
-		u.New = d.uint8() > 0
-		u.Row = d.tupledata()
-		return u, nil
-	case 'D':
-		dl := Delete{}
-		dl.RelationID = d.uint32()
-		dl.Key = d.rowinfo('K')
-		dl.Old = d.rowinfo('O')
-		dl.Row = d.tupledata()
-		return dl, nil
-	case 'T':
-		tr := Truncate{}
-		d.uint32()
-		d.int8()
-		tr.RelationID = d.uint32()
-		return tr, nil
-	default:
-		return nil, fmt.Errorf("Unknown message type for %s (%d)", []byte{msgType}, msgType)
-	}
-}
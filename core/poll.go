@@ -0,0 +1,50 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// poll 每隔interval在普通连接上调用fn，直到ctx结束或fn返回false，用于SchemaRefresh、LagMonitor、SequenceSync及RetentionPolicy等后台查询
+// 连接配置见primaryConfig，配置Failover时连接当前主库；连接断开或主库切换后在下一次调用前重新连接，fresh表示连接是新建的
+func (t *Replication) poll(ctx context.Context, name string, interval time.Duration, fn func(conn *pgx.Conn, fresh bool) bool) {
+	var conn *pgx.Conn
+	var host string
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if conn != nil && (!conn.IsAlive() || host != t.primaryHost()) {
+			conn.Close()
+			conn = nil
+		}
+		fresh := conn == nil
+		if fresh {
+			host = t.primaryHost()
+			config, err := t.primaryConfig()
+			if err != nil {
+				t.debug(name, "credentials", err)
+				continue
+			}
+			if conn, err = pgx.Connect(config); err != nil {
+				t.debug(name, "connect", err)
+				conn = nil
+				continue
+			}
+		}
+		if !fn(conn, fresh) {
+			return
+		}
+	}
+}
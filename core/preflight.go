@@ -0,0 +1,142 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+var (
+	// ErrNoReplicationPrivilege 同步账号没有REPLICATION权限
+	ErrNoReplicationPrivilege = errors.New("role lacks the REPLICATION privilege")
+	// ErrSlotsExhausted 没有空闲的复制槽(max_replication_slots)
+	ErrSlotsExhausted = errors.New("no free replication slots")
+	// ErrWalSendersExhausted 没有空闲的wal sender进程(max_wal_senders)
+	ErrWalSendersExhausted = errors.New("no free wal senders")
+	// ErrNotTableOwner 同步账号不是表的所有者，不能把表加入发布流或修改复制标识
+	ErrNotTableOwner = errors.New("role does not own table")
+)
+
+// PreflightError Preflight发现的所有问题，errors.Is可判断是否包含某类问题
+type PreflightError struct {
+	Problems []error
+}
+
+func (e *PreflightError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, v := range e.Problems {
+		problems = append(problems, v.Error())
+	}
+	return "preflight failed:\n" + strings.Join(problems, "\n")
+}
+
+func (e *PreflightError) Is(target error) bool {
+	for _, v := range e.Problems {
+		if errors.Is(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreflightCheck Start前执行Preflight，服务器配置或权限不满足时直接返回*PreflightError，不再创建复制槽和发布流
+func (t *Replication) PreflightCheck() *Replication {
+	t._preflight = true
+	return t
+}
+
+// Preflight 检查服务器配置及同步账号的权限：wal_level=logical、REPLICATION权限、
+// 复制槽及wal sender余量、Tables中需要加入发布流的表的所有权
+// 返回*PreflightError，包含所有发现的问题及处理方法
+func (t *Replication) Preflight() error {
+	e := &PreflightError{}
+	res, err := t.result("SHOW wal_level")
+	if err != nil {
+		return err
+	}
+	if len(res) > 0 && fmt.Sprint(res[0]["wal_level"]) != "logical" {
+		e.Problems = append(e.Problems, fmt.Errorf("%w: wal_level is %v, set wal_level = logical in postgresql.conf and restart the server", ErrWalLevelNotLogical, res[0]["wal_level"]))
+	}
+	// RDS/Aurora通过rds_replication角色授予复制权限
+	res, err = t.result(`SELECT (rolreplication OR rolsuper OR coalesce((SELECT pg_has_role(current_user, oid, 'member') FROM pg_roles WHERE rolname = 'rds_replication'), false))::text AS replication
+FROM pg_roles WHERE rolname = current_user`)
+	if err != nil {
+		return err
+	}
+	if len(res) > 0 && res[0]["replication"] != "true" {
+		e.Problems = append(e.Problems, fmt.Errorf("%w: run ALTER ROLE %s WITH REPLICATION as a superuser", ErrNoReplicationPrivilege, pgx.Identifier{t.config.User}.Sanitize()))
+	}
+	if err = t.preflightCapacity(e); err != nil {
+		return err
+	}
+	if !t.noPublicationDDL() && len(t._tables) > 0 {
+		state, err := t.publicationState(t._tables)
+		if err != nil {
+			return err
+		}
+		missing := state.missing
+		if !state.exists {
+			missing = t._tables
+		}
+		if err = t.preflightOwnership(e, missing, "add them to publication "+t.name); err != nil {
+			return err
+		}
+	}
+	if len(e.Problems) > 0 {
+		return e
+	}
+	return nil
+}
+
+// preflightCapacity 复制槽及wal sender余量，TestDecoding额外占用一个临时复制槽和一个wal sender
+func (t *Replication) preflightCapacity(e *PreflightError) error {
+	info, err := t.Slot()
+	if err != nil {
+		return err
+	}
+	var slots, senders int
+	if !info.Exists {
+		slots++
+	}
+	if t._testDecoding != nil {
+		slots, senders = slots+1, senders+1
+	}
+	// 当前复制连接本身已占用一个wal sender，包含在pg_stat_replication中
+	res, err := t.result(`SELECT (current_setting('max_replication_slots')::int - (SELECT count(*) FROM pg_replication_slots))::text AS slots,
+(current_setting('max_wal_senders')::int - (SELECT count(*) FROM pg_stat_replication))::text AS senders`)
+	if err != nil || len(res) == 0 {
+		return err
+	}
+	if free, err := strconv.Atoi(fmt.Sprint(res[0]["slots"])); err == nil && free < slots {
+		e.Problems = append(e.Problems, fmt.Errorf("%w: %d free, %d needed; drop unused slots (SELECT slot_name FROM pg_replication_slots WHERE NOT active) or raise max_replication_slots and restart", ErrSlotsExhausted, free, slots))
+	}
+	if free, err := strconv.Atoi(fmt.Sprint(res[0]["senders"])); err == nil && free < senders {
+		e.Problems = append(e.Problems, fmt.Errorf("%w: %d free, %d needed; raise max_wal_senders and restart", ErrWalSendersExhausted, free, senders))
+	}
+	return nil
+}
+
+// preflightOwnership 同步账号需是tables的所有者(或其所有者角色的成员)，不存在的表由ValidateTables报告
+func (t *Replication) preflightOwnership(e *PreflightError, tables []string, purpose string) error {
+	var notOwned []string
+	for _, v := range tables {
+		table, err := quoteTable(v)
+		if err != nil {
+			return err
+		}
+		res, err := t.result(fmt.Sprintf("SELECT pg_has_role(current_user, relowner, 'USAGE')::text AS owner, pg_get_userbyid(relowner)::text AS rolname FROM pg_class WHERE oid = to_regclass(%s)", quoteLiteral(table)))
+		if err != nil {
+			return err
+		}
+		if len(res) > 0 && res[0]["owner"] != "true" {
+			notOwned = append(notOwned, fmt.Sprintf("%s (owner %v)", qualifiedTable(v), res[0]["rolname"]))
+		}
+	}
+	if len(notOwned) > 0 {
+		e.Problems = append(e.Problems, fmt.Errorf("%w: %s; grant the owner role to the replication user or ask the owner to %s", ErrNotTableOwner, strings.Join(notOwned, ", "), purpose))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package core
+
+// columnProjection 表的列白名单/黑名单
+type columnProjection struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+// IncludeColumns 表只保留指定的列，其他列从Body/Fields/Columns中移除，table格式同ParseTable
+func (t *Replication) IncludeColumns(table string, columns ...string) *Replication {
+	p := t.projection(table)
+	p.include = make(map[string]bool, len(columns))
+	for _, v := range columns {
+		p.include[v] = true
+	}
+	return t
+}
+
+// ExcludeColumns 从表的消息中移除指定的列，用于去除敏感或较大的列(如password_hash、bytea)，table格式同ParseTable
+func (t *Replication) ExcludeColumns(table string, columns ...string) *Replication {
+	p := t.projection(table)
+	p.exclude = make(map[string]bool, len(columns))
+	for _, v := range columns {
+		p.exclude[v] = true
+	}
+	return t
+}
+
+func (t *Replication) projection(table string) *columnProjection {
+	if t._columns == nil {
+		t._columns = map[string]*columnProjection{}
+	}
+	key := qualifiedTable(table)
+	p, ok := t._columns[key]
+	if !ok {
+		p = &columnProjection{}
+		t._columns[key] = p
+	}
+	return p
+}
+
+func (p *columnProjection) keep(column string) bool {
+	if p.include != nil && !p.include[column] {
+		return false
+	}
+	return !p.exclude[column]
+}
+
+// project 按表配置移除消息中的列
+func (t *Replication) project(msg *ReplicationMessage) {
+	if t._columns == nil {
+		return
+	}
+	p, ok := t._columns[TableName(msg.SchemaName, msg.TableName)]
+	if !ok {
+		return
+	}
+	for name := range msg.Body {
+		if !p.keep(name) {
+			delete(msg.Body, name)
+		}
+	}
+	for name := range msg.OldBody {
+		if !p.keep(name) {
+			delete(msg.OldBody, name)
+		}
+	}
+	for name := range msg.Key {
+		if !p.keep(name) {
+			delete(msg.Key, name)
+		}
+	}
+	fields := msg.Fields[:0]
+	for _, f := range msg.Fields {
+		if p.keep(f.Name) {
+			fields = append(fields, f)
+		}
+	}
+	msg.Fields = fields
+	msg.Columns = keepColumns(p, msg.Columns)
+	msg.Generated = keepColumns(p, msg.Generated)
+}
+
+func keepColumns(p *columnProjection, columns []string) []string {
+	if columns == nil {
+		return nil
+	}
+	res := columns[:0]
+	for _, v := range columns {
+		if p.keep(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// LeastPrivilege 最小权限模式，不执行任何DDL(创建/删除复制槽和发布流、修改表复制标识)
+// 适用于同步账号只有REPLICATION权限的场景，复制槽、发布流等需由DBA预先创建
+// CreateReplication、CreatePublication、SetReplicaIdentity、AlterPublication改为校验对象是否已就绪，
+// 未就绪时返回*ProvisionError，其中包含需要DBA执行的sql
+func (t *Replication) LeastPrivilege() *Replication {
+	t._noDDL = true
+	return t
+}
+
+// ProvisionError 最小权限模式下数据库对象未就绪
+type ProvisionError struct {
+	// 缺失或不符合要求的对象
+	Missing []string
+	// 需要DBA执行的sql
+	SQL []string
+	// 发布流不存在时为ErrPublicationMissing
+	kind error
+}
+
+func (e *ProvisionError) Error() string {
+	return fmt.Sprintf("missing %s, ask a DBA to run:\n%s", strings.Join(e.Missing, ", "), strings.Join(e.SQL, "\n"))
+}
+
+func (e *ProvisionError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+func (e *ProvisionError) add(missing, sql string) {
+	e.Missing = append(e.Missing, missing)
+	e.SQL = append(e.SQL, sql)
+}
+
+func (e *ProvisionError) err() error {
+	if len(e.Missing) == 0 {
+		return nil
+	}
+	return e
+}
+
+// checkSlot 复制槽需已存在
+func (t *Replication) checkSlot() error {
+	info, err := t.Slot()
+	if err != nil {
+		return err
+	}
+	e := &ProvisionError{}
+	if !info.Exists {
+		if t._twoPhase {
+			e.add("replication slot "+t.name, fmt.Sprintf("SELECT pg_create_logical_replication_slot(%s, %s, false, true);", quoteLiteral(t.name), quoteLiteral(t.plugin())))
+		} else {
+			e.add("replication slot "+t.name, fmt.Sprintf("SELECT pg_create_logical_replication_slot(%s, %s);", quoteLiteral(t.name), quoteLiteral(t.plugin())))
+		}
+	}
+	return e.err()
+}
+
+// checkPublication 发布流需已存在且包含tables，tables为空时需为FOR ALL TABLES
+func (t *Replication) checkPublication(tables []string) error {
+	state, err := t.publicationState(tables)
+	if err != nil {
+		return err
+	}
+	e := &ProvisionError{}
+	if !state.exists {
+		target := "ALL TABLES"
+		if len(tables) > 0 {
+			quoted, _, err := publicationTables(tables)
+			if err != nil {
+				return err
+			}
+			target = "TABLE " + quoted
+		}
+		e.kind = ErrPublicationMissing
+		e.add("publication "+t.name, fmt.Sprintf("CREATE PUBLICATION %s FOR %s%s;", pgx.Identifier{t.name}.Sanitize(), target, t.publishClause()))
+		return e
+	}
+	if len(tables) == 0 && !state.allTables {
+		e.add("publication "+t.name+" FOR ALL TABLES", fmt.Sprintf("DROP PUBLICATION %s; CREATE PUBLICATION %s FOR ALL TABLES%s;", pgx.Identifier{t.name}.Sanitize(), pgx.Identifier{t.name}.Sanitize(), t.publishClause()))
+	}
+	if len(state.missing) > 0 {
+		quoted, _, err := publicationTables(state.missing)
+		if err != nil {
+			return err
+		}
+		e.add(fmt.Sprintf("tables %s in publication %s", strings.Join(state.missing, ", "), t.name), fmt.Sprintf("ALTER PUBLICATION %s ADD TABLE %s;", pgx.Identifier{t.name}.Sanitize(), quoted))
+	}
+	return e.err()
+}
+
+// checkReplicaIdentity 表的复制标识需与status一致
+func (t *Replication) checkReplicaIdentity(tables []string, status ReplicaIdentity) error {
+	want := "d"
+	switch {
+	case status == ReplicaIdentityFull:
+		want = "f"
+	case status == ReplicaIdentityNothing:
+		want = "n"
+	case strings.HasPrefix(string(status), "USING INDEX"):
+		want = "i"
+	}
+	e := &ProvisionError{}
+	for _, v := range tables {
+		table, err := quoteTable(v)
+		if err != nil {
+			return err
+		}
+		res, err := t.result(fmt.Sprintf("SELECT relreplident::text FROM pg_class WHERE oid = %s::regclass", quoteLiteral(table)))
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 || res[0]["relreplident"] != want {
+			e.add("replica identity "+string(status)+" on "+v, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY %s;", table, status))
+		}
+	}
+	return e.err()
+}
+
+// checkPublicationTables 发布流中需包含add且不包含drop
+func (t *Replication) checkPublicationTables(add, drop []string) error {
+	if err := t.checkPublication(add); err != nil || len(drop) == 0 {
+		return err
+	}
+	published, err := t.PublicationTables()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(published))
+	for _, v := range published {
+		exists[v] = true
+	}
+	var extra []string
+	for _, v := range drop {
+		if exists[qualifiedTable(v)] {
+			extra = append(extra, v)
+		}
+	}
+	e := &ProvisionError{}
+	if len(extra) > 0 {
+		quoted, err := quoteTables(extra)
+		if err != nil {
+			return err
+		}
+		e.add(fmt.Sprintf("removal of tables %s from publication %s", strings.Join(extra, ", "), t.name), fmt.Sprintf("ALTER PUBLICATION %s DROP TABLE %s;", pgx.Identifier{t.name}.Sanitize(), quoted))
+	}
+	return e.err()
+}
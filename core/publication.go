@@ -0,0 +1,421 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// Tables 配置需要同步的表，Start前校验表是否存在以及是否已加入发布流
+// 表不存在时返回*MissingTablesError，表不在发布流中时返回*ProvisionError(包含需要执行的sql)，
+// 配置AutoAlterPublication时自动创建发布流或把缺少的表加入发布流
+func (t *Replication) Tables(tables ...string) *Replication {
+	t._tables = tables
+	return t
+}
+
+// AutoAlterPublication Start时自动创建发布流或把Tables中缺少的表加入发布流，最小权限模式及UseExistingPublication时无效
+func (t *Replication) AutoAlterPublication() *Replication {
+	t._autoAlter = true
+	return t
+}
+
+// UseExistingPublication 使用已存在的发布流(由迁移脚本或DBA管理)，不执行CREATE/ALTER/DROP PUBLICATION，复制槽仍可自动创建
+// CreatePublication、AlterPublication等改为校验发布流，Start前校验发布流是否存在，
+// 不存在时返回*ProvisionError(errors.Is(err, ErrPublicationMissing))，配置Tables/Schemas时还校验是否包含这些表/schema
+func (t *Replication) UseExistingPublication() *Replication {
+	t._existingPub = true
+	return t
+}
+
+// noPublicationDDL 不执行发布流DDL，只校验
+func (t *Replication) noPublicationDDL() bool {
+	return t._noDDL || t._existingPub
+}
+
+// checkPublicationExists 发布流需已存在，不限制发布的表
+func (t *Replication) checkPublicationExists() error {
+	state, err := t.publicationState(nil)
+	if err != nil || state.exists {
+		return err
+	}
+	e := &ProvisionError{kind: ErrPublicationMissing}
+	e.add("publication "+t.name, fmt.Sprintf("CREATE PUBLICATION %s FOR ALL TABLES%s;", pgx.Identifier{t.name}.Sanitize(), t.publishClause()))
+	return e
+}
+
+// PublishOperation 发布流发布的操作类型
+type PublishOperation string
+
+const (
+	PublishInsert   PublishOperation = "insert"
+	PublishUpdate   PublishOperation = "update"
+	PublishDelete   PublishOperation = "delete"
+	PublishTruncate PublishOperation = "truncate"
+)
+
+var publishOperations = []PublishOperation{PublishInsert, PublishUpdate, PublishDelete, PublishTruncate}
+
+// Publish 配置发布流发布的操作，默认发布所有操作，只关心部分操作时服务器不再发送和解码其他操作
+// CreatePublication时使用WITH (publish = ...)创建，已存在的发布流不一致时返回*ProvisionError，配置AutoAlterPublication时自动修改
+func (t *Replication) Publish(ops ...PublishOperation) *Replication {
+	t._publish = ops
+	return t
+}
+
+// publishOption 按固定顺序返回publish选项的值，如insert, update，未配置时为空
+func (t *Replication) publishOption() string {
+	if len(t._publish) == 0 {
+		return ""
+	}
+	set := make(map[PublishOperation]bool, len(t._publish))
+	for _, op := range t._publish {
+		set[op] = true
+	}
+	var ops []string
+	for _, op := range publishOperations {
+		if set[op] {
+			ops = append(ops, string(op))
+		}
+	}
+	return strings.Join(ops, ", ")
+}
+
+// publishClause CREATE PUBLICATION的WITH子句
+func (t *Replication) publishClause() string {
+	if option := t.publishOption(); option != "" {
+		return fmt.Sprintf(" WITH (publish = %s)", quoteLiteral(option))
+	}
+	return ""
+}
+
+type publicationState struct {
+	exists    bool
+	allTables bool
+	// 发布的操作，格式同publishOption
+	publish string
+	// 不在发布流中的表，FOR ALL TABLES时为空
+	missing []string
+}
+
+// publicationState 获取发布流是否存在及tables中不在发布流的表
+func (t *Replication) publicationState(tables []string) (state publicationState, err error) {
+	f, err := t.Features()
+	if err != nil {
+		return
+	}
+	columns := "puballtables::text, pubinsert::text, pubupdate::text, pubdelete::text"
+	if f.Version >= 110000 {
+		columns += ", pubtruncate::text"
+	}
+	res, err := t.result(fmt.Sprintf("SELECT %s FROM pg_publication WHERE pubname = %s", columns, quoteLiteral(t.name)))
+	if err != nil || len(res) == 0 {
+		return
+	}
+	state.exists = true
+	state.allTables = res[0]["puballtables"] == "true"
+	var ops []string
+	for _, op := range publishOperations {
+		if res[0]["pub"+string(op)] == "true" {
+			ops = append(ops, string(op))
+		}
+	}
+	state.publish = strings.Join(ops, ", ")
+	if state.allTables || len(tables) == 0 {
+		return
+	}
+	published, err := t.PublicationTables()
+	if err != nil {
+		return
+	}
+	exists := make(map[string]bool, len(published))
+	for _, v := range published {
+		exists[v] = true
+	}
+	for _, v := range tables {
+		if !exists[qualifiedTable(v)] {
+			state.missing = append(state.missing, v)
+		}
+	}
+	return
+}
+
+// validatePublication 同步开始前校验Schemas、Tables及Publish
+func (t *Replication) validatePublication() error {
+	if t._existingPub && len(t._tables) == 0 && len(t._schemas) == 0 {
+		if err := t.checkPublicationExists(); err != nil {
+			return err
+		}
+	}
+	if err := t.validatePublicationSchemas(); err != nil {
+		return err
+	}
+	if err := t.validatePublicationTables(); err != nil {
+		return err
+	}
+	return t.validatePublish()
+}
+
+// validatePublish 已存在的发布流发布的操作需与Publish一致
+func (t *Replication) validatePublish() error {
+	want := t.publishOption()
+	if want == "" {
+		return nil
+	}
+	state, err := t.publicationState(nil)
+	if err != nil || !state.exists || state.publish == want {
+		return err
+	}
+	sql := fmt.Sprintf("ALTER PUBLICATION %s SET (publish = %s)", pgx.Identifier{t.name}.Sanitize(), quoteLiteral(want))
+	if !t._autoAlter || t.noPublicationDDL() {
+		e := &ProvisionError{}
+		e.add(fmt.Sprintf("publish = '%s' on publication %s (currently '%s')", want, t.name, state.publish), sql+";")
+		return e
+	}
+	t.debug("publication", "publish", t.name, state.publish, "->", want)
+	return t.execEx(sql)
+}
+
+// validatePublicationTables 发布流需包含Tables
+func (t *Replication) validatePublicationTables() error {
+	if len(t._tables) == 0 {
+		return nil
+	}
+	if err := t.ValidateTables(t._tables); err != nil {
+		return err
+	}
+	err := t.checkPublication(t._tables)
+	var provision *ProvisionError
+	if err == nil || !errors.As(err, &provision) || !t._autoAlter || t.noPublicationDDL() {
+		return err
+	}
+	state, err := t.publicationState(t._tables)
+	if err != nil {
+		return err
+	}
+	if !state.exists {
+		t.debug("publication", "create", t.name, t._tables)
+		return t.CreatePublication(t._tables)
+	}
+	t.debug("publication", "add", t.name, state.missing)
+	return t.AlterPublication(state.missing, nil)
+}
+
+// requireTableSpecs 发布流列列表及行过滤条件需要PostgreSQL 15+
+func (t *Replication) requireTableSpecs(specs []TableSpec) error {
+	var feature string
+	for _, v := range specs {
+		if len(v.Columns) > 0 {
+			feature = "publication column lists"
+			break
+		}
+		if v.Where != "" {
+			feature = "publication row filters"
+			break
+		}
+	}
+	if feature == "" {
+		return nil
+	}
+	f, err := t.Features()
+	if err != nil {
+		return err
+	}
+	return f.require(feature, 150000)
+}
+
+// Schemas 配置需要同步的schema，发布流为FOR TABLES IN SCHEMA(PostgreSQL 15+)，之后在schema中新建的表自动发布
+// Start前校验发布流是否包含这些schema，不包含时返回*ProvisionError，配置AutoAlterPublication时自动创建发布流或添加schema
+func (t *Replication) Schemas(schemas ...string) *Replication {
+	t._schemas = schemas
+	return t
+}
+
+// parseSchemas 解析schema名称，返回规范名称(格式同TableName的各部分)及转义后以逗号分隔的sql
+func parseSchemas(schemas []string) (names []string, sql string, err error) {
+	quoted := make([]string, 0, len(schemas))
+	for _, v := range schemas {
+		ident, err := ParseIdentifier(v)
+		if err != nil || len(ident) != 1 {
+			return nil, "", fmt.Errorf("invalid schema %q", v)
+		}
+		names = append(names, canonicalPart(ident[0]))
+		quoted = append(quoted, ident.Sanitize())
+	}
+	return names, strings.Join(quoted, ", "), nil
+}
+
+// CreateSchemaPublication 创建FOR TABLES IN SCHEMA发布流，需要PostgreSQL 15+
+// 发布流包含schema中的所有表(包括之后新建的表)，新表的结构在首次变更时随relation消息一起到达
+func (t *Replication) CreateSchemaPublication(schemas []string) error {
+	if len(schemas) == 0 {
+		return errors.New("no schemas for publication")
+	}
+	_, quoted, err := parseSchemas(schemas)
+	if err != nil {
+		return err
+	}
+	if t.noPublicationDDL() {
+		return t.checkSchemaPublication(schemas)
+	}
+	f, err := t.Features()
+	if err != nil {
+		return err
+	}
+	if err = f.require("publications FOR TABLES IN SCHEMA", 150000); err != nil {
+		return err
+	}
+	return t.execEx(fmt.Sprintf("CREATE PUBLICATION %s FOR TABLES IN SCHEMA %s%s", pgx.Identifier{t.name}.Sanitize(), quoted, t.publishClause()))
+}
+
+// AlterPublicationSchemas 向发布流中添加/移除schema
+func (t *Replication) AlterPublicationSchemas(add, drop []string) error {
+	if t.noPublicationDDL() {
+		return t.checkPublicationSchemas(add, drop)
+	}
+	for _, v := range []struct {
+		action  string
+		schemas []string
+	}{{"ADD", add}, {"DROP", drop}} {
+		if len(v.schemas) == 0 {
+			continue
+		}
+		_, quoted, err := parseSchemas(v.schemas)
+		if err != nil {
+			return err
+		}
+		if err = t.execEx(fmt.Sprintf("ALTER PUBLICATION %s %s TABLES IN SCHEMA %s", pgx.Identifier{t.name}.Sanitize(), v.action, quoted)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublicationSchemas 获取FOR TABLES IN SCHEMA发布的schema，PostgreSQL 15以下为空
+// 详见：select * from pg_catalog.pg_publication_namespace;
+func (t *Replication) PublicationSchemas() ([]string, error) {
+	f, err := t.Features()
+	if err != nil || !f.SchemaPublication {
+		return nil, err
+	}
+	res, err := t.result(fmt.Sprintf("SELECT n.nspname::text FROM pg_publication_namespace pn JOIN pg_namespace n ON n.oid = pn.pnnspid JOIN pg_publication p ON p.oid = pn.pnpubid WHERE p.pubname = %s", quoteLiteral(t.name)))
+	if err != nil {
+		return nil, err
+	}
+	schemas := make([]string, 0, len(res))
+	for _, v := range res {
+		schemas = append(schemas, canonicalPart(fmt.Sprint(v["nspname"])))
+	}
+	return schemas, nil
+}
+
+// missingSchemas schemas中不在发布流的schema，发布流为FOR ALL TABLES时为空
+func (t *Replication) missingSchemas(state publicationState, schemas []string) ([]string, error) {
+	names, _, err := parseSchemas(schemas)
+	if err != nil || state.allTables {
+		return nil, err
+	}
+	published, err := t.PublicationSchemas()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(published))
+	for _, v := range published {
+		exists[v] = true
+	}
+	var missing []string
+	for i, v := range names {
+		if !exists[v] {
+			missing = append(missing, schemas[i])
+		}
+	}
+	return missing, nil
+}
+
+// checkSchemaPublication 发布流需已存在且包含schemas
+func (t *Replication) checkSchemaPublication(schemas []string) error {
+	_, quoted, err := parseSchemas(schemas)
+	if err != nil {
+		return err
+	}
+	state, err := t.publicationState(nil)
+	if err != nil {
+		return err
+	}
+	e := &ProvisionError{}
+	if !state.exists {
+		e.kind = ErrPublicationMissing
+		e.add("publication "+t.name, fmt.Sprintf("CREATE PUBLICATION %s FOR TABLES IN SCHEMA %s%s;", pgx.Identifier{t.name}.Sanitize(), quoted, t.publishClause()))
+		return e
+	}
+	missing, err := t.missingSchemas(state, schemas)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		_, quoted, _ = parseSchemas(missing)
+		e.add(fmt.Sprintf("schemas %s in publication %s", strings.Join(missing, ", "), t.name), fmt.Sprintf("ALTER PUBLICATION %s ADD TABLES IN SCHEMA %s;", pgx.Identifier{t.name}.Sanitize(), quoted))
+	}
+	return e.err()
+}
+
+// checkPublicationSchemas 发布流中需包含add且不包含drop
+func (t *Replication) checkPublicationSchemas(add, drop []string) error {
+	if len(add) > 0 {
+		if err := t.checkSchemaPublication(add); err != nil {
+			return err
+		}
+	}
+	names, _, err := parseSchemas(drop)
+	if err != nil || len(drop) == 0 {
+		return err
+	}
+	published, err := t.PublicationSchemas()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(published))
+	for _, v := range published {
+		exists[v] = true
+	}
+	var extra []string
+	for i, v := range names {
+		if exists[v] {
+			extra = append(extra, drop[i])
+		}
+	}
+	e := &ProvisionError{}
+	if len(extra) > 0 {
+		_, quoted, _ := parseSchemas(extra)
+		e.add(fmt.Sprintf("removal of schemas %s from publication %s", strings.Join(extra, ", "), t.name), fmt.Sprintf("ALTER PUBLICATION %s DROP TABLES IN SCHEMA %s;", pgx.Identifier{t.name}.Sanitize(), quoted))
+	}
+	return e.err()
+}
+
+// validatePublicationSchemas 发布流需包含Schemas
+func (t *Replication) validatePublicationSchemas() error {
+	if len(t._schemas) == 0 {
+		return nil
+	}
+	err := t.checkSchemaPublication(t._schemas)
+	var provision *ProvisionError
+	if err == nil || !errors.As(err, &provision) || !t._autoAlter || t.noPublicationDDL() {
+		return err
+	}
+	state, err := t.publicationState(nil)
+	if err != nil {
+		return err
+	}
+	if !state.exists {
+		t.debug("publication", "create", t.name, "schemas", t._schemas)
+		return t.CreateSchemaPublication(t._schemas)
+	}
+	missing, err := t.missingSchemas(state, t._schemas)
+	if err != nil {
+		return err
+	}
+	t.debug("publication", "add", t.name, "schemas", missing)
+	return t.AlterPublicationSchemas(missing, nil)
+}
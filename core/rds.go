@@ -0,0 +1,164 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// AWSCredentials AWS访问凭证
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// 临时凭证(STS/实例角色)的会话令牌，可为空
+	SessionToken string
+}
+
+// AWSCredentialsFromEnv 从环境变量AWS_ACCESS_KEY_ID、AWS_SECRET_ACCESS_KEY、AWS_SESSION_TOKEN读取凭证
+func AWSCredentialsFromEnv() (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	return creds, nil
+}
+
+// rdsTokenExpires RDS IAM认证令牌的有效期，只在建立连接时校验，已建立的连接不受影响
+const rdsTokenExpires = 15 * time.Minute
+
+// RDSAuthToken 生成RDS/Aurora IAM数据库认证令牌，作为连接密码使用
+// endpoint为host:port，令牌15分钟内有效
+func RDSAuthToken(endpoint, region, user string, creds AWSCredentials, now time.Time) (string, error) {
+	if _, _, err := splitEndpoint(endpoint); err != nil {
+		return "", err
+	}
+	now = now.UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/rds-db/aws4_request"
+	query := map[string]string{
+		"Action":              "connect",
+		"DBUser":              user,
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    creds.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(rdsTokenExpires / time.Second)),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if creds.SessionToken != "" {
+		query["X-Amz-Security-Token"] = creds.SessionToken
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		params = append(params, awsEscape(k)+"="+awsEscape(query[k]))
+	}
+	canonicalQuery := strings.Join(params, "&")
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		"/",
+		canonicalQuery,
+		"host:" + endpoint + "\n",
+		"host",
+		hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	signature := sigV4Signature(creds.SecretAccessKey, amzDate, region, "rds-db", canonicalRequest)
+	return endpoint + "/?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+// sigV4Signature AWS Signature Version 4对规范请求的签名，amzDate为20060102T150405Z格式的UTC时间
+func sigV4Signature(secret, amzDate, region, service, canonicalRequest string) string {
+	date := amzDate[:8]
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		date + "/" + region + "/" + service + "/aws4_request",
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, v := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape SigV4的URI编码，除A-Za-z0-9-_.~外全部编码为%XX
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func splitEndpoint(endpoint string) (host string, port uint16, err error) {
+	i := strings.LastIndexByte(endpoint, ':')
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid endpoint %s, host:port required", endpoint)
+	}
+	p, err := strconv.ParseUint(endpoint[i+1:], 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid endpoint %s, host:port required", endpoint)
+	}
+	return endpoint[:i], uint16(p), nil
+}
+
+// RDSIAM 使用RDS/Aurora IAM认证，每次建立连接(包括重连及故障转移)时用creds生成新的令牌作为密码
+// creds为空时从环境变量读取，RDS要求IAM认证的连接使用TLS，需在config中配置TLSConfig
+func (t *Replication) RDSIAM(region string, creds func() (AWSCredentials, error)) *Replication {
+	return t.Credentials(RDSIAMCredentials(region, creds))
+}
+
+// RDSIAMCredentials RDS/Aurora IAM认证的凭证提供者，creds为空时从环境变量读取
+func RDSIAMCredentials(region string, creds func() (AWSCredentials, error)) CredentialsProvider {
+	if creds == nil {
+		creds = AWSCredentialsFromEnv
+	}
+	return CredentialsFunc(func(config pgx.ConnConfig) (Credentials, error) {
+		c, err := creds()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("aws credentials: %w", err)
+		}
+		port := config.Port
+		if port == 0 {
+			port = 5432
+		}
+		token, err := RDSAuthToken(fmt.Sprintf("%s:%d", config.Host, port), region, config.User, c, time.Now())
+		if err != nil {
+			return Credentials{}, err
+		}
+		// 令牌只在建立连接时校验，已建立的连接无需重连
+		return Credentials{Password: token}, nil
+	})
+}
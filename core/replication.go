@@ -1,14 +1,14 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/cube-group/pg-replication/pkg/utils"
 	"github.com/jackc/pgx"
-	"github.com/jackc/pgx/pgtype"
-	"log"
-	"regexp"
-	"strings"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -28,23 +28,108 @@ const (
 	// 默认按照主键id为复制标识
 	// update时无法得知详细更新column信息
 	ReplicaIdentityDefault ReplicaIdentity = "DEFAULT"
+	// ReplicaIdentityNothing
+	// 不记录旧值，update/delete无法复制，只用于恢复表原来的复制标识
+	ReplicaIdentityNothing ReplicaIdentity = "NOTHING"
 )
 
 type Replication struct {
-	_debug    bool
-	_conn     *pgx.ReplicationConn
-	_flushMsg []ReplicationMessage
+	// 原子操作的64位字段放在第一个，32位平台上保证8字节对齐
+	_skippedMsg    uint64 // ParseLenient跳过的消息数
+	_debug         bool
+	_logger        Logger
+	_strict        bool
+	_noDDL         bool
+	_preflight     bool
+	_restoreIdent  bool
+	_identities    map[string]ReplicaIdentity // SetReplicaIdentity修改前的复制标识
+	_tempSlot      bool
+	_snapshot      bool
+	_snapshotJobs  int
+	_backfill      *backfillState
+	_rowCache      *rowCache // InferChangedColumns缓存的行值
+	_toast         ToastPolicy
+	_json          JSONPolicy
+	_numeric       NumericPolicy
+	_numericFn     func(string) (interface{}, error)
+	_time          TimePolicy
+	_timeLoc       *time.Location
+	_toastConn     *pgx.Conn         // ToastFetch读取用的连接
+	_exported      *exportedSnapshot // 刚创建复制槽时导出的快照，Start中读取后清空
+	_existingPub   bool
+	_schemaRefresh time.Duration
+	_sequenceSync  time.Duration
+	_status        time.Duration
+	_sequences     *sequenceTracker
+	_lag           *lagMonitor
+	_retention     *retentionWatchdog
+	_tenant        TenantExtractor
+	_credentials   CredentialsProvider
+	_reconnectAt   time.Time
+	_standby       bool
+	_primary       *pgx.ConnConfig
+	_recovery      bool
+	_failoverHosts []string
+	_primaryHost   string
+	_mu            sync.Mutex // 保护_conn，Close可能与Start并发调用
+	_statusMu      sync.Mutex // 串行化发送状态及保存checkpoint，Ack可能与Start在不同goroutine中调用
+	_conn          *pgx.ReplicationConn
+	_stop          chan struct{} // Stop时关闭
+	_done          chan struct{} // Start返回时关闭
+	_pause         chan struct{} // 暂停期间不为nil，Resume时关闭
+	_err           error         // Events在后台运行的Start返回的错误
+	_abortErr      error         // handler要求停止同步的错误，Start发送最终状态后返回
+	_state         ConnState
+	_lastErr       error // Start因错误返回时的错误，见Health
+	_transport     Transport
+	_capture       *CaptureWriter
+	_captureFile   string
+	_startLsn      uint64
+	_checkpoint    CheckpointAdapter
+	_features      *Features
+	_tables        []string
+	_schemas       []string
+	_autoAlter     bool
+	_publish       []PublishOperation
+	_include       []string
+	_exclude       []string
+	_filter        *tableFilter
+	_columns       map[string]*columnProjection
+	_msgFilter     MessageFilter
+	_testDecoding  func(lsn uint64, data string)
+	_plugin        string
+	_binary        bool
+	_dbufsIDs      map[string]uint32 // decoderbufs按表名分配的relation编号
+	_expectSystem  *SystemIdentity
+	_system        SystemIdentity
+	_lsn           lsnTracker
+	_faults        *faultInjector
+	_flushMsg      []ReplicationMessage
+	_streaming     bool
+	_stream        uint32 // 当前流式传输事务块的xid
+	_parser        Parser
+	_parseMode     ParseMode
+	_metrics       *streamMetrics
+	_tracer        Tracer
+	_traceCtx      context.Context // Start的ctx，事务span的父context
+	_txCtx         context.Context
+	_txSpan        Span // 当前事务的span
+	_twoPhase      bool
+	_xid           uint32 // 当前事务的xid
+	_origin        string // 当前事务的复制源
+	_skipOrigin    bool
+	_origins       []string
+	_nameErr       error // NewReplication的名称不合法
 
-	name   string
-	config pgx.ConnConfig
-	set    *RelationSet
+	name    string
+	config  pgx.ConnConfig
+	set     *RelationSet
+	catalog *Catalog
 }
 
+// NewReplication name同时作为复制槽及发布流的名称，不合法时(见ValidateName)Start及连接服务器的方法返回该错误
 func NewReplication(name string, config pgx.ConnConfig) *Replication {
-	if !regexp.MustCompile(`[a-z0-9_]{3,64}`).MatchString(name) {
-		log.Fatal("name invalid")
-	}
-	return &Replication{name: name, config: config, set: NewRelationSet()}
+	return &Replication{name: name, config: config, set: NewRelationSet(), catalog: NewCatalog(), _nameErr: ValidateName(name)}
 }
 
 func (t *Replication) Debug() *Replication {
@@ -52,19 +137,97 @@ func (t *Replication) Debug() *Replication {
 	return t
 }
 
+// StrictSchema 严格模式
+// 已缓存的表结构发生任何变化(新增列、类型变化、删除重建等)时停止同步并返回*SchemaDriftError
+// 当前事务不会确认lsn，重启后从该事务重新开始
+func (t *Replication) StrictSchema() *Replication {
+	t._strict = true
+	return t
+}
+
+// Transport 复制流传输层，*pgx.ReplicationConn实现了该接口
+type Transport interface {
+	StartReplication(slotName string, startLsn uint64, timeline int64, pluginArguments ...string) error
+	WaitForReplicationMessage(ctx context.Context) (*pgx.ReplicationMessage, error)
+	SendStandbyStatus(k *pgx.StandbyStatus) error
+	IsAlive() bool
+	Close() error
+}
+
+// StatusInterval 定时向服务器发送状态的间隔，默认10秒，负数为不定时发送
+// 只在事务提交和服务器要求回复时发送状态，长时间没有事务或handler处理较慢时可能超过wal_sender_timeout被服务器断开
+func (t *Replication) StatusInterval(d time.Duration) *Replication {
+	t._status = d
+	return t
+}
+
+func (t *Replication) statusInterval() time.Duration {
+	if t._status == 0 {
+		return 10 * time.Second
+	}
+	return t._status
+}
+
+// StartLsn 从指定lsn开始同步，0为从复制槽的confirmed_flush_lsn开始
+// 小于confirmed_flush_lsn的位置会被服务器忽略
+func (t *Replication) StartLsn(lsn uint64) *Replication {
+	t._startLsn = lsn
+	return t
+}
+
+// WithTransport 使用自定义传输层代替数据库复制连接，如单元测试中的模拟数据源
+// 自定义传输层没有数据库连接，Start时不会创建复制槽等数据库对象
+func (t *Replication) WithTransport(transport Transport) *Replication {
+	t._transport = transport
+	return t
+}
+
+// transport 获取复制流传输层
+func (t *Replication) transport() (Transport, error) {
+	transport := t._transport
+	if transport == nil {
+		conn, err := t.conn()
+		if err != nil {
+			return nil, err
+		}
+		transport = conn
+	}
+	if t._faults != nil {
+		transport = &faultTransport{Transport: transport, injector: t._faults}
+	}
+	return transport, nil
+}
+
 func (t *Replication) conn() (*pgx.ReplicationConn, error) {
+	if t._nameErr != nil {
+		return nil, t._nameErr
+	}
+	t._mu.Lock()
+	defer t._mu.Unlock()
 	if t._conn == nil || !t._conn.IsAlive() {
-		conn, err := pgx.ReplicationConnect(t.config)
+		var conn *pgx.ReplicationConn
+		var err error
+		var reconnectAt time.Time
+		if len(t._failoverHosts) > 0 {
+			conn, reconnectAt, err = t.connectPrimary()
+		} else {
+			var config pgx.ConnConfig
+			if config, reconnectAt, err = t.connConfig(t.config); err != nil {
+				return nil, err
+			}
+			conn, err = pgx.ReplicationConnect(config)
+		}
 		if err != nil {
 			return nil, err
 		}
 		t._conn = conn
+		t._reconnectAt = reconnectAt
 	}
 	return t._conn, nil
 }
 
-// 组装ReplicationMessage
-func (t *Replication) dump(eventType EventType, relation uint32, row, oldRow []Tuple) (msg ReplicationMessage, err error) {
+// 组装ReplicationMessage，key为变更前复制标识列所在的行(delete的旧行、update的旧值或新行)
+func (t *Replication) dump(eventType EventType, relation uint32, row, oldRow, key []Tuple) (msg ReplicationMessage, err error) {
 	msg.RelationID = relation
 	msg.EventType = eventType
 	msg.SchemaName, msg.TableName = t.set.Assist(relation)
@@ -77,110 +240,616 @@ func (t *Replication) dump(eventType EventType, relation uint32, row, oldRow []T
 		return
 	}
 	if oldRow != nil {
-		if oldValues, er := t.set.Values(relation, oldRow); er == nil {
-			msg.Columns = t.dumpChangedColumns(values, oldValues)
+		if rel, ok := t.set.Get(relation); ok && len(oldRow) == len(rel.Columns) {
+			msg.Columns = changedColumns(rel, row, oldRow)
 			if len(msg.Columns) == 0 { //没必要的update
 				return
 			}
+			if msg.OldBody, err = t.oldBody(rel, oldRow); err != nil {
+				return
+			}
 		}
 	}
 	body := make(map[string]interface{}, 0)
 	for name, value := range values {
-		val := value.Get()
+		val := t.columnValue(value)
 		body[name] = val
 	}
+	if err = t.unchangedToast(relation, row, oldRow, body); err != nil {
+		return
+	}
 	msg.Body = body
+	if key != nil {
+		if msg.Key, err = t.identity(relation, key); err != nil {
+			return
+		}
+	}
+	if rel, ok := t.set.Get(relation); ok {
+		changed := make(map[string]bool, len(msg.Columns))
+		for _, name := range msg.Columns {
+			changed[name] = true
+		}
+		msg.Fields = make(Fields, 0, len(rel.Columns))
+		for _, col := range rel.Columns {
+			if val, ok := body[col.Name]; ok {
+				msg.Fields = append(msg.Fields, Field{Name: col.Name, Value: val, Changed: changed[col.Name]})
+			}
+		}
+	}
+	if meta, ok := t.catalog.Table(relation); ok {
+		for _, col := range meta.Columns {
+			if _, ok := body[col.Name]; ok && col.Generated != "" {
+				msg.Generated = append(msg.Generated, col.Name)
+			}
+		}
+	}
+	t.project(&msg)
 	return
 }
 
-func (t *Replication) dumpChangedColumns(values, oldValues map[string]pgtype.Value) (res []string) {
-	if oldValues == nil || values == nil {
-		return nil
+// oldBody 解码update的旧值，跳过未变化的TOAST列
+func (t *Replication) oldBody(rel Relation, oldRow []Tuple) (map[string]interface{}, error) {
+	values, err := t.set.Values(rel.ID, oldRow)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing old values: %s", err)
+	}
+	body := make(map[string]interface{}, len(values))
+	for i, col := range rel.Columns {
+		if oldRow[i].Flag != 'u' {
+			body[col.Name] = t.columnValue(values[col.Name])
+		}
+	}
+	return body, nil
+}
+
+// identity 解码行中复制标识列的值
+func (t *Replication) identity(relation uint32, row []Tuple) (map[string]interface{}, error) {
+	rel, ok := t.set.Get(relation)
+	if !ok {
+		return nil, nil
+	}
+	values, err := t.set.Values(relation, row)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key values: %s", err)
 	}
-	for k, v := range oldValues {
-		if newV, ok := values[k]; !ok || newV.Get() != v.Get() {
-			res = append(res, k)
+	key := make(map[string]interface{})
+	for _, col := range rel.Columns {
+		if col.Key {
+			key[col.Name] = t.columnValue(values[col.Name])
+		}
+	}
+	return key, nil
+}
+
+// changedColumns 比较新旧行每一列的文本格式，按列顺序返回发生变化的列
+// 同一服务器对同一类型的文本输出是确定的，直接比较原始文本可以正确处理bytea、json、数组等不可用==比较的值
+// NULL与非NULL之间的变化视为变化，新行中未变化的TOAST值('u')视为未变化
+func changedColumns(rel Relation, row, oldRow []Tuple) (res []string) {
+	for i, col := range rel.Columns {
+		if i >= len(row) || i >= len(oldRow) {
+			break
+		}
+		n, o := row[i], oldRow[i]
+		if n.Flag == 'u' || o.Flag == 'u' {
+			continue
+		}
+		if (n.Flag == 'n') != (o.Flag == 'n') || !bytes.Equal(n.Value, o.Value) {
+			res = append(res, col.Name)
 		}
 	}
 	return
 }
 
 func (t *Replication) handle(message *pgx.WalMessage, dmlHandler ReplicationDMLHandler) error {
-	msg, err := Parse(message.WalData)
+	if t._plugin == pluginDecoderbufs {
+		return t.handleDecoderbufs(message, dmlHandler)
+	}
+	msg, err := t._parser.Parse(message.WalData)
 	if err != nil {
-		return fmt.Errorf("invalid pgoutput message: %s", err)
+		return t.parseError(pluginPgoutput, message.WalData, err)
 	}
 	var m ReplicationMessage
+	var xid uint32
+	var span Span
+	switch msg.(type) {
+	case Insert, Update, Delete, Truncate:
+		span = t.traceDecode(message.WalStart)
+	}
 	switch v := msg.(type) {
 	case Begin:
+		// 事务内的所有变更缓存到Commit时一起交给handler
+		// 上一个事务没有收到Commit(如重连后服务器从确认位置重新发送)时丢弃其缓存，避免重复投递
+		if len(t._flushMsg) > 0 {
+			t.debug("replication", "discard", len(t._flushMsg), "messages of unfinished transaction")
+		}
+		t._xid = uint32(v.XID)
+		t._origin = ""
+		t.traceBegin(v, message.WalStart)
+		t._flushMsg = []ReplicationMessage{{EventType: EventType_BEGIN, Lsn: message.WalStart, CommitTime: v.Timestamp, Xid: t._xid}}
 	case Relation:
+		xid = v.XID
 		if t._flushMsg == nil {
 			t._flushMsg = make([]ReplicationMessage, 0)
 		}
-		t.set.Add(v)
+		if t._strict && t.allowTable(v.Namespace, v.Name) {
+			if old, changes := t.set.Drift(v); len(changes) > 0 {
+				t._flushMsg = nil
+				return &SchemaDriftError{Lsn: message.WalStart, Old: old, New: v, Changes: changes}
+			}
+		}
+		if t.set.Add(v) && t.allowTable(v.Namespace, v.Name) {
+			t.debug("relation", "reset", v.ID, v.Namespace, v.Name)
+			if t._rowCache != nil {
+				t._rowCache.clear(v)
+			}
+			m = ReplicationMessage{RelationID: v.ID, EventType: EventType_SCHEMA_RESET, SchemaName: v.Namespace, TableName: v.Name}
+			for _, col := range v.Columns {
+				m.Columns = append(m.Columns, col.Name)
+			}
+		}
+	case Type:
+		// 自定义类型(enum等)在使用它的Relation之前发送
+		t.debug("type", v.ID, v.Namespace, v.Name)
+		t.set.AddType(v)
 	case Insert:
-		m, err = t.dump(EventType_INSERT, v.RelationID, v.Row, nil)
+		if !t.allowRelation(v.RelationID) {
+			break
+		}
+		xid = v.XID
+		t.cacheRow(EventType_INSERT, v.RelationID, v.Row)
+		// 新行的复制标识列作为Key，REPLICA IDENTITY FULL时所有列都是复制标识列，不设置
+		key := v.Row
+		if rel, ok := t.set.Get(v.RelationID); ok && rel.Replica == 'f' {
+			key = nil
+		}
+		m, err = t.dump(EventType_INSERT, v.RelationID, v.Row, nil, key)
 	case Update:
-		m, err = t.dump(EventType_UPDATE, v.RelationID, v.Row, v.OldRow)
+		if !t.allowRelation(v.RelationID) {
+			break
+		}
+		xid = v.XID
+		// 主键变化时OldRow只有复制标识列('K')，不是完整的旧值
+		var oldRow []Tuple
+		if v.Old {
+			oldRow = v.OldRow
+		} else if t._rowCache != nil {
+			oldRow = t.inferOldRow(v)
+		}
+		key := v.Row
+		if v.Key || v.Old {
+			key = v.OldRow
+		}
+		m, err = t.dump(EventType_UPDATE, v.RelationID, v.Row, oldRow, key)
+		m.FullOldRow = m.OldBody != nil
 	case Delete:
-		m, err = t.dump(EventType_DELETE, v.RelationID, v.Row, nil)
+		if !t.allowRelation(v.RelationID) {
+			break
+		}
+		xid = v.XID
+		t.cacheRow(EventType_DELETE, v.RelationID, v.Row)
+		m, err = t.dump(EventType_DELETE, v.RelationID, v.Row, nil, v.Row)
+		m.FullOldRow = v.Old
 	case Truncate:
-		m, err = t.dump(EventType_TRUNCATE, v.RelationID, nil, nil)
-	case Commit:
-		t._flushMsg = append(t._flushMsg, ReplicationMessage{EventType: EventType_COMMIT, Lsn: message.WalStart})
-		status := dmlHandler(t._flushMsg...)
-		t._flushMsg = nil
-		if status == DMLHandlerStatusSuccess {
-			err = t.SendStatusACK(message.WalStart)
+		// 一条TRUNCATE语句清空的所有表在同一消息中，每张表一个变更
+		xid = v.XID
+		for _, id := range v.RelationIDs {
+			if !t.allowRelation(id) {
+				continue
+			}
+			if m.RelationID > 0 {
+				t.buffer(t.stamp(m, message.WalStart, xid))
+			}
+			t.cacheRow(EventType_TRUNCATE, id, nil)
+			m, _ = t.dump(EventType_TRUNCATE, id, nil, nil, nil)
 		}
+	case Origin:
+		t.origin(v)
+	case Commit:
+		err = t.commit(message.WalStart, v.Timestamp, dmlHandler)
+	case StreamStart:
+		t.streamStart(v)
+	case StreamStop:
+		t.streamStop(message, dmlHandler)
+	case StreamCommit:
+		err = t.streamCommit(message, v, dmlHandler)
+	case StreamAbort:
+		t.streamAbort(message, v, dmlHandler)
+	case BeginPrepare:
+		t.beginPrepare(message, v)
+	case Prepare:
+		err = t.prepare(message, v, dmlHandler)
+	case StreamPrepare:
+		err = t.prepare(message, Prepare(v), dmlHandler)
+	case CommitPrepared:
+		err = t.finishPrepared(message, ReplicationMessage{EventType: EventType_COMMIT_PREPARED, CommitTime: v.Timestamp, Xid: v.XID, Gid: v.GID}, dmlHandler)
+	case RollbackPrepared:
+		err = t.finishPrepared(message, ReplicationMessage{EventType: EventType_ROLLBACK_PREPARED, CommitTime: v.Timestamp, Xid: v.XID, Gid: v.GID}, dmlHandler)
+	case LogicalMessage:
+		t.backfillMessage(v, message.WalStart, dmlHandler)
 	}
+	t.traceDecoded(span, m, err)
 	if err != nil {
 		return err
 	}
 	if m.RelationID > 0 {
-		m.Lsn = message.WalStart
-		t._flushMsg = append(t._flushMsg, m)
+		t.buffer(t.stamp(m, message.WalStart, xid))
 	}
 	return nil
 }
 
+// stamp 设置变更的lsn及所在事务
+func (t *Replication) stamp(m ReplicationMessage, lsn uint64, xid uint32) ReplicationMessage {
+	m.Lsn = lsn
+	if t._stream != 0 {
+		m.Xid, m.SubXid = t._stream, xid
+	} else if t._xid != 0 {
+		m.Xid = t._xid
+	}
+	return m
+}
+
+// buffer 把变更加入当前事务的缓存
+func (t *Replication) buffer(m ReplicationMessage) {
+	t.trackBackfill(m)
+	m.Origin = t._origin
+	if t._stream == 0 && t.skipOrigin(t._origin) && m.EventType != EventType_SCHEMA_RESET {
+		return
+	}
+	if t.accept(&m) {
+		t._flushMsg = append(t._flushMsg, m)
+	}
+}
+
+// accept 设置租户并执行Filter，返回false时丢弃该变更
+func (t *Replication) accept(m *ReplicationMessage) bool {
+	if t._tenant != nil {
+		m.Tenant = t._tenant(*m)
+	}
+	return t._msgFilter == nil || m.EventType == EventType_SCHEMA_RESET || t._msgFilter(*m)
+}
+
+// commit 事务提交，缓存的变更交给handler，处理成功后确认lsn
+// 跳过的事务只把COMMIT交给handler，由handler决定能否确认，攒批的Sink有缓存时不确认
+func (t *Replication) commit(lsn uint64, commitTime time.Time, dmlHandler ReplicationDMLHandler) error {
+	skipped := t.skipped()
+	if skipped {
+		t._flushMsg = nil
+	}
+	for i := range t._flushMsg {
+		t._flushMsg[i].CommitTime = commitTime
+	}
+	t._flushMsg = append(t._flushMsg, ReplicationMessage{EventType: EventType_COMMIT, Lsn: lsn, CommitTime: commitTime, Xid: t._xid, Origin: t._origin})
+	status := t.traceHandler(t._flushMsg, dmlHandler)(t._flushMsg...)
+	t._flushMsg = nil
+	t._xid, t._origin = 0, ""
+	var err error
+	if status == DMLHandlerStatusSuccess {
+		err = t.confirm(lsn)
+	}
+	t.traceEnd(map[string]interface{}{"pg_replication.commit": pgx.FormatLSN(lsn), "pg_replication.skipped": skipped}, err)
+	return err
+}
+
 func (t *Replication) Close() {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	if t._checkpoint != nil {
+		t._checkpoint.Close()
+	}
 	if t._conn != nil {
 		t._conn.Close()
 	}
+	if t._transport != nil {
+		t._transport.Close()
+	}
+}
+
+// Stop 优雅停止同步：等待handler处理完当前事务，向服务器发送最终状态并关闭连接，之后Start返回nil
+// 未收到Commit的事务不会交给handler，重启后服务器从确认位置重新发送
+// ctx结束时不再等待并返回ctx.Err()，Start仍会在当前事务处理完后退出
+func (t *Replication) Stop(ctx context.Context) error {
+	done := t.requestStop()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause 暂停读取和投递消息，保持连接并定时向服务器发送状态，避免超过wal_sender_timeout
+// 在handler处理完当前消息后生效，未读取的变更保留在服务器端，可用于下游维护期间暂停同步
+func (t *Replication) Pause() {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	if t._pause == nil {
+		t.debug("replication", "pause")
+		t._pause = make(chan struct{})
+	}
+}
+
+// Resume 恢复Pause暂停的同步
+func (t *Replication) Resume() {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	if t._pause != nil {
+		t.debug("replication", "resume")
+		close(t._pause)
+		t._pause = nil
+	}
+}
+
+// Paused 是否已暂停
+func (t *Replication) Paused() bool {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._pause != nil
+}
+
+// waitResume 暂停期间阻塞，每隔interval向服务器发送状态，返回false时需结束同步
+// Stop会同时取消ctx，stop已关闭时总是返回true，由调用方检查stop后正常结束
+func (t *Replication) waitResume(ctx context.Context, stop chan struct{}, interval time.Duration) bool {
+	t._mu.Lock()
+	paused := t._pause
+	t._mu.Unlock()
+	if paused == nil {
+		return true
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-paused:
+			return true
+		case <-stop:
+			return true
+		case <-ctx.Done():
+			select {
+			case <-stop:
+				return true
+			default:
+			}
+			return false
+		case <-ticker.C:
+			if err := t.SendStatusACK(0); err != nil {
+				t.debug("replication", "status", err)
+			}
+		}
+	}
+}
+
+// requestStop 通知Start停止，不等待，返回Start结束时关闭的通道
+func (t *Replication) requestStop() chan struct{} {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	if t._stop != nil {
+		select {
+		case <-t._stop:
+		default:
+			close(t._stop)
+		}
+	}
+	return t._done
+}
+
+// abort handler处理失败时停止同步，Start发送最终状态(不包含失败的事务)后返回err，重启后服务器从确认位置重新发送
+func (t *Replication) abort(err error) {
+	t._mu.Lock()
+	if t._abortErr == nil {
+		t._abortErr = err
+	}
+	t._mu.Unlock()
+	t.requestStop()
+}
+
+// aborted 本次Start是否已因handler失败而停止
+func (t *Replication) aborted() bool {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._abortErr != nil
+}
+
+// shutdown Stop后发送最终状态，因abort停止时返回其错误
+func (t *Replication) shutdown() error {
+	t.debug("replication", "stop")
+	if err := t.SendStatusACK(0); err != nil {
+		t.debug("replication", "final status", err)
+	}
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._abortErr
 }
 
 func (t *Replication) Start(ctx context.Context, dmlHandler ReplicationDMLHandler) (err error) {
-	conn, err := t.conn()
+	stop, done := make(chan struct{}), make(chan struct{})
+	t._mu.Lock()
+	t._stop, t._done = stop, done
+	t._state, t._lastErr, t._abortErr = ConnConnecting, nil, nil
+	t._mu.Unlock()
+	defer close(done)
+	parent := ctx
+	defer func() {
+		t._mu.Lock()
+		defer t._mu.Unlock()
+		t._state = ConnStopped
+		if parent.Err() == nil {
+			t._lastErr = err
+		}
+	}()
+	if err = t._nameErr; err != nil {
+		return
+	}
+	if t._metrics != nil {
+		t._metrics.start()
+		dmlHandler = t._metrics.instrument(dmlHandler)
+	}
+	if _, err = t.tableFilter(); err != nil {
+		return
+	}
+	closeCapture, err := t.openCapture()
 	if err != nil {
 		return
 	}
-	defer conn.Close()
-	// create replica identity|publication|replication
-	if err = t.CreateReplication(); err != nil {
-		return fmt.Errorf("CreateReplication %v", err)
+	defer closeCapture()
+	conn, err := t.transport()
+	if err != nil {
+		return
+	}
+	defer func() { conn.Close() }()
+	defer t.closeToastConn()
+	t._traceCtx = ctx
+	defer t.traceEnd(map[string]interface{}{"pg_replication.discarded": true}, nil)
+	t._parser.Reset()
+	t._stream = 0
+	t._xid = 0
+	var promoted bool
+	if t._transport == nil {
+		if err = t.negotiate(); err != nil {
+			return fmt.Errorf("server version %w", err)
+		}
+		if t._standby {
+			if promoted, err = t.standbyCheck(); err != nil {
+				return fmt.Errorf("standby %w", err)
+			}
+		}
+		if err = t.checkSystem(); err != nil {
+			return fmt.Errorf("IDENTIFY_SYSTEM %w", err)
+		}
+		if t._preflight {
+			if err = t.Preflight(); err != nil {
+				return err
+			}
+		}
+		// create replica identity|publication|replication
+		// 发布流先于复制槽就绪，导出的快照中才能看到发布流
+		if err = t.validatePublication(); err != nil {
+			return fmt.Errorf("publication %s: %w", t.name, err)
+		}
+		if err = t.CreateReplication(); err != nil {
+			return fmt.Errorf("CreateReplication %w", classifyError(err))
+		}
+		if err = t.initialSnapshot(ctx, dmlHandler); err != nil {
+			return fmt.Errorf("initial snapshot %w", err)
+		}
 	}
 	// start replication slot
-	pluginArguments := t.pluginArgs("1", t.name)
-	if err = conn.StartReplication(t.name, 0, -1, pluginArguments...); err != nil {
-		return fmt.Errorf("StartReplication %v", err)
+	startLsn, err := t.startPosition()
+	if err != nil {
+		return err
+	}
+	pluginArguments := t.pluginArgs(t.protoVersion(t.features()), t.name)
+	if err = conn.StartReplication(t.name, startLsn, -1, pluginArguments...); err != nil {
+		return fmt.Errorf("StartReplication %w", classifyError(err))
+	}
+	t.setState(ConnStreaming)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Stop时中断等待中的读取
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if t._schemaRefresh > 0 {
+		go t.refreshCatalog(ctx)
+	}
+	if t._sequenceSync > 0 {
+		t._sequences = newSequenceTracker()
+		go t.captureSequences(ctx)
+	}
+	if t._testDecoding != nil && t._transport == nil {
+		go t.runTestDecoding(ctx)
+	}
+	if t._lag != nil && t._transport == nil {
+		go t.monitorLag(ctx)
+	}
+	if t._retention != nil && t._transport == nil {
+		go t.watchRetention(ctx, done)
 	}
 	// ready notify
 	dmlHandler(ReplicationMessage{EventType: EventType_READY})
+	if promoted {
+		t.debug("replication", "standby promoted")
+		dmlHandler(ReplicationMessage{EventType: EventType_PROMOTED})
+	}
 	// round read
 	waitTimeout := 10 * time.Second
+	if t._sequenceSync > 0 && t._sequenceSync < waitTimeout {
+		waitTimeout = t._sequenceSync
+	}
+	statusInterval := t.statusInterval()
 	for {
+		if !t.waitResume(ctx, stop, statusInterval) {
+			return ctx.Err()
+		}
+		select {
+		case <-stop:
+			return t.shutdown()
+		default:
+		}
+		if t._sequences != nil {
+			t.deliverSequences(dmlHandler)
+		}
+		timeout := waitTimeout
+		if statusInterval > 0 {
+			next := time.Until(t._lsn.sentAt().Add(statusInterval))
+			if next <= 0 {
+				if err = t.SendStatusACK(0); err != nil {
+					t.debug("replication", "status", err)
+				}
+				next = statusInterval
+			}
+			if next < timeout {
+				timeout = next
+			}
+		}
+		if reconnectAt := t.reconnectAt(); !reconnectAt.IsZero() {
+			until := time.Until(reconnectAt)
+			if until <= 0 {
+				// 凭证即将过期，使用新凭证重连
+				if conn, err = t.reconnect(conn); err != nil {
+					return err
+				}
+				continue
+			}
+			if until < timeout {
+				timeout = until
+			}
+		}
 		var message *pgx.ReplicationMessage
-		wctx, cancel := context.WithTimeout(ctx, waitTimeout)
+		wctx, cancel := context.WithTimeout(ctx, timeout)
 		message, err = conn.WaitForReplicationMessage(wctx)
 		cancel()
 		if err == context.DeadlineExceeded {
 			continue
 		}
 		if err != nil {
-			return fmt.Errorf("WaitForReplicationMessage: %s", err)
+			select {
+			case <-stop:
+				return t.shutdown()
+			default:
+			}
+			return fmt.Errorf("WaitForReplicationMessage: %w", classifyError(err))
+		}
+		if t._capture != nil {
+			if err = t._capture.Write(message); err != nil {
+				return fmt.Errorf("capture: %s", err)
+			}
 		}
 		if message.WalMessage != nil {
+			t._lsn.receive(message.WalMessage.WalStart)
+			t._lsn.server(message.WalMessage.ServerWalEnd)
+			if t._metrics != nil {
+				t._metrics.receive(len(message.WalMessage.WalData))
+			}
 			if err = t.handle(message.WalMessage, dmlHandler); err != nil {
 				return err
 			}
@@ -188,6 +857,7 @@ func (t *Replication) Start(ctx context.Context, dmlHandler ReplicationDMLHandle
 		// 服务器心跳验证当前sub是否可用
 		// 不向master发送reply可能会导致连接EOF
 		if message.ServerHeartbeat != nil {
+			t._lsn.server(message.ServerHeartbeat.ServerWalEnd)
 			if message.ServerHeartbeat.ReplyRequested == 1 {
 				if err = t.SendStatusACK(0); err != nil {
 					t.debug("replication", "ServerHeartbeat", err)
@@ -197,6 +867,42 @@ func (t *Replication) Start(ctx context.Context, dmlHandler ReplicationDMLHandle
 	}
 }
 
+// reconnect 关闭当前复制连接并重新开始同步
+// 未提交的事务没有确认lsn，服务器会从复制槽的确认位置重新发送
+func (t *Replication) reconnect(conn Transport) (Transport, error) {
+	t.debug("replication", "reconnect")
+	if t._metrics != nil {
+		t._metrics.reconnect()
+	}
+	conn.Close()
+	t.setState(ConnConnecting)
+	t._flushMsg = nil
+	t._parser.Reset()
+	t._stream = 0
+	t._xid = 0
+	next, err := t.transport()
+	if err != nil {
+		return conn, fmt.Errorf("reconnect %w", err)
+	}
+	if t._transport == nil {
+		if err = t.negotiate(); err != nil {
+			return next, fmt.Errorf("server version %w", err)
+		}
+		if err = t.checkSystem(); err != nil {
+			return next, fmt.Errorf("IDENTIFY_SYSTEM %w", err)
+		}
+	}
+	startLsn, err := t.startPosition()
+	if err != nil {
+		return next, err
+	}
+	if err = next.StartReplication(t.name, startLsn, -1, t.pluginArgs(t.protoVersion(t.features()), t.name)...); err != nil {
+		return next, fmt.Errorf("StartReplication %w", classifyError(err))
+	}
+	t.setState(ConnStreaming)
+	return next, nil
+}
+
 // 执行sql忽略exist
 func (t *Replication) execEx(sql string) error {
 	conn, err := t.conn()
@@ -209,7 +915,7 @@ func (t *Replication) execEx(sql string) error {
 		// 42704 no exist
 		if !ok || (pgErr.Code != "42710" && pgErr.Code != "42704") {
 			t.debug("exec.err:", sql, err)
-			return err
+			return classifyError(err)
 		} else {
 			t.debug("exec:", sql, "[silent]")
 		}
@@ -234,7 +940,6 @@ func (t *Replication) result(sql string) (res []map[string]interface{}, err erro
 	var values []interface{}
 	for rows.Next() {
 		values, err = rows.Values()
-		fmt.Println(values, rows.FieldDescriptions())
 		if err != nil {
 			return
 		}
@@ -247,15 +952,38 @@ func (t *Replication) result(sql string) (res []map[string]interface{}, err erro
 	return
 }
 
+// 服务器版本号，如160002
+func (t *Replication) serverVersionNum() (int, error) {
+	res, err := t.result("SHOW server_version_num")
+	if err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, fmt.Errorf("empty server_version_num")
+	}
+	return strconv.Atoi(fmt.Sprint(res[0]["server_version_num"]))
+}
+
 // SendStatusACK
 // 向master发送lsn，即：WAL中使用者已经收到解码数据的最新位置
 // 详见：select * from pg_catalog.pg_replication_slots；结果中的confirmed_flush_lsn
+// lsn为已被handler成功处理的位置，0为只上报当前位置(如回复服务器心跳)
+// write位置为已收到的最新位置，flush/apply位置为已处理的最新位置，见pg_stat_replication
 func (t *Replication) SendStatusACK(lsn uint64) error {
-	conn, err := t.conn()
+	t._statusMu.Lock()
+	defer t._statusMu.Unlock()
+	return t.sendStatus(lsn)
+}
+
+// sendStatus 需持有t._statusMu，pgx的SendStandbyStatus共用连接的写缓冲，不能并发调用
+func (t *Replication) sendStatus(lsn uint64) error {
+	conn, err := t.transport()
 	if err != nil {
 		return err
 	}
-	k, err := pgx.NewStandbyStatus(lsn)
+	received, flushed := t._lsn.flush(lsn)
+	// 三个参数的顺序为flush、apply、write
+	k, err := pgx.NewStandbyStatus(flushed, flushed, received)
 	if err != nil {
 		return fmt.Errorf("error confirm lsn: %v %s", lsn, err)
 	}
@@ -264,55 +992,337 @@ func (t *Replication) SendStatusACK(lsn uint64) error {
 	}); err != nil {
 		return err
 	}
-	t.debug("sendStatus lsn:", lsn, pgx.FormatLSN(lsn))
+	t.debug("sendStatus lsn:", lsn, "write:", pgx.FormatLSN(received), "flush:", pgx.FormatLSN(flushed))
 	return nil
 }
 
+// Positions 获取已收到的最新位置及已确认处理的最新位置
+func (t *Replication) Positions() (received, flushed uint64) {
+	return t._lsn.positions()
+}
+
+// lsnTracker 分别记录已收到和已处理的wal位置
+type lsnTracker struct {
+	mu       sync.Mutex
+	received uint64
+	flushed  uint64
+	end      uint64    // 服务器wal的最新位置
+	at       time.Time // 最近一次收到服务器消息的时间
+	sent     time.Time // 最近一次发送状态的时间
+}
+
+func (l *lsnTracker) receive(lsn uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lsn > l.received {
+		l.received = lsn
+	}
+}
+
+// flush 记录已处理的位置，返回需要上报的write和flush位置
+func (l *lsnTracker) flush(lsn uint64) (received, flushed uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lsn > l.flushed {
+		l.flushed = lsn
+	}
+	if l.flushed > l.received {
+		l.received = l.flushed
+	}
+	l.sent = time.Now()
+	return l.received, l.flushed
+}
+
+// server 记录服务器消息及心跳中的wal最新位置
+func (l *lsnTracker) server(end uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if end > l.end {
+		l.end = end
+	}
+	l.at = time.Now()
+}
+
+func (l *lsnTracker) lastMessage() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.at
+}
+
+func (l *lsnTracker) serverEnd() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.end
+}
+
+func (l *lsnTracker) sentAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sent
+}
+
+func (l *lsnTracker) positions() (received, flushed uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.received, l.flushed
+}
+
 func (t *Replication) pluginArgs(version, publication string) []string {
+	if t._plugin == pluginDecoderbufs {
+		return nil
+	}
 	//} else if outputPlugin == "wal2json" {
 	//	pluginArguments = []string{"\"pretty-print\" 'true'"}
 	//}
-	return []string{fmt.Sprintf(`proto_version '%s'`, version), fmt.Sprintf(`publication_names '%s'`, publication)}
+	args := []string{fmt.Sprintf(`proto_version '%s'`, version), fmt.Sprintf(`publication_names %s`, quoteLiteral(publication))}
+	if t._streaming {
+		args = append(args, `streaming 'on'`)
+	}
+	if t._twoPhase {
+		args = append(args, `two_phase 'on'`)
+	}
+	if t._binary {
+		args = append(args, `binary 'true'`)
+	}
+	if t._backfill != nil {
+		args = append(args, `messages 'true'`)
+	}
+	if t._skipOrigin && len(t._origins) == 0 && t.features().Origin {
+		args = append(args, `origin 'none'`)
+	}
+	return args
 }
 
 // CreateReplication 创建逻辑复制槽
-// 锁定起始lsn位置
+// 锁定起始lsn位置，复制槽已存在时直接使用
 func (t *Replication) CreateReplication() (err error) {
-	// create publication
-	return t.execEx(fmt.Sprintf("CREATE_REPLICATION_SLOT %s LOGICAL %s NOEXPORT_SNAPSHOT", t.name, "pgoutput"))
+	if t._noDDL && !t._tempSlot {
+		return t.checkSlot()
+	}
+	info, err := t.Slot()
+	if err != nil {
+		return err
+	}
+	if info.Exists && t._snapshot && !info.Temporary {
+		if info, err = t.resetSnapshot(info); err != nil {
+			return err
+		}
+	}
+	if info.Exists {
+		if t._twoPhase && !info.TwoPhase {
+			t.debug("twophase", "slot", t.name, "was created without TWO_PHASE, prepared transactions are sent at COMMIT PREPARED")
+		}
+		if info.Synced {
+			t.debug("failover", "resume from synced slot", t.name, info.ConfirmedFlushLsn)
+		}
+		if t._tempSlot && !info.Temporary {
+			t.debug("replication", "slot", t.name, "exists and is not temporary, it is kept after disconnect")
+		}
+		t.debug("replication", "slot exists", t.name, info.ConfirmedFlushLsn)
+		return nil
+	}
+	options, err := t.slotOptions()
+	if err != nil {
+		return err
+	}
+	return t.createSlot(options)
+}
+
+// createSlot 创建复制槽，只在确认复制槽不存在后调用，不忽略任何错误
+func (t *Replication) createSlot(options string) error {
+	conn, err := t.conn()
+	if err != nil {
+		return err
+	}
+	kind := "LOGICAL"
+	if t._tempSlot {
+		kind = "TEMPORARY LOGICAL"
+	}
+	sql := fmt.Sprintf("CREATE_REPLICATION_SLOT %s %s %s %s", t.name, kind, t.plugin(), options)
+	t.debug("exec:", sql)
+	var res []map[string]interface{}
+	if t._snapshot && !t._tempSlot {
+		// 导出快照后复制连接不能执行其他命令，标记需在创建复制槽之前
+		if err = t.markSnapshot(); err != nil {
+			return err
+		}
+	}
+	if t._snapshot {
+		// 返回slot_name, consistent_point, snapshot_name, output_plugin
+		res, err = t.result(sql)
+	} else {
+		_, err = conn.Exec(sql)
+	}
+	if err != nil {
+		err = classifyError(err)
+		if errors.Is(err, ErrSlotExists) {
+			// 检查之后被其他消费者创建
+			t.debug("exec:", sql, "[exists]")
+			return nil
+		}
+		return err
+	}
+	if len(res) > 0 {
+		lsn, err := pgx.ParseLSN(fmt.Sprint(res[0]["consistent_point"]))
+		if err != nil {
+			return err
+		}
+		t._exported = &exportedSnapshot{name: fmt.Sprint(res[0]["snapshot_name"]), lsn: lsn}
+	}
+	return nil
 }
 
 // DropReplication 移除复制槽
 func (t *Replication) DropReplication() error {
-	return t.execEx(fmt.Sprintf("SELECT pg_drop_replication_slot('%s');", t.name))
+	if t._noDDL {
+		return &ProvisionError{Missing: []string{"drop of replication slot " + t.name}, SQL: []string{fmt.Sprintf("SELECT pg_drop_replication_slot(%s);", quoteLiteral(t.name))}}
+	}
+	if err := t.execEx(fmt.Sprintf("SELECT pg_drop_replication_slot(%s);", quoteLiteral(t.name))); err != nil {
+		return err
+	}
+	if t._snapshot {
+		if err := t.unmarkSnapshot(); err != nil {
+			return err
+		}
+	}
+	if t._restoreIdent {
+		return t.RestoreReplicaIdentity()
+	}
+	return nil
 }
 
-// CreatePublication 移除复制槽
+// CreatePublication 创建发布流，tables为空时为FOR ALL TABLES，之后新建的表自动发布
+// 按schema发布见CreateSchemaPublication
 func (t *Replication) CreatePublication(tables []string) error {
+	if err := t.ValidateTables(tables); err != nil {
+		return err
+	}
+	if t._existingPub && len(tables) == 0 {
+		return t.checkPublicationExists()
+	}
+	if t.noPublicationDDL() {
+		return t.checkPublication(tables)
+	}
 	var tableString string
 	if tables == nil || len(tables) == 0 {
 		tableString = "ALL TABLES"
 	} else {
-		tableString = "TABLE " + strings.Join(tables, ",")
+		quoted, specs, err := publicationTables(tables)
+		if err != nil {
+			return err
+		}
+		if err = t.requireTableSpecs(specs); err != nil {
+			return err
+		}
+		tableString = "TABLE " + quoted
 	}
 	// 详见：select * from pg_catalog.pg_publication;
-	return t.execEx(fmt.Sprintf("CREATE PUBLICATION %s FOR %s", t.name, tableString))
+	return t.execEx(fmt.Sprintf("CREATE PUBLICATION %s FOR %s%s", pgx.Identifier{t.name}.Sanitize(), tableString, t.publishClause()))
 }
 
-// DropPublication 移除复制槽
+// DropPublication 移除发布流
 func (t *Replication) DropPublication() error {
-	if err := t.execEx(fmt.Sprintf("drop publication if exists %s;", t.name)); err != nil {
+	if t.noPublicationDDL() {
+		return &ProvisionError{Missing: []string{"drop of publication " + t.name}, SQL: []string{fmt.Sprintf("DROP PUBLICATION IF EXISTS %s;", pgx.Identifier{t.name}.Sanitize())}}
+	}
+	if err := t.execEx(fmt.Sprintf("drop publication if exists %s;", pgx.Identifier{t.name}.Sanitize())); err != nil {
 		return err
 	}
 	return nil
 }
 
-// SetReplicaIdentity 配置表复制标识
+// SetReplicaIdentity 配置表复制标识，修改前记录原来的复制标识，可用RestoreReplicaIdentity恢复
 func (t *Replication) SetReplicaIdentity(tables []string, status ReplicaIdentity) (err error) {
+	if t._noDDL {
+		return t.checkReplicaIdentity(tables, status)
+	}
+	// 不是表的所有者时在修改任何表之前返回
+	e := &PreflightError{}
+	if err = t.preflightOwnership(e, tables, "run ALTER TABLE ... REPLICA IDENTITY "+string(status)); err != nil {
+		return
+	}
+	if len(e.Problems) > 0 {
+		return e
+	}
 	for _, v := range tables {
-		if err = t.execEx(fmt.Sprintf("ALTER TABLE %s replica identity %s", v, status)); err != nil {
+		var table string
+		if table, err = quoteTable(v); err != nil {
+			return
+		}
+		if err = t.recordReplicaIdentity(v, table, status); err != nil {
+			return
+		}
+		if err = t.execEx(fmt.Sprintf("ALTER TABLE %s replica identity %s", table, status)); err != nil {
 			return
 		}
 	}
 	return
 }
+
+// PublicationTables 获取发布流中的表，格式见TableName，包括FOR ALL TABLES及FOR TABLES IN SCHEMA展开后的表
+// 详见：select * from pg_catalog.pg_publication_tables;
+func (t *Replication) PublicationTables() ([]string, error) {
+	res, err := t.result(fmt.Sprintf("SELECT schemaname::text, tablename::text FROM pg_publication_tables WHERE pubname = %s", quoteLiteral(t.name)))
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(res))
+	for _, v := range res {
+		tables = append(tables, TableName(fmt.Sprint(v["schemaname"]), fmt.Sprint(v["tablename"])))
+	}
+	return tables, nil
+}
+
+// ValidateTables 检查表是否存在，不存在时返回*MissingTablesError
+func (t *Replication) ValidateTables(tables []string) error {
+	var missing []string
+	for _, v := range tables {
+		table, err := quoteTable(v)
+		if err != nil {
+			return err
+		}
+		res, err := t.result(fmt.Sprintf("SELECT coalesce(to_regclass(%s)::text, '') AS oid", quoteLiteral(table)))
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 || res[0]["oid"] == "" {
+			missing = append(missing, qualifiedTable(v))
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingTablesError{Tables: missing}
+	}
+	return nil
+}
+
+// AlterPublication 向发布流中添加/移除表
+func (t *Replication) AlterPublication(add, drop []string) error {
+	if err := t.ValidateTables(add); err != nil {
+		return err
+	}
+	if t.noPublicationDDL() {
+		return t.checkPublicationTables(add, drop)
+	}
+	if len(add) > 0 {
+		tables, specs, err := publicationTables(add)
+		if err != nil {
+			return err
+		}
+		if err = t.requireTableSpecs(specs); err != nil {
+			return err
+		}
+		if err = t.execEx(fmt.Sprintf("ALTER PUBLICATION %s ADD TABLE %s", pgx.Identifier{t.name}.Sanitize(), tables)); err != nil {
+			return err
+		}
+	}
+	if len(drop) > 0 {
+		tables, err := quoteTables(drop)
+		if err != nil {
+			return err
+		}
+		if err = t.execEx(fmt.Sprintf("ALTER PUBLICATION %s DROP TABLE %s", pgx.Identifier{t.name}.Sanitize(), tables)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// changedTypes ColumnDecoder支持的每种类型一列及一个未知类型，old与new为同一列变化前后的文本格式
+var changedTypes = []struct {
+	name     string
+	oid      uint32
+	old, new string
+}{
+	{"bool", pgtype.BoolOID, "f", "t"},
+	{"bytea", pgtype.ByteaOID, `\x00ff`, `\x00fe`},
+	{"char", pgtype.CharOID, "a", "b"},
+	{"name", pgtype.NameOID, "public", "sales"},
+	{"int8", pgtype.Int8OID, "9223372036854775807", "0"},
+	{"int2", pgtype.Int2OID, "1", "2"},
+	{"int4", pgtype.Int4OID, "1", "-1"},
+	{"text", pgtype.TextOID, "tom", "Tom"},
+	{"oid", pgtype.OIDOID, "16384", "16385"},
+	{"tid", pgtype.TIDOID, "(0,1)", "(0,2)"},
+	{"xid", pgtype.XIDOID, "740", "741"},
+	{"cid", pgtype.CIDOID, "0", "1"},
+	{"json", pgtype.JSONOID, `{"a":1}`, `{"a": 1}`},
+	{"cidr", pgtype.CIDROID, "10.0.0.0/8", "10.0.0.0/16"},
+	{"cidr[]", pgtype.CIDRArrayOID, "{10.0.0.0/8}", "{10.0.0.0/16}"},
+	{"float4", pgtype.Float4OID, "1.5", "1.25"},
+	{"float8", pgtype.Float8OID, "NaN", "Infinity"},
+	{"unknown", pgtype.UnknownOID, "a", "b"},
+	{"inet", pgtype.InetOID, "10.0.0.1", "10.0.0.1/32"},
+	{"bool[]", pgtype.BoolArrayOID, "{t}", "{f}"},
+	{"int2[]", pgtype.Int2ArrayOID, "{1}", "{NULL}"},
+	{"int4[]", pgtype.Int4ArrayOID, "{1,2}", "{2,1}"},
+	{"text[]", pgtype.TextArrayOID, `{a,"b c"}`, `{a,NULL}`},
+	{"bytea[]", pgtype.ByteaArrayOID, `{"\\x00"}`, `{"\\x01"}`},
+	{"bpchar[]", pgtype.BPCharArrayOID, `{"a "}`, `{"b "}`},
+	{"varchar[]", pgtype.VarcharArrayOID, "{a}", "{}"},
+	{"int8[]", pgtype.Int8ArrayOID, "{1}", "{1,1}"},
+	{"float4[]", pgtype.Float4ArrayOID, "{1.5}", "{-1.5}"},
+	{"float8[]", pgtype.Float8ArrayOID, "{1}", "{1.0}"},
+	{"aclitem", pgtype.ACLItemOID, "postgres=arwdDxt/postgres", "postgres=r/postgres"},
+	{"aclitem[]", pgtype.ACLItemArrayOID, "{postgres=r/postgres}", "{}"},
+	{"inet[]", pgtype.InetArrayOID, "{10.0.0.1}", "{10.0.0.2}"},
+	{"bpchar", pgtype.BPCharOID, "a  ", "b  "},
+	{"varchar", pgtype.VarcharOID, "a", "a "},
+	{"date", pgtype.DateOID, "2024-01-01", "infinity"},
+	{"timestamp", pgtype.TimestampOID, "2024-01-01 00:00:00", "2024-01-01 00:00:01"},
+	{"timestamp[]", pgtype.TimestampArrayOID, `{"2024-01-01 00:00:00"}`, `{"2024-01-01 00:00:00.000001"}`},
+	{"date[]", pgtype.DateArrayOID, "{2024-01-01}", "{-infinity}"},
+	{"timestamptz", pgtype.TimestamptzOID, "2024-01-01 00:00:00+00", "2024-01-01 08:00:00+08"},
+	{"timestamptz[]", pgtype.TimestamptzArrayOID, `{"2024-01-01 00:00:00+00"}`, `{"2024-01-01 08:00:00+08"}`},
+	{"numeric", pgtype.NumericOID, "1.0", "1.00"},
+	{"record", pgtype.RecordOID, "(1,a)", "(1,b)"},
+	{"uuid", pgtype.UUIDOID, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a12"},
+	{"uuid[]", pgtype.UUIDArrayOID, "{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11}", "{}"},
+	{"jsonb", pgtype.JSONBOID, `{"a": 1}`, `{"a": 2}`},
+	{"custom", 90000, "happy", "sad"},
+}
+
+func changedRelation() Relation {
+	rel := Relation{ID: 1, Namespace: "public", Name: "types"}
+	for _, typ := range changedTypes {
+		rel.Columns = append(rel.Columns, Column{Name: typ.name, Type: typ.oid})
+	}
+	return rel
+}
+
+// changedRows 所有列为old的新旧两行
+func changedRows() (row, oldRow []Tuple) {
+	for _, typ := range changedTypes {
+		row = append(row, Tuple{Flag: 't', Value: []byte(typ.old)})
+		oldRow = append(oldRow, Tuple{Flag: 't', Value: []byte(typ.old)})
+	}
+	return
+}
+
+func TestChangedColumnsUnchanged(t *testing.T) {
+	row, oldRow := changedRows()
+	if res := changedColumns(changedRelation(), row, oldRow); res != nil {
+		t.Fatalf("identical rows changed %v", res)
+	}
+}
+
+func TestChangedColumnsValue(t *testing.T) {
+	rel := changedRelation()
+	for i, typ := range changedTypes {
+		row, oldRow := changedRows()
+		row[i].Value = []byte(typ.new)
+		if res := changedColumns(rel, row, oldRow); !reflect.DeepEqual(res, []string{typ.name}) {
+			t.Errorf("%s %q -> %q: changed %v", typ.name, typ.old, typ.new, res)
+		}
+	}
+}
+
+func TestChangedColumnsNull(t *testing.T) {
+	rel := changedRelation()
+	for i, typ := range changedTypes {
+		// NULL -> 值
+		row, oldRow := changedRows()
+		oldRow[i] = Tuple{Flag: 'n'}
+		if res := changedColumns(rel, row, oldRow); !reflect.DeepEqual(res, []string{typ.name}) {
+			t.Errorf("%s NULL -> %q: changed %v", typ.name, typ.old, res)
+		}
+		// 值 -> NULL
+		row, oldRow = changedRows()
+		row[i] = Tuple{Flag: 'n'}
+		if res := changedColumns(rel, row, oldRow); !reflect.DeepEqual(res, []string{typ.name}) {
+			t.Errorf("%s %q -> NULL: changed %v", typ.name, typ.old, res)
+		}
+		// NULL -> NULL
+		row, oldRow = changedRows()
+		row[i], oldRow[i] = Tuple{Flag: 'n'}, Tuple{Flag: 'n'}
+		if res := changedColumns(rel, row, oldRow); res != nil {
+			t.Errorf("%s NULL -> NULL: changed %v", typ.name, res)
+		}
+	}
+	// 空字符串不是NULL
+	row := []Tuple{{Flag: 't', Value: []byte{}}}
+	oldRow := []Tuple{{Flag: 'n'}}
+	rel = Relation{ID: 1, Columns: []Column{{Name: "text", Type: pgtype.TextOID}}}
+	if res := changedColumns(rel, row, oldRow); !reflect.DeepEqual(res, []string{"text"}) {
+		t.Errorf("NULL -> '': changed %v", res)
+	}
+}
+
+func TestChangedColumnsUnchangedToast(t *testing.T) {
+	rel := changedRelation()
+	for i, typ := range changedTypes {
+		row, oldRow := changedRows()
+		row[i] = Tuple{Flag: 'u'}
+		if res := changedColumns(rel, row, oldRow); res != nil {
+			t.Errorf("%s unchanged toast in new row: changed %v", typ.name, res)
+		}
+		row, oldRow = changedRows()
+		oldRow[i] = Tuple{Flag: 'u'}
+		row[i].Value = []byte(typ.new)
+		if res := changedColumns(rel, row, oldRow); res != nil {
+			t.Errorf("%s unchanged toast in old row: changed %v", typ.name, res)
+		}
+	}
+	// 未变化的TOAST列不影响其他列
+	row, oldRow := changedRows()
+	row[0] = Tuple{Flag: 'u'}
+	last := len(changedTypes) - 1
+	row[last].Value = []byte(changedTypes[last].new)
+	if res := changedColumns(rel, row, oldRow); !reflect.DeepEqual(res, []string{changedTypes[last].name}) {
+		t.Errorf("changed %v, want %s", res, changedTypes[last].name)
+	}
+}
+
+func TestChangedColumnsOrder(t *testing.T) {
+	rel := changedRelation()
+	row, oldRow := changedRows()
+	var want []string
+	for i := len(changedTypes) - 1; i >= 0; i -= 3 {
+		row[i].Value = []byte(changedTypes[i].new)
+	}
+	for i, typ := range changedTypes {
+		if string(row[i].Value) != typ.old {
+			want = append(want, typ.name)
+		}
+	}
+	if res := changedColumns(rel, row, oldRow); !reflect.DeepEqual(res, want) {
+		t.Fatalf("changed %v, want %v", res, want)
+	}
+	// 行比列少时只比较存在的列
+	row, oldRow = changedRows()
+	row[1].Value = []byte(changedTypes[1].new)
+	if res := changedColumns(rel, row[:1], oldRow); res != nil {
+		t.Fatalf("short row changed %v", res)
+	}
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// WalRetention 复制槽保留的wal
+type WalRetention struct {
+	RestartLsn uint64
+	// 服务器wal的当前位置(备库为已回放的位置)
+	ServerLsn uint64
+	// 因复制槽无法回收的wal字节数(ServerLsn与RestartLsn的差)
+	Bytes uint64
+	// PostgreSQL 13+：reserved、extended、unreserved或lost，lost时复制槽已失效
+	WalStatus string
+	// PostgreSQL 13+：超过max_slot_wal_keep_size导致复制槽失效前还可写入的字节数，未限制时为-1，unreserved时可能为负数
+	SafeBytes int64
+	// 查询时间
+	CheckedAt time.Time
+}
+
+// RetentionPolicy 复制槽wal保留的检查间隔、阈值及处理方式，阈值为0时不检查该阈值
+type RetentionPolicy struct {
+	Interval time.Duration
+	// 保留超过Warn字节时调用OnWarn
+	Warn   uint64
+	OnWarn func(WalRetention)
+	// 保留超过Critical字节，或距离复制槽失效不足Critical字节时调用OnCritical
+	// 复制槽已失效(lost)或所需的wal将在下次checkpoint删除(unreserved且SafeBytes为负数)时不论阈值都调用
+	Critical   uint64
+	OnCritical func(WalRetention)
+	// 达到Critical时停止同步并删除复制槽，释放主库磁盘，之后的变更会丢失，只应用于开发环境
+	// 停止方式与Stop相同，Start返回nil
+	DropSlot bool
+}
+
+type retentionWatchdog struct {
+	policy RetentionPolicy
+
+	mu    sync.Mutex
+	last  WalRetention
+	level int // 0正常 1 Warn 2 Critical
+}
+
+// RetentionWatchdog 同步期间按policy.Interval使用独立的普通连接检查复制槽保留的wal(restart_lsn到当前位置)，
+// 避免消费者停滞时复制槽占满主库磁盘；OnWarn/OnCritical在超过阈值时调用一次，回到阈值以内后再次超过时重新调用
+func (t *Replication) RetentionWatchdog(policy RetentionPolicy) *Replication {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Minute
+	}
+	t._retention = &retentionWatchdog{policy: policy}
+	return t
+}
+
+// WalRetention RetentionWatchdog最近一次检查的结果，未配置或尚未检查时为零值
+func (t *Replication) WalRetention() WalRetention {
+	if t._retention == nil {
+		return WalRetention{}
+	}
+	t._retention.mu.Lock()
+	defer t._retention.mu.Unlock()
+	return t._retention.last
+}
+
+func (t *Replication) watchRetention(ctx context.Context, done chan struct{}) {
+	var version int
+	t.poll(ctx, "retention", t._retention.policy.Interval, func(conn *pgx.Conn, fresh bool) bool {
+		if fresh || version == 0 {
+			var v string
+			if err := conn.QueryRow("SHOW server_version_num").Scan(&v); err != nil {
+				t.debug("retention", "version", err)
+				return true
+			}
+			version, _ = strconv.Atoi(v)
+		}
+		r, err := queryRetention(conn, t.name, version)
+		if err != nil {
+			t.debug("retention", "query", err)
+			return true
+		}
+		if t._retention.update(r) == 2 && t._retention.policy.DropSlot {
+			t.dropRetainingSlot(conn, done)
+			return false
+		}
+		return true
+	})
+}
+
+func queryRetention(conn *pgx.Conn, slot string, version int) (r WalRetention, err error) {
+	status := "'', '-1'"
+	if version >= 130000 {
+		status = "coalesce(wal_status, ''), coalesce(safe_wal_size, -1)::text"
+	}
+	sql := fmt.Sprintf(`SELECT coalesce(restart_lsn::text, '0/0'),
+	(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END)::text, %s
+FROM pg_replication_slots WHERE slot_name = $1`, status)
+	var restart, current, safe string
+	if err = conn.QueryRow(sql, slot).Scan(&restart, &current, &r.WalStatus, &safe); err != nil {
+		return
+	}
+	if r.RestartLsn, err = pgx.ParseLSN(restart); err != nil {
+		return
+	}
+	if r.ServerLsn, err = pgx.ParseLSN(current); err != nil {
+		return
+	}
+	if r.SafeBytes, err = strconv.ParseInt(safe, 10, 64); err != nil {
+		return
+	}
+	if r.RestartLsn > 0 && r.ServerLsn > r.RestartLsn {
+		r.Bytes = r.ServerLsn - r.RestartLsn
+	}
+	r.CheckedAt = time.Now()
+	return
+}
+
+// update 记录检查结果，级别升高时调用对应的回调，返回当前级别
+func (w *retentionWatchdog) update(r WalRetention) int {
+	p := w.policy
+	level := 0
+	if p.Warn > 0 && r.Bytes > p.Warn {
+		level = 1
+	}
+	if p.Critical > 0 && (r.Bytes > p.Critical || (r.SafeBytes >= 0 && r.WalStatus != "" && uint64(r.SafeBytes) < p.Critical)) {
+		level = 2
+	}
+	if r.WalStatus == "lost" || (r.WalStatus == "unreserved" && r.SafeBytes < 0) {
+		level = 2
+	}
+	w.mu.Lock()
+	w.last = r
+	prev := w.level
+	w.level = level
+	w.mu.Unlock()
+	if level > prev {
+		if level == 2 && p.OnCritical != nil {
+			p.OnCritical(r)
+		} else if level == 1 && p.OnWarn != nil {
+			p.OnWarn(r)
+		}
+	}
+	return level
+}
+
+// dropRetainingSlot 停止同步，等待Start返回(复制槽不再活动)后删除复制槽
+func (t *Replication) dropRetainingSlot(conn *pgx.Conn, done chan struct{}) {
+	t.debug("retention", "dropping slot", t.name)
+	t.requestStop()
+	<-done
+	var err error
+	for i := 0; i < 10; i++ {
+		// walsender进程在复制连接关闭后才会退出，复制槽在此之前仍为活动状态
+		if _, err = conn.Exec("SELECT pg_drop_replication_slot($1)", t.name); err == nil {
+			t.logger().Warn("retention: dropped replication slot", "slot", t.name)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	t.logger().Error("retention: drop replication slot", "slot", t.name, "error", err)
+}
@@ -0,0 +1,40 @@
+package core
+
+import "testing"
+
+func TestRetentionLevel(t *testing.T) {
+	cases := []struct {
+		name string
+		r    WalRetention
+		// 配置Warn/Critical及未配置阈值时的级别
+		level, bare int
+	}{
+		{"ok", WalRetention{Bytes: 10, WalStatus: "reserved", SafeBytes: -1}, 0, 0},
+		{"warn", WalRetention{Bytes: 200, WalStatus: "reserved", SafeBytes: -1}, 1, 0},
+		{"critical bytes", WalRetention{Bytes: 2000, WalStatus: "extended", SafeBytes: -1}, 2, 0},
+		{"critical safe bytes", WalRetention{Bytes: 10, WalStatus: "extended", SafeBytes: 500}, 2, 0},
+		{"before 13", WalRetention{Bytes: 10, SafeBytes: -1}, 0, 0},
+		{"lost", WalRetention{WalStatus: "lost", SafeBytes: -1}, 2, 2},
+		{"unreserved", WalRetention{Bytes: 10, WalStatus: "unreserved", SafeBytes: -4096}, 2, 2},
+		{"unreserved safe", WalRetention{Bytes: 10, WalStatus: "unreserved", SafeBytes: 4096}, 0, 0},
+	}
+	for _, c := range cases {
+		for _, p := range []RetentionPolicy{{Warn: 100, Critical: 1000}, {}} {
+			want := c.level
+			if p.Critical == 0 {
+				want = c.bare
+			}
+			var critical int
+			p.OnCritical = func(WalRetention) { critical++ }
+			w := &retentionWatchdog{policy: p}
+			if level := w.update(c.r); level != want {
+				t.Errorf("%s warn %d critical %d: level %d, want %d", c.name, p.Warn, p.Critical, level, want)
+			}
+			// 保持Critical时不重复调用
+			w.update(c.r)
+			if want == 2 && critical != 1 || want != 2 && critical != 0 {
+				t.Errorf("%s warn %d critical %d: OnCritical called %d times", c.name, p.Warn, p.Critical, critical)
+			}
+		}
+	}
+}
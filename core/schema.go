@@ -0,0 +1,120 @@
+package core
+
+// ColumnSchema 列的类型信息
+type ColumnSchema struct {
+	Name string
+	// 类型OID
+	Type uint32
+	// 类型名称(pg_type.typname，如int4、varchar、numeric)
+	// 自定义类型及扩展类型为服务器Type消息中的schema.typname，未知时为空
+	TypeName string
+	// 类型修饰符(atttypmod)，没有修饰符时为-1
+	// varchar(n)/char(n)为n+4，numeric(p,s)为(p<<16|s)+4，timestamp(p)/time(p)为p
+	Modifier int32
+	// 是否为复制标识列(REPLICA IDENTITY FULL时所有列均是)
+	Key bool
+	// NOT NULL约束，需配置SchemaRefresh，没有刷新到该表时为false
+	NotNull bool
+}
+
+// RelationSchema 表的列类型信息，来自复制流中的Relation消息
+type RelationSchema struct {
+	RelationID uint32
+	Schema     string
+	Name       string
+	// 复制标识 'd' default / 'n' nothing / 'f' full / 'i' index
+	ReplicaIdentity byte
+	Columns         []ColumnSchema
+}
+
+// Column 按列名查找列信息
+func (s RelationSchema) Column(name string) (ColumnSchema, bool) {
+	for _, col := range s.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return ColumnSchema{}, false
+}
+
+// Length varchar(n)/char(n)的长度，没有长度限制时ok为false
+func (c ColumnSchema) Length() (n int, ok bool) {
+	if c.Modifier < 4 {
+		return 0, false
+	}
+	switch c.TypeName {
+	case "varchar", "bpchar", "_varchar", "_bpchar":
+		return int(c.Modifier - 4), true
+	}
+	return 0, false
+}
+
+// Precision numeric(p,s)的精度及小数位数，没有限制时ok为false
+func (c ColumnSchema) Precision() (precision, scale int, ok bool) {
+	if c.Modifier < 4 || (c.TypeName != "numeric" && c.TypeName != "_numeric") {
+		return 0, 0, false
+	}
+	m := c.Modifier - 4
+	return int(m>>16) & 0xffff, int(int16(m & 0xffff)), true
+}
+
+// builtinTypes 常用内置类型的OID及名称(pg_type.typname)
+var builtinTypes = map[uint32]string{
+	16: "bool", 17: "bytea", 18: "char", 19: "name", 20: "int8", 21: "int2", 23: "int4", 25: "text", 26: "oid",
+	114: "json", 142: "xml", 650: "cidr", 700: "float4", 701: "float8", 774: "macaddr8", 790: "money", 829: "macaddr", 869: "inet",
+	1042: "bpchar", 1043: "varchar", 1082: "date", 1083: "time", 1114: "timestamp", 1184: "timestamptz", 1186: "interval",
+	1266: "timetz", 1560: "bit", 1562: "varbit", 1700: "numeric", 2950: "uuid", 3614: "tsvector", 3802: "jsonb",
+	3904: "int4range", 3906: "numrange", 3908: "tsrange", 3910: "tstzrange", 3912: "daterange", 3926: "int8range",
+	1000: "_bool", 1001: "_bytea", 1005: "_int2", 1007: "_int4", 1009: "_text", 1014: "_bpchar", 1015: "_varchar",
+	1016: "_int8", 1021: "_float4", 1022: "_float8", 1115: "_timestamp", 1182: "_date", 1185: "_timestamptz",
+	1231: "_numeric", 199: "_json", 2951: "_uuid", 3807: "_jsonb",
+}
+
+func (rs *RelationSet) schema(rel Relation) RelationSchema {
+	s := RelationSchema{RelationID: rel.ID, Schema: rel.Namespace, Name: rel.Name, ReplicaIdentity: rel.Replica, Columns: make([]ColumnSchema, 0, len(rel.Columns))}
+	for _, col := range rel.Columns {
+		c := ColumnSchema{Name: col.Name, Type: col.Type, Modifier: int32(col.Mode), Key: col.Key}
+		c.TypeName = builtinTypes[col.Type]
+		if typ, ok := rs.Type(col.Type); ok {
+			c.TypeName = typ.Namespace + "." + typ.Name
+		}
+		s.Columns = append(s.Columns, c)
+	}
+	return s
+}
+
+// Schema 已订阅表的列类型信息，收到该表的第一条变更(Relation消息)前ok为false
+// 配置SchemaRefresh时补充NOT NULL约束
+func (t *Replication) Schema(relationID uint32) (schema RelationSchema, ok bool) {
+	if schema, ok = t.set.Schema(relationID); ok {
+		t.catalogSchema(&schema)
+	}
+	return
+}
+
+// LookupSchema 按表名(如public.users)获取列类型信息，同Schema
+func (t *Replication) LookupSchema(table string) (schema RelationSchema, ok bool) {
+	spec, err := ParseTableSpec(table)
+	if err != nil {
+		return
+	}
+	rel, ok := t.set.Lookup(spec.Schema, spec.Name)
+	if !ok {
+		return
+	}
+	schema = t.set.schema(rel)
+	t.catalogSchema(&schema)
+	return
+}
+
+func (t *Replication) catalogSchema(schema *RelationSchema) {
+	meta, ok := t.catalog.Table(schema.RelationID)
+	if !ok {
+		return
+	}
+	for i, col := range schema.Columns {
+		if m, ok := meta.Column(col.Name); ok {
+			schema.Columns[i].NotNull = m.NotNull
+		}
+	}
+}
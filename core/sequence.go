@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// 逻辑复制不包含序列的变化，备库/目标库切换为主库后序列需要手动同步
+const sequencesSQL = `SELECT n.nspname, c.relname, s.last_value, tn.nspname, t.relname, a.attname
+FROM pg_depend d
+JOIN pg_class c ON c.oid = d.objid AND c.relkind = 'S'
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_sequences s ON s.schemaname = n.nspname AND s.sequencename = c.relname
+JOIN pg_class t ON t.oid = d.refobjid
+JOIN pg_namespace tn ON tn.oid = t.relnamespace
+JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+WHERE d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass
+	AND d.deptype IN ('a', 'i') AND s.last_value IS NOT NULL AND d.refobjid::int8 = ANY($1)`
+
+// SequenceSync 按interval采集已订阅表的序列(serial/identity列)当前值，值变化时发送EventType_SEQUENCE消息
+// 消息的SchemaName/TableName为序列名称，Body为{"last_value": int64, "table": 所属表, "column": 所属列}
+// 采集使用独立的普通连接，需PostgreSQL 10+
+func (t *Replication) SequenceSync(interval time.Duration) *Replication {
+	t._sequenceSync = interval
+	return t
+}
+
+type sequenceTracker struct {
+	mu      sync.Mutex
+	last    map[string]int64
+	pending []ReplicationMessage
+}
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{last: map[string]int64{}}
+}
+
+// update 记录序列值，值发生变化时加入待发送队列
+func (s *sequenceTracker) update(msg ReplicationMessage, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := TableName(msg.SchemaName, msg.TableName)
+	if last, ok := s.last[key]; ok && last == value {
+		return
+	}
+	s.last[key] = value
+	for i, m := range s.pending {
+		if m.SchemaName == msg.SchemaName && m.TableName == msg.TableName {
+			s.pending[i] = msg
+			return
+		}
+	}
+	s.pending = append(s.pending, msg)
+}
+
+func (s *sequenceTracker) take() []ReplicationMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.pending
+	s.pending = nil
+	return res
+}
+
+// deliverSequences 在同步协程中发送采集到的序列值，与其他消息不会并发调用handler
+func (t *Replication) deliverSequences(dmlHandler ReplicationDMLHandler) {
+	msg := t._sequences.take()
+	if len(msg) == 0 {
+		return
+	}
+	if t._tenant != nil {
+		for i := range msg {
+			msg[i].Tenant = t._tenant(msg[i])
+		}
+	}
+	dmlHandler(msg...)
+}
+
+func (t *Replication) captureSequences(ctx context.Context) {
+	t.poll(ctx, "sequence", t._sequenceSync, func(conn *pgx.Conn, fresh bool) bool {
+		relations := t.set.Relations()
+		if len(relations) == 0 {
+			return true
+		}
+		ids := make([]int64, 0, len(relations))
+		for _, rel := range relations {
+			ids = append(ids, int64(rel.ID))
+		}
+		if err := t.querySequences(conn, ids); err != nil {
+			t.debug("sequence", "query", err)
+		}
+		return true
+	})
+}
+
+func (t *Replication) querySequences(conn *pgx.Conn, ids []int64) error {
+	rows, err := conn.Query(sequencesSQL, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema, name, tableSchema, table, column string
+		var value int64
+		if err = rows.Scan(&schema, &name, &value, &tableSchema, &table, &column); err != nil {
+			return err
+		}
+		fields := Fields{
+			{Name: "last_value", Value: value},
+			{Name: "table", Value: TableName(tableSchema, table)},
+			{Name: "column", Value: column},
+		}
+		body := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			body[f.Name] = f.Value
+		}
+		t._sequences.update(ReplicationMessage{
+			EventType:  EventType_SEQUENCE,
+			SchemaName: schema,
+			TableName:  name,
+			Body:       body,
+			Fields:     fields,
+		}, value)
+	}
+	return rows.Err()
+}
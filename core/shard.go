@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/jackc/pgx"
+)
+
+// ShardTables 使用rendezvous hash把表分配到shards个分片
+// 同一张表总是分配到同一个分片，分片数量变化时只有少量表需要迁移
+func ShardTables(tables []string, shards int) [][]string {
+	res := make([][]string, shards)
+	if shards <= 0 {
+		return res
+	}
+	candidates := make([]string, shards)
+	for i := range candidates {
+		candidates[i] = fmt.Sprint(i)
+	}
+	for _, table := range tables {
+		best := rendezvous(table, candidates)
+		res[best] = append(res[best], table)
+	}
+	for _, v := range res {
+		sort.Strings(v)
+	}
+	return res
+}
+
+// rendezvous 返回key在candidates中得分最高的下标
+func rendezvous(key string, candidates []string) int {
+	best, bestScore := -1, uint64(0)
+	for i, c := range candidates {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s#%s", key, c)
+		if score := h.Sum64(); best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// qualifiedTable 表的规范名称，未指定schema的表默认为public，无法解析时原样返回
+func qualifiedTable(table string) string {
+	spec, err := ParseTableSpec(table)
+	if err != nil {
+		return table
+	}
+	return spec.Table()
+}
+
+// ShardGroup 把一组表拆分到多个复制槽/发布流，提升单个复制连接的解码吞吐
+// 第i个分片的复制槽和发布流名称为 name_i，超过63字节时截断name并加入hash
+type ShardGroup struct {
+	name         string
+	replications []*Replication
+	assignment   [][]string
+}
+
+func NewShardGroup(name string, config pgx.ConnConfig, shards int) *ShardGroup {
+	g := &ShardGroup{name: name}
+	for i := 0; i < shards; i++ {
+		g.replications = append(g.replications, NewReplication(slotName(name, fmt.Sprintf("_%d", i)), config))
+	}
+	return g
+}
+
+// Replications 获取所有分片
+func (g *ShardGroup) Replications() []*Replication {
+	return g.replications
+}
+
+// Assignment 获取当前每个分片负责的表
+func (g *ShardGroup) Assignment() [][]string {
+	return g.assignment
+}
+
+// Rebalance 按tables重新分配分片并同步复制槽和发布流
+// 先向新分片添加表再从旧分片移除，迁移过程中的变更可能被两个分片重复投递，但不会丢失
+func (g *ShardGroup) Rebalance(tables []string) error {
+	qualified := make([]string, 0, len(tables))
+	for _, v := range tables {
+		qualified = append(qualified, qualifiedTable(v))
+	}
+	assignment := ShardTables(qualified, len(g.replications))
+	drops := make([][]string, len(g.replications))
+	for i, r := range g.replications {
+		// 发布流在复制槽之前创建，复制槽开始解码时发布流已存在
+		if err := r.execEx(fmt.Sprintf("CREATE PUBLICATION %s", pgx.Identifier{r.name}.Sanitize())); err != nil {
+			return fmt.Errorf("shard %s: %w", r.name, err)
+		}
+		if err := r.CreateReplication(); err != nil {
+			return fmt.Errorf("shard %s: %w", r.name, err)
+		}
+		current, err := r.PublicationTables()
+		if err != nil {
+			return fmt.Errorf("shard %s: %w", r.name, err)
+		}
+		want := make(map[string]bool, len(assignment[i]))
+		for _, v := range assignment[i] {
+			want[v] = true
+		}
+		for _, v := range current {
+			if want[v] {
+				delete(want, v)
+			} else {
+				drops[i] = append(drops[i], v)
+			}
+		}
+		add := make([]string, 0, len(want))
+		for v := range want {
+			add = append(add, v)
+		}
+		sort.Strings(add)
+		if err = r.AlterPublication(add, nil); err != nil {
+			return fmt.Errorf("shard %s: %w", r.name, err)
+		}
+	}
+	for i, r := range g.replications {
+		if err := r.AlterPublication(nil, drops[i]); err != nil {
+			return fmt.Errorf("shard %s: %w", r.name, err)
+		}
+	}
+	g.assignment = assignment
+	return nil
+}
+
+// Register 把所有分片添加到Manager中统一管理
+func (g *ShardGroup) Register(m *Manager, handler ReplicationDMLHandler, policy RestartPolicy) error {
+	for _, r := range g.replications {
+		if err := m.Add(r, handler, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start 同步所有分片直到ctx取消，handler会被多个分片并发调用
+func (g *ShardGroup) Start(ctx context.Context, handler ReplicationDMLHandler, policy RestartPolicy) error {
+	m := NewManager()
+	if err := g.Register(m, handler, policy); err != nil {
+		return err
+	}
+	return m.Start(ctx)
+}
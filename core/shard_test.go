@@ -0,0 +1,27 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx"
+)
+
+func TestShardGroupNames(t *testing.T) {
+	for _, name := range []string{"orders", strings.Repeat("o", 63)} {
+		g := NewShardGroup(name, pgx.ConnConfig{}, 12)
+		seen := map[string]bool{}
+		for _, r := range g.Replications() {
+			if r._nameErr != nil {
+				t.Errorf("%s: %v", r.name, r._nameErr)
+			}
+			if seen[r.name] {
+				t.Errorf("duplicate shard %s", r.name)
+			}
+			seen[r.name] = true
+		}
+	}
+	if r := NewShardGroup("orders", pgx.ConnConfig{}, 2).Replications()[1]; r.name != "orders_1" {
+		t.Errorf("shard name %s, want orders_1", r.name)
+	}
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// Sink 把变更写入下游系统(消息队列、搜索引擎等)，实现见sinks目录
+type Sink interface {
+	// Write 写入一个事务的消息，返回nil表示下游已确认写入，之后才确认事务的lsn
+	// 返回错误时SinkHandler停止同步，Start返回该错误，重启后服务器从已确认的位置重新发送，需要时应在Write内重试
+	// 下游需按lsn去重或可重复写入
+	// 攒批写入的Sink缓存了消息而尚未写入下游时返回ErrBuffered
+	Write(ctx context.Context, msg ...ReplicationMessage) error
+	Close() error
+}
+
+// ErrBuffered Sink.Write缓存了事务的消息而尚未写入下游，SinkHandler不确认该事务也不停止同步，
+// 之后的事务连同缓存一起写入成功并确认时，该事务随之确认
+var ErrBuffered = errors.New("sink: buffered")
+
+// SinkHandler 把Sink作为Start的handler，写入失败时停止同步，Start在发送最终状态后返回写入的错误，
+// 失败的事务没有确认，重启(如Manager的RestartPolicy)后服务器从该事务重新发送
+//
+//	sink := kafka.NewSink(producer, kafka.Config{Schemas: r})
+//	defer sink.Close()
+//	r.Start(ctx, r.SinkHandler(ctx, sink))
+func (t *Replication) SinkHandler(ctx context.Context, sink Sink) ReplicationDMLHandler {
+	return func(msg ...ReplicationMessage) DMLHandlerStatus {
+		if t.aborted() {
+			// 停止前不再写入，避免之后的事务确认越过失败的事务
+			return DMLHandlerStatusContinue
+		}
+		if err := sink.Write(ctx, msg...); err != nil {
+			if errors.Is(err, ErrBuffered) {
+				return DMLHandlerStatusContinue
+			}
+			t.logger().Error("sink", "error", err)
+			t.abort(err)
+			return DMLHandlerStatusContinue
+		}
+		return DMLHandlerStatusSuccess
+	}
+}
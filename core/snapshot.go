@@ -0,0 +1,573 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/jackc/pgx"
+)
+
+const (
+	// snapshotBatch 初始快照每次交给handler的行数
+	snapshotBatch = 1000
+	// snapshotSplit 并行读取快照时每个worker平均分到的主键范围数
+	snapshotSplit = 4
+)
+
+// InitialSnapshot 首次创建复制槽时导出快照，在该快照中读取发布流中的所有表，以EventType_SNAPSHOT交给handler，
+// 之后从复制槽的一致点开始流式同步，快照数据与之后的变更之间不重复也不遗漏
+// 每张表按snapshotBatch行分批调用handler，消息的Lsn为一致点，遵循IncludeTables/ExcludeTables、列过滤及Filter，
+// PostgreSQL 15+同时遵循发布流的列列表及行过滤条件
+// 复制槽已存在时不再读取快照；读取失败(包括handler未返回DMLHandlerStatusSuccess)时删除复制槽并返回错误，下次Start重新读取
+// 进程在读取过程中退出时复制槽保留，下次Start根据快照标记(见snapshotMarker)删除复制槽重新创建并读取
+// 各表以COPY (SELECT ...) TO STDOUT读取，列值按流式同步相同的文本解码器解码
+func (t *Replication) InitialSnapshot() *Replication {
+	t._snapshot = true
+	return t
+}
+
+// SnapshotWorkers 初始快照使用n个并发连接读取，单列整数主键的表按主键范围拆分为多段，其他表整表读取
+// 各连接导入同一快照，数据一致性与单连接相同；handler仍按批串行调用，但不同段、不同表的批次交错到达
+func (t *Replication) SnapshotWorkers(n int) *Replication {
+	t._snapshotJobs = n
+	return t
+}
+
+// exportedSnapshot CREATE_REPLICATION_SLOT ... EXPORT_SNAPSHOT导出的快照
+// 只在创建复制槽的连接执行下一条命令之前有效，需在此之前导入
+type exportedSnapshot struct {
+	name string
+	lsn  uint64
+}
+
+// snapshotTable 快照中读取的表
+type snapshotTable struct {
+	id     uint32
+	schema string
+	table  string
+	// 发布流的列列表，已转义，为空时读取所有列
+	columns string
+	// 发布流的行过滤条件
+	where string
+}
+
+// snapshotSQL 读取发布流中的表，在导入的快照中执行，看到的是创建复制槽时的发布流
+func snapshotSQL(f Features, publication string) string {
+	columns := "(quote_ident(schemaname) || '.' || quote_ident(tablename))::regclass::oid::int8, schemaname::text, tablename::text"
+	if f.ColumnList {
+		columns += ", coalesce((SELECT string_agg(quote_ident(a), ', ') FROM unnest(attnames) a), '')"
+	} else {
+		columns += ", ''"
+	}
+	if f.RowFilter {
+		columns += ", coalesce(rowfilter, '')"
+	} else {
+		columns += ", ''"
+	}
+	return fmt.Sprintf("SELECT %s FROM pg_publication_tables WHERE pubname = %s", columns, quoteLiteral(publication))
+}
+
+// primaryConfig 普通连接的配置，配置Failover时连接当前主库
+func (t *Replication) primaryConfig() (pgx.ConnConfig, error) {
+	config := t.config
+	if host := t.primaryHost(); host != "" {
+		if h, p, err := net.SplitHostPort(host); err != nil {
+			config.Host = host
+		} else if port, err := strconv.ParseUint(p, 10, 16); err == nil {
+			config.Host, config.Port = h, uint16(port)
+		}
+	}
+	config, _, err := t.connConfig(config)
+	return config, err
+}
+
+// primaryHost 复制连接最近一次找到的主库，未配置Failover时为空
+func (t *Replication) primaryHost() string {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._primaryHost
+}
+
+// initialSnapshot 复制槽刚以EXPORT_SNAPSHOT创建时读取快照，必须在复制连接执行其他命令之前调用
+func (t *Replication) initialSnapshot(ctx context.Context, dmlHandler ReplicationDMLHandler) error {
+	snapshot := t._exported
+	if snapshot == nil {
+		return nil
+	}
+	t._exported = nil
+	if err := t.copySnapshot(ctx, snapshot, dmlHandler); err != nil {
+		t.debug("snapshot", "failed, dropping slot", t.name, err)
+		if !t._tempSlot {
+			if dropErr := t.DropReplication(); dropErr != nil {
+				t.debug("snapshot", "drop slot", dropErr)
+			}
+		}
+		return err
+	}
+	if t._tempSlot {
+		return nil
+	}
+	if err := t.unmarkSnapshot(); err != nil {
+		return fmt.Errorf("snapshot marker: %w", err)
+	}
+	return nil
+}
+
+// snapshotMarker 初始快照未完成的标记，以复制槽名加_snapshot的物理复制槽保存在服务器上(不保留wal)
+// 创建复制槽之前创建，快照读取完成后删除；复制槽存在而标记也存在说明上次读取被中断
+func (t *Replication) snapshotMarker() string {
+	return slotName(t.name, "_snapshot")
+}
+
+func (t *Replication) markSnapshot() error {
+	return t.execEx(fmt.Sprintf("SELECT pg_create_physical_replication_slot(%s)", quoteLiteral(t.snapshotMarker())))
+}
+
+func (t *Replication) unmarkSnapshot() error {
+	return t.execEx(fmt.Sprintf("SELECT pg_drop_replication_slot(%s)", quoteLiteral(t.snapshotMarker())))
+}
+
+// resetSnapshot 复制槽已存在但快照标记仍在时删除复制槽，之后重新创建并读取快照
+func (t *Replication) resetSnapshot(info SlotInfo) (SlotInfo, error) {
+	res, err := t.result(fmt.Sprintf("SELECT 1 FROM pg_replication_slots WHERE slot_name = %s", quoteLiteral(t.snapshotMarker())))
+	if err != nil || len(res) == 0 {
+		return info, err
+	}
+	t.logger().Warn("snapshot: initial snapshot was interrupted, recreating replication slot", "slot", t.name)
+	if err = t.execEx(fmt.Sprintf("SELECT pg_drop_replication_slot(%s)", quoteLiteral(t.name))); err != nil {
+		return info, err
+	}
+	info.Exists = false
+	return info, nil
+}
+
+func (t *Replication) copySnapshot(ctx context.Context, snapshot *exportedSnapshot, dmlHandler ReplicationDMLHandler) error {
+	conn, tx, err := t.snapshotTx(ctx, snapshot.name)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer tx.Rollback()
+	t.debug("snapshot", "imported", snapshot.name, pgx.FormatLSN(snapshot.lsn))
+	tables, err := t.snapshotTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	workers := t._snapshotJobs
+	if workers <= 1 {
+		c := &snapshotCopy{lsn: snapshot.lsn, handler: dmlHandler}
+		for _, table := range tables {
+			if err = t.copyChunk(ctx, tx, c, snapshotChunk{table: table}); err != nil {
+				return err
+			}
+		}
+		return tx.CommitEx(ctx)
+	}
+	// 各worker导入协调事务重新导出的快照，与复制槽的一致点相同
+	var exported string
+	if err = tx.QueryRowEx(ctx, "SELECT pg_export_snapshot()", nil).Scan(&exported); err != nil {
+		return err
+	}
+	var chunks []snapshotChunk
+	for _, table := range tables {
+		split, err := t.splitTable(ctx, tx, table, workers)
+		if err != nil {
+			return fmt.Errorf("%s: %w", TableName(table.schema, table.table), err)
+		}
+		chunks = append(chunks, split...)
+	}
+	t.debug("snapshot", len(tables), "tables", len(chunks), "chunks", workers, "workers")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := &snapshotCopy{lsn: snapshot.lsn, handler: dmlHandler}
+	queue := make(chan snapshotChunk)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.snapshotWorker(ctx, exported, c, queue); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+feed:
+	for _, chunk := range chunks {
+		select {
+		case queue <- chunk:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+	if err = <-errs; err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return tx.CommitEx(ctx)
+}
+
+// snapshotTx 新建普通连接并在可重复读事务中导入快照
+func (t *Replication) snapshotTx(ctx context.Context, snapshot string) (*pgx.Conn, *pgx.Tx, error) {
+	config, err := t.primaryConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx, err := conn.BeginEx(ctx, &pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err == nil {
+		_, err = tx.ExecEx(ctx, "SET TRANSACTION SNAPSHOT "+quoteLiteral(snapshot), nil)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, tx, nil
+}
+
+func (t *Replication) snapshotWorker(ctx context.Context, snapshot string, c *snapshotCopy, queue <-chan snapshotChunk) error {
+	conn, tx, err := t.snapshotTx(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer tx.Rollback()
+	for chunk := range queue {
+		if err = t.copyChunk(ctx, tx, c, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Replication) snapshotTables(ctx context.Context, tx *pgx.Tx) ([]snapshotTable, error) {
+	rows, err := tx.QueryEx(ctx, snapshotSQL(t.features(), t.name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []snapshotTable
+	for rows.Next() {
+		var id int64
+		var v snapshotTable
+		if err = rows.Scan(&id, &v.schema, &v.table, &v.columns, &v.where); err != nil {
+			return nil, err
+		}
+		if !t.allowTable(v.schema, v.table) {
+			continue
+		}
+		v.id = uint32(id)
+		tables = append(tables, v)
+	}
+	return tables, rows.Err()
+}
+
+// snapshotChunk 快照中读取的一段数据，rangeCond为空时读取整张表
+type snapshotChunk struct {
+	table     snapshotTable
+	rangeCond string
+}
+
+// snapshotCopy 快照读取过程中各worker共享的状态，handler按批串行调用
+type snapshotCopy struct {
+	lsn     uint64
+	handler ReplicationDMLHandler
+	mu      sync.Mutex
+}
+
+func (c *snapshotCopy) deliver(batch []ReplicationMessage) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handler(batch...) != DMLHandlerStatusSuccess {
+		return errors.New("snapshot rows not accepted by handler")
+	}
+	return nil
+}
+
+// querier *pgx.Conn及*pgx.Tx
+type querier interface {
+	QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*pgx.Rows, error)
+}
+
+// primaryKey 按顺序获取表的主键列及类型，name为已转义的表名，没有主键时为空
+func primaryKey(ctx context.Context, q querier, name string) (keys, types []string, err error) {
+	rows, err := q.QueryEx(ctx, fmt.Sprintf(`SELECT a.attname::text, format_type(a.atttypid, NULL) FROM pg_index i
+CROSS JOIN generate_subscripts(i.indkey, 1) k
+JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[k]
+WHERE i.indrelid = %s::regclass AND i.indisprimary ORDER BY k`, quoteLiteral(name)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, typ string
+		if err = rows.Scan(&key, &typ); err != nil {
+			return nil, nil, err
+		}
+		keys, types = append(keys, key), append(types, typ)
+	}
+	return keys, types, rows.Err()
+}
+
+// splitTable 按整数主键范围把表拆分为多段，主键不是单列整数时不拆分
+func (t *Replication) splitTable(ctx context.Context, tx *pgx.Tx, table snapshotTable, workers int) ([]snapshotChunk, error) {
+	whole := []snapshotChunk{{table: table}}
+	name := pgx.Identifier{table.schema, table.table}.Sanitize()
+	keys, types, err := primaryKey(ctx, tx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 || (types[0] != "smallint" && types[0] != "integer" && types[0] != "bigint") {
+		return whole, nil
+	}
+	key := pgx.Identifier{keys[0]}.Sanitize()
+	sql := fmt.Sprintf("SELECT coalesce(min(%s), 0)::int8, coalesce(max(%s), -1)::int8 FROM %s", key, key, name)
+	if table.where != "" {
+		sql += " WHERE " + table.where
+	}
+	var min, max int64
+	if err = tx.QueryRowEx(ctx, sql, nil).Scan(&min, &max); err != nil {
+		return nil, err
+	}
+	// 每个worker平均分到snapshotSplit段，段越多负载越均衡
+	n := uint64(workers * snapshotSplit)
+	span := uint64(max-min)/n + 1
+	if max < min || span < snapshotBatch {
+		return whole, nil
+	}
+	var chunks []snapshotChunk
+	for lo := min; ; lo += int64(span) {
+		if uint64(max-lo) < span {
+			chunks = append(chunks, snapshotChunk{table: table, rangeCond: fmt.Sprintf("%s >= %d", key, lo)})
+			break
+		}
+		chunks = append(chunks, snapshotChunk{table: table, rangeCond: fmt.Sprintf("%s >= %d AND %s < %d", key, lo, key, lo+int64(span))})
+	}
+	return chunks, nil
+}
+
+// snapshotMessage 把查询到的一行组装为EventType_SNAPSHOT消息
+func (t *Replication) snapshotMessage(lsn uint64, relation uint32, schema, table string, fields []pgx.FieldDescription, values []interface{}) ReplicationMessage {
+	msg := ReplicationMessage{
+		Lsn:        lsn,
+		RelationID: relation,
+		EventType:  EventType_SNAPSHOT,
+		SchemaName: schema,
+		TableName:  table,
+		Body:       make(map[string]interface{}, len(values)),
+		Fields:     make(Fields, 0, len(values)),
+	}
+	for i, field := range fields {
+		value := t.snapshotValue(field.DataType, plainValue(values[i]))
+		msg.Body[field.Name] = value
+		msg.Fields = append(msg.Fields, Field{Name: field.Name, Value: value})
+	}
+	t.project(&msg)
+	return msg
+}
+
+// copyChunk 以COPY (SELECT ...) TO STDOUT读取一段数据，文本格式的列值与pgoutput发送的相同
+func (t *Replication) copyChunk(ctx context.Context, tx *pgx.Tx, c *snapshotCopy, chunk snapshotChunk) error {
+	table := chunk.table
+	name := TableName(table.schema, table.table)
+	columns := table.columns
+	if columns == "" {
+		columns = "*"
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", columns, pgx.Identifier{table.schema, table.table}.Sanitize())
+	switch {
+	case table.where != "" && chunk.rangeCond != "":
+		sql += fmt.Sprintf(" WHERE (%s) AND %s", table.where, chunk.rangeCond)
+	case table.where != "":
+		sql += " WHERE " + table.where
+	case chunk.rangeCond != "":
+		sql += " WHERE " + chunk.rangeCond
+	}
+	// COPY不返回列信息，先以LIMIT 0获取列名及类型
+	rows, err := tx.QueryEx(ctx, sql+" LIMIT 0", nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	fields := rows.FieldDescriptions()
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	w := &copyWriter{ctx: ctx, t: t, c: c, table: table, batch: make([]ReplicationMessage, 0, snapshotBatch)}
+	for _, f := range fields {
+		w.columns = append(w.columns, Column{Name: f.Name, Type: uint32(f.DataType)})
+	}
+	sql = fmt.Sprintf("COPY (%s) TO STDOUT", sql)
+	t.debug("snapshot", "query:", sql)
+	if _, err = tx.CopyToWriter(w, sql); err != nil {
+		if w.err != nil {
+			return w.err
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if len(w.buf) > 0 {
+		return fmt.Errorf("%s: incomplete copy row", name)
+	}
+	t.debug("snapshot", name, chunk.rangeCond, w.count, "rows")
+	return c.deliver(w.batch)
+}
+
+// copyWriter 解析COPY文本格式的输出，每行一条EventType_SNAPSHOT消息，按snapshotBatch行交给handler
+type copyWriter struct {
+	ctx     context.Context
+	t       *Replication
+	c       *snapshotCopy
+	table   snapshotTable
+	columns []Column
+	// 未读完的行
+	buf   []byte
+	batch []ReplicationMessage
+	count int
+	// 解码或handler的错误，Write返回错误后连接被关闭
+	err error
+}
+
+func (w *copyWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf, p...)
+	line := w.buf
+	for {
+		i := bytes.IndexByte(line, '\n')
+		if i < 0 {
+			break
+		}
+		if w.err = w.row(line[:i]); w.err != nil {
+			return 0, w.err
+		}
+		line = line[i+1:]
+	}
+	w.buf = append(w.buf[:0], line...)
+	return len(p), nil
+}
+
+func (w *copyWriter) row(line []byte) error {
+	t, table := w.t, w.table
+	values := bytes.Split(line, []byte{'\t'})
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("%s: copy row has %d columns, expected %d", TableName(table.schema, table.table), len(values), len(w.columns))
+	}
+	msg := ReplicationMessage{
+		Lsn:        w.c.lsn,
+		RelationID: table.id,
+		EventType:  EventType_SNAPSHOT,
+		SchemaName: table.schema,
+		TableName:  table.table,
+		Body:       make(map[string]interface{}, len(values)),
+		Fields:     make(Fields, 0, len(values)),
+	}
+	for i, col := range w.columns {
+		var value interface{}
+		if string(values[i]) != `\N` {
+			decoder := t.set.Decoder(col)
+			if err := decoder.DecodeText(nil, copyUnescape(values[i])); err != nil {
+				return fmt.Errorf("%s: error decoding %s: %s", TableName(table.schema, table.table), col.Name, err)
+			}
+			value = t.columnValue(decoder)
+		}
+		msg.Body[col.Name] = value
+		msg.Fields = append(msg.Fields, Field{Name: col.Name, Value: value})
+	}
+	t.project(&msg)
+	if t.accept(&msg) {
+		w.batch = append(w.batch, msg)
+	}
+	if w.count++; len(w.batch) == snapshotBatch {
+		if err := w.c.deliver(w.batch); err != nil {
+			return err
+		}
+		w.batch = w.batch[:0]
+	}
+	return nil
+}
+
+// copyUnescape 还原COPY文本格式中反斜杠转义的列值
+func copyUnescape(src []byte) []byte {
+	if bytes.IndexByte(src, '\\') < 0 {
+		return src
+	}
+	dst := make([]byte, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\\' || i+1 == len(src) {
+			dst = append(dst, src[i])
+			continue
+		}
+		i++
+		switch c := src[i]; c {
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'v':
+			dst = append(dst, '\v')
+		case 'x':
+			// \xh或\xhh
+			n, v := 0, byte(0)
+			for ; n < 2 && i+1 < len(src) && isHex(src[i+1]); n++ {
+				i++
+				v = v<<4 | unhex(src[i])
+			}
+			if n == 0 {
+				dst = append(dst, c)
+			} else {
+				dst = append(dst, v)
+			}
+		default:
+			if c < '0' || c > '7' {
+				dst = append(dst, c)
+				continue
+			}
+			// \o、\oo或\ooo
+			v := c - '0'
+			for n := 1; n < 3 && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '7'; n++ {
+				i++
+				v = v<<3 | (src[i] - '0')
+			}
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
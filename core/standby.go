@@ -0,0 +1,61 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// Standby 允许在热备库上创建和消费复制槽(PostgreSQL 16+)
+// 备库需开启hot_standby_feedback并配置primary_slot_name，否则主库vacuum会导致复制槽失效
+// primary为主库连接配置，非nil时创建复制槽前会在主库执行pg_log_standby_snapshot()，
+// 避免备库等待主库产生running_xacts记录而长时间阻塞
+func (t *Replication) Standby(primary *pgx.ConnConfig) *Replication {
+	t._standby = true
+	t._primary = primary
+	return t
+}
+
+// 备库解码前检查：版本、复制槽是否因冲突失效、是否已被提升为主库
+func (t *Replication) standbyCheck() (promoted bool, err error) {
+	f, err := t.Features()
+	if err != nil {
+		return
+	}
+	res, err := t.result("SELECT pg_is_in_recovery()::text AS recovery")
+	if err != nil {
+		return
+	}
+	recovery := len(res) > 0 && res[0]["recovery"] == "true"
+	if recovery {
+		if err = f.require("logical decoding on standby", 160000); err != nil {
+			return
+		}
+	}
+	promoted = t._recovery && !recovery
+	t._recovery = recovery
+	if f.StandbyDecoding {
+		res, err = t.result(fmt.Sprintf("SELECT conflicting::text FROM pg_replication_slots WHERE slot_name = %s", quoteLiteral(t.name)))
+		if err != nil {
+			return
+		}
+		if len(res) > 0 && res[0]["conflicting"] == "true" {
+			return promoted, fmt.Errorf("%w: slot %s conflicts with recovery", ErrSlotInvalidated, t.name)
+		}
+	}
+	if recovery && t._primary != nil {
+		var config pgx.ConnConfig
+		if config, _, err = t.connConfig(*t._primary); err != nil {
+			return
+		}
+		var conn *pgx.Conn
+		if conn, err = pgx.Connect(config); err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err = conn.Exec("SELECT pg_log_standby_snapshot()"); err != nil {
+			return
+		}
+	}
+	return
+}
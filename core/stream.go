@@ -0,0 +1,52 @@
+package core
+
+import (
+	"github.com/jackc/pgx"
+)
+
+// Streaming 大事务流式传输(协议版本2，需要PostgreSQL 14+)
+// 未配置时服务器在事务提交后才发送整个事务，超过logical_decoding_work_mem的事务需先写入服务器磁盘
+// 配置后服务器在事务进行中分块发送，handler收到的块可能属于最终回滚的事务，需按Xid暂存直到收到EventType_COMMIT
+// 未提交的事务不会确认lsn，重连后服务器重新发送该事务的所有块，需丢弃该Xid之前暂存的块
+func (t *Replication) Streaming() *Replication {
+	t._streaming = true
+	return t
+}
+
+// streamStart 事务块开始，块内的变更缓存到streamStop时交给handler
+func (t *Replication) streamStart(v StreamStart) {
+	if len(t._flushMsg) > 0 {
+		t.debug("replication", "discard", len(t._flushMsg), "messages of unfinished transaction")
+	}
+	t._flushMsg = nil
+	t._stream = v.XID
+	t.debug("stream", "start", v.XID, "first", v.FirstSegment)
+}
+
+// streamStop 事务块结束，块内的变更交给handler，此时事务尚未提交，不确认lsn
+func (t *Replication) streamStop(message *pgx.WalMessage, dmlHandler ReplicationDMLHandler) {
+	msg := append(t._flushMsg, ReplicationMessage{EventType: EventType_STREAM_STOP, Lsn: message.WalStart, Xid: t._stream, SubXid: t._stream})
+	t._flushMsg = nil
+	t._stream = 0
+	dmlHandler(msg...)
+}
+
+// streamCommit 流式传输的事务提交
+func (t *Replication) streamCommit(message *pgx.WalMessage, v StreamCommit, dmlHandler ReplicationDMLHandler) error {
+	t.debug("stream", "commit", v.XID)
+	status := dmlHandler(ReplicationMessage{EventType: EventType_COMMIT, Lsn: message.WalStart, CommitTime: v.Timestamp, Xid: v.XID, SubXid: v.XID})
+	if status == DMLHandlerStatusSuccess {
+		return t.confirm(message.WalStart)
+	}
+	return nil
+}
+
+// streamAbort 流式传输的事务或子事务回滚
+func (t *Replication) streamAbort(message *pgx.WalMessage, v StreamAbort, dmlHandler ReplicationDMLHandler) {
+	t.debug("stream", "abort", v.XID, v.SubXID)
+	if t._rowCache != nil {
+		// 缓存中可能有回滚的事务写入的行值
+		t._rowCache.reset()
+	}
+	dmlHandler(ReplicationMessage{EventType: EventType_STREAM_ABORT, Lsn: message.WalStart, Xid: v.XID, SubXid: v.SubXID})
+}
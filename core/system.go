@@ -0,0 +1,204 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// SystemIdentity IDENTIFY_SYSTEM的结果
+type SystemIdentity struct {
+	// 数据库集群的唯一标识，重建集群(initdb)或从物理备份恢复为新集群时变化
+	SystemID string
+	// 当前时间线，提升备库或PITR恢复后递增
+	Timeline int32
+	// 当前wal写入位置
+	XLogPos uint64
+	DBName  string
+}
+
+// SystemMismatchError 服务器与checkpoint记录的系统标识不一致，或时间线不是记录的时间线的延续
+// 数据库可能已被恢复(PITR)或替换，保存的lsn可能已不再指向相同的数据
+type SystemMismatchError struct {
+	Expected SystemIdentity
+	Actual   SystemIdentity
+}
+
+func (e *SystemMismatchError) Error() string {
+	if e.Expected.SystemID != e.Actual.SystemID {
+		return fmt.Sprintf("system identifier changed from %s to %s", e.Expected.SystemID, e.Actual.SystemID)
+	}
+	return fmt.Sprintf("timeline %d on system %s does not continue timeline %d at the saved position", e.Actual.Timeline, e.Actual.SystemID, e.Expected.Timeline)
+}
+
+// IdentifySystem 在复制连接上执行IDENTIFY_SYSTEM
+func (t *Replication) IdentifySystem() (id SystemIdentity, err error) {
+	conn, err := t.conn()
+	if err != nil {
+		return
+	}
+	rows, err := conn.IdentifySystem()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = fmt.Errorf("IDENTIFY_SYSTEM returned no rows")
+		}
+		return
+	}
+	values, err := rows.Values()
+	if err != nil {
+		return
+	}
+	if len(values) < 4 {
+		return id, fmt.Errorf("IDENTIFY_SYSTEM returned %d columns", len(values))
+	}
+	id.SystemID = fmt.Sprint(values[0])
+	timeline, err := strconv.ParseInt(fmt.Sprint(values[1]), 10, 32)
+	if err != nil {
+		return id, fmt.Errorf("invalid timeline %v", values[1])
+	}
+	id.Timeline = int32(timeline)
+	if id.XLogPos, err = pgx.ParseLSN(fmt.Sprint(values[2])); err != nil {
+		return
+	}
+	if values[3] != nil {
+		id.DBName = fmt.Sprint(values[3])
+	}
+	return id, rows.Err()
+}
+
+// ExpectSystem 启动时校验服务器的系统标识和时间线与checkpoint记录的一致，不一致时Start返回*SystemMismatchError
+// 提升备库或切换主库后时间线递增，新时间线的历史(TIMELINE_HISTORY)中记录的时间线在已确认位置之后才分叉时视为一致
+// timeline为0时只校验系统标识
+func (t *Replication) ExpectSystem(systemID string, timeline int32) *Replication {
+	t._expectSystem = &SystemIdentity{SystemID: systemID, Timeline: timeline}
+	return t
+}
+
+// System 获取最近一次Start时服务器的系统标识，需与lsn一起保存到checkpoint
+func (t *Replication) System() SystemIdentity {
+	t._mu.Lock()
+	defer t._mu.Unlock()
+	return t._system
+}
+
+// checkSystem 启动及重连时记录并校验系统标识
+func (t *Replication) checkSystem() error {
+	id, err := t.IdentifySystem()
+	if err != nil {
+		return err
+	}
+	t._mu.Lock()
+	previous := t._system
+	t._system = id
+	t._mu.Unlock()
+	t.debug("replication", "system", id.SystemID, "timeline", id.Timeline, "xlogpos", pgx.FormatLSN(id.XLogPos))
+	expected := t._expectSystem
+	if expected == nil && previous.SystemID != "" {
+		// 重连时与上次连接的服务器比较
+		expected = &previous
+	}
+	if expected == nil {
+		return nil
+	}
+	if expected.SystemID != id.SystemID {
+		return &SystemMismatchError{Expected: *expected, Actual: id}
+	}
+	if expected.Timeline == 0 || expected.Timeline == id.Timeline {
+		return nil
+	}
+	if id.Timeline > expected.Timeline {
+		ok, err := t.continuesTimeline(id.Timeline, expected.Timeline)
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.debug("replication", "timeline", expected.Timeline, "->", id.Timeline)
+			return nil
+		}
+	}
+	return &SystemMismatchError{Expected: *expected, Actual: id}
+}
+
+// continuesTimeline timeline的历史中包含previous，且previous在已确认的位置之后才分叉
+func (t *Replication) continuesTimeline(timeline, previous int32) (bool, error) {
+	history, err := t.timelineHistory(timeline)
+	if err != nil {
+		return false, fmt.Errorf("TIMELINE_HISTORY %d: %w", timeline, err)
+	}
+	switchpoint, ok := history[previous]
+	if !ok {
+		return false, nil
+	}
+	_, lsn := t._lsn.positions()
+	if lsn == 0 {
+		if lsn, err = t.startPosition(); err != nil {
+			return false, err
+		}
+	}
+	// 没有保存的位置时从复制槽的确认位置开始，由服务器保证
+	return lsn <= switchpoint, nil
+}
+
+// timelineHistory 时间线的历史文件，返回每个祖先时间线结束(分叉)的位置
+// 文件每行为"父时间线<tab>分叉位置<tab>原因"
+func (t *Replication) timelineHistory(timeline int32) (map[int32]uint64, error) {
+	conn, err := t.conn()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.TimelineHistory(int(timeline))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var content string
+	if rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) < 2 {
+			return nil, fmt.Errorf("returned %d columns", len(values))
+		}
+		switch v := values[1].(type) {
+		case []byte:
+			content = string(v)
+		default:
+			content = fmt.Sprint(v)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return parseTimelineHistory(content)
+}
+
+func parseTimelineHistory(content string) (map[int32]uint64, error) {
+	history := map[int32]uint64{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid timeline history line %q", line)
+		}
+		parent, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeline history line %q", line)
+		}
+		lsn, err := pgx.ParseLSN(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeline history line %q", line)
+		}
+		history[int32(parent)] = lsn
+	}
+	return history, nil
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TenantExtractor 从消息中提取租户标识，返回空字符串表示不属于任何租户
+type TenantExtractor func(msg ReplicationMessage) string
+
+// TenantFromSchema 从schema名称中提取租户标识
+// pattern为nil时schema名称即租户标识，否则取第一个子匹配(没有子匹配时取整个匹配)
+func TenantFromSchema(pattern *regexp.Regexp) TenantExtractor {
+	return func(msg ReplicationMessage) string {
+		if pattern == nil {
+			return msg.SchemaName
+		}
+		match := pattern.FindStringSubmatch(msg.SchemaName)
+		switch len(match) {
+		case 0:
+			return ""
+		case 1:
+			return match[0]
+		default:
+			return match[1]
+		}
+	}
+}
+
+// TenantFromColumn 从指定列的值中提取租户标识
+func TenantFromColumn(column string) TenantExtractor {
+	return func(msg ReplicationMessage) string {
+		if v, ok := msg.Body[column]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+}
+
+// Tenant 为每条消息附加租户标识
+func (t *Replication) Tenant(extractor TenantExtractor) *Replication {
+	t._tenant = extractor
+	return t
+}
+
+// TenantTopic 根据模板生成消息的主题名称
+// 支持{tenant} {schema} {table}占位符
+func TenantTopic(template string, msg ReplicationMessage) string {
+	return strings.NewReplacer(
+		"{tenant}", msg.Tenant,
+		"{schema}", msg.SchemaName,
+		"{table}", msg.TableName,
+	).Replace(template)
+}
+
+// TenantRouter 按租户把消息分发到不同的handler
+type TenantRouter struct {
+	routes   map[string]ReplicationDMLHandler
+	fallback ReplicationDMLHandler
+}
+
+// NewTenantRouter fallback处理未配置路由的租户，为nil时丢弃这些租户的消息
+func NewTenantRouter(fallback ReplicationDMLHandler) *TenantRouter {
+	return &TenantRouter{routes: map[string]ReplicationDMLHandler{}, fallback: fallback}
+}
+
+// Route 配置租户的handler
+func (r *TenantRouter) Route(tenant string, handler ReplicationDMLHandler) *TenantRouter {
+	r.routes[tenant] = handler
+	return r
+}
+
+func (r *TenantRouter) handler(tenant string) ReplicationDMLHandler {
+	if h, ok := r.routes[tenant]; ok {
+		return h
+	}
+	return r.fallback
+}
+
+// Handle 实现ReplicationDMLHandler
+// 每个租户的消息保持原有顺序，不属于任何租户的消息(READY/BEGIN/COMMIT等)会发送给每个收到消息的handler，BEGIN在最前面
+// 所有handler都返回DMLHandlerStatusSuccess时才确认lsn
+func (r *TenantRouter) Handle(msg ...ReplicationMessage) DMLHandlerStatus {
+	var order []string
+	groups := map[string][]ReplicationMessage{}
+	var begin, common []ReplicationMessage
+	for _, m := range msg {
+		if m.EventType == EventType_BEGIN {
+			begin = append(begin, m)
+			continue
+		}
+		if m.RelationID == 0 {
+			common = append(common, m)
+			continue
+		}
+		if _, ok := groups[m.Tenant]; !ok {
+			order = append(order, m.Tenant)
+		}
+		groups[m.Tenant] = append(groups[m.Tenant], m)
+	}
+	status := DMLHandlerStatusSuccess
+	if len(order) == 0 {
+		// READY等控制消息通知所有handler
+		control := append(begin, common...)
+		for _, h := range r.handlers() {
+			if h(control...) != DMLHandlerStatusSuccess {
+				status = DMLHandlerStatusContinue
+			}
+		}
+		return status
+	}
+	for _, tenant := range order {
+		h := r.handler(tenant)
+		if h == nil {
+			continue
+		}
+		batch := append(append(append([]ReplicationMessage(nil), begin...), groups[tenant]...), common...)
+		if h(batch...) != DMLHandlerStatusSuccess {
+			status = DMLHandlerStatusContinue
+		}
+	}
+	return status
+}
+
+func (r *TenantRouter) handlers() []ReplicationDMLHandler {
+	res := make([]ReplicationDMLHandler, 0, len(r.routes)+1)
+	for _, h := range r.routes {
+		res = append(res, h)
+	}
+	if r.fallback != nil {
+		res = append(res, r.fallback)
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// TestDecoding 调试模式：同时通过test_decoding插件的临时复制槽解码，把服务器输出的文本交给fn，fn为nil时输出到debug日志
+// 用于对比排查pgoutput解析结果与服务器解码结果的差异，不需要修改服务器配置
+// 临时复制槽从创建时的位置开始，连接关闭后由服务器自动删除；test_decoding不受发布流限制，会输出所有表的变更
+// fn在独立的协程中调用，与handler并发
+func (t *Replication) TestDecoding(fn func(lsn uint64, data string)) *Replication {
+	if fn == nil {
+		fn = func(lsn uint64, data string) {
+			t.debug("test_decoding", pgx.FormatLSN(lsn), data)
+		}
+	}
+	t._testDecoding = fn
+	return t
+}
+
+// testDecodingSlot 临时复制槽名称，长度不超过63
+func (t *Replication) testDecodingSlot() string {
+	const suffix = "_test_decoding"
+	name := t.name
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+	return name + suffix
+}
+
+// runTestDecoding 出错后等待重连，直到ctx结束
+func (t *Replication) runTestDecoding(ctx context.Context) {
+	for {
+		err := t.testDecoding(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		t.debug("test_decoding", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (t *Replication) testDecoding(ctx context.Context) error {
+	config, _, err := t.connConfig(t.config)
+	if err != nil {
+		return err
+	}
+	conn, err := pgx.ReplicationConnect(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	slot := t.testDecodingSlot()
+	if _, err = conn.Exec(fmt.Sprintf("CREATE_REPLICATION_SLOT %s TEMPORARY LOGICAL test_decoding NOEXPORT_SNAPSHOT", slot)); err != nil {
+		return fmt.Errorf("create slot %s: %w", slot, classifyError(err))
+	}
+	if err = conn.StartReplication(slot, 0, -1, `"include-xids" '1'`, `"include-timestamp" '1'`, `"skip-empty-xacts" '1'`); err != nil {
+		return fmt.Errorf("StartReplication %w", classifyError(err))
+	}
+	for {
+		message, err := conn.WaitForReplicationMessage(ctx)
+		if err != nil {
+			return err
+		}
+		var lsn uint64
+		if message.WalMessage != nil {
+			lsn = message.WalMessage.WalStart
+			t._testDecoding(lsn, string(message.WalMessage.WalData))
+		}
+		if message.ServerHeartbeat != nil {
+			lsn = message.ServerHeartbeat.ServerWalEnd
+		}
+		if lsn > 0 {
+			// 临时复制槽只用于调试，收到即确认，避免服务器保留wal
+			status, err := pgx.NewStandbyStatus(lsn)
+			if err != nil {
+				return err
+			}
+			if err = conn.SendStandbyStatus(status); err != nil {
+				return err
+			}
+		}
+	}
+}
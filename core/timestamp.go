@@ -0,0 +1,67 @@
+package core
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// TimePolicy timestamp、timestamptz及date列的解码方式
+type TimePolicy int
+
+const (
+	// TimeValue time.Time(默认)
+	TimeValue TimePolicy = iota
+	// TimeRFC3339 RFC3339字符串，timestamptz带时区偏移，timestamp不带时区，date为2006-01-02
+	TimeRFC3339
+	// TimeEpochMillis 距1970-01-01 00:00:00 UTC的毫秒数(int64)
+	TimeEpochMillis
+)
+
+// TimeColumns timestamp、timestamptz及date列的解码方式
+// loc为nil时使用UTC；timestamptz转换到loc，timestamp的值视为loc的本地时间，date为UTC零点
+// 非TimeValue时infinity/-infinity为字符串；数组元素不受影响
+func (t *Replication) TimeColumns(policy TimePolicy, loc *time.Location) *Replication {
+	t._time = policy
+	t._timeLoc = loc
+	return t
+}
+
+// timeValue 按TimeColumns转换时间列，oid为列的类型
+func (t *Replication) timeValue(oid pgtype.OID, tm time.Time) interface{} {
+	loc := t._timeLoc
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch oid {
+	case pgtype.TimestamptzOID:
+		tm = tm.In(loc)
+	case pgtype.TimestampOID:
+		// 没有时区的墙上时间，pgtype按UTC解码
+		tm = time.Date(tm.Year(), tm.Month(), tm.Day(), tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond(), loc)
+	}
+	switch t._time {
+	case TimeRFC3339:
+		switch oid {
+		case pgtype.DateOID:
+			return tm.Format("2006-01-02")
+		case pgtype.TimestampOID:
+			return tm.Format("2006-01-02T15:04:05.999999")
+		}
+		return tm.Format(time.RFC3339Nano)
+	case TimeEpochMillis:
+		return tm.UnixNano() / int64(time.Millisecond)
+	}
+	return tm
+}
+
+// infinityValue 非TimeValue时infinity/-infinity的值
+func (t *Replication) infinityValue(v pgtype.InfinityModifier) interface{} {
+	if t._time == TimeValue {
+		return v
+	}
+	if v == pgtype.NegativeInfinity {
+		return "-infinity"
+	}
+	return "infinity"
+}
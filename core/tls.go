@@ -0,0 +1,142 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ClientTLS 双向TLS配置，证书文件在每次建立连接时检查修改时间，轮换后自动重新加载
+type ClientTLS struct {
+	// 客户端证书及私钥，PEM格式
+	CertFile string
+	KeyFile  string
+	// 校验服务器证书的CA，PEM格式，为空时使用系统根证书
+	CAFile string
+	// 校验服务器证书的主机名(verify-full)，为空时只校验证书链(verify-ca)
+	ServerName string
+}
+
+// TLSConfig 生成tls.Config，证书文件不存在或格式错误时返回错误
+func (c ClientTLS) TLSConfig() (*tls.Config, error) {
+	r := &tlsReloader{c: c}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ServerName: c.ServerName,
+		// 由VerifyConnection使用重新加载后的CA校验
+		InsecureSkipVerify:   true,
+		VerifyConnection:     r.verify,
+		GetClientCertificate: r.clientCertificate,
+	}, nil
+}
+
+// ClientTLS 复制连接及管理连接(建表、目录刷新等)使用双向TLS
+func (t *Replication) ClientTLS(c ClientTLS) (*Replication, error) {
+	config, err := c.TLSConfig()
+	if err != nil {
+		return t, err
+	}
+	t.config.TLSConfig = config
+	if t._primary != nil && t._primary.TLSConfig == nil {
+		t._primary.TLSConfig = config
+	}
+	return t, nil
+}
+
+type tlsReloader struct {
+	c ClientTLS
+
+	mu      sync.Mutex
+	modTime [3]time.Time
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+}
+
+func fileModTime(file string) (time.Time, error) {
+	if file == "" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// reload 文件修改时间变化时重新加载
+func (r *tlsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var modTime [3]time.Time
+	for i, file := range []string{r.c.CertFile, r.c.KeyFile, r.c.CAFile} {
+		t, err := fileModTime(file)
+		if err != nil {
+			return err
+		}
+		modTime[i] = t
+	}
+	if modTime == r.modTime && (r.cert != nil || r.c.CertFile == "") {
+		return nil
+	}
+	var cert *tls.Certificate
+	if r.c.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.c.CertFile, r.c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load client certificate: %w", err)
+		}
+		cert = &c
+	}
+	var roots *x509.CertPool
+	if r.c.CAFile != "" {
+		pem, err := os.ReadFile(r.c.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.c.CAFile)
+		}
+	}
+	r.cert, r.roots, r.modTime = cert, roots, modTime
+	return nil
+}
+
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert == nil {
+		// 不提供客户端证书
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+func (r *tlsReloader) verify(cs tls.ConnectionState) error {
+	if err := r.reload(); err != nil {
+		return err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server did not present a certificate")
+	}
+	r.mu.Lock()
+	roots := r.roots
+	r.mu.Unlock()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       r.c.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// ToastPolicy update中未变化的TOAST列(服务器不发送其值)的处理方式
+type ToastPolicy int
+
+const (
+	// ToastNull 值为nil，与NULL无法区分(默认)
+	ToastNull ToastPolicy = iota
+	// ToastOmit Body及Fields中不包含该列
+	ToastOmit
+	// ToastSentinel 值为UnchangedToast
+	ToastSentinel
+	// ToastFetch 按复制标识列从数据库读取该列，读取的是查询时的值，可能比本次变更更新；行已被删除时不包含该列
+	ToastFetch
+)
+
+// UnchangedToast ToastSentinel时未变化的TOAST列的值，JSON序列化为"__unchanged_toast__"
+var UnchangedToast = unchangedToast{}
+
+type unchangedToast struct{}
+
+func (unchangedToast) String() string { return "__unchanged_toast__" }
+
+func (unchangedToast) MarshalJSON() ([]byte, error) { return []byte(`"__unchanged_toast__"`), nil }
+
+// UnchangedToastPolicy 未变化的TOAST列的处理方式
+// 有完整旧值(REPLICA IDENTITY FULL)时直接使用旧值，不受policy影响
+func (t *Replication) UnchangedToastPolicy(policy ToastPolicy) *Replication {
+	t._toast = policy
+	return t
+}
+
+// Toast 未变化的TOAST列的处理方式
+func (t *Replication) Toast() ToastPolicy {
+	return t._toast
+}
+
+// unchangedToast 按policy替换body中未变化的TOAST列
+func (t *Replication) unchangedToast(relation uint32, row, oldRow []Tuple, body map[string]interface{}) error {
+	rel, ok := t.set.Get(relation)
+	if !ok {
+		return nil
+	}
+	var missing []int
+	var old map[string]pgtype.Value
+	for i, tuple := range row {
+		if tuple.Flag != 'u' || i >= len(rel.Columns) {
+			continue
+		}
+		name := rel.Columns[i].Name
+		if len(oldRow) == len(row) && oldRow[i].Flag != 'u' {
+			if old == nil {
+				var err error
+				if old, err = t.set.Values(relation, oldRow); err != nil {
+					return fmt.Errorf("error parsing old values: %s", err)
+				}
+			}
+			body[name] = t.columnValue(old[name])
+			continue
+		}
+		switch t._toast {
+		case ToastOmit:
+			delete(body, name)
+		case ToastSentinel:
+			body[name] = UnchangedToast
+		case ToastFetch:
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return t.fetchToast(rel, row, body, missing)
+}
+
+// fetchToast 按复制标识列读取columns的当前值
+func (t *Replication) fetchToast(rel Relation, row []Tuple, body map[string]interface{}, columns []int) error {
+	var where []string
+	var args []interface{}
+	for i, col := range rel.Columns {
+		if !col.Key || row[i].Flag == 'u' {
+			continue
+		}
+		name := pgx.Identifier{col.Name}.Sanitize()
+		if body[col.Name] == nil {
+			where = append(where, name+" IS NULL")
+			continue
+		}
+		args = append(args, body[col.Name])
+		where = append(where, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+	if len(where) == 0 {
+		for _, i := range columns {
+			delete(body, rel.Columns[i].Name)
+		}
+		t.debug("toast", rel.Namespace, rel.Name, "no replica identity to fetch unchanged values")
+		return nil
+	}
+	selects := make([]string, 0, len(columns))
+	for _, i := range columns {
+		selects = append(selects, pgx.Identifier{rel.Columns[i].Name}.Sanitize()+"::text")
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s LIMIT 1", strings.Join(selects, ", "), pgx.Identifier{rel.Namespace, rel.Name}.Sanitize(), strings.Join(where, " AND "))
+	conn, err := t.toastConn()
+	if err != nil {
+		return fmt.Errorf("unchanged toast: %w", err)
+	}
+	values := make([]pgtype.Text, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = conn.QueryRow(sql, args...).Scan(dest...); err == pgx.ErrNoRows {
+		// 行在读取前已被删除或主键已变化
+		for _, i := range columns {
+			delete(body, rel.Columns[i].Name)
+		}
+		t.debug("toast", rel.Namespace, rel.Name, "row not found")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unchanged toast %s.%s: %w", rel.Namespace, rel.Name, err)
+	}
+	for j, i := range columns {
+		col := rel.Columns[i]
+		if values[j].Status != pgtype.Present {
+			body[col.Name] = nil
+			continue
+		}
+		decoder := t.set.Decoder(col)
+		if err = decoder.DecodeText(nil, []byte(values[j].String)); err != nil {
+			return fmt.Errorf("unchanged toast %s.%s: error decoding %s: %s", rel.Namespace, rel.Name, col.Name, err)
+		}
+		body[col.Name] = t.columnValue(decoder)
+	}
+	return nil
+}
+
+// toastConn ToastFetch读取用的连接，断开后重连，standby时连接主库
+func (t *Replication) toastConn() (*pgx.Conn, error) {
+	if t._toastConn != nil && t._toastConn.IsAlive() {
+		return t._toastConn, nil
+	}
+	t.closeToastConn()
+	config, err := t.primaryConfig()
+	if err != nil {
+		return nil, err
+	}
+	if t._toastConn, err = pgx.Connect(config); err != nil {
+		return nil, err
+	}
+	return t._toastConn, nil
+}
+
+func (t *Replication) closeToastConn() {
+	if t._toastConn != nil {
+		t._toastConn.Close()
+		t._toastConn = nil
+	}
+}
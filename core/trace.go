@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jackc/pgx"
+)
+
+// Tracer 创建追踪span，用OpenTelemetry时包装trace.Tracer，attrs转换为attribute.KeyValue
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, core.Span) {
+//		ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(toAttributes(attrs)...))
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, Span)
+}
+
+// Span 追踪span，属性值为string、int64、bool或[]string
+type Span interface {
+	SetAttributes(attrs map[string]interface{})
+	RecordError(err error)
+	End()
+}
+
+// WithTracer 追踪事务，每个事务(Begin到Commit)一个pg_replication.transaction span，
+// 其中每条变更的解码为pg_replication.decode子span，handler的执行为pg_replication.handler子span
+// 事务span的context通过ReplicationMessage.Trace交给handler，下游可据此传播追踪，串联端到端的延迟
+// 流式传输及两阶段提交的事务不追踪
+func (t *Replication) WithTracer(tracer Tracer) *Replication {
+	t._tracer = tracer
+	return t
+}
+
+// traceBegin 事务开始，未结束的事务(重连后服务器重新发送)的span标记为discarded
+func (t *Replication) traceBegin(v Begin, lsn uint64) {
+	if t._tracer == nil {
+		return
+	}
+	t.traceEnd(map[string]interface{}{"pg_replication.discarded": true}, nil)
+	parent := t._traceCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	t._txCtx, t._txSpan = t._tracer.Start(parent, "pg_replication.transaction", map[string]interface{}{
+		"db.system":            "postgresql",
+		"db.name":              t.config.Database,
+		"pg_replication.slot":  t.name,
+		"pg_replication.xid":   int64(v.XID),
+		"pg_replication.begin": pgx.FormatLSN(lsn),
+	})
+}
+
+// traceDecode 事务中一条变更的解码，未追踪时返回nil
+func (t *Replication) traceDecode(lsn uint64) Span {
+	if t._txSpan == nil {
+		return nil
+	}
+	_, span := t._tracer.Start(t._txCtx, "pg_replication.decode", map[string]interface{}{"pg_replication.lsn": pgx.FormatLSN(lsn)})
+	return span
+}
+
+func (t *Replication) traceDecoded(span Span, m ReplicationMessage, err error) {
+	if span == nil {
+		return
+	}
+	if m.RelationID > 0 {
+		span.SetAttributes(map[string]interface{}{
+			"pg_replication.event": m.EventType.String(),
+			"db.sql.table":         m.SchemaName + "." + m.TableName,
+		})
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// traceHandler handler处理事务中的消息，返回的handler在pg_replication.handler span中执行
+func (t *Replication) traceHandler(msg []ReplicationMessage, dmlHandler ReplicationDMLHandler) ReplicationDMLHandler {
+	if t._txSpan == nil {
+		return dmlHandler
+	}
+	tables := map[string]bool{}
+	for i := range msg {
+		msg[i].Trace = t._txCtx
+		if msg[i].TableName != "" {
+			tables[msg[i].SchemaName+"."+msg[i].TableName] = true
+		}
+	}
+	names := make([]string, 0, len(tables))
+	for k := range tables {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	t._txSpan.SetAttributes(map[string]interface{}{"pg_replication.tables": names, "pg_replication.messages": int64(len(msg))})
+	return func(msg ...ReplicationMessage) DMLHandlerStatus {
+		_, span := t._tracer.Start(t._txCtx, "pg_replication.handler", nil)
+		status := dmlHandler(msg...)
+		span.SetAttributes(map[string]interface{}{"pg_replication.success": status == DMLHandlerStatusSuccess})
+		span.End()
+		return status
+	}
+}
+
+// traceEnd 结束当前事务的span
+func (t *Replication) traceEnd(attrs map[string]interface{}, err error) {
+	if t._txSpan == nil {
+		return
+	}
+	if attrs != nil {
+		t._txSpan.SetAttributes(attrs)
+	}
+	if err != nil {
+		t._txSpan.RecordError(err)
+	}
+	t._txSpan.End()
+	t._txCtx, t._txSpan = nil, nil
+}
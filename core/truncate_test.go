@@ -0,0 +1,40 @@
+package core_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// 一条TRUNCATE语句清空的每张表各有一个变更
+func TestTruncateRelations(t *testing.T) {
+	cols := []core.Column{mock.Key("id", pgtype.Int4OID)}
+	src := mock.NewSource().
+		Relation(core.Relation{ID: 1, Namespace: "public", Name: "users", Columns: cols}).
+		Relation(core.Relation{ID: 2, Namespace: "public", Name: "orders", Columns: cols}).
+		Begin().Truncate(1, 2).Commit().End()
+	var tables []string
+	var lsn uint64
+	core.NewReplication("users_slot", pgx.ConnConfig{}).WithTransport(src).
+		Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+			for _, m := range msg {
+				if m.EventType != core.EventType_TRUNCATE {
+					continue
+				}
+				if lsn != 0 && m.Lsn != lsn || m.Xid == 0 {
+					t.Errorf("truncate %s lsn %x xid %d", m.TableName, m.Lsn, m.Xid)
+				}
+				lsn = m.Lsn
+				tables = append(tables, m.TableName)
+			}
+			return core.DMLHandlerStatusSuccess
+		})
+	if want := []string{"users", "orders"}; !reflect.DeepEqual(tables, want) {
+		t.Fatalf("truncated %v, want %v", tables, want)
+	}
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"github.com/jackc/pgx"
+)
+
+// TwoPhase 两阶段提交解码(协议版本3，需要PostgreSQL 15+)
+// 未配置时PREPARE TRANSACTION的事务在COMMIT PREPARED时才作为普通事务发送
+// 配置后在PREPARE TRANSACTION时发送事务中的变更(EventType_PREPARE)，之后单独发送EventType_COMMIT_PREPARED或EventType_ROLLBACK_PREPARED
+// handler对EventType_PREPARE返回DMLHandlerStatusSuccess后确认lsn，服务器不会再重新发送该事务的变更，需先持久化(如在目标库PREPARE TRANSACTION)
+// 复制槽需以TWO_PHASE方式创建，已存在的复制槽不会被修改
+func (t *Replication) TwoPhase() *Replication {
+	t._twoPhase = true
+	return t
+}
+
+// beginPrepare 两阶段提交的事务开始，变更缓存到prepare时交给handler
+func (t *Replication) beginPrepare(message *pgx.WalMessage, v BeginPrepare) {
+	if len(t._flushMsg) > 0 {
+		t.debug("replication", "discard", len(t._flushMsg), "messages of unfinished transaction")
+	}
+	t._xid = v.XID
+	t._flushMsg = []ReplicationMessage{{EventType: EventType_BEGIN, Lsn: message.WalStart, CommitTime: v.Timestamp, Xid: v.XID, Gid: v.GID}}
+}
+
+// prepare PREPARE TRANSACTION，v为Prepare或StreamPrepare，流式传输的事务变更已在各块中交给handler
+func (t *Replication) prepare(message *pgx.WalMessage, v Prepare, dmlHandler ReplicationDMLHandler) error {
+	t.debug("twophase", "prepare", v.XID, v.GID)
+	msg := append(t._flushMsg, ReplicationMessage{EventType: EventType_PREPARE, Lsn: message.WalStart, Xid: v.XID, Gid: v.GID})
+	t._flushMsg = nil
+	t._xid = 0
+	if dmlHandler(msg...) == DMLHandlerStatusSuccess {
+		return t.confirm(message.WalStart)
+	}
+	return nil
+}
+
+// finishPrepared COMMIT PREPARED/ROLLBACK PREPARED
+func (t *Replication) finishPrepared(message *pgx.WalMessage, m ReplicationMessage, dmlHandler ReplicationDMLHandler) error {
+	t.debug("twophase", m.EventType, m.Xid, m.Gid)
+	m.Lsn = message.WalStart
+	if dmlHandler(m) == DMLHandlerStatusSuccess {
+		return t.confirm(message.WalStart)
+	}
+	return nil
+}
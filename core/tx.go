@@ -0,0 +1,44 @@
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// TxHandler 每个事务调用一次，tx按顺序包含事务中的所有行变更，不包含BEGIN/COMMIT等控制消息
+// 返回nil后确认该事务的lsn，可用于把整个事务原子地写入下游
+// 返回错误时不确认lsn并停止同步，StartTx返回该错误，重启后服务器从该事务重新发送
+type TxHandler func(ctx context.Context, tx []ReplicationMessage) error
+
+// StartTx 以TxHandler启动同步，需要完整的事务，不能与Streaming/TwoPhase同时使用
+func (t *Replication) StartTx(ctx context.Context, handler TxHandler) error {
+	if t._streaming || t._twoPhase {
+		return fmt.Errorf("TxHandler requires complete transactions, disable Streaming and TwoPhase")
+	}
+	var txErr error
+	err := t.Start(ctx, func(msg ...ReplicationMessage) DMLHandlerStatus {
+		if txErr != nil {
+			return DMLHandlerStatusContinue
+		}
+		tx := make([]ReplicationMessage, 0, len(msg))
+		for _, m := range msg {
+			switch m.EventType {
+			case EventType_READY, EventType_PROMOTED, EventType_BEGIN, EventType_COMMIT:
+				continue
+			}
+			tx = append(tx, m)
+		}
+		if len(tx) == 0 {
+			return DMLHandlerStatusSuccess
+		}
+		if txErr = handler(ctx, tx); txErr != nil {
+			t.requestStop()
+			return DMLHandlerStatusContinue
+		}
+		return DMLHandlerStatusSuccess
+	})
+	if txErr != nil {
+		return txErr
+	}
+	return err
+}
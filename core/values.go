@@ -2,24 +2,177 @@ package core
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/pgtype"
 )
 
 type RelationSet struct {
-	// TODO: Add mutex
+	mu        sync.RWMutex
 	relations map[uint32]Relation
+	// schema.table -> relation id
+	names map[string]uint32
+	// custom data types announced by Type messages
+	types map[uint32]Type
 }
 
 func NewRelationSet() *RelationSet {
-	return &RelationSet{relations: map[uint32]Relation{}}
+	return &RelationSet{relations: map[uint32]Relation{}, names: map[string]uint32{}, types: map[uint32]Type{}}
 }
 
-func (rs *RelationSet) Add(r Relation) {
+// AddType caches a custom data type (enum, domain, composite, extension
+// type or an array of one) used by a replicated relation.
+func (rs *RelationSet) AddType(t Type) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.types[t.ID] = t
+}
+
+// Type returns the cached custom data type.
+func (rs *RelationSet) Type(id uint32) (t Type, ok bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	t, ok = rs.types[id]
+	return
+}
+
+// Decoder returns the decoder for the column. Custom types decode to their
+// text representation (the label of an enum), arrays of them like text[].
+func (rs *RelationSet) Decoder(c Column) DecoderValue {
+	if t, ok := rs.Type(c.Type); ok {
+		if strings.HasPrefix(t.Name, "_") {
+			return &pgtype.TextArray{}
+		}
+		return &pgtype.Text{}
+	}
+	return ColumnDecoder(c)
+}
+
+// Add caches the relation definition. It reports whether the definition
+// replaced a stale one, i.e. the table was dropped and recreated under a new
+// OID, or an OID previously cached for another table was reused.
+func (rs *RelationSet) Add(r Relation) (reset bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	key := relationKey(r.Namespace, r.Name)
+	if old, ok := rs.relations[r.ID]; ok {
+		if oldKey := relationKey(old.Namespace, old.Name); oldKey != key {
+			// OID reused by another table
+			delete(rs.names, oldKey)
+			reset = true
+		}
+	}
+	if id, ok := rs.names[key]; ok && id != r.ID {
+		// table recreated with a new OID
+		delete(rs.relations, id)
+		reset = true
+	}
 	rs.relations[r.ID] = r
+	rs.names[key] = r.ID
+	return
+}
+
+// Get returns the cached definition of the relation.
+func (rs *RelationSet) Get(id uint32) (rel Relation, ok bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	rel, ok = rs.relations[id]
+	return
+}
+
+// Lookup returns the cached definition of the relation by its name.
+func (rs *RelationSet) Lookup(schema, table string) (rel Relation, ok bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.lookup(schema, table)
+}
+
+func (rs *RelationSet) lookup(schema, table string) (rel Relation, ok bool) {
+	if id, found := rs.names[relationKey(schema, table)]; found {
+		rel, ok = rs.relations[id]
+	}
+	return
+}
+
+// Drift lists the differences between the cached definition of the table
+// and r. It returns no changes when the table is unknown or unchanged.
+func (rs *RelationSet) Drift(r Relation) (old Relation, changes []string) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	old, ok := rs.relations[r.ID]
+	if !ok {
+		if old, ok = rs.lookup(r.Namespace, r.Name); !ok {
+			return
+		}
+	}
+	if old.ID != r.ID {
+		changes = append(changes, fmt.Sprintf("relation id %d -> %d", old.ID, r.ID))
+	}
+	if old.Namespace != r.Namespace || old.Name != r.Name {
+		changes = append(changes, fmt.Sprintf("relation %s.%s -> %s.%s", old.Namespace, old.Name, r.Namespace, r.Name))
+	}
+	if old.Replica != r.Replica {
+		changes = append(changes, fmt.Sprintf("replica identity %c -> %c", old.Replica, r.Replica))
+	}
+	oldCols := make(map[string]Column, len(old.Columns))
+	for _, col := range old.Columns {
+		oldCols[col.Name] = col
+	}
+	for _, col := range r.Columns {
+		oc, ok := oldCols[col.Name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("column %s added", col.Name))
+			continue
+		}
+		delete(oldCols, col.Name)
+		if oc.Type != col.Type || oc.Mode != col.Mode {
+			changes = append(changes, fmt.Sprintf("column %s type %d(%d) -> %d(%d)", col.Name, oc.Type, oc.Mode, col.Type, col.Mode))
+		}
+		if oc.Key != col.Key {
+			changes = append(changes, fmt.Sprintf("column %s key %v -> %v", col.Name, oc.Key, col.Key))
+		}
+	}
+	for _, col := range old.Columns {
+		if _, ok := oldCols[col.Name]; ok {
+			changes = append(changes, fmt.Sprintf("column %s dropped", col.Name))
+		}
+	}
+	return
+}
+
+// Relations returns a snapshot of the cached relations ordered by id.
+func (rs *RelationSet) Relations() []Relation {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	res := make([]Relation, 0, len(rs.relations))
+	for _, rel := range rs.relations {
+		res = append(res, rel)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// Schema returns the column names, type OIDs, type modifiers and key flags
+// of the cached relation.
+func (rs *RelationSet) Schema(id uint32) (schema RelationSchema, ok bool) {
+	rel, ok := rs.Get(id)
+	if ok {
+		schema = rs.schema(rel)
+	}
+	return
+}
+
+func relationKey(schema, table string) string {
+	return schema + "." + table
 }
 
 func (rs *RelationSet) Assist(id uint32) (schema, table string) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
 	if rel, ok := rs.relations[id]; ok {
 		return rel.Namespace, rel.Name
 	}
@@ -28,16 +181,23 @@ func (rs *RelationSet) Assist(id uint32) (schema, table string) {
 
 func (rs *RelationSet) Values(id uint32, row []Tuple) (values map[string]pgtype.Value, err error) {
 	values = map[string]pgtype.Value{}
-	rel, ok := rs.relations[id]
+	rel, ok := rs.Get(id)
 	if !ok {
 		return nil, fmt.Errorf("no relation for %d", id)
 	}
-	// assert same number of row and columns
+	if len(row) != len(rel.Columns) {
+		return nil, fmt.Errorf("relation %s.%s(%d) has %d columns, tuple has %d", rel.Namespace, rel.Name, id, len(rel.Columns), len(row))
+	}
 	for i, tuple := range row {
 		col := rel.Columns[i]
-		decoder := col.Decoder()
-		// TODO: Pass in connection?
-		if err = decoder.DecodeText(nil, tuple.Value); err != nil {
+		decoder := rs.Decoder(col)
+		if tuple.Flag == 'b' {
+			err = decodeBinary(decoder, tuple.Value)
+		} else {
+			// TODO: Pass in connection?
+			err = decoder.DecodeText(nil, tuple.Value)
+		}
+		if err != nil {
 			err = fmt.Errorf("error decoding tuple %d: %s", i, err)
 			return
 		}
@@ -46,7 +206,128 @@ func (rs *RelationSet) Values(id uint32, row []Tuple) (values map[string]pgtype.
 	return
 }
 
-func (c Column) Decoder() DecoderValue {
+// Binary 要求pgoutput以二进制格式发送列值(需要PostgreSQL 14+)，使用pgtype的二进制解码，减少numeric/timestamp/uuid等类型的文本解析开销
+// 没有二进制收发函数的类型(如部分扩展类型)服务器仍以文本格式发送
+func (t *Replication) Binary() *Replication {
+	t._binary = true
+	return t
+}
+
+// binaryConnInfo 二进制格式的数组等类型需要按OID查找元素类型
+var binaryConnInfo = pgtype.NewConnInfo()
+
+// decodeBinary 解码binary模式下的列值，不支持二进制格式的类型按文本解码
+func decodeBinary(decoder DecoderValue, src []byte) error {
+	if bd, ok := decoder.(pgtype.BinaryDecoder); ok {
+		return bd.DecodeBinary(binaryConnInfo, src)
+	}
+	return decoder.DecodeText(nil, src)
+}
+
+// plainValue 解码后的列值，pgtype数组(Get()返回数组本身)转换为[]interface{}，多维数组为嵌套的[]interface{}
+// 元素为元素类型Get()的值，NULL元素为nil，空数组为长度0的切片，其他值原样返回
+func plainValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return v
+	}
+	s := rv.Elem()
+	elements, dimensions := s.FieldByName("Elements"), s.FieldByName("Dimensions")
+	if elements.Kind() != reflect.Slice || !dimensions.IsValid() {
+		return v
+	}
+	dims, ok := dimensions.Interface().([]pgtype.ArrayDimension)
+	if !ok {
+		return v
+	}
+	values := make([]interface{}, elements.Len())
+	for i := range values {
+		if elem, ok := elements.Index(i).Addr().Interface().(pgtype.Value); ok {
+			values[i] = elem.Get()
+		}
+	}
+	if len(dims) == 0 {
+		return values
+	}
+	return nestArray(values, dims)
+}
+
+// nestArray 按维度把按行优先排列的元素拆分为嵌套切片
+func nestArray(values []interface{}, dims []pgtype.ArrayDimension) []interface{} {
+	if len(dims) <= 1 || dims[0].Length == 0 {
+		return values
+	}
+	n := int(dims[0].Length)
+	size := len(values) / n
+	res := make([]interface{}, n)
+	for i := range res {
+		res[i] = nestArray(values[i*size:(i+1)*size], dims[1:])
+	}
+	return res
+}
+
+// columnValue 解码后的列值交给handler的形式，见plainValue、JSONColumns、NumericColumns及TimeColumns
+func (t *Replication) columnValue(v pgtype.Value) interface{} {
+	switch n := v.(type) {
+	case *numericText:
+		if n.Status != pgtype.Present {
+			return nil
+		}
+		return t.numericValue(n.String)
+	case *pgtype.Timestamptz:
+		return t.timeColumn(pgtype.TimestamptzOID, n.Get())
+	case *pgtype.Timestamp:
+		return t.timeColumn(pgtype.TimestampOID, n.Get())
+	case *pgtype.Date:
+		return t.timeColumn(pgtype.DateOID, n.Get())
+	}
+	if t._json != JSONDecode {
+		switch j := v.(type) {
+		case *pgtype.JSON:
+			if j.Status != pgtype.Present {
+				return nil
+			}
+			return t.jsonValue(j.Bytes)
+		case *pgtype.JSONB:
+			if j.Status != pgtype.Present {
+				return nil
+			}
+			return t.jsonValue(j.Bytes)
+		}
+	}
+	return plainValue(v.Get())
+}
+
+// snapshotValue 快照查询连接解码的numeric列转换为NumericColumns的形式，json列按JSONColumns重新编码
+func (t *Replication) snapshotValue(oid pgtype.OID, v interface{}) interface{} {
+	if n, ok := v.(*pgtype.Numeric); ok {
+		return t.numericValue(numericString(n))
+	}
+	switch oid {
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		return t.snapshotJSON(v)
+	case pgtype.TimestamptzOID, pgtype.TimestampOID, pgtype.DateOID:
+		return t.timeColumn(oid, v)
+	}
+	return v
+}
+
+// timeColumn 时间列Get()的值按TimeColumns转换
+func (t *Replication) timeColumn(oid pgtype.OID, v interface{}) interface{} {
+	if t._time == TimeValue && t._timeLoc == nil {
+		return v
+	}
+	switch val := v.(type) {
+	case time.Time:
+		return t.timeValue(oid, val)
+	case pgtype.InfinityModifier:
+		return t.infinityValue(val)
+	}
+	return v
+}
+
+// ColumnDecoder 根据列的类型OID选择文本解码器
+func ColumnDecoder(c Column) DecoderValue {
 	switch c.Type {
 	case pgtype.ACLItemArrayOID:
 		return &pgtype.ACLItemArray{}
@@ -56,6 +337,8 @@ func (c Column) Decoder() DecoderValue {
 		return &pgtype.BoolArray{}
 	case pgtype.BoolOID:
 		return &pgtype.Bool{}
+	case pgtype.BPCharArrayOID:
+		return &pgtype.BPCharArray{}
 	case pgtype.ByteaArrayOID:
 		return &pgtype.BoolArray{}
 	case pgtype.ByteaOID:
@@ -103,6 +386,8 @@ func (c Column) Decoder() DecoderValue {
 		return &pgtype.JSON{}
 	case pgtype.NameOID:
 		return &pgtype.Name{}
+	case pgtype.NumericOID:
+		return &numericText{}
 	case pgtype.OIDOID:
 		// pgtype.OID does not implement the value interface
 		return &pgtype.Unknown{}
@@ -124,6 +409,8 @@ func (c Column) Decoder() DecoderValue {
 		return &pgtype.TimestamptzArray{}
 	case pgtype.TimestamptzOID:
 		return &pgtype.Timestamptz{}
+	case pgtype.UUIDArrayOID:
+		return &pgtype.UUIDArray{}
 	case pgtype.UUIDOID:
 		return &pgtype.UUID{}
 	case pgtype.UnknownOID:
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// VaultCredentials 从HashiCorp Vault数据库密钥引擎获取短期动态凭证
+// 凭证在剩余有效期超过一半时复用，否则重新申请
+//
+//	r.Credentials(&core.VaultCredentials{Addr: "https://vault:8200", Role: "cdc"})
+type VaultCredentials struct {
+	// Vault地址，为空时使用环境变量VAULT_ADDR
+	Addr string
+	// Vault令牌，为空时使用环境变量VAULT_TOKEN
+	Token string
+	// 数据库密钥引擎的挂载路径，默认database
+	Mount string
+	// 数据库角色
+	Role string
+	// 为空时使用http.DefaultClient
+	Client *http.Client
+
+	mu      sync.Mutex
+	cached  Credentials
+	fetched time.Time
+}
+
+type vaultCredsResponse struct {
+	LeaseDuration int `json:"lease_duration"`
+	Data          struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (v *VaultCredentials) Credentials(config pgx.ConnConfig) (Credentials, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	if v.cached.Password != "" && (v.cached.Expires.IsZero() || now.Before(v.fetched.Add(v.cached.Expires.Sub(v.fetched)/2))) {
+		return v.cached, nil
+	}
+	addr, token, mount := v.Addr, v.Token, v.Mount
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if mount == "" {
+		mount = "database"
+	}
+	if addr == "" || v.Role == "" {
+		return Credentials{}, fmt.Errorf("vault address and role are required")
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/%s/creds/%s", strings.TrimRight(addr, "/"), strings.Trim(mount, "/"), v.Role), nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	var body vaultCredsResponse
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Credentials{}, fmt.Errorf("vault: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("vault: %s: %s", resp.Status, strings.Join(body.Errors, "; "))
+	}
+	v.cached = Credentials{User: body.Data.Username, Password: body.Data.Password}
+	if body.LeaseDuration > 0 {
+		v.cached.Expires = now.Add(time.Duration(body.LeaseDuration) * time.Second)
+	}
+	v.fetched = now
+	return v.cached, nil
+}
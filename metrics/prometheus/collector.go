@@ -0,0 +1,119 @@
+// Package prometheus 把core.Metrics注册到Prometheus registry的Collector，计数器、仪表及直方图按各自的类型输出
+// 单独成包，只有使用Prometheus客户端的程序才依赖client_golang:
+//
+//	m := core.NewMetrics()
+//	r := core.NewReplication("orders", config).WithMetrics(m)
+//	prometheus.MustRegister(pgprom.NewCollector(m))
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cube-group/pg-replication/core"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector 实现prometheus.Collector，每次Collect读取core.Metrics的当前值
+type Collector struct {
+	metrics *core.Metrics
+	descs   map[string]*prom.Desc
+}
+
+// NewCollector 指标名称、帮助及标签来自core.Metrics.Families，Describe发送所有Desc，可注册到检查一致性的registry
+func NewCollector(m *core.Metrics) *Collector {
+	c := &Collector{metrics: m, descs: map[string]*prom.Desc{}}
+	for _, f := range core.NewMetrics().Families() {
+		c.descs[f.Name] = prom.NewDesc(f.Name, f.Help, f.Labels, nil)
+	}
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	for _, f := range c.metrics.Families() {
+		desc, ok := c.descs[f.Name]
+		if !ok {
+			continue
+		}
+		switch f.Type {
+		case "counter":
+			collectValues(ch, desc, prom.CounterValue, f)
+		case "gauge":
+			collectValues(ch, desc, prom.GaugeValue, f)
+		case "histogram":
+			collectHistograms(ch, desc, f)
+		}
+	}
+}
+
+func labelValues(f core.MetricFamily, s core.MetricSample) []string {
+	values := make([]string, len(f.Labels))
+	for i, name := range f.Labels {
+		values[i] = s.Labels[name]
+	}
+	return values
+}
+
+func collectValues(ch chan<- prom.Metric, desc *prom.Desc, typ prom.ValueType, f core.MetricFamily) {
+	for _, s := range f.Samples {
+		m, err := prom.NewConstMetric(desc, typ, s.Value, labelValues(f, s)...)
+		if err != nil {
+			m = prom.NewInvalidMetric(desc, err)
+		}
+		ch <- m
+	}
+}
+
+type histogram struct {
+	labels  []string
+	buckets map[float64]uint64
+	count   uint64
+	sum     float64
+}
+
+// collectHistograms 把每组标签的_bucket/_sum/_count样本合并为一个直方图，+Inf桶即_count
+func collectHistograms(ch chan<- prom.Metric, desc *prom.Desc, f core.MetricFamily) {
+	var order []string
+	groups := map[string]*histogram{}
+	for _, s := range f.Samples {
+		labels := labelValues(f, s)
+		key := strings.Join(labels, "\xff")
+		h, ok := groups[key]
+		if !ok {
+			h = &histogram{labels: labels, buckets: map[float64]uint64{}}
+			groups[key] = h
+			order = append(order, key)
+		}
+		switch strings.TrimPrefix(s.Name, f.Name) {
+		case "_bucket":
+			le, err := strconv.ParseFloat(s.Labels["le"], 64)
+			if err != nil {
+				ch <- prom.NewInvalidMetric(desc, fmt.Errorf("bucket le %q: %w", s.Labels["le"], err))
+				continue
+			}
+			if !math.IsInf(le, 1) {
+				h.buckets[le] = uint64(s.Value)
+			}
+		case "_sum":
+			h.sum = s.Value
+		case "_count":
+			h.count = uint64(s.Value)
+		}
+	}
+	for _, key := range order {
+		h := groups[key]
+		m, err := prom.NewConstHistogram(desc, h.count, h.sum, h.buckets, h.labels...)
+		if err != nil {
+			m = prom.NewInvalidMetric(desc, err)
+		}
+		ch <- m
+	}
+}
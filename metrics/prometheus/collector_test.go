@@ -0,0 +1,92 @@
+package prometheus_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+	pgprom "github.com/cube-group/pg-replication/metrics/prometheus"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollector(t *testing.T) {
+	m := core.NewMetrics()
+	src := mock.NewSource().Relation(core.Relation{ID: 1, Namespace: "public", Name: "users", Columns: []core.Column{
+		mock.Key("id", pgtype.Int4OID),
+		mock.Col("name", pgtype.TextOID),
+	}}).Begin().Insert(1, 1, "tom").Insert(1, 2, "amy").Commit().End()
+	r := core.NewReplication("users_slot", pgx.ConnConfig{Database: "app"}).WithTransport(src).WithMetrics(m)
+	r.Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+		return core.DMLHandlerStatusSuccess
+	})
+
+	// Pedantic检查Describe发送的Desc与Collect输出的指标一致
+	reg := prom.NewPedanticRegistry()
+	reg.MustRegister(pgprom.NewCollector(m))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*dto.MetricFamily{}
+	for _, f := range families {
+		got[f.GetName()] = f
+	}
+	for name, typ := range map[string]dto.MetricType{
+		"pg_replication_events_total":           dto.MetricType_COUNTER,
+		"pg_replication_received_bytes_total":   dto.MetricType_COUNTER,
+		"pg_replication_handler_seconds":        dto.MetricType_HISTOGRAM,
+		"pg_replication_flushed_lsn":            dto.MetricType_GAUGE,
+		"pg_replication_lag_bytes":              dto.MetricType_GAUGE,
+		"pg_replication_reconnects_total":       dto.MetricType_COUNTER,
+		"pg_replication_skipped_messages_total": dto.MetricType_COUNTER,
+	} {
+		f, ok := got[name]
+		if !ok {
+			t.Errorf("%s not collected", name)
+			continue
+		}
+		if f.GetType() != typ {
+			t.Errorf("%s type %s, want %s", name, f.GetType(), typ)
+		}
+	}
+
+	var inserts float64
+	for _, metric := range got["pg_replication_events_total"].GetMetric() {
+		labels := map[string]string{}
+		for _, l := range metric.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["slot"] != "users_slot" || labels["database"] != "app" {
+			t.Errorf("labels %v", labels)
+		}
+		if labels["type"] == core.EventType_INSERT.String() && labels["table"] == "users" {
+			inserts = metric.GetCounter().GetValue()
+		}
+	}
+	if inserts != 2 {
+		t.Errorf("%v inserts, want 2", inserts)
+	}
+
+	h := got["pg_replication_handler_seconds"].GetMetric()
+	if len(h) != 1 {
+		t.Fatalf("%d handler histograms, want 1", len(h))
+	}
+	if count := h[0].GetHistogram().GetSampleCount(); count == 0 {
+		t.Errorf("handler histogram is empty")
+	}
+	if buckets := h[0].GetHistogram().GetBucket(); len(buckets) == 0 {
+		t.Errorf("handler histogram has no buckets")
+	}
+}
+
+func TestCollectorEmpty(t *testing.T) {
+	reg := prom.NewPedanticRegistry()
+	reg.MustRegister(pgprom.NewCollector(core.NewMetrics()))
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,645 @@
+package pgoutput
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrUnknownMessage the message type is not part of the protocol (e.g. sent by a newer server)
+	ErrUnknownMessage = errors.New("unknown message type")
+	// ErrMalformedMessage the message is empty, truncated or contains invalid fields
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// ParseError a message that could not be parsed, errors.Is matches ErrUnknownMessage or ErrMalformedMessage
+type ParseError struct {
+	// Message type byte, 0 for an empty message.
+	Type byte
+	// Offset of the failed read from the start of the message (including the type byte).
+	Offset int
+	Reason string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Type == 0 && e.Offset == 0 {
+		return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+	}
+	return fmt.Sprintf("%s %q at offset %d: %s", e.Err, e.Type, e.Offset, e.Reason)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// decoder reads are bounds checked, the first failure is kept in err and later reads return zero values
+type decoder struct {
+	order binary.ByteOrder
+	src   []byte
+	off   int
+	err   *ParseError
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = &ParseError{Type: d.src[0], Offset: d.off, Reason: fmt.Sprintf(format, args...), Err: ErrMalformedMessage}
+	}
+}
+
+// next returns the following n bytes, nil if the message is shorter
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.src)-d.off {
+		d.fail("need %d bytes, %d left", n, len(d.src)-d.off)
+		return nil
+	}
+	b := d.src[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) bool() bool {
+	return d.uint8() != 0
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return d.order.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	if d.err != nil {
+		return ""
+	}
+	for i := d.off; i < len(d.src); i++ {
+		if d.src[i] == 0 {
+			s := string(d.src[d.off:i])
+			d.off = i + 1
+			return s
+		}
+	}
+	d.fail("unterminated string")
+	return ""
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return d.order.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return d.order.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) int8() int8   { return int8(d.uint8()) }
+func (d *decoder) int16() int16 { return int16(d.uint16()) }
+func (d *decoder) int32() int32 { return int32(d.uint32()) }
+func (d *decoder) int64() int64 { return int64(d.uint64()) }
+
+func (d *decoder) timestamp() time.Time {
+	micro := int(d.uint64())
+	ts := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return ts.Add(time.Duration(micro) * time.Microsecond)
+}
+
+// rowinfo consumes the next byte if it is char
+func (d *decoder) rowinfo(char byte) bool {
+	if d.err == nil && d.off < len(d.src) && d.src[d.off] == char {
+		d.off++
+		return true
+	}
+	return false
+}
+
+// tag reads a byte that must be char
+func (d *decoder) tag(char byte) bool {
+	off := d.off
+	if c := d.uint8(); d.err == nil && c != char {
+		d.off = off
+		d.fail("expected %q, got %q", char, c)
+	}
+	return d.err == nil
+}
+
+func (d *decoder) tupledata() []Tuple {
+	size := int(d.uint16())
+	// every column takes at least one byte, checked before allocating
+	if size > len(d.src)-d.off {
+		d.fail("%d columns in %d bytes", size, len(d.src)-d.off)
+	}
+	if d.err != nil {
+		return nil
+	}
+	data := make([]Tuple, size)
+	for i := 0; i < size && d.err == nil; i++ {
+		switch kind := d.uint8(); kind {
+		case 'n', 'u':
+			// 'u'为未变化的TOAST值，服务器不发送
+			data[i] = Tuple{Flag: int8(kind)}
+		case 't', 'b':
+			// 'b'为binary模式下的二进制格式
+			vsize := d.uint32()
+			if uint64(vsize) > uint64(len(d.src)-d.off) {
+				d.fail("column %d value of %d bytes, %d left", i, vsize, len(d.src)-d.off)
+				break
+			}
+			data[i] = Tuple{Flag: int8(kind), Value: d.next(int(vsize))}
+		default:
+			if d.err == nil {
+				d.off--
+				d.fail("column %d has unknown kind %q", i, kind)
+			}
+		}
+	}
+	return data
+}
+
+func (d *decoder) relationIDs(size int) []uint32 {
+	// checked before allocating, a corrupt count must not allocate gigabytes
+	if size < 0 || size > (len(d.src)-d.off)/4 {
+		d.fail("%d relations in %d bytes", size, len(d.src)-d.off)
+	}
+	if d.err != nil {
+		return nil
+	}
+	ids := make([]uint32, size)
+	for i := range ids {
+		ids[i] = d.uint32()
+	}
+	return ids
+}
+
+func (d *decoder) columns() []Column {
+	size := int(d.uint16())
+	// flags, name terminator, type and modifier take at least 10 bytes per column
+	if size*10 > len(d.src)-d.off {
+		d.fail("%d columns in %d bytes", size, len(d.src)-d.off)
+	}
+	if d.err != nil {
+		return nil
+	}
+	data := make([]Column, size)
+	for i := 0; i < size && d.err == nil; i++ {
+		data[i] = Column{
+			Key:  d.bool(),
+			Name: d.string(),
+			Type: d.uint32(),
+			Mode: d.uint32(),
+		}
+	}
+	return data
+}
+
+type Begin struct {
+	// The final LSN of the transaction.
+	LSN uint64
+	// Commit timestamp of the transaction. The value is in number of
+	// microseconds since PostgreSQL epoch (2000-01-01).
+	Timestamp time.Time
+	// 	Xid of the transaction.
+	XID int32
+}
+
+type Commit struct {
+	Flags uint8
+	// The final LSN of the transaction.
+	LSN uint64
+	// The final LSN of the transaction.
+	TransactionLSN uint64
+	Timestamp      time.Time
+}
+
+type Relation struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	// ID of the relation.
+	ID uint32
+	// Namespace (empty string for pg_catalog).
+	Namespace string
+	Name      string
+	Replica   uint8
+	Columns   []Column
+}
+
+type Type struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	// ID of the data type
+	ID        uint32
+	Namespace string
+	Name      string
+}
+
+type Insert struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	/// ID of the relation corresponding to the ID in the relation message.
+	RelationID uint32
+	// Identifies the following TupleData message as a new tuple.
+	New bool
+	Row []Tuple
+}
+
+type Update struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	/// ID of the relation corresponding to the ID in the relation message.
+	RelationID uint32
+	// Identifies the following TupleData message as a new tuple.
+	Old    bool
+	Key    bool
+	New    bool
+	OldRow []Tuple
+	Row    []Tuple
+}
+
+type Delete struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	/// ID of the relation corresponding to the ID in the relation message.
+	RelationID uint32
+	// Row is the replica identity key (other columns null) or the full old tuple (REPLICA IDENTITY FULL).
+	Key bool
+	Old bool
+	Row []Tuple
+}
+
+// Truncate is sent once for all relations truncated by one TRUNCATE statement.
+type Truncate struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	// IDs of the relations corresponding to the ID in the relation message.
+	RelationIDs []uint32
+	// TRUNCATE ... CASCADE
+	Cascade bool
+	// TRUNCATE ... RESTART IDENTITY
+	RestartIdentity bool
+}
+
+// truncate option bits
+const (
+	truncateCascade         = 1
+	truncateRestartIdentity = 2
+)
+
+type Origin struct {
+	LSN  uint64
+	Name string
+}
+
+// StreamStart 流式传输的事务块开始，协议版本2+
+type StreamStart struct {
+	// Xid of the transaction.
+	XID uint32
+	// 该事务的第一个块
+	FirstSegment bool
+}
+
+// StreamStop 流式传输的事务块结束
+type StreamStop struct{}
+
+// StreamCommit 流式传输的事务提交
+type StreamCommit struct {
+	// Xid of the transaction.
+	XID   uint32
+	Flags uint8
+	// The LSN of the commit.
+	LSN uint64
+	// The end LSN of the transaction.
+	TransactionLSN uint64
+	Timestamp      time.Time
+}
+
+// StreamAbort 流式传输的事务或其子事务回滚
+type StreamAbort struct {
+	// Xid of the transaction.
+	XID uint32
+	// Xid of the subtransaction (will be same as xid of the transaction for top-level transactions).
+	SubXID uint32
+}
+
+// BeginPrepare 两阶段提交的事务开始，协议版本3+
+type BeginPrepare struct {
+	// The LSN of the prepare.
+	LSN uint64
+	// The end LSN of the prepared transaction.
+	EndLSN uint64
+	// Prepare timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// Prepare PREPARE TRANSACTION，StreamPrepare格式相同
+type Prepare struct {
+	Flags uint8
+	// The LSN of the prepare.
+	LSN uint64
+	// The end LSN of the prepared transaction.
+	EndLSN uint64
+	// Prepare timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// StreamPrepare 流式传输的事务PREPARE TRANSACTION
+type StreamPrepare Prepare
+
+// CommitPrepared COMMIT PREPARED
+type CommitPrepared struct {
+	Flags uint8
+	// The LSN of the commit prepared.
+	LSN uint64
+	// The end LSN of the commit prepared transaction.
+	EndLSN uint64
+	// Commit timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// RollbackPrepared ROLLBACK PREPARED
+type RollbackPrepared struct {
+	Flags uint8
+	// The end LSN of the prepared transaction.
+	EndLSN uint64
+	// The end LSN of the rollback prepared transaction.
+	RollbackEndLSN uint64
+	// Prepare timestamp of the transaction.
+	PrepareTimestamp time.Time
+	// Rollback timestamp of the transaction.
+	Timestamp time.Time
+	// Xid of the transaction.
+	XID uint32
+	// The user defined GID of the two-phase transaction.
+	GID string
+}
+
+// LogicalMessage pg_logical_emit_message写入的消息，需开启pgoutput messages选项(14+)
+type LogicalMessage struct {
+	// Xid of the transaction (only present for streamed transactions).
+	XID uint32
+	// 事务性消息随事务在提交时发送，非事务性消息在写入wal的位置立即发送
+	Transactional bool
+	// The LSN of the logical decoding message.
+	LSN     uint64
+	Prefix  string
+	Content []byte
+}
+
+type Column struct {
+	Key  bool
+	Name string
+	Type uint32
+	Mode uint32
+}
+
+type Tuple struct {
+	Flag  int8
+	Value []byte
+}
+
+type Message interface {
+	msg()
+}
+
+func (Begin) msg()    {}
+func (Relation) msg() {}
+func (Update) msg()   {}
+func (Insert) msg()   {}
+func (Delete) msg()   {}
+func (Commit) msg()   {}
+func (Origin) msg()   {}
+func (Truncate) msg() {}
+func (Type) msg()     {}
+
+func (LogicalMessage) msg() {}
+
+func (StreamStart) msg()  {}
+func (StreamStop) msg()   {}
+func (StreamCommit) msg() {}
+func (StreamAbort) msg()  {}
+
+func (BeginPrepare) msg()     {}
+func (Prepare) msg()          {}
+func (StreamPrepare) msg()    {}
+func (CommitPrepared) msg()   {}
+func (RollbackPrepared) msg() {}
+
+// Parse a logical replication message.
+// See https://www.postgresql.org/docs/current/static/protocol-logicalrep-message-formats.html
+func Parse(src []byte) (msg Message, err error) {
+	return parse(src, false)
+}
+
+// Parser 按顺序解析复制流中的消息
+// 协议版本2+流式传输的事务块(StreamStart到StreamStop之间)中的消息带有xid，需要记录当前是否在事务块中
+type Parser struct {
+	stream bool
+}
+
+// Parse a logical replication message.
+func (p *Parser) Parse(src []byte) (Message, error) {
+	msg, err := parse(src, p.stream)
+	switch msg.(type) {
+	case StreamStart:
+		p.stream = true
+	case StreamStop:
+		p.stream = false
+	}
+	return msg, err
+}
+
+// Reset 复制流重新开始时调用
+func (p *Parser) Reset() {
+	p.stream = false
+}
+
+func parse(src []byte, stream bool) (Message, error) {
+	if len(src) == 0 {
+		return nil, &ParseError{Reason: "empty message", Err: ErrMalformedMessage}
+	}
+	d := &decoder{order: binary.BigEndian, src: src, off: 1}
+	msg := decode(d, stream)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if msg == nil {
+		return nil, &ParseError{Type: src[0], Reason: fmt.Sprintf("type byte %d not supported", src[0]), Err: ErrUnknownMessage}
+	}
+	return msg, nil
+}
+
+// decode returns nil for an unknown message type, read errors are left in d.err
+func decode(d *decoder, stream bool) Message {
+	msgType := d.src[0]
+	var xid uint32
+	switch msgType {
+	case 'R', 'Y', 'I', 'U', 'D', 'T', 'M':
+		if stream {
+			xid = d.uint32()
+		}
+	}
+	switch msgType {
+	case 'B':
+		b := Begin{}
+		b.LSN = d.uint64()
+		b.Timestamp = d.timestamp()
+		b.XID = d.int32()
+		return b
+	case 'C':
+		c := Commit{}
+		c.Flags = d.uint8()
+		c.LSN = d.uint64()
+		c.TransactionLSN = d.uint64()
+		c.Timestamp = d.timestamp()
+		return c
+	case 'O':
+		o := Origin{}
+		o.LSN = d.uint64()
+		o.Name = d.string()
+		return o
+	case 'R':
+		r := Relation{XID: xid}
+		r.ID = d.uint32()
+		r.Namespace = d.string()
+		r.Name = d.string()
+		r.Replica = d.uint8()
+		r.Columns = d.columns()
+		return r
+	case 'Y':
+		t := Type{XID: xid}
+		t.ID = d.uint32()
+		t.Namespace = d.string()
+		t.Name = d.string()
+		return t
+	case 'I':
+		i := Insert{XID: xid}
+		i.RelationID = d.uint32()
+		i.New = d.tag('N')
+		i.Row = d.tupledata()
+		return i
+	case 'U':
+		u := Update{XID: xid}
+		u.RelationID = d.uint32()
+		u.Key = d.rowinfo('K')
+		u.Old = d.rowinfo('O')
+		if u.Key || u.Old {
+			u.OldRow = d.tupledata()
+		}
+		u.New = d.tag('N')
+		u.Row = d.tupledata()
+		return u
+	case 'D':
+		dl := Delete{XID: xid}
+		dl.RelationID = d.uint32()
+		// replica identity key or full old tuple, one of them is always sent
+		if dl.Key = d.rowinfo('K'); !dl.Key {
+			dl.Old = d.tag('O')
+		}
+		dl.Row = d.tupledata()
+		return dl
+	case 'T':
+		tr := Truncate{XID: xid}
+		size := int(d.uint32())
+		options := d.uint8()
+		tr.Cascade = options&truncateCascade != 0
+		tr.RestartIdentity = options&truncateRestartIdentity != 0
+		tr.RelationIDs = d.relationIDs(size)
+		return tr
+	case 'M':
+		lm := LogicalMessage{XID: xid}
+		lm.Transactional = d.uint8() == 1
+		lm.LSN = d.uint64()
+		lm.Prefix = d.string()
+		lm.Content = d.next(int(d.uint32()))
+		return lm
+	case 'S':
+		ss := StreamStart{}
+		ss.XID = d.uint32()
+		ss.FirstSegment = d.uint8() == 1
+		return ss
+	case 'E':
+		return StreamStop{}
+	case 'c':
+		sc := StreamCommit{}
+		sc.XID = d.uint32()
+		sc.Flags = d.uint8()
+		sc.LSN = d.uint64()
+		sc.TransactionLSN = d.uint64()
+		sc.Timestamp = d.timestamp()
+		return sc
+	case 'A':
+		sa := StreamAbort{}
+		sa.XID = d.uint32()
+		sa.SubXID = d.uint32()
+		return sa
+	case 'b':
+		bp := BeginPrepare{}
+		bp.LSN = d.uint64()
+		bp.EndLSN = d.uint64()
+		bp.Timestamp = d.timestamp()
+		bp.XID = d.uint32()
+		bp.GID = d.string()
+		return bp
+	case 'P', 'p':
+		p := Prepare{}
+		p.Flags = d.uint8()
+		p.LSN = d.uint64()
+		p.EndLSN = d.uint64()
+		p.Timestamp = d.timestamp()
+		p.XID = d.uint32()
+		p.GID = d.string()
+		if msgType == 'p' {
+			return StreamPrepare(p)
+		}
+		return p
+	case 'K':
+		cp := CommitPrepared{}
+		cp.Flags = d.uint8()
+		cp.LSN = d.uint64()
+		cp.EndLSN = d.uint64()
+		cp.Timestamp = d.timestamp()
+		cp.XID = d.uint32()
+		cp.GID = d.string()
+		return cp
+	case 'r':
+		rp := RollbackPrepared{}
+		rp.Flags = d.uint8()
+		rp.EndLSN = d.uint64()
+		rp.RollbackEndLSN = d.uint64()
+		rp.PrepareTimestamp = d.timestamp()
+		rp.Timestamp = d.timestamp()
+		rp.XID = d.uint32()
+		rp.GID = d.string()
+		return rp
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package pgoutput
+
+import (
+	"errors"
+	"testing"
+)
+
+// seeds are well-formed messages of every type, as sent by the server
+var seeds = [][]byte{
+	{'B', 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 2, 0xe4},
+	{'C', 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+	{'O', 0, 0, 0, 0, 0, 0, 0, 1, 'o', 0},
+	{'R', 0, 0, 0x40, 0, 'p', 'u', 'b', 'l', 'i', 'c', 0, 'u', 0, 'd', 0, 2,
+		1, 'i', 'd', 0, 0, 0, 0, 23, 0xff, 0xff, 0xff, 0xff,
+		0, 'n', 0, 0, 0, 0, 25, 0xff, 0xff, 0xff, 0xff},
+	{'Y', 0, 0, 0x40, 1, 'p', 'u', 'b', 'l', 'i', 'c', 0, 'm', 'o', 'o', 'd', 0},
+	{'I', 0, 0, 0x40, 0, 'N', 0, 2, 't', 0, 0, 0, 1, '1', 'n'},
+	{'U', 0, 0, 0x40, 0, 'K', 0, 2, 't', 0, 0, 0, 1, '1', 'n', 'N', 0, 2, 't', 0, 0, 0, 1, '2', 'u'},
+	{'U', 0, 0, 0x40, 0, 'O', 0, 1, 'b', 0, 0, 0, 0, 'N', 0, 1, 'b', 0, 0, 0, 1, 0xff},
+	{'D', 0, 0, 0x40, 0, 'K', 0, 2, 't', 0, 0, 0, 1, '1', 'n'},
+	// relation count, options, relation ids
+	{'T', 0, 0, 0, 1, 0, 0, 0, 0x40, 0},
+	{'T', 0, 0, 0, 2, 3, 0, 0, 0x40, 0, 0, 0, 0x40, 1},
+	{'M', 1, 0, 0, 0, 0, 0, 0, 0, 1, 'p', 0, 0, 0, 0, 2, 'h', 'i'},
+	{'S', 0, 0, 0, 9, 1},
+	{'E'},
+	{'c', 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3},
+	{'A', 0, 0, 0, 9, 0, 0, 0, 10},
+	{'b', 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 9, 'g', 0},
+	{'P', 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 9, 'g', 0},
+	{'K', 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 9, 'g', 0},
+	{'r', 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 'g', 0},
+}
+
+func TestParseSeeds(t *testing.T) {
+	for _, src := range seeds {
+		if _, err := Parse(src); err != nil {
+			t.Errorf("%q: %v", src, err)
+		}
+	}
+}
+
+func TestParseTruncate(t *testing.T) {
+	msg, err := Parse([]byte{'T', 0, 0, 0, 2, truncateCascade | truncateRestartIdentity, 0, 0, 0x40, 0, 0, 0, 0x40, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, ok := msg.(Truncate)
+	if !ok {
+		t.Fatalf("parsed %T", msg)
+	}
+	if len(tr.RelationIDs) != 2 || tr.RelationIDs[0] != 0x4000 || tr.RelationIDs[1] != 0x4001 || !tr.Cascade || !tr.RestartIdentity {
+		t.Fatalf("parsed %+v", tr)
+	}
+
+	// the count must fit in the rest of the message
+	_, err = Parse([]byte{'T', 0, 0, 0, 3, 0, 0, 0, 0x40, 0, 0, 0, 0x40, 1})
+	if !errors.Is(err, ErrMalformedMessage) {
+		t.Fatalf("short truncate: %v", err)
+	}
+}
+
+// FuzzParse checks that arbitrary input never panics and that every failure
+// is a *ParseError classified as unknown or malformed, inside the message.
+func FuzzParse(f *testing.F) {
+	for _, src := range seeds {
+		f.Add(src, false)
+		f.Add(src, true)
+	}
+	f.Add([]byte{}, false)
+	f.Add([]byte{'I', 0, 0, 0x40, 0, 'N', 0xff, 0xff}, false)
+	f.Add([]byte{'R', 0, 0, 0x40, 0, 0, 0, 'd', 0xff, 0xff}, false)
+	f.Add([]byte{'M', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}, true)
+	f.Fuzz(func(t *testing.T, src []byte, stream bool) {
+		var p Parser
+		if stream {
+			if _, err := p.Parse([]byte{'S', 0, 0, 0, 1, 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msg, err := p.Parse(src)
+		if err == nil {
+			if msg == nil {
+				t.Fatalf("%q: nil message without error", src)
+			}
+			return
+		}
+		if msg != nil {
+			t.Fatalf("%q: message %#v with error %v", src, msg, err)
+		}
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("%q: error %T is not a *ParseError", src, err)
+		}
+		if !errors.Is(err, ErrUnknownMessage) && !errors.Is(err, ErrMalformedMessage) {
+			t.Fatalf("%q: error %v is neither unknown nor malformed", src, err)
+		}
+		if pe.Offset < 0 || pe.Offset > len(src) {
+			t.Fatalf("%q: offset %d outside the message", src, pe.Offset)
+		}
+		if len(src) > 0 && pe.Type != src[0] {
+			t.Fatalf("%q: error type %q", src, pe.Type)
+		}
+		_ = err.Error()
+	})
+}
@@ -0,0 +1,253 @@
+// Package amqp 把变更发布到RabbitMQ(AMQP 0.9.1)的Sink，exchange及routing key按表的模板生成，
+// 一个事务的所有消息被broker确认(publisher confirms)后才确认lsn，发布失败时重新连接并重试
+//
+// 不依赖具体的AMQP客户端，通过Channel适配，如amqp091-go(*amqp.DeferredConfirmation实现了Confirmation)，
+// 本包以amqpsink导入:
+//
+//	type channel struct {
+//		conn *amqp.Connection
+//		ch   *amqp.Channel
+//	}
+//
+//	func (c channel) Publish(ctx context.Context, exchange, key string, msg amqpsink.Message) (amqpsink.Confirmation, error) {
+//		return c.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, amqp.Publishing{
+//			ContentType: msg.ContentType, DeliveryMode: amqp.Persistent, MessageId: msg.MessageID, Headers: msg.Headers, Body: msg.Body,
+//		})
+//	}
+//
+//	func (c channel) Close() error { return c.conn.Close() }
+//
+//	sink := amqpsink.NewSink(func() (amqpsink.Channel, error) {
+//		conn, err := amqp.Dial(url)
+//		if err != nil {
+//			return nil, err
+//		}
+//		ch, err := conn.Channel()
+//		if err == nil {
+//			err = ch.Confirm(false)
+//		}
+//		if err != nil {
+//			conn.Close()
+//			return nil, err
+//		}
+//		return channel{conn, ch}, nil
+//	}, amqpsink.Config{Exchange: "cdc"})
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+	"github.com/jackc/pgx"
+)
+
+// Message 发布的一条消息
+type Message struct {
+	ContentType string
+	// 变更的lsn，消费者可据此去重
+	MessageID string
+	Headers   map[string]interface{}
+	Body      []byte
+}
+
+// Confirmation 等待broker确认，ack为false表示broker拒绝(nack)
+type Confirmation interface {
+	WaitContext(ctx context.Context) (ack bool, err error)
+}
+
+// Channel 已开启confirm模式的channel，Close时同时关闭所属的连接
+type Channel interface {
+	Publish(ctx context.Context, exchange, key string, msg Message) (Confirmation, error)
+	Close() error
+}
+
+// Dialer 建立连接并创建channel，连接断开或发布失败后重新调用
+type Dialer func() (Channel, error)
+
+// Route 消息发布到的exchange及routing key，支持{schema} {table} {tenant} {event}占位符，{event}为小写的事件类型
+type Route struct {
+	Exchange   string
+	RoutingKey string
+}
+
+func (r Route) resolve(msg core.ReplicationMessage) (exchange, key string) {
+	replacer := strings.NewReplacer("{event}", strings.ToLower(msg.EventType.String()))
+	return replacer.Replace(core.TenantTopic(r.Exchange, msg)), replacer.Replace(core.TenantTopic(r.RoutingKey, msg))
+}
+
+// Config Sink的配置，零值可用
+type Config struct {
+	// 默认的exchange为pg_replication，routing key为{schema}.{table}.{event}，exchange需已存在
+	Route
+	// Tables 按表(格式见core.TableName)覆盖Route，为空的项使用默认值
+	Tables map[string]Route
+	// Body 消息内容，默认为sinks.Event的JSON
+	Body func(msg core.ReplicationMessage) ([]byte, error)
+	// Retries 发布失败时重新连接并重试整个事务的次数，默认为3，小于0时不重试；重试可能导致重复的消息
+	Retries int
+	// Backoff 第一次重试的等待时间，之后每次加倍，默认为1秒
+	Backoff time.Duration
+}
+
+// Sink 实现core.Sink，只发布表的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)
+type Sink struct {
+	dial   Dialer
+	config Config
+
+	mu      sync.Mutex
+	channel Channel
+}
+
+func NewSink(dial Dialer, config Config) *Sink {
+	if config.Exchange == "" {
+		config.Exchange = "pg_replication"
+	}
+	if config.RoutingKey == "" {
+		config.RoutingKey = "{schema}.{table}.{event}"
+	}
+	if config.Retries == 0 {
+		config.Retries = 3
+	} else if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = time.Second
+	}
+	return &Sink{dial: dial, config: config}
+}
+
+type publishing struct {
+	exchange, key string
+	msg           Message
+}
+
+func (s *Sink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	commit := sinks.Commit(msg)
+	var pending []publishing
+	for _, m := range msg {
+		if !sinks.IsChange(m) {
+			continue
+		}
+		p, err := s.publishing(m, commit.Lsn)
+		if err != nil {
+			return fmt.Errorf("amqp %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+		pending = append(pending, p)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	backoff := s.config.Backoff
+	for attempt := 0; ; attempt++ {
+		err := s.publish(ctx, pending)
+		if err == nil {
+			return nil
+		}
+		// 连接可能已失效，下次重新连接
+		s.reset()
+		if attempt >= s.config.Retries || ctx.Err() != nil {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// publish 发布所有消息后等待确认，需持有s.mu
+func (s *Sink) publish(ctx context.Context, pending []publishing) error {
+	if s.channel == nil {
+		channel, err := s.dial()
+		if err != nil {
+			return fmt.Errorf("amqp dial: %w", err)
+		}
+		s.channel = channel
+	}
+	confirms := make([]Confirmation, 0, len(pending))
+	for _, p := range pending {
+		confirm, err := s.channel.Publish(ctx, p.exchange, p.key, p.msg)
+		if err != nil {
+			return fmt.Errorf("amqp publish %s %s: %w", p.exchange, p.key, err)
+		}
+		if confirm == nil {
+			return errors.New("amqp publish: channel is not in confirm mode")
+		}
+		confirms = append(confirms, confirm)
+	}
+	for i, confirm := range confirms {
+		ack, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return fmt.Errorf("amqp confirm: %w", err)
+		}
+		if !ack {
+			return fmt.Errorf("amqp publish %s %s: nack", pending[i].exchange, pending[i].key)
+		}
+	}
+	return nil
+}
+
+// reset 关闭当前channel，需持有s.mu
+func (s *Sink) reset() {
+	if s.channel != nil {
+		s.channel.Close()
+		s.channel = nil
+	}
+}
+
+// Close 关闭当前的channel及连接
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.channel == nil {
+		return nil
+	}
+	err := s.channel.Close()
+	s.channel = nil
+	return err
+}
+
+func (s *Sink) route(m core.ReplicationMessage) Route {
+	route := s.config.Route
+	if r, ok := s.config.Tables[core.TableName(m.SchemaName, m.TableName)]; ok {
+		if r.Exchange != "" {
+			route.Exchange = r.Exchange
+		}
+		if r.RoutingKey != "" {
+			route.RoutingKey = r.RoutingKey
+		}
+	}
+	return route
+}
+
+func (s *Sink) publishing(m core.ReplicationMessage, commitLsn uint64) (p publishing, err error) {
+	p.exchange, p.key = s.route(m).resolve(m)
+	p.msg = Message{
+		ContentType: "application/json",
+		MessageID:   pgx.FormatLSN(m.Lsn),
+		Headers:     map[string]interface{}{"pg_event": m.EventType.String()},
+	}
+	if commitLsn > 0 {
+		p.msg.Headers["pg_commit_lsn"] = pgx.FormatLSN(commitLsn)
+	}
+	if s.config.Body != nil {
+		p.msg.ContentType = ""
+		p.msg.Body, err = s.config.Body(m)
+	} else {
+		p.msg.Body, err = json.Marshal(sinks.NewEvent(m, commitLsn))
+	}
+	return
+}
@@ -0,0 +1,499 @@
+// Package clickhouse 把变更攒批写入ClickHouse的Sink，每张表按列组成Block，行数达到MaxRows或距第一行超过FlushInterval时写入
+//
+// 每行附加两列：_version(UInt64，变更的lsn)及_deleted(UInt8，delete为1)，配合ReplacingMergeTree(_version, _deleted)
+// (ClickHouse 23.2+)按主键去重，重启后重复写入的变更被合并；delete及主键变化的update为旧主键写入墓碑，
+// 其余列为NULL或类型的零值；没有主键的表保留所有变更
+//
+// 每次写入都是整行，update中未变化的TOAST列(服务器不发送其值)若写为NULL或零值会覆盖表中已有的值，
+// 因此表需设置REPLICA IDENTITY FULL(使用旧值)，或Schemas为*core.Replication且UnchangedToastPolicy为core.ToastFetch，
+// 否则写入该表时返回错误；仍收到core.UnchangedToast时同样返回错误，不写入
+// 列类型由表结构(sinks.Schemas)映射，见Type，CreateTable生成建表语句，Config.AutoCreate时写入前自动建表
+//
+// 缓存的事务在写入前返回core.ErrBuffered，不确认lsn，写入成功后由之后的事务一并确认；
+// 变更全被过滤或来自跳过的origin的事务只有COMMIT，有缓存时同样返回core.ErrBuffered，不会越过缓存确认lsn
+//
+// 不依赖具体的ClickHouse客户端，通过Conn适配，如clickhouse-go v2(本包以chsink导入):
+//
+//	type conn struct{ c driver.Conn }
+//
+//	func (c conn) Insert(ctx context.Context, block chsink.Block) error {
+//		batch, err := c.c.PrepareBatch(ctx, "INSERT INTO "+block.Table+" ("+strings.Join(block.Names(), ", ")+")")
+//		if err != nil {
+//			return err
+//		}
+//		for i := 0; i < block.Rows(); i++ {
+//			if err := batch.Append(block.Row(i)...); err != nil {
+//				return err
+//			}
+//		}
+//		return batch.Send()
+//	}
+//
+//	func (c conn) Exec(ctx context.Context, query string) error { return c.c.Exec(ctx, query) }
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+)
+
+// 附加的列
+const (
+	VersionColumn = "_version"
+	DeletedColumn = "_deleted"
+)
+
+// Column Block中的一列，Values按行排列
+type Column struct {
+	Name string
+	// ClickHouse类型，如Nullable(Int32)
+	Type   string
+	Values []interface{}
+}
+
+// Block 写入一张表的一批行
+type Block struct {
+	Table   string
+	Columns []Column
+}
+
+// Names 列名
+func (b Block) Names() []string {
+	names := make([]string, len(b.Columns))
+	for i, col := range b.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// Rows 行数
+func (b Block) Rows() int {
+	if len(b.Columns) == 0 {
+		return 0
+	}
+	return len(b.Columns[0].Values)
+}
+
+// Row 第i行各列的值
+func (b Block) Row(i int) []interface{} {
+	row := make([]interface{}, len(b.Columns))
+	for j, col := range b.Columns {
+		row[j] = col.Values[i]
+	}
+	return row
+}
+
+// Conn 写入Block及执行语句(建表、TRUNCATE)
+type Conn interface {
+	Insert(ctx context.Context, block Block) error
+	Exec(ctx context.Context, query string) error
+}
+
+// Config Sink的配置，Schemas必须设置
+type Config struct {
+	// 用于获取列类型及主键列，通常为*core.Replication
+	Schemas sinks.Schemas
+	// Table 表名模板，支持{schema} {table} {tenant}占位符，默认为{schema}_{table}
+	Table string
+	// MaxRows 缓存的行数达到时写入，默认为10000
+	MaxRows int
+	// FlushInterval 第一行缓存后超过该时间写入，默认为1秒
+	FlushInterval time.Duration
+	// AutoCreate 第一次写入表前执行CreateTable生成的语句
+	AutoCreate bool
+	// Truncate 执行源表的TRUNCATE，默认忽略
+	Truncate bool
+}
+
+type block struct {
+	Block
+	relationID uint32
+	// 列名及类型，表结构变化时不同
+	signature string
+}
+
+// Sink 实现core.Sink，写入表的INSERT/UPDATE/DELETE/SNAPSHOT
+// 写入失败时保留缓存，由之后的Write或定时写入重试；同一张表的Block按顺序写入
+type Sink struct {
+	conn   Conn
+	config Config
+
+	mu      sync.Mutex
+	blocks  []*block
+	rows    int
+	first   time.Time
+	created map[string]bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSink 创建Sink并启动定时写入，Close时停止并写入剩余的缓存
+func NewSink(conn Conn, config Config) *Sink {
+	if config.Table == "" {
+		config.Table = "{schema}_{table}"
+	}
+	if config.MaxRows <= 0 {
+		config.MaxRows = 10000
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sink{conn: conn, config: config, created: map[string]bool{}, cancel: cancel, done: make(chan struct{})}
+	go s.loop(ctx)
+	return s
+}
+
+func (s *Sink) loop(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.FlushInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if len(s.blocks) > 0 && time.Since(s.first) >= s.config.FlushInterval {
+				// 失败时保留缓存，下次重试
+				s.flush(ctx)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Sink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msg {
+		switch m.EventType {
+		case core.EventType_INSERT, core.EventType_UPDATE, core.EventType_DELETE, core.EventType_SNAPSHOT:
+			if err := s.append(ctx, m); err != nil {
+				return fmt.Errorf("clickhouse %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+			}
+		case core.EventType_TRUNCATE:
+			if !s.config.Truncate {
+				continue
+			}
+			if err := s.flush(ctx); err != nil {
+				return err
+			}
+			table := core.TenantTopic(s.config.Table, m)
+			if err := s.conn.Exec(ctx, "TRUNCATE TABLE IF EXISTS "+table); err != nil {
+				return fmt.Errorf("clickhouse truncate %s: %w", table, err)
+			}
+		}
+	}
+	if len(s.blocks) == 0 {
+		return nil
+	}
+	if s.rows >= s.config.MaxRows || time.Since(s.first) >= s.config.FlushInterval {
+		return s.flush(ctx)
+	}
+	return core.ErrBuffered
+}
+
+// Flush 写入所有缓存
+func (s *Sink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(ctx)
+}
+
+// Close 停止定时写入并写入剩余的缓存
+func (s *Sink) Close() error {
+	s.cancel()
+	<-s.done
+	return s.Flush(context.Background())
+}
+
+// append 把一行加入表的Block，表结构变化时开始新的Block，需持有s.mu
+func (s *Sink) append(ctx context.Context, m core.ReplicationMessage) error {
+	var schema core.RelationSchema
+	ok := false
+	if s.config.Schemas != nil {
+		schema, ok = s.config.Schemas.Schema(m.RelationID)
+	}
+	if !ok {
+		return fmt.Errorf("schema of relation %d not found", m.RelationID)
+	}
+	if err := s.checkToast(schema); err != nil {
+		return err
+	}
+	for _, body := range []map[string]interface{}{m.Body, m.Key, m.OldBody} {
+		for name, v := range body {
+			if v == core.UnchangedToast {
+				return fmt.Errorf("column %s is an unchanged toast value, writing it would overwrite the stored value", name)
+			}
+		}
+	}
+	columns := sinks.KeyColumns(s.config.Schemas, m)
+	keys := map[string]bool{}
+	for _, name := range columns {
+		keys[name] = true
+	}
+	types := make([]string, len(schema.Columns))
+	var signature strings.Builder
+	for i, col := range schema.Columns {
+		types[i] = columnType(col, keys[col.Name])
+		signature.WriteString(col.Name + " " + types[i] + ",")
+	}
+	b := s.current(m.RelationID, signature.String())
+	if b == nil {
+		b = &block{relationID: m.RelationID, signature: signature.String()}
+		b.Table = core.TenantTopic(s.config.Table, m)
+		for i, col := range schema.Columns {
+			b.Columns = append(b.Columns, Column{Name: col.Name, Type: types[i]})
+		}
+		b.Columns = append(b.Columns, Column{Name: VersionColumn, Type: "UInt64"}, Column{Name: DeletedColumn, Type: "UInt8"})
+		if s.config.AutoCreate && !s.created[b.Table] {
+			if err := s.conn.Exec(ctx, CreateTable(b.Table, schema, columns)); err != nil {
+				return fmt.Errorf("create table %s: %w", b.Table, err)
+			}
+			s.created[b.Table] = true
+		}
+		s.blocks = append(s.blocks, b)
+	}
+	if m.EventType == core.EventType_UPDATE {
+		// 主键变化时为旧主键写入墓碑，旧值在Key(默认复制标识)或OldBody(REPLICA IDENTITY FULL)中
+		id, _ := sinks.KeyValues(columns, m.Body)
+		for _, old := range []map[string]interface{}{m.Key, m.OldBody} {
+			if oldID, ok := sinks.KeyValues(columns, old); ok {
+				if oldID != id {
+					s.appendRow(b, schema, types, old, m.Lsn, 1)
+				}
+				break
+			}
+		}
+	}
+	var deleted uint8
+	if m.EventType == core.EventType_DELETE {
+		deleted = 1
+	}
+	s.appendRow(b, schema, types, m.Body, m.Lsn, deleted)
+	return nil
+}
+
+// checkToast 表为REPLICA IDENTITY FULL或未变化的TOAST列由ToastFetch读取时才能写入整行
+func (s *Sink) checkToast(schema core.RelationSchema) error {
+	if schema.ReplicaIdentity == 'f' {
+		return nil
+	}
+	if r, ok := s.config.Schemas.(interface{ Toast() core.ToastPolicy }); ok && r.Toast() == core.ToastFetch {
+		return nil
+	}
+	return fmt.Errorf("unchanged toast values would be lost: table requires REPLICA IDENTITY FULL or core.ToastFetch")
+}
+
+// appendRow 需持有s.mu
+func (s *Sink) appendRow(b *block, schema core.RelationSchema, types []string, body map[string]interface{}, lsn uint64, deleted uint8) {
+	for i, col := range schema.Columns {
+		b.Columns[i].Values = append(b.Columns[i].Values, value(types[i], body[col.Name]))
+	}
+	n := len(schema.Columns)
+	b.Columns[n].Values = append(b.Columns[n].Values, lsn)
+	b.Columns[n+1].Values = append(b.Columns[n+1].Values, deleted)
+	if s.rows == 0 {
+		s.first = time.Now()
+	}
+	s.rows++
+}
+
+// current 表最后一个Block，列名或类型不同时为nil，需持有s.mu
+func (s *Sink) current(relationID uint32, signature string) *block {
+	for i := len(s.blocks) - 1; i >= 0; i-- {
+		if b := s.blocks[i]; b.relationID == relationID {
+			if b.signature != signature {
+				return nil
+			}
+			return b
+		}
+	}
+	return nil
+}
+
+// flush 按顺序写入缓存的Block，失败时保留未写入的Block，需持有s.mu
+func (s *Sink) flush(ctx context.Context) error {
+	for len(s.blocks) > 0 {
+		b := s.blocks[0]
+		if err := s.conn.Insert(ctx, b.Block); err != nil {
+			return fmt.Errorf("clickhouse insert %s: %w", b.Table, err)
+		}
+		s.rows -= b.Rows()
+		s.blocks = s.blocks[1:]
+	}
+	s.blocks = nil
+	s.rows = 0
+	return nil
+}
+
+// Type PostgreSQL列对应的ClickHouse类型(不含Nullable)，未知类型为String
+// numeric没有精度时为String，避免丢失精度；json/jsonb、时间间隔、网络地址等为String
+func Type(col core.ColumnSchema) string {
+	name := col.TypeName
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		// 扩展类型，如public.citext
+		name = name[i+1:]
+	}
+	if strings.HasPrefix(name, "_") {
+		elem := Type(core.ColumnSchema{TypeName: name[1:], Modifier: col.Modifier})
+		return "Array(Nullable(" + elem + "))"
+	}
+	switch name {
+	case "bool":
+		return "Bool"
+	case "int2":
+		return "Int16"
+	case "int4":
+		return "Int32"
+	case "int8":
+		return "Int64"
+	case "oid", "xid":
+		return "UInt32"
+	case "float4":
+		return "Float32"
+	case "float8":
+		return "Float64"
+	case "numeric":
+		if col.Modifier < 4 {
+			return "String"
+		}
+		precision, scale := (col.Modifier-4)>>16, (col.Modifier-4)&0xffff
+		if precision > 76 || scale > precision {
+			return "String"
+		}
+		return fmt.Sprintf("Decimal(%d, %d)", precision, scale)
+	case "uuid":
+		return "UUID"
+	case "date":
+		return "Date32"
+	case "timestamp":
+		return fmt.Sprintf("DateTime64(%d)", timePrecision(col))
+	case "timestamptz":
+		return fmt.Sprintf("DateTime64(%d, 'UTC')", timePrecision(col))
+	}
+	return "String"
+}
+
+func timePrecision(col core.ColumnSchema) int32 {
+	if col.Modifier < 0 || col.Modifier > 6 {
+		return 6
+	}
+	return col.Modifier
+}
+
+// columnType 非主键且没有NOT NULL约束的列为Nullable，数组不能为Nullable
+func columnType(col core.ColumnSchema, key bool) string {
+	t := Type(col)
+	if key || col.NotNull || strings.HasPrefix(t, "Array(") {
+		return t
+	}
+	return "Nullable(" + t + ")"
+}
+
+// CreateTable 表的建表语句，引擎为ReplacingMergeTree(_version, _deleted)，按keys(主键)排序及去重
+// keys为空(没有主键的表)时为MergeTree，保留所有变更
+func CreateTable(table string, schema core.RelationSchema, keys []string) string {
+	isKey := map[string]bool{}
+	for _, name := range keys {
+		isKey[name] = true
+	}
+	var b strings.Builder
+	b.WriteString("CREATE TABLE IF NOT EXISTS " + table + " (\n")
+	for _, col := range schema.Columns {
+		b.WriteString("\t" + quote(col.Name) + " " + columnType(col, isKey[col.Name]) + ",\n")
+	}
+	b.WriteString("\t" + VersionColumn + " UInt64,\n\t" + DeletedColumn + " UInt8\n")
+	if len(keys) == 0 {
+		b.WriteString(") ENGINE = MergeTree\nORDER BY tuple()")
+		return b.String()
+	}
+	order := make([]string, len(keys))
+	for i, name := range keys {
+		order[i] = quote(name)
+	}
+	b.WriteString(") ENGINE = ReplacingMergeTree(" + VersionColumn + ", " + DeletedColumn + ")\nORDER BY (" + strings.Join(order, ", ") + ")")
+	return b.String()
+}
+
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// value 按ClickHouse类型转换值：String列为字符串(JSON等编码为JSON)，Decimal为字符串，UUID为标准格式，
+// 非Nullable列的NULL为类型的零值，其余保持解码后的值
+func value(t string, v interface{}) interface{} {
+	nullable := strings.HasPrefix(t, "Nullable(")
+	if nullable {
+		t = t[len("Nullable(") : len(t)-1]
+	}
+	if v == nil {
+		if nullable {
+			return nil
+		}
+		return zero(t)
+	}
+	switch {
+	case t == "String":
+		switch s := v.(type) {
+		case string:
+			return s
+		case []byte:
+			return string(s)
+		case fmt.Stringer:
+			return s.String()
+		}
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+		return fmt.Sprint(v)
+	case strings.HasPrefix(t, "Decimal("):
+		return fmt.Sprint(v)
+	case t == "UUID":
+		if u, ok := v.([16]byte); ok {
+			return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+		}
+	}
+	return v
+}
+
+func zero(t string) interface{} {
+	switch t {
+	case "Bool":
+		return false
+	case "Int16":
+		return int16(0)
+	case "Int32":
+		return int32(0)
+	case "Int64":
+		return int64(0)
+	case "UInt32":
+		return uint32(0)
+	case "Float32":
+		return float32(0)
+	case "Float64":
+		return float64(0)
+	case "UUID":
+		return "00000000-0000-0000-0000-000000000000"
+	case "String":
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(t, "Decimal("):
+		return "0"
+	case strings.HasPrefix(t, "Date"):
+		return time.Unix(0, 0).UTC()
+	case strings.HasPrefix(t, "Array("):
+		return []interface{}{}
+	}
+	return ""
+}
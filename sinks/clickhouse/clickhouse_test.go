@@ -0,0 +1,222 @@
+package clickhouse_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+	chsink "github.com/cube-group/pg-replication/sinks/clickhouse"
+)
+
+type schemas map[uint32]core.RelationSchema
+
+func (s schemas) Schema(id uint32) (core.RelationSchema, bool) {
+	schema, ok := s[id]
+	return schema, ok
+}
+
+// toastSchemas 未变化的TOAST列由ToastFetch读取
+type toastSchemas struct{ schemas }
+
+func (toastSchemas) Toast() core.ToastPolicy { return core.ToastFetch }
+
+func usersSchema(replica byte) schemas {
+	return schemas{1: {RelationID: 1, Schema: "public", Name: "users", ReplicaIdentity: replica, Columns: []core.ColumnSchema{
+		{Name: "id", TypeName: "int4", Key: true, NotNull: true},
+		{Name: "name", TypeName: "text", Key: replica == 'f'},
+	}}}
+}
+
+func change(event core.EventType, lsn uint64, id int32, name interface{}) core.ReplicationMessage {
+	return core.ReplicationMessage{
+		Lsn: lsn, RelationID: 1, EventType: event, SchemaName: "public", TableName: "users",
+		Body:   map[string]interface{}{"id": id, "name": name},
+		Fields: core.Fields{{Name: "id", Value: id}, {Name: "name", Value: name}},
+	}
+}
+
+func commit(lsn uint64) core.ReplicationMessage {
+	return core.ReplicationMessage{EventType: core.EventType_COMMIT, Lsn: lsn}
+}
+
+type conn struct {
+	mu      sync.Mutex
+	blocks  []chsink.Block
+	queries []string
+	err     error
+}
+
+func (c *conn) Insert(ctx context.Context, block chsink.Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.blocks = append(c.blocks, block)
+	return nil
+}
+
+func (c *conn) Exec(ctx context.Context, query string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries = append(c.queries, query)
+	return nil
+}
+
+// rows 写入的所有行
+func (c *conn) rows() [][]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var rows [][]interface{}
+	for _, b := range c.blocks {
+		for i := 0; i < b.Rows(); i++ {
+			rows = append(rows, b.Row(i))
+		}
+	}
+	return rows
+}
+
+func newSink(c *conn, config chsink.Config) *chsink.Sink {
+	if config.Schemas == nil {
+		config.Schemas = toastSchemas{usersSchema('d')}
+	}
+	config.FlushInterval = time.Hour
+	return chsink.NewSink(c, config)
+}
+
+func TestBuffered(t *testing.T) {
+	c := &conn{}
+	s := newSink(c, chsink.Config{MaxRows: 3})
+	ctx := context.Background()
+	if err := s.Write(ctx, change(core.EventType_INSERT, 10, 1, "tom"), commit(11)); !errors.Is(err, core.ErrBuffered) {
+		t.Fatalf("first transaction: %v", err)
+	}
+	// 只有COMMIT的事务(变更被过滤或来自跳过的origin)不越过缓存确认
+	if err := s.Write(ctx, commit(12)); !errors.Is(err, core.ErrBuffered) {
+		t.Fatalf("empty transaction: %v", err)
+	}
+	if len(c.rows()) != 0 {
+		t.Fatalf("wrote %v before MaxRows", c.rows())
+	}
+	if err := s.Write(ctx, change(core.EventType_INSERT, 20, 2, "amy"), change(core.EventType_DELETE, 21, 1, nil), commit(22)); err != nil {
+		t.Fatalf("MaxRows reached: %v", err)
+	}
+	want := [][]interface{}{
+		{int32(1), "tom", uint64(10), uint8(0)},
+		{int32(2), "amy", uint64(20), uint8(0)},
+		{int32(1), nil, uint64(21), uint8(1)},
+	}
+	if rows := c.rows(); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows %v, want %v", rows, want)
+	}
+	// 没有缓存时只有COMMIT的事务直接确认
+	if err := s.Write(ctx, commit(30)); err != nil {
+		t.Fatalf("empty transaction without buffer: %v", err)
+	}
+	if err := s.Write(ctx, change(core.EventType_INSERT, 40, 3, "bob"), commit(41)); !errors.Is(err, core.ErrBuffered) {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows := c.rows(); len(rows) != 4 {
+		t.Fatalf("Close wrote %d rows, want 4", len(rows))
+	}
+}
+
+// 写入失败时保留缓存，下次写入按顺序重试
+func TestRetry(t *testing.T) {
+	c := &conn{err: errors.New("connection refused")}
+	s := newSink(c, chsink.Config{MaxRows: 1})
+	defer s.Close()
+	ctx := context.Background()
+	if err := s.Write(ctx, change(core.EventType_INSERT, 10, 1, "tom"), commit(11)); !errors.Is(err, c.err) {
+		t.Fatalf("error %v", err)
+	}
+	c.mu.Lock()
+	c.err = nil
+	c.mu.Unlock()
+	if err := s.Write(ctx, change(core.EventType_INSERT, 20, 2, "amy"), commit(21)); err != nil {
+		t.Fatal(err)
+	}
+	var ids []interface{}
+	for _, row := range c.rows() {
+		ids = append(ids, row[0])
+	}
+	if want := []interface{}{int32(1), int32(2)}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("ids %v, want %v", ids, want)
+	}
+}
+
+// 主键变化的update为旧主键写入墓碑，墓碑的其余列为NULL
+func TestPrimaryKeyChange(t *testing.T) {
+	c := &conn{}
+	s := newSink(c, chsink.Config{MaxRows: 1})
+	defer s.Close()
+	m := change(core.EventType_UPDATE, 10, 2, "tom")
+	m.Key = map[string]interface{}{"id": int32(1)}
+	if err := s.Write(context.Background(), m, commit(11)); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{int32(1), nil, uint64(10), uint8(1)},
+		{int32(2), "tom", uint64(10), uint8(0)},
+	}
+	if rows := c.rows(); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows %v, want %v", rows, want)
+	}
+}
+
+func TestUnchangedToast(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		schemas sinks.Schemas
+		msg     core.ReplicationMessage
+		ok      bool
+	}{
+		{"default replica identity", usersSchema('d'), change(core.EventType_INSERT, 10, 1, "tom"), false},
+		{"replica identity full", usersSchema('f'), change(core.EventType_INSERT, 10, 1, "tom"), true},
+		{"toast fetch", toastSchemas{usersSchema('d')}, change(core.EventType_INSERT, 10, 1, "tom"), true},
+		{"unchanged toast value", toastSchemas{usersSchema('d')}, change(core.EventType_UPDATE, 10, 1, core.UnchangedToast), false},
+	}
+	for _, c := range cases {
+		conn := &conn{}
+		s := newSink(conn, chsink.Config{Schemas: c.schemas, MaxRows: 1})
+		err := s.Write(ctx, c.msg, commit(11))
+		if c.ok != (err == nil) {
+			t.Errorf("%s: error %v", c.name, err)
+		}
+		if !c.ok && len(conn.rows()) != 0 {
+			t.Errorf("%s: wrote %v", c.name, conn.rows())
+		}
+		s.Close()
+	}
+}
+
+func TestAutoCreate(t *testing.T) {
+	c := &conn{}
+	s := newSink(c, chsink.Config{AutoCreate: true, Truncate: true, MaxRows: 10})
+	ctx := context.Background()
+	if err := s.Write(ctx, change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_INSERT, 11, 2, "amy"), commit(12)); !errors.Is(err, core.ErrBuffered) {
+		t.Fatal(err)
+	}
+	truncate := core.ReplicationMessage{Lsn: 20, RelationID: 1, EventType: core.EventType_TRUNCATE, SchemaName: "public", TableName: "users"}
+	// TRUNCATE前写入缓存
+	if err := s.Write(ctx, truncate, commit(21)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.queries) != 2 || !strings.HasPrefix(c.queries[0], "CREATE TABLE IF NOT EXISTS public_users") || c.queries[1] != "TRUNCATE TABLE IF EXISTS public_users" {
+		t.Fatalf("queries %q", c.queries)
+	}
+	if len(c.rows()) != 2 {
+		t.Fatalf("wrote %d rows before truncate, want 2", len(c.rows()))
+	}
+	s.Close()
+}
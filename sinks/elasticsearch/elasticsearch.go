@@ -0,0 +1,347 @@
+// Package elasticsearch 把变更写入Elasticsearch/OpenSearch索引的Sink，每张表一个索引，文档_id为主键列的值，
+// 通过_bulk接口批量写入，insert及初始快照覆盖文档，update按字段合并(不存在时插入)，delete删除文档
+//
+// 直接调用HTTP接口，不依赖客户端库，Elasticsearch 7+及OpenSearch的_bulk接口相同：
+//
+//	sink := elasticsearch.NewSink(elasticsearch.Config{URL: "http://localhost:9200", Schemas: r})
+//	r.Start(ctx, r.SinkHandler(ctx, sink))
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+)
+
+// Config Sink的配置，URL必须设置
+type Config struct {
+	// URL 集群地址，如http://localhost:9200
+	URL string
+	// Client 默认为http.DefaultClient
+	Client *http.Client
+	// Header 附加的请求头，如Authorization
+	Header http.Header
+	// Username Password 设置时使用Basic认证
+	Username string
+	Password string
+	// Index 索引名模板，支持{schema} {table} {tenant}占位符，转换为小写，默认为{schema}.{table}
+	Index string
+	// 用于获取主键列，通常为*core.Replication，见sinks.KeyColumns
+	Schemas sinks.Schemas
+	// Document 文档内容，默认为消息各列的JSON对象，不包含未变化的TOAST列(core.UnchangedToast)
+	// update以部分文档合并，未包含的字段保持原值
+	Document func(msg core.ReplicationMessage) ([]byte, error)
+	// Refresh _bulk的refresh参数，如wait_for，默认不设置
+	Refresh string
+	// MaxActions MaxBytes 一次_bulk请求的最大操作数及请求体大小，默认为1000及5MB
+	MaxActions int
+	MaxBytes   int
+	// MaxRetries 写入失败(429以外的可重试错误，如连接错误、5xx)时的重试次数，默认为10，小于0时不重试
+	// 集群返回429(整个请求或其中的操作)时一直重试直到ctx取消，期间不确认lsn
+	MaxRetries int
+	// Backoff 第一次重试的等待时间，之后每次加倍直到MaxBackoff，默认为200毫秒及10秒
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Sink 实现core.Sink，写入表的INSERT/UPDATE/DELETE/SNAPSHOT
+// 一个事务的操作按顺序分批写入，同一批中每个文档最多一个操作，失败的操作重试成功后才写入下一批，因此同一文档的操作保持顺序；
+// 所有操作成功(删除不存在的文档视为成功)后才确认lsn
+// 没有主键的表insert时由集群生成_id，update/delete无法定位文档而被忽略；TRUNCATE被忽略
+type Sink struct {
+	config Config
+}
+
+func NewSink(config Config) *Sink {
+	config.URL = strings.TrimRight(config.URL, "/")
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	if config.Index == "" {
+		config.Index = "{schema}.{table}"
+	}
+	if config.MaxActions <= 0 {
+		config.MaxActions = 1000
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 5 << 20
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 10
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = 200 * time.Millisecond
+	}
+	if config.MaxBackoff < config.Backoff {
+		config.MaxBackoff = 10 * time.Second
+	}
+	return &Sink{config: config}
+}
+
+// operation _bulk中的一个操作
+type operation struct {
+	action string
+	index  string
+	// 为空时由集群生成
+	id string
+	// 操作行及文档行(delete没有文档行)
+	data []byte
+}
+
+type meta struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id,omitempty"`
+}
+
+func (s *Sink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	var ops []operation
+	for _, m := range msg {
+		res, err := s.operations(m)
+		if err != nil {
+			return fmt.Errorf("elasticsearch %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+		ops = append(ops, res...)
+	}
+	for len(ops) > 0 {
+		n := s.batch(ops)
+		if err := s.bulk(ctx, ops[:n]); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+func (s *Sink) Close() error {
+	return nil
+}
+
+func (s *Sink) operations(m core.ReplicationMessage) ([]operation, error) {
+	switch m.EventType {
+	case core.EventType_INSERT, core.EventType_SNAPSHOT, core.EventType_UPDATE, core.EventType_DELETE:
+	default:
+		return nil, nil
+	}
+	index := strings.ToLower(core.TenantTopic(s.config.Index, m))
+	columns := sinks.KeyColumns(s.config.Schemas, m)
+	id, ok := sinks.KeyValues(columns, m.Body)
+	if m.EventType == core.EventType_DELETE {
+		if !ok {
+			return nil, nil
+		}
+		op, err := newOperation("delete", index, id, nil)
+		return []operation{op}, err
+	}
+	doc, err := s.document(m)
+	if err != nil {
+		return nil, err
+	}
+	if m.EventType != core.EventType_UPDATE {
+		op, err := newOperation("index", index, id, doc)
+		return []operation{op}, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	var res []operation
+	// 主键变化时删除旧文档，旧值在Key(默认复制标识)或OldBody(REPLICA IDENTITY FULL)中
+	for _, old := range []map[string]interface{}{m.Key, m.OldBody} {
+		if oldID, ok := sinks.KeyValues(columns, old); ok {
+			if oldID != id {
+				op, err := newOperation("delete", index, oldID, nil)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, op)
+			}
+			break
+		}
+	}
+	body, err := json.Marshal(struct {
+		Doc         json.RawMessage `json:"doc"`
+		DocAsUpsert bool            `json:"doc_as_upsert"`
+	}{doc, true})
+	if err != nil {
+		return nil, err
+	}
+	op, err := newOperation("update", index, id, body)
+	return append(res, op), err
+}
+
+func newOperation(action, index, id string, doc []byte) (op operation, err error) {
+	line, err := json.Marshal(map[string]meta{action: {Index: index, ID: id}})
+	if err != nil {
+		return
+	}
+	op = operation{action: action, index: index, id: id, data: append(line, '\n')}
+	if doc != nil {
+		op.data = append(append(op.data, doc...), '\n')
+	}
+	return
+}
+
+func (s *Sink) document(m core.ReplicationMessage) ([]byte, error) {
+	if s.config.Document != nil {
+		return s.config.Document(m)
+	}
+	fields := make(core.Fields, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		if f.Value != core.UnchangedToast {
+			fields = append(fields, f)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// batch 从头开始满足大小限制且文档不重复的操作数
+func (s *Sink) batch(ops []operation) int {
+	ids := map[string]bool{}
+	size := 0
+	for i, op := range ops {
+		size += len(op.data)
+		if i == s.config.MaxActions || (i > 0 && size > s.config.MaxBytes) {
+			return i
+		}
+		if op.id == "" {
+			continue
+		}
+		key := op.index + "/" + op.id
+		if ids[key] {
+			return i
+		}
+		ids[key] = true
+	}
+	return len(ops)
+}
+
+type bulkResponse struct {
+	Items []map[string]bulkItem `json:"items"`
+}
+
+type bulkItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// bulk 写入一批操作，只重试失败的操作
+func (s *Sink) bulk(ctx context.Context, ops []operation) error {
+	backoff := s.config.Backoff
+	retries := 0
+	for {
+		status, resp, err := s.do(ctx, ops)
+		throttle := status == http.StatusTooManyRequests
+		retryable := err != nil || throttle || status >= 500
+		if err == nil && status/100 != 2 {
+			err = fmt.Errorf("status %d: %s", status, resp)
+		}
+		if err == nil {
+			var res bulkResponse
+			if err = json.Unmarshal(resp, &res); err != nil {
+				return fmt.Errorf("elasticsearch bulk: %w", err)
+			}
+			if len(res.Items) != len(ops) {
+				return fmt.Errorf("elasticsearch bulk: %d items for %d operations", len(res.Items), len(ops))
+			}
+			var failed []operation
+			throttle = true
+			for i, item := range res.Items {
+				op := ops[i]
+				r := item[op.action]
+				if r.Status/100 == 2 || (op.action == "delete" && r.Status == http.StatusNotFound) {
+					continue
+				}
+				err = fmt.Errorf("%s %s/%s: status %d", op.action, op.index, op.id, r.Status)
+				if r.Error != nil {
+					err = fmt.Errorf("%s %s/%s: %s: %s", op.action, op.index, op.id, r.Error.Type, r.Error.Reason)
+				}
+				if r.Status != http.StatusTooManyRequests && r.Status < 500 {
+					// 如映射错误，重试无效
+					return fmt.Errorf("elasticsearch bulk: %w", err)
+				}
+				if r.Status != http.StatusTooManyRequests {
+					throttle = false
+				}
+				failed = append(failed, op)
+			}
+			if len(failed) == 0 {
+				return nil
+			}
+			ops = failed
+			retryable = true
+			err = fmt.Errorf("%d operations failed, last error %w", len(failed), err)
+		}
+		err = fmt.Errorf("elasticsearch bulk: %w", err)
+		if !retryable || ctx.Err() != nil {
+			return err
+		}
+		if !throttle {
+			if retries >= s.config.MaxRetries {
+				return err
+			}
+			retries++
+		}
+		// 随机化等待时间，避免多个实例同时重试
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > s.config.MaxBackoff {
+			backoff = s.config.MaxBackoff
+		}
+	}
+}
+
+// do 发送_bulk请求，返回状态码及响应内容
+func (s *Sink) do(ctx context.Context, ops []operation) (int, []byte, error) {
+	var body bytes.Buffer
+	for _, op := range ops {
+		body.Write(op.data)
+	}
+	url := s.config.URL + "/_bulk"
+	if s.config.Refresh != "" {
+		url += "?refresh=" + s.config.Refresh
+	}
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range s.config.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode/100 != 2 && len(data) > 512 {
+		data = data[:512]
+	}
+	return resp.StatusCode, data, nil
+}
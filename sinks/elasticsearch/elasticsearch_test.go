@@ -0,0 +1,206 @@
+package elasticsearch_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks/elasticsearch"
+)
+
+type schemas map[uint32]core.RelationSchema
+
+func (s schemas) Schema(id uint32) (core.RelationSchema, bool) {
+	schema, ok := s[id]
+	return schema, ok
+}
+
+func usersSchema(replica byte) schemas {
+	return schemas{1: {RelationID: 1, Schema: "public", Name: "users", ReplicaIdentity: replica, Columns: []core.ColumnSchema{
+		{Name: "id", TypeName: "int4", Key: true},
+		{Name: "name", TypeName: "text", Key: replica == 'f'},
+	}}}
+}
+
+func change(event core.EventType, lsn uint64, id int32, name string) core.ReplicationMessage {
+	return core.ReplicationMessage{
+		Lsn: lsn, RelationID: 1, EventType: event, SchemaName: "public", TableName: "Users",
+		Body:   map[string]interface{}{"id": id, "name": name},
+		Fields: core.Fields{{Name: "id", Value: id}, {Name: "name", Value: name}},
+	}
+}
+
+func commit(lsn uint64) core.ReplicationMessage {
+	return core.ReplicationMessage{EventType: core.EventType_COMMIT, Lsn: lsn}
+}
+
+// response 集群对一次_bulk请求的响应，items为每个操作的状态码，为空时都是200
+type response struct {
+	status int
+	items  []int
+}
+
+// cluster 记录_bulk请求中的操作，按顺序返回responses，之后都成功
+type cluster struct {
+	mu        sync.Mutex
+	requests  [][]string
+	responses []response
+}
+
+func (c *cluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var actions []string
+	dec := json.NewDecoder(r.Body)
+	for {
+		var line map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for action, meta := range line {
+			actions = append(actions, action+" "+meta.Index+"/"+meta.ID)
+			if action != "delete" {
+				var doc json.RawMessage
+				dec.Decode(&doc)
+			}
+		}
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, actions)
+	res := response{status: http.StatusOK}
+	if len(c.responses) > 0 {
+		res, c.responses = c.responses[0], c.responses[1:]
+	}
+	c.mu.Unlock()
+	if res.status != http.StatusOK {
+		http.Error(w, "unavailable", res.status)
+		return
+	}
+	items := make([]map[string]interface{}, len(actions))
+	for i, action := range actions {
+		status := http.StatusOK
+		if i < len(res.items) {
+			status = res.items[i]
+		}
+		item := map[string]interface{}{"status": status}
+		if status != http.StatusOK {
+			item["error"] = map[string]string{"type": "error", "reason": http.StatusText(status)}
+		}
+		items[i] = map[string]interface{}{strings.Fields(action)[0]: item}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func newSink(t *testing.T, c *cluster, config elasticsearch.Config) *elasticsearch.Sink {
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+	config.URL = server.URL
+	config.Backoff = time.Millisecond
+	config.MaxBackoff = time.Millisecond
+	return elasticsearch.NewSink(config)
+}
+
+func TestOperations(t *testing.T) {
+	moved := change(core.EventType_UPDATE, 10, 2, "tom")
+	moved.Key = map[string]interface{}{"id": int32(1)}
+	full := change(core.EventType_UPDATE, 10, 1, "tom")
+	full.OldBody, full.FullOldRow = map[string]interface{}{"id": int32(1), "name": "amy"}, true
+	keyed := change(core.EventType_INSERT, 10, 1, "tom")
+	keyed.Key = map[string]interface{}{"id": int32(1)}
+	cases := []struct {
+		name    string
+		config  elasticsearch.Config
+		msg     core.ReplicationMessage
+		actions []string
+	}{
+		{"insert key from schemas", elasticsearch.Config{Schemas: usersSchema('d')}, change(core.EventType_INSERT, 10, 1, "tom"), []string{"index public.users/1"}},
+		{"insert key from the message", elasticsearch.Config{}, keyed, []string{"index public.users/1"}},
+		{"insert without key", elasticsearch.Config{}, change(core.EventType_INSERT, 10, 1, "tom"), []string{"index public.users/"}},
+		{"primary key change deletes the old document", elasticsearch.Config{}, moved, []string{"delete public.users/1", "update public.users/2"}},
+		{"replica identity full", elasticsearch.Config{}, full, nil},
+		{"delete", elasticsearch.Config{Schemas: usersSchema('d'), Index: "{table}"}, change(core.EventType_DELETE, 10, 1, "tom"), []string{"delete users/1"}},
+	}
+	for _, c := range cases {
+		es := &cluster{}
+		if err := newSink(t, es, c.config).Write(context.Background(), c.msg, commit(20)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var actions []string
+		for _, r := range es.requests {
+			actions = append(actions, r...)
+		}
+		if !reflect.DeepEqual(actions, c.actions) {
+			t.Errorf("%s: actions %q, want %q", c.name, actions, c.actions)
+		}
+	}
+}
+
+// 同一文档的操作分批写入，保持顺序
+func TestBatch(t *testing.T) {
+	es := &cluster{}
+	s := newSink(t, es, elasticsearch.Config{Schemas: usersSchema('d')})
+	err := s.Write(context.Background(), change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_INSERT, 11, 2, "amy"),
+		change(core.EventType_DELETE, 12, 1, "tom"), commit(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"index public.users/1", "index public.users/2"}, {"delete public.users/1"}}
+	if !reflect.DeepEqual(es.requests, want) {
+		t.Fatalf("requests %q, want %q", es.requests, want)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	msg := []core.ReplicationMessage{change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_INSERT, 11, 2, "amy"), commit(20)}
+	cases := []struct {
+		name       string
+		maxRetries int
+		responses  []response
+		requests   [][]string
+		ok         bool
+	}{
+		{"5xx then success", 0, []response{{status: http.StatusServiceUnavailable}}, [][]string{
+			{"index public.users/1", "index public.users/2"},
+			{"index public.users/1", "index public.users/2"},
+		}, true},
+		{"5xx beyond MaxRetries", 1, []response{{status: http.StatusBadGateway}, {status: http.StatusBadGateway}}, [][]string{
+			{"index public.users/1", "index public.users/2"},
+			{"index public.users/1", "index public.users/2"},
+		}, false},
+		// 429不计入MaxRetries，只重试失败的操作
+		{"throttled item", -1, []response{
+			{status: http.StatusOK, items: []int{http.StatusOK, http.StatusTooManyRequests}},
+			{status: http.StatusOK, items: []int{http.StatusTooManyRequests}},
+		}, [][]string{
+			{"index public.users/1", "index public.users/2"},
+			{"index public.users/2"},
+			{"index public.users/2"},
+		}, true},
+		{"mapping error", 0, []response{{status: http.StatusOK, items: []int{http.StatusOK, http.StatusBadRequest}}}, [][]string{
+			{"index public.users/1", "index public.users/2"},
+		}, false},
+	}
+	for _, c := range cases {
+		es := &cluster{responses: c.responses}
+		err := newSink(t, es, elasticsearch.Config{Schemas: usersSchema('d'), MaxRetries: c.maxRetries}).Write(ctx, msg...)
+		if c.ok != (err == nil) {
+			t.Errorf("%s: error %v", c.name, err)
+		}
+		if !reflect.DeepEqual(es.requests, c.requests) {
+			t.Errorf("%s: requests %q, want %q", c.name, es.requests, c.requests)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package kafka 把变更写入Kafka的Sink，每张表一个topic，消息key为主键列的值，保证同一行的变更进入同一分区并保持顺序
+//
+// 不依赖具体的Kafka客户端，通过Producer适配，如sarama:
+//
+//	type saramaProducer struct{ p sarama.SyncProducer }
+//
+//	func (s saramaProducer) Produce(ctx context.Context, records []kafka.Record) error {
+//		msgs := make([]*sarama.ProducerMessage, len(records))
+//		for i, r := range records {
+//			msgs[i] = &sarama.ProducerMessage{Topic: r.Topic, Key: sarama.ByteEncoder(r.Key), Value: sarama.ByteEncoder(r.Value)}
+//			for _, h := range r.Headers {
+//				msgs[i].Headers = append(msgs[i].Headers, sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+//			}
+//		}
+//		return s.p.SendMessages(msgs)
+//	}
+//
+// franz-go可使用client.ProduceSync(ctx, rs...).FirstErr()
+//
+// Producer同时实现TxnProducer时每个PostgreSQL事务在一个Kafka事务中写入，配合CheckpointTopic及Resume实现exactly-once，见TxnProducer
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+	"github.com/jackc/pgx"
+)
+
+// Record 写入Kafka的一条消息
+type Record struct {
+	Topic string
+	// 为nil时由Producer选择分区
+	Key     []byte
+	Value   []byte
+	Headers []Header
+}
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Producer 写入消息，所有消息被broker确认(acks按Producer的配置，建议为all)后才返回nil
+// 同一key的消息需按顺序写入同一分区，sarama需设置Producer.Idempotent或MaxOpenRequests为1
+type Producer interface {
+	Produce(ctx context.Context, records []Record) error
+}
+
+// TxnProducer 事务型Producer(需配置transactional.id，同一复制槽应固定，新实例启动时会使旧实例失效)
+// 每个PostgreSQL事务的变更及检查点在一个Kafka事务中写入，提交失败时中止，下游以read_committed读取时不会看到部分事务
+// 写入Kafka后、确认lsn前崩溃时服务器会重新发送已写入的事务，启动时需读取CheckpointTopic的最后一条消息调用Resume跳过：
+//
+//	lsn, err := kafka.ParseCheckpoint(lastCheckpoint)
+//	sink.Resume(lsn)
+//	r.Start(ctx, r.SinkHandler(ctx, sink))
+type TxnProducer interface {
+	Producer
+	BeginTxn() error
+	CommitTxn(ctx context.Context) error
+	AbortTxn(ctx context.Context) error
+}
+
+// Config Sink的配置，零值可用
+type Config struct {
+	// Topic 表对应的topic，默认为TopicPrefix+schema.table
+	Topic       func(schema, table string) string
+	TopicPrefix string
+	// Key 消息key，默认为主键(复制标识)列按列顺序组成的JSON对象，如{"id":1}
+	// 返回nil时由Producer选择分区
+	Key func(msg core.ReplicationMessage) ([]byte, error)
+	// Value 消息内容，默认为sinks.Event的JSON
+	Value func(msg core.ReplicationMessage) ([]byte, error)
+	// 用于获取主键列，未设置时为消息中的复制标识列(Key)
+	// 为*core.Replication且配置了SchemaRefresh时使用表的主键
+	Schemas sinks.Schemas
+	// CheckpointTopic 每个事务的最后写入一条检查点消息，内容为Checkpoint的JSON，为空时不写入
+	// Producer为TxnProducer时检查点与变更在同一Kafka事务中提交，重启时用其最后一条消息调用Resume
+	CheckpointTopic string
+	// CheckpointKey 检查点消息的key，同一复制槽应固定，默认为pg_replication
+	CheckpointKey string
+}
+
+// Checkpoint 检查点消息，记录已写入Kafka的最后一个事务
+type Checkpoint struct {
+	Lsn string `json:"lsn"`
+	Xid uint32 `json:"xid,omitempty"`
+}
+
+// ParseCheckpoint 解析检查点消息，返回事务的提交lsn
+func ParseCheckpoint(value []byte) (uint64, error) {
+	var cp Checkpoint
+	if err := json.Unmarshal(value, &cp); err != nil {
+		return 0, fmt.Errorf("kafka checkpoint: %w", err)
+	}
+	return pgx.ParseLSN(cp.Lsn)
+}
+
+// Sink 实现core.Sink，只写入表的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)，
+// 一个事务的消息(及检查点)在一次Produce中写入，Produce(TxnProducer为CommitTxn)返回nil后才确认事务的lsn
+type Sink struct {
+	producer Producer
+	config   Config
+
+	mu sync.Mutex
+	// 已写入Kafka的最后一个事务的提交lsn
+	written uint64
+}
+
+func NewSink(producer Producer, config Config) *Sink {
+	if config.CheckpointKey == "" {
+		config.CheckpointKey = "pg_replication"
+	}
+	return &Sink{producer: producer, config: config}
+}
+
+// Resume 设置已写入Kafka的最后一个事务的提交lsn，提交lsn不大于它的事务直接确认而不再写入
+func (s *Sink) Resume(lsn uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = lsn
+}
+
+// Written 已写入Kafka的最后一个事务的提交lsn
+func (s *Sink) Written() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
+
+func (s *Sink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commit := sinks.Commit(msg)
+	if commit.Lsn > 0 && commit.Lsn <= s.written {
+		return nil
+	}
+	var records []Record
+	for _, m := range msg {
+		if !sinks.IsChange(m) {
+			continue
+		}
+		r, err := s.record(m, commit.Lsn)
+		if err != nil {
+			return fmt.Errorf("kafka %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+		records = append(records, r)
+	}
+	if s.config.CheckpointTopic != "" && commit.Lsn > 0 {
+		value, err := json.Marshal(Checkpoint{Lsn: pgx.FormatLSN(commit.Lsn), Xid: commit.Xid})
+		if err != nil {
+			return err
+		}
+		records = append(records, Record{Topic: s.config.CheckpointTopic, Key: []byte(s.config.CheckpointKey), Value: value})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := s.produce(ctx, records); err != nil {
+		return err
+	}
+	if commit.Lsn > 0 {
+		s.written = commit.Lsn
+	}
+	return nil
+}
+
+// produce TxnProducer时在Kafka事务中写入，失败时中止事务
+func (s *Sink) produce(ctx context.Context, records []Record) error {
+	txn, ok := s.producer.(TxnProducer)
+	if !ok {
+		if err := s.producer.Produce(ctx, records); err != nil {
+			return fmt.Errorf("kafka produce: %w", err)
+		}
+		return nil
+	}
+	if err := txn.BeginTxn(); err != nil {
+		return fmt.Errorf("kafka begin transaction: %w", err)
+	}
+	err := txn.Produce(ctx, records)
+	if err == nil {
+		if err = txn.CommitTxn(ctx); err != nil {
+			err = fmt.Errorf("kafka commit transaction: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("kafka produce: %w", err)
+	}
+	if err != nil {
+		if abortErr := txn.AbortTxn(ctx); abortErr != nil {
+			return fmt.Errorf("%w (abort: %v)", err, abortErr)
+		}
+	}
+	return err
+}
+
+// Close 不关闭Producer，由创建者关闭
+func (s *Sink) Close() error {
+	return nil
+}
+
+func (s *Sink) record(m core.ReplicationMessage, commitLsn uint64) (r Record, err error) {
+	r.Topic = s.topic(m.SchemaName, m.TableName)
+	if s.config.Key != nil {
+		r.Key, err = s.config.Key(m)
+	} else {
+		r.Key, err = sinks.Key(s.config.Schemas, m)
+	}
+	if err != nil {
+		return
+	}
+	if s.config.Value != nil {
+		r.Value, err = s.config.Value(m)
+	} else {
+		r.Value, err = json.Marshal(sinks.NewEvent(m, commitLsn))
+	}
+	if err != nil {
+		return
+	}
+	r.Headers = []Header{
+		{Key: "pg_lsn", Value: []byte(pgx.FormatLSN(m.Lsn))},
+		{Key: "pg_event", Value: []byte(m.EventType.String())},
+	}
+	if commitLsn > 0 {
+		r.Headers = append(r.Headers, Header{Key: "pg_commit_lsn", Value: []byte(pgx.FormatLSN(commitLsn))})
+	}
+	return
+}
+
+func (s *Sink) topic(schema, table string) string {
+	if s.config.Topic != nil {
+		return s.config.Topic(schema, table)
+	}
+	return s.config.TopicPrefix + schema + "." + table
+}
@@ -0,0 +1,203 @@
+package kafka_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+	"github.com/cube-group/pg-replication/sinks/kafka"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+type schemas map[uint32]core.RelationSchema
+
+func (s schemas) Schema(id uint32) (core.RelationSchema, bool) {
+	schema, ok := s[id]
+	return schema, ok
+}
+
+func usersSchema(replica byte) schemas {
+	return schemas{1: {RelationID: 1, Schema: "public", Name: "users", ReplicaIdentity: replica, Columns: []core.ColumnSchema{
+		{Name: "id", TypeName: "int4", Key: true},
+		{Name: "name", TypeName: "text", Key: replica == 'f'},
+	}}}
+}
+
+func change(event core.EventType, lsn uint64, id int32, name string) core.ReplicationMessage {
+	return core.ReplicationMessage{
+		Lsn: lsn, RelationID: 1, EventType: event, SchemaName: "public", TableName: "users",
+		Body:   map[string]interface{}{"id": id, "name": name},
+		Fields: core.Fields{{Name: "id", Value: id}, {Name: "name", Value: name}},
+	}
+}
+
+func commit(lsn uint64) core.ReplicationMessage {
+	return core.ReplicationMessage{EventType: core.EventType_COMMIT, Lsn: lsn, Xid: 740}
+}
+
+type producer struct {
+	batches [][]kafka.Record
+	err     error
+}
+
+func (p *producer) Produce(ctx context.Context, records []kafka.Record) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.batches = append(p.batches, records)
+	return nil
+}
+
+type txnProducer struct {
+	producer
+	calls []string
+}
+
+func (p *txnProducer) BeginTxn() error {
+	p.calls = append(p.calls, "begin")
+	return nil
+}
+
+func (p *txnProducer) CommitTxn(ctx context.Context) error {
+	p.calls = append(p.calls, "commit")
+	return nil
+}
+
+func (p *txnProducer) AbortTxn(ctx context.Context) error {
+	p.calls = append(p.calls, "abort")
+	return nil
+}
+
+func TestKey(t *testing.T) {
+	keyed := change(core.EventType_INSERT, 10, 1, "tom")
+	keyed.Key = map[string]interface{}{"id": int32(1)}
+	moved := change(core.EventType_UPDATE, 10, 2, "tom")
+	moved.Key = map[string]interface{}{"id": int32(1)}
+	full := change(core.EventType_DELETE, 10, 1, "tom")
+	full.Key, full.FullOldRow = full.Body, true
+	truncate := core.ReplicationMessage{Lsn: 10, RelationID: 1, EventType: core.EventType_TRUNCATE, SchemaName: "public", TableName: "users"}
+	cases := []struct {
+		name   string
+		config kafka.Config
+		msg    core.ReplicationMessage
+		key    string
+	}{
+		{"insert key from the message", kafka.Config{}, keyed, `{"id":1}`},
+		{"insert without key or schemas", kafka.Config{}, change(core.EventType_INSERT, 10, 1, "tom"), ""},
+		{"insert key from schemas", kafka.Config{Schemas: usersSchema('d')}, change(core.EventType_INSERT, 10, 1, "tom"), `{"id":1}`},
+		{"primary key change uses the new key", kafka.Config{}, moved, `{"id":2}`},
+		{"replica identity full from schemas", kafka.Config{Schemas: usersSchema('f')}, change(core.EventType_UPDATE, 10, 1, "tom"), ""},
+		{"replica identity full from the message", kafka.Config{}, full, ""},
+		{"truncate", kafka.Config{Schemas: usersSchema('d')}, truncate, ""},
+		{"custom key", kafka.Config{Key: func(m core.ReplicationMessage) ([]byte, error) { return []byte(m.TableName), nil }}, keyed, "users"},
+	}
+	for _, c := range cases {
+		p := &producer{}
+		if err := kafka.NewSink(p, c.config).Write(context.Background(), c.msg, commit(20)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(p.batches) != 1 || len(p.batches[0]) != 1 {
+			t.Fatalf("%s: produced %v", c.name, p.batches)
+		}
+		if key := string(p.batches[0][0].Key); key != c.key {
+			t.Errorf("%s: key %q, want %q", c.name, key, c.key)
+		}
+	}
+}
+
+// 没有Schemas时insert的key来自core设置的复制标识列
+func TestKeyFromStream(t *testing.T) {
+	src := mock.NewSource().Relation(core.Relation{ID: 1, Namespace: "public", Name: "users", Columns: []core.Column{
+		mock.Key("id", pgtype.Int4OID),
+		mock.Col("name", pgtype.TextOID),
+	}}).Begin().Insert(1, 1, "tom").Update(1, nil, []interface{}{1, "amy"}).Delete(1, 1).Commit().End()
+	p := &producer{}
+	r := core.NewReplication("users_slot", pgx.ConnConfig{}).WithTransport(src)
+	r.Start(context.Background(), r.SinkHandler(context.Background(), kafka.NewSink(p, kafka.Config{})))
+	if len(p.batches) != 1 || len(p.batches[0]) != 3 {
+		t.Fatalf("produced %v", p.batches)
+	}
+	for _, r := range p.batches[0] {
+		if string(r.Key) != `{"id":1}` {
+			t.Errorf("%s key %q", r.Headers[1].Value, r.Key)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	p := &producer{}
+	s := kafka.NewSink(p, kafka.Config{TopicPrefix: "pg.", CheckpointTopic: "checkpoints"})
+	begin := core.ReplicationMessage{EventType: core.EventType_BEGIN, Lsn: 5}
+	if err := s.Write(context.Background(), begin, change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_INSERT, 11, 2, "amy"), commit(20)); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.batches) != 1 {
+		t.Fatalf("%d produce calls, want 1", len(p.batches))
+	}
+	records := p.batches[0]
+	var topics []string
+	for _, r := range records {
+		topics = append(topics, r.Topic)
+	}
+	if want := []string{"pg.public.users", "pg.public.users", "checkpoints"}; !reflect.DeepEqual(topics, want) {
+		t.Fatalf("topics %v, want %v", topics, want)
+	}
+	if lsn, err := kafka.ParseCheckpoint(records[2].Value); err != nil || lsn != 20 {
+		t.Fatalf("checkpoint %s: %x %v", records[2].Value, lsn, err)
+	}
+	if s.Written() != 20 {
+		t.Fatalf("written %x", s.Written())
+	}
+
+	// 重新发送已写入的事务时直接确认
+	if err := s.Write(context.Background(), change(core.EventType_INSERT, 10, 1, "tom"), commit(20)); err != nil || len(p.batches) != 1 {
+		t.Fatalf("rewrote a written transaction: %v %d", err, len(p.batches))
+	}
+	s.Resume(30)
+	if err := s.Write(context.Background(), change(core.EventType_INSERT, 25, 3, "bob"), commit(30)); err != nil || len(p.batches) != 1 {
+		t.Fatalf("rewrote a resumed transaction: %v %d", err, len(p.batches))
+	}
+}
+
+// 写入失败时不确认，重新发送的事务再次写入
+func TestWriteRetry(t *testing.T) {
+	p := &producer{err: errors.New("broker down")}
+	s := kafka.NewSink(p, kafka.Config{})
+	msg := []core.ReplicationMessage{change(core.EventType_INSERT, 10, 1, "tom"), commit(20)}
+	if err := s.Write(context.Background(), msg...); !errors.Is(err, p.err) {
+		t.Fatalf("error %v", err)
+	}
+	if s.Written() != 0 {
+		t.Fatalf("written %x after a failure", s.Written())
+	}
+	p.err = nil
+	if err := s.Write(context.Background(), msg...); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.batches) != 1 || s.Written() != 20 {
+		t.Fatalf("retry produced %d batches, written %x", len(p.batches), s.Written())
+	}
+}
+
+func TestWriteTxn(t *testing.T) {
+	p := &txnProducer{}
+	s := kafka.NewSink(p, kafka.Config{})
+	msg := []core.ReplicationMessage{change(core.EventType_INSERT, 10, 1, "tom"), commit(20)}
+	if err := s.Write(context.Background(), msg...); err != nil {
+		t.Fatal(err)
+	}
+	p.err = errors.New("fenced")
+	if err := s.Write(context.Background(), change(core.EventType_INSERT, 25, 2, "amy"), commit(30)); !errors.Is(err, p.err) {
+		t.Fatalf("error %v", err)
+	}
+	if want := []string{"begin", "commit", "begin", "abort"}; !reflect.DeepEqual(p.calls, want) {
+		t.Fatalf("calls %v, want %v", p.calls, want)
+	}
+	if s.Written() != 20 {
+		t.Fatalf("written %x", s.Written())
+	}
+}
@@ -0,0 +1,324 @@
+// Package kinesis 把变更写入AWS Kinesis Data Streams的Sink，partition key由表名及主键组成，保证同一行的变更进入同一shard并保持顺序
+//
+// 不依赖AWS SDK，通过Client适配，如aws-sdk-go-v2(本包以kinesissink导入):
+//
+//	type client struct{ c *kinesis.Client }
+//
+//	func (c client) PutRecords(ctx context.Context, stream string, entries []kinesissink.Entry) ([]kinesissink.Result, error) {
+//		input := &kinesis.PutRecordsInput{StreamName: aws.String(stream)}
+//		for _, e := range entries {
+//			input.Records = append(input.Records, types.PutRecordsRequestEntry{PartitionKey: aws.String(e.PartitionKey), Data: e.Data})
+//		}
+//		out, err := c.c.PutRecords(ctx, input)
+//		if err != nil {
+//			return nil, err
+//		}
+//		res := make([]kinesissink.Result, len(out.Records))
+//		for i, r := range out.Records {
+//			res[i] = kinesissink.Result{ErrorCode: aws.ToString(r.ErrorCode), ErrorMessage: aws.ToString(r.ErrorMessage)}
+//		}
+//		return res, nil
+//	}
+package kinesis
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+)
+
+// PutRecords的限制
+const (
+	maxBatchRecords = 500
+	maxBatchBytes   = 5 << 20
+	maxRecordBytes  = 1 << 20
+	maxKeyLength    = 256
+)
+
+// Entry PutRecords的一条记录
+type Entry struct {
+	PartitionKey string
+	Data         []byte
+}
+
+// Result PutRecords中对应记录的结果，ErrorCode为空时成功
+type Result struct {
+	ErrorCode    string
+	ErrorMessage string
+}
+
+// Client 调用PutRecords，返回的结果与entries一一对应
+type Client interface {
+	PutRecords(ctx context.Context, stream string, entries []Entry) ([]Result, error)
+}
+
+// Config Sink的配置，Stream必须设置
+type Config struct {
+	Stream string
+	// 用于获取主键列，通常为*core.Replication，见sinks.KeyColumns
+	Schemas sinks.Schemas
+	// PartitionKey 默认为规范表名加主键的JSON，如public.users{"id":1}，没有主键时为表名；超过256个字符时取md5
+	PartitionKey func(msg core.ReplicationMessage) (string, error)
+	// Data 记录内容，默认为sinks.Event的JSON
+	Data func(msg core.ReplicationMessage) ([]byte, error)
+	// Aggregate 按KPL格式把事务中partition key相同的变更聚合为一条记录，减少记录数及吞吐配额的消耗
+	// 消费端需使用KCL或kinesis-aggregation库解聚合
+	Aggregate bool
+	// MaxRetries 写入失败(限流以外的错误)时的重试次数，默认为10，小于0时不重试
+	// 限流(ProvisionedThroughputExceeded等)时一直重试直到ctx取消，期间不确认lsn
+	MaxRetries int
+	// Backoff 第一次重试的等待时间，之后每次加倍直到MaxBackoff，默认为100毫秒及5秒
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Throttled 判断PutRecords返回的错误是否为限流，默认按错误信息判断
+	Throttled func(err error) bool
+}
+
+// Sink 实现core.Sink，只写入表的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)
+// 一个事务的记录按PutRecords的限制分批写入，同一批中每个partition key最多一条记录，
+// 失败的记录重试成功后才写入下一批，因此同一partition key的记录保持顺序；没有主键的表每批只有一条记录
+type Sink struct {
+	client Client
+	config Config
+}
+
+func NewSink(client Client, config Config) *Sink {
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 10
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff < config.Backoff {
+		config.MaxBackoff = 5 * time.Second
+	}
+	if config.Throttled == nil {
+		config.Throttled = throttled
+	}
+	return &Sink{client: client, config: config}
+}
+
+func (s *Sink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	commit := sinks.Commit(msg)
+	var entries []Entry
+	for _, m := range msg {
+		if !sinks.IsChange(m) {
+			continue
+		}
+		e, err := s.entry(m, commit.Lsn)
+		if err != nil {
+			return fmt.Errorf("kinesis %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+		entries = append(entries, e)
+	}
+	if s.config.Aggregate {
+		entries = aggregate(entries)
+	}
+	for len(entries) > 0 {
+		n := batch(entries)
+		if err := s.put(ctx, entries[:n]); err != nil {
+			return err
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+func (s *Sink) Close() error {
+	return nil
+}
+
+func (s *Sink) entry(m core.ReplicationMessage, commitLsn uint64) (e Entry, err error) {
+	if s.config.PartitionKey != nil {
+		e.PartitionKey, err = s.config.PartitionKey(m)
+	} else {
+		e.PartitionKey, err = s.partitionKey(m)
+	}
+	if err != nil {
+		return
+	}
+	if s.config.Data != nil {
+		e.Data, err = s.config.Data(m)
+	} else {
+		e.Data, err = json.Marshal(sinks.NewEvent(m, commitLsn))
+	}
+	if err == nil && len(e.Data)+len(e.PartitionKey) > maxRecordBytes {
+		err = fmt.Errorf("record of %d bytes exceeds the 1MB limit", len(e.Data))
+	}
+	return
+}
+
+func (s *Sink) partitionKey(m core.ReplicationMessage) (string, error) {
+	key, err := sinks.Key(s.config.Schemas, m)
+	if err != nil {
+		return "", err
+	}
+	pk := core.TableName(m.SchemaName, m.TableName) + string(key)
+	if len([]rune(pk)) > maxKeyLength {
+		sum := md5.Sum([]byte(pk))
+		pk = hex.EncodeToString(sum[:])
+	}
+	return pk, nil
+}
+
+// batch 从头开始满足PutRecords限制且partition key不重复的记录数
+func batch(entries []Entry) int {
+	keys := map[string]bool{}
+	size := 0
+	for i, e := range entries {
+		size += len(e.Data) + len(e.PartitionKey)
+		if i == maxBatchRecords || (i > 0 && size > maxBatchBytes) || keys[e.PartitionKey] {
+			return i
+		}
+		keys[e.PartitionKey] = true
+	}
+	return len(entries)
+}
+
+// put 写入一批记录，只重试失败的记录
+func (s *Sink) put(ctx context.Context, entries []Entry) error {
+	backoff := s.config.Backoff
+	retries := 0
+	for {
+		results, err := s.client.PutRecords(ctx, s.config.Stream, entries)
+		throttle := err != nil && s.config.Throttled(err)
+		if err == nil && len(results) != len(entries) {
+			err = fmt.Errorf("%d results for %d records", len(results), len(entries))
+		}
+		if err == nil {
+			var failed []Entry
+			throttle = true
+			for i, r := range results {
+				if r.ErrorCode == "" {
+					continue
+				}
+				failed = append(failed, entries[i])
+				if r.ErrorCode != "ProvisionedThroughputExceededException" {
+					throttle = false
+				}
+				err = fmt.Errorf("%s: %s", r.ErrorCode, r.ErrorMessage)
+			}
+			if len(failed) == 0 {
+				return nil
+			}
+			entries = failed
+			err = fmt.Errorf("%d records failed, last error %w", len(failed), err)
+		}
+		err = fmt.Errorf("kinesis put records to %s: %w", s.config.Stream, err)
+		if ctx.Err() != nil {
+			return err
+		}
+		if !throttle {
+			if retries >= s.config.MaxRetries {
+				return err
+			}
+			retries++
+		}
+		// 随机化等待时间，避免多个实例同时重试
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > s.config.MaxBackoff {
+			backoff = s.config.MaxBackoff
+		}
+	}
+}
+
+func throttled(err error) bool {
+	msg := err.Error()
+	for _, s := range []string{"ProvisionedThroughputExceeded", "LimitExceeded", "Throttl", "SlowDown"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// KPL聚合记录的格式：magic | AggregatedRecord(protobuf) | md5(AggregatedRecord)
+//
+//	message AggregatedRecord {
+//		repeated string partition_key_table = 1;
+//		repeated string explicit_hash_key_table = 2;
+//		repeated Record records = 3;
+//	}
+//	message Record {
+//		required uint64 partition_key_index = 1;
+//		optional uint64 explicit_hash_key_index = 2;
+//		required bytes data = 3;
+//	}
+var kplMagic = []byte{0xf3, 0x89, 0x9a, 0xc2}
+
+// aggregate 把partition key相同的记录按顺序聚合，不同partition key在不同shard上没有顺序关系
+// 聚合后超过单条记录的大小限制时拆分为多条
+func aggregate(entries []Entry) []Entry {
+	var keys []string
+	groups := map[string][][]byte{}
+	for _, e := range entries {
+		if _, ok := groups[e.PartitionKey]; !ok {
+			keys = append(keys, e.PartitionKey)
+		}
+		groups[e.PartitionKey] = append(groups[e.PartitionKey], e.Data)
+	}
+	res := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		data := groups[key]
+		for len(data) > 0 {
+			var records []byte
+			n := 0
+			for ; n < len(data); n++ {
+				record := kplRecord(data[n])
+				if n > 0 && len(kplMagic)+protoSize(key)+len(records)+protoSize(string(record))+md5.Size+len(key) > maxRecordBytes {
+					break
+				}
+				records = appendProtoBytes(records, 3, record)
+			}
+			if n == 1 {
+				// 只有一条时不聚合
+				res = append(res, Entry{PartitionKey: key, Data: data[0]})
+			} else {
+				msg := append(appendProtoBytes(nil, 1, []byte(key)), records...)
+				sum := md5.Sum(msg)
+				res = append(res, Entry{PartitionKey: key, Data: append(append(append([]byte(nil), kplMagic...), msg...), sum[:]...)})
+			}
+			data = data[n:]
+		}
+	}
+	return res
+}
+
+// kplRecord 聚合记录中的一条，partition_key_index固定为0
+func kplRecord(data []byte) []byte {
+	return appendProtoBytes([]byte{1 << 3, 0}, 3, data)
+}
+
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	b = appendUvarint(b, uint64(field<<3|2))
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// protoSize 长度分隔字段编码后的长度(字段号小于16)
+func protoSize(data string) int {
+	return 1 + len(appendUvarint(nil, uint64(len(data)))) + len(data)
+}
@@ -0,0 +1,216 @@
+// Package pulsar 把变更写入Apache Pulsar的Sink，每张表一个topic，消息key为主键，
+// 以Key_Shared订阅消费时同一行的变更由同一消费者按顺序处理
+//
+// 序列号由事务的提交lsn及事务内的序号生成，重启后重新发送的事务序列号不变：
+// 开启broker去重(pulsar-admin namespaces set-deduplication)并为每个topic使用固定的producer名称时由broker丢弃重复消息，
+// 另外Sink在创建producer时取得broker上的最大序列号，跳过不大于它的消息
+//
+// 不依赖具体的Pulsar客户端，通过Producer适配，如pulsar-client-go(本包以pulsarsink导入):
+//
+//	type producer struct{ p pulsar.Producer }
+//
+//	func (p producer) SendAsync(ctx context.Context, msg pulsarsink.Message, callback func(error)) {
+//		p.p.SendAsync(ctx, &pulsar.ProducerMessage{Key: msg.Key, Payload: msg.Payload, Properties: msg.Properties,
+//			SequenceID: msg.SequenceID, EventTime: msg.EventTime}, func(_ pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+//			callback(err)
+//		})
+//	}
+//	func (p producer) Flush() error          { return p.p.Flush() }
+//	func (p producer) LastSequenceID() int64 { return p.p.LastSequenceID() }
+//	func (p producer) Close()                { p.p.Close() }
+//
+//	sink := pulsarsink.NewSink(func(topic string) (pulsarsink.Producer, error) {
+//		p, err := client.CreateProducer(pulsar.ProducerOptions{Topic: topic, Name: "my_slot-" + topic})
+//		return producer{p}, err
+//	}, pulsarsink.Config{Schemas: r})
+package pulsar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+	"github.com/jackc/pgx"
+)
+
+// Message 发送的一条消息
+type Message struct {
+	// 主键，没有主键的表为空
+	Key        string
+	Payload    []byte
+	Properties map[string]string
+	// 由提交lsn生成的序列号，没有提交lsn(如初始快照)时为nil，由producer分配
+	SequenceID *int64
+	EventTime  time.Time
+}
+
+// Producer 一个topic的producer
+type Producer interface {
+	// SendAsync 异步发送，broker确认或失败后调用callback
+	SendAsync(ctx context.Context, msg Message, callback func(err error))
+	// Flush 发送缓存中的消息并等待确认
+	Flush() error
+	// LastSequenceID broker上该producer已持久化的最大序列号，没有时为-1
+	LastSequenceID() int64
+	Close()
+}
+
+// ProducerFactory 创建topic的producer，同一topic的producer名称应固定，broker才能按序列号去重
+type ProducerFactory func(topic string) (Producer, error)
+
+// Config Sink的配置，零值可用
+type Config struct {
+	// Topic topic模板，支持{schema} {table} {tenant}占位符，默认为persistent://public/default/{schema}.{table}
+	Topic string
+	// 用于获取主键列，通常为*core.Replication，见sinks.KeyColumns
+	Schemas sinks.Schemas
+	// Payload 消息内容，默认为sinks.Event的JSON
+	Payload func(msg core.ReplicationMessage) ([]byte, error)
+}
+
+// sequenceShift 序列号为提交lsn<<12加事务内的序号，提交lsn小于2^51时不会溢出
+// 相邻事务的提交记录至少相隔32字节，事务的消息少于131072条时序列号单调递增
+const sequenceShift = 12
+
+// SequenceID 事务中第index条消息的序列号
+func SequenceID(commitLsn uint64, index int) int64 {
+	return int64(commitLsn<<sequenceShift) + int64(index)
+}
+
+type topicProducer struct {
+	producer Producer
+	// broker上已持久化的最大序列号
+	last int64
+}
+
+// Sink 实现core.Sink，只写入表的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)
+// 一个事务的消息全部异步发送后等待确认，全部成功才确认lsn，失败时关闭涉及的producer，下次写入时重新创建
+type Sink struct {
+	factory ProducerFactory
+	config  Config
+
+	mu        sync.Mutex
+	producers map[string]*topicProducer
+}
+
+func NewSink(factory ProducerFactory, config Config) *Sink {
+	if config.Topic == "" {
+		config.Topic = "persistent://public/default/{schema}.{table}"
+	}
+	return &Sink{factory: factory, config: config, producers: map[string]*topicProducer{}}
+}
+
+func (s *Sink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commit := sinks.Commit(msg)
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var sendErr error
+	used := map[string]*topicProducer{}
+	for i, m := range msg {
+		if !sinks.IsChange(m) {
+			continue
+		}
+		topic := core.TenantTopic(s.config.Topic, m)
+		p, err := s.producer(topic)
+		if err != nil {
+			s.fail(used)
+			return fmt.Errorf("pulsar create producer %s: %w", topic, err)
+		}
+		used[topic] = p
+		message, err := s.message(m, commit.Lsn, i)
+		if err != nil {
+			s.fail(used)
+			return fmt.Errorf("pulsar %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+		if message.SequenceID != nil && *message.SequenceID <= p.last {
+			// broker已持久化
+			continue
+		}
+		wg.Add(1)
+		p.producer.SendAsync(ctx, message, func(err error) {
+			defer wg.Done()
+			if err != nil {
+				errMu.Lock()
+				sendErr = fmt.Errorf("pulsar send %s: %w", topic, err)
+				errMu.Unlock()
+			}
+		})
+	}
+	var err error
+	for topic, p := range used {
+		if err = p.producer.Flush(); err != nil {
+			err = fmt.Errorf("pulsar flush %s: %w", topic, err)
+			break
+		}
+	}
+	wg.Wait()
+	if err == nil {
+		err = sendErr
+	}
+	if err != nil {
+		s.fail(used)
+	}
+	return err
+}
+
+// producer 获取topic的producer，需持有s.mu
+func (s *Sink) producer(topic string) (*topicProducer, error) {
+	if p, ok := s.producers[topic]; ok {
+		return p, nil
+	}
+	producer, err := s.factory(topic)
+	if err != nil {
+		return nil, err
+	}
+	p := &topicProducer{producer: producer, last: producer.LastSequenceID()}
+	s.producers[topic] = p
+	return p, nil
+}
+
+// fail 关闭发送失败的producer，需持有s.mu
+func (s *Sink) fail(used map[string]*topicProducer) {
+	for topic, p := range used {
+		p.producer.Close()
+		delete(s.producers, topic)
+	}
+}
+
+func (s *Sink) message(m core.ReplicationMessage, commitLsn uint64, index int) (message Message, err error) {
+	key, err := sinks.Key(s.config.Schemas, m)
+	if err != nil {
+		return
+	}
+	message = Message{
+		Key:        string(key),
+		Properties: map[string]string{"pg_lsn": pgx.FormatLSN(m.Lsn), "pg_event": m.EventType.String()},
+		EventTime:  m.CommitTime,
+	}
+	if commitLsn > 0 {
+		seq := SequenceID(commitLsn, index)
+		message.SequenceID = &seq
+		message.Properties["pg_commit_lsn"] = pgx.FormatLSN(commitLsn)
+	}
+	if s.config.Payload != nil {
+		message.Payload, err = s.config.Payload(m)
+	} else {
+		message.Payload, err = json.Marshal(sinks.NewEvent(m, commitLsn))
+	}
+	return
+}
+
+// Close 关闭所有producer
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, p := range s.producers {
+		p.producer.Close()
+		delete(s.producers, topic)
+	}
+	return nil
+}
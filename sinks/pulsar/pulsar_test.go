@@ -0,0 +1,171 @@
+package pulsar_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks/pulsar"
+)
+
+type schemas map[uint32]core.RelationSchema
+
+func (s schemas) Schema(id uint32) (core.RelationSchema, bool) {
+	schema, ok := s[id]
+	return schema, ok
+}
+
+func usersSchema(replica byte) schemas {
+	return schemas{1: {RelationID: 1, Schema: "public", Name: "users", ReplicaIdentity: replica, Columns: []core.ColumnSchema{
+		{Name: "id", TypeName: "int4", Key: true},
+		{Name: "name", TypeName: "text", Key: replica == 'f'},
+	}}}
+}
+
+func change(event core.EventType, lsn uint64, id int32, name string) core.ReplicationMessage {
+	return core.ReplicationMessage{
+		Lsn: lsn, RelationID: 1, EventType: event, SchemaName: "public", TableName: "users",
+		Body:   map[string]interface{}{"id": id, "name": name},
+		Fields: core.Fields{{Name: "id", Value: id}, {Name: "name", Value: name}},
+	}
+}
+
+func commit(lsn uint64) core.ReplicationMessage {
+	return core.ReplicationMessage{EventType: core.EventType_COMMIT, Lsn: lsn}
+}
+
+type producer struct {
+	mu     sync.Mutex
+	sent   []pulsar.Message
+	last   int64
+	err    error
+	closed bool
+}
+
+func (p *producer) SendAsync(ctx context.Context, msg pulsar.Message, callback func(err error)) {
+	p.mu.Lock()
+	err := p.err
+	if err == nil {
+		p.sent = append(p.sent, msg)
+	}
+	p.mu.Unlock()
+	go callback(err)
+}
+
+func (p *producer) Flush() error {
+	return nil
+}
+
+func (p *producer) LastSequenceID() int64 {
+	return p.last
+}
+
+func (p *producer) Close() {
+	p.closed = true
+}
+
+// factory 记录创建的producer，新建的producer使用last及err
+type factory struct {
+	producers []*producer
+	last      int64
+	err       error
+}
+
+func (f *factory) create(topic string) (pulsar.Producer, error) {
+	p := &producer{last: f.last, err: f.err}
+	f.producers = append(f.producers, p)
+	return p, nil
+}
+
+func TestKey(t *testing.T) {
+	keyed := change(core.EventType_INSERT, 10, 1, "tom")
+	keyed.Key = map[string]interface{}{"id": int32(1)}
+	moved := change(core.EventType_UPDATE, 10, 2, "tom")
+	moved.Key = map[string]interface{}{"id": int32(1)}
+	cases := []struct {
+		name    string
+		schemas schemas
+		msg     core.ReplicationMessage
+		key     string
+	}{
+		{"insert key from schemas", usersSchema('d'), change(core.EventType_INSERT, 10, 1, "tom"), `{"id":1}`},
+		{"insert key from the message", nil, keyed, `{"id":1}`},
+		{"insert without key", nil, change(core.EventType_INSERT, 10, 1, "tom"), ""},
+		{"primary key change uses the new key", nil, moved, `{"id":2}`},
+		{"replica identity full", usersSchema('f'), change(core.EventType_UPDATE, 10, 1, "tom"), ""},
+	}
+	for _, c := range cases {
+		f := &factory{last: -1}
+		config := pulsar.Config{}
+		if c.schemas != nil {
+			config.Schemas = c.schemas
+		}
+		if err := pulsar.NewSink(f.create, config).Write(context.Background(), c.msg, commit(20)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(f.producers) != 1 || len(f.producers[0].sent) != 1 {
+			t.Fatalf("%s: producers %v", c.name, f.producers)
+		}
+		if key := f.producers[0].sent[0].Key; key != c.key {
+			t.Errorf("%s: key %q, want %q", c.name, key, c.key)
+		}
+	}
+}
+
+// 序列号不大于broker上最大序列号的消息已持久化，不再发送
+func TestSequenceID(t *testing.T) {
+	f := &factory{last: pulsar.SequenceID(20, 1)}
+	s := pulsar.NewSink(f.create, pulsar.Config{Topic: "{table}"})
+	defer s.Close()
+	begin := core.ReplicationMessage{EventType: core.EventType_BEGIN, Lsn: 5}
+	msg := []core.ReplicationMessage{begin, change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_INSERT, 11, 2, "amy"), commit(20)}
+	if err := s.Write(context.Background(), msg...); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.producers) != 1 || len(f.producers[0].sent) != 1 {
+		t.Fatalf("producers %v", f.producers)
+	}
+	sent := f.producers[0].sent[0]
+	if *sent.SequenceID != pulsar.SequenceID(20, 2) || sent.Properties["pg_commit_lsn"] != "0/14" {
+		t.Fatalf("sent %d %v", *sent.SequenceID, sent.Properties)
+	}
+	// 没有提交lsn的初始快照由producer分配序列号
+	snapshot := change(core.EventType_SNAPSHOT, 0, 3, "bob")
+	if err := s.Write(context.Background(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if sent := f.producers[0].sent; len(sent) != 2 || sent[1].SequenceID != nil {
+		t.Fatalf("snapshot sent %v", sent)
+	}
+}
+
+// 发送失败时关闭producer，下次写入重新创建并从broker取得最大序列号
+func TestSendError(t *testing.T) {
+	f := &factory{last: -1, err: errors.New("producer fenced")}
+	s := pulsar.NewSink(f.create, pulsar.Config{})
+	defer s.Close()
+	msg := []core.ReplicationMessage{change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_INSERT, 11, 2, "amy"), commit(20)}
+	if err := s.Write(context.Background(), msg...); !errors.Is(err, f.err) {
+		t.Fatalf("error %v", err)
+	}
+	if len(f.producers) != 1 || !f.producers[0].closed {
+		t.Fatalf("producer not closed after a failure")
+	}
+	f.last, f.err = pulsar.SequenceID(20, 0), nil
+	if err := s.Write(context.Background(), msg...); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.producers) != 2 {
+		t.Fatalf("%d producers, want 2", len(f.producers))
+	}
+	var seqs []int64
+	for _, m := range f.producers[1].sent {
+		seqs = append(seqs, *m.SequenceID)
+	}
+	if want := []int64{pulsar.SequenceID(20, 1)}; !reflect.DeepEqual(seqs, want) {
+		t.Fatalf("sequence ids %v, want %v", seqs, want)
+	}
+}
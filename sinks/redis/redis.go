@@ -0,0 +1,184 @@
+// Package redis 把变更写入Redis Streams的StreamSink，以及按表名和主键删除缓存key的Invalidator
+//
+// 不依赖具体的Redis客户端，通过Client适配，如go-redis(本包以redissink导入):
+//
+//	type client struct{ c *redis.Client }
+//
+//	func (c client) Exec(ctx context.Context, cmds []redissink.Command) error {
+//		_, err := c.c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+//			for _, cmd := range cmds {
+//				pipe.Do(ctx, append([]interface{}{cmd.Name}, cmd.Args...)...)
+//			}
+//			return nil
+//		})
+//		return err
+//	}
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks"
+	"github.com/jackc/pgx"
+)
+
+// Command 一条Redis命令
+type Command struct {
+	Name string
+	Args []interface{}
+}
+
+// Client 在一个MULTI/EXEC事务中执行命令，所有命令成功后才返回nil
+type Client interface {
+	Exec(ctx context.Context, cmds []Command) error
+}
+
+// StreamConfig StreamSink的配置，零值可用
+type StreamConfig struct {
+	// Stream stream名称模板，支持{schema} {table} {tenant}占位符，默认为{schema}.{table}
+	Stream string
+	// MaxLen 大于0时以XADD MAXLEN ~近似裁剪stream的长度
+	MaxLen int64
+	// Payload 消息中event字段的内容，默认为sinks.Event的JSON
+	Payload func(msg core.ReplicationMessage) ([]byte, error)
+}
+
+// StreamSink 实现core.Sink，把表的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)以XADD写入stream
+// 消息的字段为event(内容)、type(事件类型)、lsn及commit_lsn，ID由Redis生成
+// 一个事务的消息在一个MULTI/EXEC中写入，成功后才确认lsn；重连后重新发送的事务会重复写入，消费者可按lsn去重
+type StreamSink struct {
+	client Client
+	config StreamConfig
+}
+
+func NewStreamSink(client Client, config StreamConfig) *StreamSink {
+	if config.Stream == "" {
+		config.Stream = "{schema}.{table}"
+	}
+	return &StreamSink{client: client, config: config}
+}
+
+func (s *StreamSink) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	commit := sinks.Commit(msg)
+	var cmds []Command
+	for _, m := range msg {
+		if !sinks.IsChange(m) {
+			continue
+		}
+		var payload []byte
+		var err error
+		if s.config.Payload != nil {
+			payload, err = s.config.Payload(m)
+		} else {
+			payload, err = json.Marshal(sinks.NewEvent(m, commit.Lsn))
+		}
+		if err != nil {
+			return fmt.Errorf("redis %s.%s %s: %w", m.SchemaName, m.TableName, m.EventType, err)
+		}
+		args := []interface{}{core.TenantTopic(s.config.Stream, m)}
+		if s.config.MaxLen > 0 {
+			args = append(args, "MAXLEN", "~", s.config.MaxLen)
+		}
+		args = append(args, "*", "event", payload, "type", m.EventType.String(), "lsn", pgx.FormatLSN(m.Lsn))
+		if commit.Lsn > 0 {
+			args = append(args, "commit_lsn", pgx.FormatLSN(commit.Lsn))
+		}
+		cmds = append(cmds, Command{Name: "XADD", Args: args})
+	}
+	return exec(ctx, s.client, cmds)
+}
+
+func (s *StreamSink) Close() error {
+	return nil
+}
+
+// InvalidatorConfig Invalidator的配置，零值可用
+type InvalidatorConfig struct {
+	// Key 缓存key模板，支持{schema} {table} {tenant} {pk}占位符，{pk}为主键列的值以冒号连接，默认为{schema}:{table}:{pk}
+	Key string
+	// Keys 自定义需要删除的缓存key，设置时忽略Key
+	Keys func(msg core.ReplicationMessage) []string
+	// 用于获取主键列，通常为*core.Replication，见sinks.KeyColumns
+	Schemas sinks.Schemas
+}
+
+// Invalidator 实现core.Sink，每条变更删除表名和主键对应的缓存key，主键变化的update同时删除旧主键的key
+// 没有主键的表及TRUNCATE不删除，需要时通过InvalidatorConfig.Keys处理
+// 一个事务的DEL在一个MULTI/EXEC中执行，成功后才确认lsn
+type Invalidator struct {
+	client Client
+	config InvalidatorConfig
+}
+
+func NewInvalidator(client Client, config InvalidatorConfig) *Invalidator {
+	if config.Key == "" {
+		config.Key = "{schema}:{table}:{pk}"
+	}
+	return &Invalidator{client: client, config: config}
+}
+
+func (v *Invalidator) Write(ctx context.Context, msg ...core.ReplicationMessage) error {
+	var keys []interface{}
+	seen := map[string]bool{}
+	for _, m := range msg {
+		if !sinks.IsChange(m) {
+			continue
+		}
+		for _, key := range v.keys(m) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return exec(ctx, v.client, []Command{{Name: "DEL", Args: keys}})
+}
+
+func (v *Invalidator) Close() error {
+	return nil
+}
+
+func (v *Invalidator) keys(m core.ReplicationMessage) []string {
+	if v.config.Keys != nil {
+		return v.config.Keys(m)
+	}
+	if m.EventType == core.EventType_TRUNCATE {
+		return nil
+	}
+	columns := sinks.KeyColumns(v.config.Schemas, m)
+	var res []string
+	if pk, ok := sinks.KeyValues(columns, m.Body); ok {
+		res = append(res, v.key(m, pk))
+	}
+	// 主键变化的update，旧值在Key(默认复制标识)或OldBody(REPLICA IDENTITY FULL)中
+	for _, old := range []map[string]interface{}{m.Key, m.OldBody} {
+		if pk, ok := sinks.KeyValues(columns, old); ok {
+			if key := v.key(m, pk); len(res) == 0 || key != res[0] {
+				res = append(res, key)
+			}
+			break
+		}
+	}
+	return res
+}
+
+func (v *Invalidator) key(m core.ReplicationMessage, pk string) string {
+	return strings.ReplaceAll(core.TenantTopic(v.config.Key, m), "{pk}", pk)
+}
+
+func exec(ctx context.Context, client Client, cmds []Command) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	if err := client.Exec(ctx, cmds); err != nil {
+		return fmt.Errorf("redis %s: %w", cmds[0].Name, err)
+	}
+	return nil
+}
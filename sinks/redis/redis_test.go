@@ -0,0 +1,117 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/sinks/redis"
+)
+
+type schemas map[uint32]core.RelationSchema
+
+func (s schemas) Schema(id uint32) (core.RelationSchema, bool) {
+	schema, ok := s[id]
+	return schema, ok
+}
+
+func usersSchema(replica byte) schemas {
+	return schemas{1: {RelationID: 1, Schema: "public", Name: "users", ReplicaIdentity: replica, Columns: []core.ColumnSchema{
+		{Name: "id", TypeName: "int4", Key: true},
+		{Name: "name", TypeName: "text", Key: replica == 'f'},
+	}}}
+}
+
+func change(event core.EventType, lsn uint64, id int32, name string) core.ReplicationMessage {
+	return core.ReplicationMessage{
+		Lsn: lsn, RelationID: 1, EventType: event, SchemaName: "public", TableName: "users",
+		Body:   map[string]interface{}{"id": id, "name": name},
+		Fields: core.Fields{{Name: "id", Value: id}, {Name: "name", Value: name}},
+	}
+}
+
+func commit(lsn uint64) core.ReplicationMessage {
+	return core.ReplicationMessage{EventType: core.EventType_COMMIT, Lsn: lsn}
+}
+
+type client struct {
+	execs [][]redis.Command
+	err   error
+}
+
+func (c *client) Exec(ctx context.Context, cmds []redis.Command) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.execs = append(c.execs, cmds)
+	return nil
+}
+
+func TestInvalidator(t *testing.T) {
+	moved := change(core.EventType_UPDATE, 10, 2, "tom")
+	moved.Key = map[string]interface{}{"id": int32(1)}
+	full := change(core.EventType_UPDATE, 10, 2, "tom")
+	full.OldBody, full.FullOldRow = map[string]interface{}{"id": int32(1), "name": "amy"}, true
+	truncate := core.ReplicationMessage{Lsn: 10, RelationID: 1, EventType: core.EventType_TRUNCATE, SchemaName: "public", TableName: "users"}
+	cases := []struct {
+		name   string
+		config redis.InvalidatorConfig
+		msg    []core.ReplicationMessage
+		keys   []interface{}
+	}{
+		{"insert key from schemas", redis.InvalidatorConfig{Schemas: usersSchema('d')}, []core.ReplicationMessage{change(core.EventType_INSERT, 10, 1, "tom")}, []interface{}{"public:users:1"}},
+		{"insert without key", redis.InvalidatorConfig{}, []core.ReplicationMessage{change(core.EventType_INSERT, 10, 1, "tom")}, nil},
+		{"primary key change", redis.InvalidatorConfig{}, []core.ReplicationMessage{moved}, []interface{}{"public:users:2", "public:users:1"}},
+		{"primary key change with replica identity full", redis.InvalidatorConfig{Schemas: usersSchema('f')}, []core.ReplicationMessage{full}, nil},
+		{"duplicate keys in a transaction", redis.InvalidatorConfig{Schemas: usersSchema('d'), Key: "{table}:{pk}"}, []core.ReplicationMessage{
+			change(core.EventType_INSERT, 10, 1, "tom"), moved, change(core.EventType_DELETE, 12, 2, "tom"),
+		}, []interface{}{"users:1", "users:2"}},
+		{"truncate", redis.InvalidatorConfig{Schemas: usersSchema('d')}, []core.ReplicationMessage{truncate}, nil},
+	}
+	for _, c := range cases {
+		cl := &client{}
+		if err := redis.NewInvalidator(cl, c.config).Write(context.Background(), append(c.msg, commit(20))...); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var want [][]redis.Command
+		if c.keys != nil {
+			want = [][]redis.Command{{{Name: "DEL", Args: c.keys}}}
+		}
+		if !reflect.DeepEqual(cl.execs, want) {
+			t.Errorf("%s: executed %v, want %v", c.name, cl.execs, want)
+		}
+	}
+}
+
+// 执行失败时返回错误，不确认lsn
+func TestInvalidatorError(t *testing.T) {
+	cl := &client{err: errors.New("READONLY")}
+	err := redis.NewInvalidator(cl, redis.InvalidatorConfig{Schemas: usersSchema('d')}).Write(context.Background(), change(core.EventType_DELETE, 10, 1, "tom"), commit(20))
+	if !errors.Is(err, cl.err) {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestStreamSink(t *testing.T) {
+	cl := &client{}
+	s := redis.NewStreamSink(cl, redis.StreamConfig{MaxLen: 1000, Payload: func(m core.ReplicationMessage) ([]byte, error) {
+		return []byte(m.TableName), nil
+	}})
+	begin := core.ReplicationMessage{EventType: core.EventType_BEGIN, Lsn: 5}
+	if err := s.Write(context.Background(), begin, change(core.EventType_INSERT, 10, 1, "tom"), change(core.EventType_DELETE, 0x1_0000_0010, 1, "tom"), commit(0x1_0000_0020)); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]redis.Command{{
+		{Name: "XADD", Args: []interface{}{"public.users", "MAXLEN", "~", int64(1000), "*", "event", []byte("users"), "type", "INSERT", "lsn", "0/A", "commit_lsn", "1/20"}},
+		{Name: "XADD", Args: []interface{}{"public.users", "MAXLEN", "~", int64(1000), "*", "event", []byte("users"), "type", "DELETE", "lsn", "1/10", "commit_lsn", "1/20"}},
+	}}
+	if !reflect.DeepEqual(cl.execs, want) {
+		t.Fatalf("executed %v, want %v", cl.execs, want)
+	}
+	// 只有COMMIT的事务不执行命令
+	if err := s.Write(context.Background(), commit(0x1_0000_0030)); err != nil || len(cl.execs) != 1 {
+		t.Fatalf("empty transaction: %v %d", err, len(cl.execs))
+	}
+}
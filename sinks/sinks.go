@@ -0,0 +1,149 @@
+// Package sinks 各Sink共用的消息格式及主键key，具体实现见子目录
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/jackc/pgx"
+)
+
+// Schemas 获取表结构，通常为*core.Replication
+type Schemas interface {
+	Schema(relationID uint32) (schema core.RelationSchema, ok bool)
+}
+
+// Event Sink默认的消息内容(JSON)
+type Event struct {
+	Lsn        string      `json:"lsn"`
+	Xid        uint32      `json:"xid,omitempty"`
+	Event      string      `json:"event"`
+	Schema     string      `json:"schema"`
+	Table      string      `json:"table"`
+	Origin     string      `json:"origin,omitempty"`
+	Tenant     string      `json:"tenant,omitempty"`
+	Columns    []string    `json:"columns,omitempty"`
+	Body       core.Fields `json:"body,omitempty"`
+	Old        core.Fields `json:"old,omitempty"`
+	CommitTime *time.Time  `json:"commit_time,omitempty"`
+	// 事务的提交lsn，与Lsn一起唯一标识一条变更，下游可据此去重
+	CommitLsn string `json:"commit_lsn,omitempty"`
+}
+
+// NewEvent 按列顺序转换消息，commitLsn为所在事务的提交lsn，未知时为0
+func NewEvent(msg core.ReplicationMessage, commitLsn uint64) Event {
+	e := Event{
+		Lsn:     pgx.FormatLSN(msg.Lsn),
+		Xid:     msg.Xid,
+		Event:   msg.EventType.String(),
+		Schema:  msg.SchemaName,
+		Table:   msg.TableName,
+		Origin:  msg.Origin,
+		Tenant:  msg.Tenant,
+		Columns: msg.Columns,
+		Body:    msg.Fields,
+	}
+	if msg.OldBody != nil {
+		for _, f := range msg.Fields {
+			if v, ok := msg.OldBody[f.Name]; ok {
+				e.Old = append(e.Old, core.Field{Name: f.Name, Value: v})
+			}
+		}
+	}
+	if !msg.CommitTime.IsZero() {
+		e.CommitTime = &msg.CommitTime
+	}
+	if commitLsn > 0 {
+		e.CommitLsn = pgx.FormatLSN(commitLsn)
+	}
+	return e
+}
+
+// IsChange 是否为表的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)，Sink只写入变更
+func IsChange(msg core.ReplicationMessage) bool {
+	switch msg.EventType {
+	case core.EventType_INSERT, core.EventType_UPDATE, core.EventType_DELETE, core.EventType_TRUNCATE, core.EventType_SNAPSHOT:
+		return true
+	}
+	return false
+}
+
+// Commit 事务的EventType_COMMIT消息，没有时(如初始快照)为零值
+func Commit(msg []core.ReplicationMessage) core.ReplicationMessage {
+	for i := len(msg) - 1; i >= 0; i-- {
+		if msg[i].EventType == core.EventType_COMMIT {
+			return msg[i]
+		}
+	}
+	return core.ReplicationMessage{}
+}
+
+// Key 主键列的值按列顺序组成的JSON对象，如{"id":1}，update使用新值，主键变化时与旧行的key不同；truncate及没有主键的表为nil
+func Key(schemas Schemas, m core.ReplicationMessage) ([]byte, error) {
+	if m.EventType == core.EventType_TRUNCATE {
+		return nil, nil
+	}
+	names := KeyColumns(schemas, m)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	key := make(core.Fields, 0, len(names))
+	for _, name := range names {
+		v, _ := m.Fields.Get(name)
+		key = append(key, core.Field{Name: name, Value: v})
+	}
+	return json.Marshal(key)
+}
+
+// KeyColumns schemas为*core.Replication且配置了SchemaRefresh时使用目录中的主键，其次为Relation消息中的复制标识列，都没有时为消息中的复制标识列
+// REPLICA IDENTITY FULL的复制标识是所有列，不适合作为key，需SchemaRefresh获取主键
+func KeyColumns(schemas Schemas, m core.ReplicationMessage) []string {
+	if schemas != nil {
+		if c, ok := schemas.(interface{ Catalog() *core.Catalog }); ok {
+			if meta, ok := c.Catalog().Table(m.RelationID); ok && len(meta.PrimaryKey) > 0 {
+				return meta.PrimaryKey
+			}
+		}
+		if schema, ok := schemas.Schema(m.RelationID); ok {
+			if schema.ReplicaIdentity == 'f' {
+				return nil
+			}
+			var names []string
+			for _, col := range schema.Columns {
+				if col.Key {
+					names = append(names, col.Name)
+				}
+			}
+			return names
+		}
+	}
+	if m.FullOldRow {
+		return nil
+	}
+	var names []string
+	for _, f := range m.Fields {
+		if _, ok := m.Key[f.Name]; ok {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// KeyValues 列的值以冒号连接，用作缓存key或文档id，有列不存在或为NULL时ok为false
+func KeyValues(columns []string, body map[string]interface{}) (string, bool) {
+	if len(columns) == 0 || body == nil {
+		return "", false
+	}
+	parts := make([]string, len(columns))
+	for i, col := range columns {
+		value, ok := body[col]
+		if !ok || value == nil {
+			return "", false
+		}
+		parts[i] = fmt.Sprint(value)
+	}
+	return strings.Join(parts, ":"), true
+}
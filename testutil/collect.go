@@ -0,0 +1,132 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+)
+
+// Collector 在后台运行Replication，收集handler收到的全部消息，每个事务处理后确认
+type Collector struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	msgs    []core.ReplicationMessage
+	changed chan struct{}
+	err     error
+}
+
+// Collect 在后台启动r并收集消息，测试结束时停止
+//
+//	pg := testutil.StartPostgres(t)
+//	pg.Exec(t, "CREATE TABLE users (id int PRIMARY KEY, name text)")
+//	c := pg.Sync(t, "test_users", "public.users")
+//	pg.Exec(t, "INSERT INTO users VALUES (1, 'tom')")
+//	msgs := c.WaitChanges(t, 1, 10*time.Second)
+func Collect(t testing.TB, r *core.Replication) *Collector {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Collector{cancel: cancel, done: make(chan struct{}), changed: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		err := r.Start(ctx, c.handle)
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			err = nil
+		}
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+	}()
+	t.Cleanup(func() { c.Stop() })
+	return c
+}
+
+func (c *Collector) handle(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg...)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return core.DMLHandlerStatusSuccess
+}
+
+// Messages 获取已收到的消息
+func (c *Collector) Messages() []core.ReplicationMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]core.ReplicationMessage(nil), c.msgs...)
+}
+
+// Changes 获取已收到的变更(INSERT/UPDATE/DELETE/TRUNCATE/SNAPSHOT)
+func (c *Collector) Changes() []core.ReplicationMessage {
+	return changes(c.Messages())
+}
+
+func changes(msgs []core.ReplicationMessage) []core.ReplicationMessage {
+	var res []core.ReplicationMessage
+	for _, m := range msgs {
+		switch m.EventType {
+		case core.EventType_INSERT, core.EventType_UPDATE, core.EventType_DELETE, core.EventType_TRUNCATE, core.EventType_SNAPSHOT:
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// Wait 等待已收到的消息满足until，超时或Start返回时测试失败
+func (c *Collector) Wait(t testing.TB, timeout time.Duration, until func(msgs []core.ReplicationMessage) bool) []core.ReplicationMessage {
+	t.Helper()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		msgs, changed, err := append([]core.ReplicationMessage(nil), c.msgs...), c.changed, c.err
+		c.mu.Unlock()
+		if until(msgs) {
+			return msgs
+		}
+		select {
+		case <-changed:
+		case <-c.done:
+			c.mu.Lock()
+			err = c.err
+			c.mu.Unlock()
+			t.Fatalf("replication stopped after %d messages: %v", len(msgs), err)
+		case <-timer.C:
+			t.Fatalf("timeout after %s with %d messages", timeout, len(msgs))
+		}
+	}
+}
+
+// WaitReady 等待EventType_READY，此后的变更都会被收到
+func (c *Collector) WaitReady(t testing.TB, timeout time.Duration) {
+	t.Helper()
+	c.Wait(t, timeout, func(msgs []core.ReplicationMessage) bool {
+		for _, m := range msgs {
+			if m.EventType == core.EventType_READY {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WaitChanges 等待至少收到n条变更，返回所有已收到的变更
+func (c *Collector) WaitChanges(t testing.TB, n int, timeout time.Duration) []core.ReplicationMessage {
+	t.Helper()
+	msgs := c.Wait(t, timeout, func(msgs []core.ReplicationMessage) bool { return len(changes(msgs)) >= n })
+	return changes(msgs)
+}
+
+// Stop 停止同步并等待Start返回，返回Start的错误(ctx取消时为nil)
+func (c *Collector) Stop() error {
+	c.cancel()
+	<-c.done
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
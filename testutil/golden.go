@@ -0,0 +1,111 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/jackc/pgx"
+)
+
+// 设置环境变量PGREPL_UPDATE_GOLDEN=1时用当前输出覆盖golden文件
+const updateGoldenEnv = "PGREPL_UPDATE_GOLDEN"
+
+// CanonicalJSON 把任意值渲染为规范JSON：对象的key按字典序排列，两个空格缩进，以换行结尾
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// 结构体字段按声明顺序输出，重新解码为map后统一按key排序
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	data, err = json.MarshalIndent(generic, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+type canonicalMessage struct {
+	Lsn        string                 `json:"lsn,omitempty"`
+	Event      string                 `json:"event"`
+	Schema     string                 `json:"schema,omitempty"`
+	Table      string                 `json:"table,omitempty"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Columns    []string               `json:"columns,omitempty"`
+	Body       map[string]interface{} `json:"body,omitempty"`
+	CommitTime string                 `json:"commit_time,omitempty"`
+}
+
+// MessagesJSON 把消息渲染为规范JSON数组
+func MessagesJSON(msgs ...core.ReplicationMessage) ([]byte, error) {
+	res := make([]canonicalMessage, 0, len(msgs))
+	for _, m := range msgs {
+		c := canonicalMessage{
+			Event:   m.EventType.String(),
+			Schema:  m.SchemaName,
+			Table:   m.TableName,
+			Tenant:  m.Tenant,
+			Columns: m.Columns,
+			Body:    m.Body,
+		}
+		if m.Lsn > 0 {
+			c.Lsn = pgx.FormatLSN(m.Lsn)
+		}
+		if !m.CommitTime.IsZero() {
+			c.CommitTime = m.CommitTime.UTC().Format(time.RFC3339Nano)
+		}
+		res = append(res, c)
+	}
+	return CanonicalJSON(res)
+}
+
+// AssertGolden 比较got与golden文件的内容
+func AssertGolden(t testing.TB, file string, got []byte) {
+	t.Helper()
+	if os.Getenv(updateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("update golden %s: %v", file, err)
+		}
+		if err := os.WriteFile(file, got, 0644); err != nil {
+			t.Fatalf("update golden %s: %v", file, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read golden %s: %v (run with %s=1 to create it)", file, err, updateGoldenEnv)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from golden %s (run with %s=1 to update)\n--- got\n%s\n--- want\n%s", file, updateGoldenEnv, got, want)
+	}
+}
+
+// AssertGoldenJSON 把v渲染为规范JSON后与golden文件比较
+func AssertGoldenJSON(t testing.TB, file string, v interface{}) {
+	t.Helper()
+	got, err := CanonicalJSON(v)
+	if err != nil {
+		t.Fatalf("render %s: %v", file, err)
+	}
+	AssertGolden(t, file, got)
+}
+
+// AssertGoldenMessages 把消息渲染为规范JSON后与golden文件比较
+func AssertGoldenMessages(t testing.TB, file string, msgs ...core.ReplicationMessage) {
+	t.Helper()
+	got, err := MessagesJSON(msgs...)
+	if err != nil {
+		t.Fatalf("render %s: %v", file, err)
+	}
+	AssertGolden(t, file, got)
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goroutines 获取当前所有goroutine的调用栈，key为goroutine id
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	res := map[string]string{}
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		// goroutine 18 [chan receive]:
+		fields := strings.Fields(string(g))
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		res[fields[1]] = string(g)
+	}
+	return res
+}
+
+// VerifyNoLeaks 记录当前的goroutine，测试结束时检查是否有新增且未退出的goroutine
+// 退出可能是异步的，最多等待5秒
+//
+//	func TestStart(t *testing.T) {
+//		testutil.VerifyNoLeaks(t)
+//		...
+//	}
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+	before := goroutines()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var leaked []string
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok && !ignoredGoroutine(stack) {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+}
+
+func ignoredGoroutine(stack string) bool {
+	for _, s := range []string{
+		// 当前执行检查的goroutine及测试框架
+		"testutil.goroutines(",
+		"testing.(*T).Run(",
+		"testing.tRunner.func1",
+		"runtime.goexit0",
+		"os/signal.signal_recv",
+	} {
+		if strings.Contains(stack, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+)
+
+// leakRecorder 记录VerifyNoLeaks的Cleanup及报告的错误
+type leakRecorder struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (r *leakRecorder) Helper() {}
+
+func (r *leakRecorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *leakRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *leakRecorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestVerifyNoLeaksReportsLeak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the leak deadline")
+	}
+	r := &leakRecorder{TB: t}
+	VerifyNoLeaks(r)
+	block := make(chan struct{})
+	defer close(block)
+	go leakedGoroutine(block)
+	r.finish()
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "leakedGoroutine") {
+		t.Fatalf("errors %q, want the leaked goroutine", r.errors)
+	}
+}
+
+func leakedGoroutine(block <-chan struct{}) {
+	<-block
+}
+
+func TestVerifyNoLeaksWaitsForExit(t *testing.T) {
+	r := &leakRecorder{TB: t}
+	VerifyNoLeaks(r)
+	go time.Sleep(100 * time.Millisecond)
+	r.finish()
+	if len(r.errors) != 0 {
+		t.Fatalf("unexpected errors %q", r.errors)
+	}
+}
+
+func TestStartNoLeaks(t *testing.T) {
+	VerifyNoLeaks(t)
+	src := stressSource(10).End()
+	err := stressReplication(src).Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+		return core.DMLHandlerStatusSuccess
+	})
+	if err == nil {
+		t.Fatal("Start at end of stream returned nil")
+	}
+	if src.Pending() != 0 {
+		t.Errorf("%d messages not consumed", src.Pending())
+	}
+}
@@ -0,0 +1,146 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/jackc/pgx"
+)
+
+// DefaultImage 默认的PostgreSQL镜像，可通过环境变量PGREPL_TEST_IMAGE覆盖
+const DefaultImage = "postgres:16"
+
+// Postgres 通过docker启动的一次性PostgreSQL实例，已开启wal_level=logical
+type Postgres struct {
+	ID     string
+	Config pgx.ConnConfig
+}
+
+// StartPostgres 启动PostgreSQL容器，测试结束时自动删除
+// 本机没有docker时跳过测试
+func StartPostgres(t testing.TB) *Postgres {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not available")
+	}
+	image := os.Getenv("PGREPL_TEST_IMAGE")
+	if image == "" {
+		image = DefaultImage
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-P",
+		"-e", "POSTGRES_PASSWORD=postgres",
+		image,
+		"-c", "wal_level=logical",
+		"-c", "max_replication_slots=20",
+		"-c", "max_wal_senders=20",
+	).Output()
+	if err != nil {
+		t.Fatalf("docker run %s: %v", image, commandError(err))
+	}
+	p := &Postgres{ID: strings.TrimSpace(string(out))}
+	t.Cleanup(p.Stop)
+
+	out, err = exec.Command("docker", "port", p.ID, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("docker port: %v", commandError(err))
+	}
+	// 可能同时输出ipv4和ipv6地址，取第一个
+	_, port, err := net.SplitHostPort(strings.Fields(string(out))[0])
+	if err != nil {
+		t.Fatalf("docker port %q: %v", out, err)
+	}
+	portNum, _ := strconv.ParseUint(port, 10, 16)
+	p.Config = pgx.ConnConfig{
+		Host:     "127.0.0.1",
+		Port:     uint16(portNum),
+		Database: "postgres",
+		User:     "postgres",
+		Password: "postgres",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for {
+		conn, err := pgx.Connect(p.Config)
+		if err == nil {
+			conn.Close()
+			return p
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("postgres not ready: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// Stop 删除容器
+func (p *Postgres) Stop() {
+	if p.ID != "" {
+		exec.Command("docker", "rm", "-f", p.ID).Run()
+		p.ID = ""
+	}
+}
+
+// Exec 在普通连接上依次执行sql
+func (p *Postgres) Exec(t testing.TB, sql ...string) {
+	t.Helper()
+	conn, err := pgx.Connect(p.Config)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+	for _, v := range sql {
+		if _, err = conn.Exec(v); err != nil {
+			t.Fatalf("exec %q: %v", v, err)
+		}
+	}
+}
+
+// Replication 创建发布流、设置复制标识并返回可直接Start的Replication
+// 测试结束时删除复制槽和发布流
+func (p *Postgres) Replication(t testing.TB, name string, tables ...string) *core.Replication {
+	t.Helper()
+	r := core.NewReplication(name, p.Config)
+	if err := r.CreateReplication(); err != nil {
+		t.Fatalf("create replication: %v", err)
+	}
+	if err := r.CreatePublication(tables); err != nil {
+		t.Fatalf("create publication: %v", err)
+	}
+	if len(tables) > 0 {
+		if err := r.SetReplicaIdentity(tables, core.ReplicaIdentityFull); err != nil {
+			t.Fatalf("set replica identity: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		r.Close()
+		r.DropReplication()
+		r.DropPublication()
+	})
+	return r
+}
+
+// Sync 创建Replication并在后台收集消息，返回时已开始接收复制流
+// 测试结束时停止同步并删除复制槽和发布流
+func (p *Postgres) Sync(t testing.TB, name string, tables ...string) *Collector {
+	t.Helper()
+	c := Collect(t, p.Replication(t, name, tables...))
+	c.WaitReady(t, time.Minute)
+	return c
+}
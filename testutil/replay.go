@@ -0,0 +1,89 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/jackc/pgx"
+)
+
+// Replay 不连接数据库，把抓包文件(Replication.RecordFile或pgcdc -record生成)中的原始消息依次交给解码及handler，
+// 返回handler收到的全部消息；configure用于在Start前设置选项(过滤、多租户等)，与线上配置一致才能复现问题
+// 回放不等待抓取时的间隔，同一抓包文件的结果是确定的
+//
+//	func TestIssue42(t *testing.T) {
+//		msgs := testutil.Replay(t, "testdata/issue42.pgrcap", nil)
+//		testutil.AssertGoldenMessages(t, "testdata/issue42.golden.json", msgs...)
+//	}
+func Replay(t testing.TB, file string, configure func(*core.Replication)) []core.ReplicationMessage {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("replay %s: %v", file, err)
+	}
+	defer f.Close()
+	msgs, err := ReplayReader(f, configure)
+	if err != nil {
+		t.Fatalf("replay %s: %v", file, err)
+	}
+	return msgs
+}
+
+// ReplayReader 与Replay相同，从r读取抓包数据，抓包数据读取完时返回nil
+func ReplayReader(r io.Reader, configure func(*core.Replication)) ([]core.ReplicationMessage, error) {
+	replication := core.NewReplication("replay", pgx.ConnConfig{}).WithTransport(core.NewReplayer(r, 0))
+	if configure != nil {
+		configure(replication)
+	}
+	var res []core.ReplicationMessage
+	err := replication.Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+		res = append(res, msg...)
+		return core.DMLHandlerStatusSuccess
+	})
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return res, err
+}
+
+// ReplayGolden 回放抓包文件，把handler收到的消息与golden文件比较
+// 设置环境变量PGREPL_UPDATE_GOLDEN=1时用回放结果覆盖golden文件
+func ReplayGolden(t testing.TB, file, golden string, configure func(*core.Replication)) {
+	t.Helper()
+	AssertGoldenMessages(t, golden, Replay(t, file, configure)...)
+}
+
+// WriteCapture 读取src(通常为调用了End的mock.Source)的全部消息写入抓包文件，用于不依赖数据库构造回放用例
+// src的消息读取完(返回io.EOF)时结束
+func WriteCapture(t testing.TB, file string, src core.Transport) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("write capture %s: %v", file, err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatalf("write capture %s: %v", file, err)
+	}
+	defer f.Close()
+	w := core.NewCaptureWriter(f)
+	for {
+		msg, err := src.WaitForReplicationMessage(context.Background())
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("write capture %s: %v", file, err)
+		}
+		if err = w.Write(msg); err != nil {
+			t.Fatalf("write capture %s: %v", file, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("write capture %s: %v", file, err)
+	}
+}
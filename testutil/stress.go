@@ -0,0 +1,146 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cube-group/pg-replication/core"
+	"github.com/cube-group/pg-replication/core/mock"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// StressLifecycle 在模拟数据源上反复执行Start/取消/Close/重启/确认，验证同步生命周期的并发安全
+// 配合go test -race使用，并检查goroutine泄漏
+//
+//	func TestLifecycle(t *testing.T) { testutil.StressLifecycle(t, 100) }
+func StressLifecycle(t testing.TB, iterations int) {
+	t.Helper()
+	VerifyNoLeaks(t)
+	for i := 0; i < iterations; i++ {
+		stressCancel(t)
+		stressClose(t)
+		stressAck(t)
+	}
+	stressRestart(t, iterations)
+}
+
+func stressSource(transactions int) *mock.Source {
+	src := mock.NewSource().Relation(core.Relation{ID: 1, Namespace: "public", Name: "stress", Columns: []core.Column{
+		mock.Key("id", pgtype.Int4OID),
+		mock.Col("name", pgtype.TextOID),
+	}})
+	for i := 0; i < transactions; i++ {
+		src.Begin().Insert(1, i, "name").Update(1, nil, []interface{}{i, "changed"}).Commit().Heartbeat(i%2 == 0)
+	}
+	return src
+}
+
+func stressReplication(src core.Transport) *core.Replication {
+	return core.NewReplication("stress", pgx.ConnConfig{}).WithTransport(src)
+}
+
+// stressCancel 消费过程中取消ctx，Start需及时返回
+func stressCancel(t testing.TB) {
+	src := stressSource(50)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	var once sync.Once
+	go func() {
+		done <- stressReplication(src).Start(ctx, func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+			for _, m := range msg {
+				if m.EventType == core.EventType_COMMIT {
+					once.Do(cancel)
+				}
+			}
+			return core.DMLHandlerStatusSuccess
+		})
+	}()
+	waitReturn(t, "Start after cancel", done)
+	cancel()
+}
+
+// stressClose 在其他goroutine中调用Close，Start需返回错误
+func stressClose(t testing.TB) {
+	src := stressSource(50)
+	r := stressReplication(src)
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+			if msg[0].EventType == core.EventType_READY {
+				close(started)
+			}
+			return core.DMLHandlerStatusSuccess
+		})
+	}()
+	<-started
+	r.Close()
+	if err := waitReturn(t, "Start after Close", done); err == nil {
+		t.Errorf("Start after Close returned nil")
+	}
+}
+
+// stressAck 消费的同时从其他goroutine确认lsn，确认的lsn需单调递增
+func stressAck(t testing.TB) {
+	src := stressSource(20).End()
+	r := stressReplication(src)
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			r.SendStatusACK(0)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Start(context.Background(), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+			return core.DMLHandlerStatusSuccess
+		})
+	}()
+	waitReturn(t, "Start at end of stream", done)
+	cancel()
+	wg.Wait()
+	var last uint64
+	for _, lsn := range src.Acks() {
+		if lsn < last {
+			t.Errorf("ack %s after %s", pgx.FormatLSN(lsn), pgx.FormatLSN(last))
+		}
+		last = lsn
+	}
+	if src.Pending() != 0 {
+		t.Errorf("%d messages not consumed", src.Pending())
+	}
+}
+
+// stressRestart 数据流结束后由Manager按重启策略重启，重启次数需与策略一致
+func stressRestart(t testing.TB, restarts int) {
+	src := stressSource(1).End()
+	m := core.NewManager()
+	if err := m.Add(stressReplication(src), func(msg ...core.ReplicationMessage) core.DMLHandlerStatus {
+		return core.DMLHandlerStatusSuccess
+	}, core.RestartPolicy{MaxRestarts: restarts, Backoff: time.Microsecond}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- m.Start(context.Background()) }()
+	waitReturn(t, "Manager after restarts", done)
+	if stats := m.Stats(); stats.Restarts != restarts || stats.Running != 0 {
+		t.Errorf("manager stats %+v, want %d restarts", stats, restarts)
+	}
+}
+
+func waitReturn(t testing.TB, name string, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s did not return", name)
+		return nil
+	}
+}
@@ -0,0 +1,12 @@
+package testutil
+
+import "testing"
+
+// go test -race ./testutil
+func TestStressLifecycle(t *testing.T) {
+	iterations := 20
+	if testing.Short() {
+		iterations = 3
+	}
+	StressLifecycle(t, iterations)
+}
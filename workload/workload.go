@@ -0,0 +1,245 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Config 压测负载配置
+type Config struct {
+	// 测试表，可带schema，如public.cdc_load
+	Table string
+	// 除主键外的text列数量及每列的字节数，决定行宽
+	Columns     int
+	ColumnWidth int
+	// 每秒事务数，0为不限速
+	Rate float64
+	// 每个事务包含的语句数
+	TxSize int
+	// insert/update/delete的权重
+	Inserts int
+	Updates int
+	Deletes int
+	// 并发连接数
+	Workers int
+	// 随机数种子，0为使用当前时间
+	Seed int64
+}
+
+func (c Config) withDefaults() Config {
+	if c.Table == "" {
+		c.Table = "cdc_load"
+	}
+	if c.Columns <= 0 {
+		c.Columns = 4
+	}
+	if c.ColumnWidth <= 0 {
+		c.ColumnWidth = 32
+	}
+	if c.TxSize <= 0 {
+		c.TxSize = 1
+	}
+	if c.Inserts+c.Updates+c.Deletes <= 0 {
+		c.Inserts, c.Updates, c.Deletes = 6, 3, 1
+	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	return c
+}
+
+// Stats 负载统计
+type Stats struct {
+	Transactions uint64
+	Inserts      uint64
+	Updates      uint64
+	Deletes      uint64
+	Errors       uint64
+	Elapsed      time.Duration
+}
+
+// Generator 对测试表产生可配置的insert/update/delete流量，用于测量解码及下游处理的吞吐
+type Generator struct {
+	// 原子操作的字段放在最前面以保证64位对齐
+	maxID        int64
+	transactions uint64
+	inserts      uint64
+	updates      uint64
+	deletes      uint64
+	errors       uint64
+
+	config pgx.ConnConfig
+	c      Config
+	table  string
+}
+
+func New(config pgx.ConnConfig, c Config) *Generator {
+	c = c.withDefaults()
+	return &Generator{config: config, c: c, table: pgx.Identifier(strings.Split(c.Table, ".")).Sanitize()}
+}
+
+func (g *Generator) column(i int) string {
+	return fmt.Sprintf("c%d", i+1)
+}
+
+// Setup 创建测试表(已存在时跳过)
+func (g *Generator) Setup() error {
+	conn, err := pgx.Connect(g.config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	columns := make([]string, 0, g.c.Columns+1)
+	columns = append(columns, "id bigserial PRIMARY KEY")
+	for i := 0; i < g.c.Columns; i++ {
+		columns = append(columns, g.column(i)+" text")
+	}
+	if _, err = conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", g.table, strings.Join(columns, ", "))); err != nil {
+		return err
+	}
+	return conn.QueryRow(fmt.Sprintf("SELECT coalesce(max(id), 0) FROM %s", g.table)).Scan(&g.maxID)
+}
+
+// Stats 获取当前统计
+func (g *Generator) Stats() Stats {
+	return Stats{
+		Transactions: atomic.LoadUint64(&g.transactions),
+		Inserts:      atomic.LoadUint64(&g.inserts),
+		Updates:      atomic.LoadUint64(&g.updates),
+		Deletes:      atomic.LoadUint64(&g.deletes),
+		Errors:       atomic.LoadUint64(&g.errors),
+	}
+}
+
+// Run 产生负载直到ctx取消，返回统计结果
+func (g *Generator) Run(ctx context.Context) (Stats, error) {
+	start := time.Now()
+	conns := make([]*pgx.Conn, 0, g.c.Workers)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < g.c.Workers; i++ {
+		conn, err := pgx.Connect(g.config)
+		if err != nil {
+			return g.Stats(), err
+		}
+		conns = append(conns, conn)
+	}
+	var interval time.Duration
+	if g.c.Rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(g.c.Workers) / g.c.Rate)
+	}
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(conn *pgx.Conn, rnd *rand.Rand) {
+			defer wg.Done()
+			g.worker(ctx, conn, rnd, interval)
+		}(conn, rand.New(rand.NewSource(g.c.Seed+int64(i))))
+	}
+	wg.Wait()
+	stats := g.Stats()
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+func (g *Generator) worker(ctx context.Context, conn *pgx.Conn, rnd *rand.Rand, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		if err := g.transaction(conn, rnd); err != nil {
+			atomic.AddUint64(&g.errors, 1)
+			continue
+		}
+		atomic.AddUint64(&g.transactions, 1)
+	}
+}
+
+func (g *Generator) payload(rnd *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, g.c.ColumnWidth)
+	for i := range b {
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+func (g *Generator) transaction(conn *pgx.Conn, rnd *rand.Rand) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var inserts, updates, deletes uint64
+	total := g.c.Inserts + g.c.Updates + g.c.Deletes
+	for i := 0; i < g.c.TxSize; i++ {
+		n := rnd.Intn(total)
+		maxID := atomic.LoadInt64(&g.maxID)
+		switch {
+		case n < g.c.Inserts || maxID == 0:
+			args := make([]interface{}, g.c.Columns)
+			columns := make([]string, g.c.Columns)
+			params := make([]string, g.c.Columns)
+			for j := range args {
+				args[j] = g.payload(rnd)
+				columns[j] = g.column(j)
+				params[j] = fmt.Sprintf("$%d", j+1)
+			}
+			var id int64
+			if err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", g.table, strings.Join(columns, ", "), strings.Join(params, ", ")), args...).Scan(&id); err != nil {
+				return err
+			}
+			for {
+				if cur := atomic.LoadInt64(&g.maxID); id <= cur || atomic.CompareAndSwapInt64(&g.maxID, cur, id) {
+					break
+				}
+			}
+			inserts++
+		case n < g.c.Inserts+g.c.Updates:
+			if _, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = $1 WHERE id = $2", g.table, g.column(rnd.Intn(g.c.Columns))), g.payload(rnd), rnd.Int63n(maxID)+1); err != nil {
+				return err
+			}
+			updates++
+		default:
+			if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = $1", g.table), rnd.Int63n(maxID)+1); err != nil {
+				return err
+			}
+			deletes++
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&g.inserts, inserts)
+	atomic.AddUint64(&g.updates, updates)
+	atomic.AddUint64(&g.deletes, deletes)
+	return nil
+}